@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"entry-access-control/internal/access"
+	"entry-access-control/internal/config"
+	"entry-access-control/internal/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var accessListCmd = &cobra.Command{
+	Use:   "access-list",
+	Short: "Inspect access list files",
+}
+
+var validateColumnsFile string
+
+var validateAccessListCmd = &cobra.Command{
+	Use:   "validate <file>...",
+	Short: "Check access list files against the column definitions",
+	Long: `Parse access list files, and report which column definition matched them,
+or why each definition rejected the file.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := validateColumnsFile
+		if path == "" {
+			path = config.Cfg.AccessListColumns
+		}
+		definitions, err := access.LoadListDefinitions(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load column definitions: %v\n", err)
+			os.Exit(1)
+		}
+		sources, err := access.LoadSources(config.Cfg.AccessListSources)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load access list sources: %v\n", err)
+			os.Exit(1)
+		}
+
+		failed := false
+		for i, file := range args {
+			if i > 0 {
+				fmt.Println()
+			}
+			if !validateAccessList(file, definitions, sources) {
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// validateAccessList prints the parse result of the file. Returns false if the
+// file was rejected, also for row roles not allowed by the sources file.
+func validateAccessList(file string, definitions []access.CSVListDefinition, sources access.AccessListSources) bool {
+	fmt.Printf("File: %s\n", file)
+
+	f, err := access.ParseCSVFile(file, definitions)
+	var noDef *access.NoDefinitionError
+	if errors.As(err, &noDef) {
+		fmt.Println("Result: rejected, no definition matched")
+		for _, m := range noDef.Mismatches {
+			fmt.Printf("  %s: %s\n", m.Definition, m.Reason)
+		}
+		return false
+	}
+	if err != nil {
+		fmt.Printf("Result: rejected, %v\n", err)
+		return false
+	}
+
+	f.Grant = access.ResolveGrant(access.RelativePath(config.Cfg.AccessListFolder, file), f.Metadata, sources.Sources)
+	if f.FilterRowRoles(sources.RowRoles); len(f.RejectedRoles) > 0 {
+		fmt.Printf("Result: rejected, roles %s are not in row_roles of %s\n", strings.Join(f.RejectedRoles, ", "), config.Cfg.AccessListSources)
+		return false
+	}
+
+	def := f.FieldDefinitions
+	if def.Language != "" {
+		fmt.Printf("Result: ok, %s file, matched definition %s (%s)\n", f.Format, def.Name, def.Language)
+	} else {
+		fmt.Printf("Result: ok, %s file, matched definition %s\n", f.Format, def.Name)
+	}
+	fmt.Printf("  Email column:  %s\n", def.EmailField)
+	if def.StatusField != "" {
+		fmt.Printf("  Status column: %s, active when %s\n", def.StatusField, strings.Join(def.ActiveStatuses, " or "))
+	} else {
+		fmt.Println("  Status column: none, all users active")
+	}
+	for _, column := range []struct{ label, name string }{
+		{"Role column:  ", def.RoleField},
+		{"Name column:  ", def.NameField},
+	} {
+		if column.name == "" {
+			continue
+		}
+		if _, ok := f.HeaderMap[column.name]; ok {
+			fmt.Printf("  %s %s\n", column.label, column.name)
+		} else {
+			fmt.Printf("  %s %s (not in file, ignored)\n", column.label, column.name)
+		}
+	}
+
+	active := 0
+	for _, e := range f.Entries {
+		if e.Status {
+			active++
+		}
+	}
+	fmt.Printf("  Users: %d, active %d, skipped rows %d\n", len(f.Entries), active, f.Skipped)
+
+	if f.Metadata != nil {
+		valid := "valid now"
+		if !f.Metadata.ValidAt(time.Now()) {
+			valid = "not valid now"
+		}
+		fmt.Printf("  Validity: %s - %s, %s\n", formatMetadataTime(f.Metadata.ValidFrom), formatMetadataTime(f.Metadata.ValidUntil), valid)
+	}
+
+	grant := f.Grant
+	roles, entries := "student (default)", "all"
+	if len(grant.Roles) > 0 {
+		roles = strings.Join(grant.Roles, ", ")
+	}
+	if grant.Entries != nil {
+		entries = strings.Join(grant.Entries, ", ")
+	}
+	fmt.Printf("  Grants: roles %s, entries %s\n", roles, entries)
+	return true
+}
+
+var diffAccessListCmd = &cobra.Command{
+	Use:   "diff <old> <new>",
+	Short: "Show users gaining or losing access between two access list files",
+	Long: `Compare two access list files, e.g. the current export and its replacement,
+and list users added, removed, activated, deactivated, or with changed roles.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		definitions, err := access.LoadListDefinitions(config.Cfg.AccessListColumns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load column definitions: %v\n", err)
+			os.Exit(1)
+		}
+		sources, err := access.LoadSources(config.Cfg.AccessListSources)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load access list sources: %v\n", err)
+			os.Exit(1)
+		}
+
+		var states [2]map[string]access.EntryState
+		for i, path := range args {
+			f, err := access.ParseCSVFile(path, definitions)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to parse %s: %v\n", path, err)
+				os.Exit(1)
+			}
+			f.Grant = access.ResolveGrant(access.RelativePath(config.Cfg.AccessListFolder, path), f.Metadata, sources.Sources)
+			// As loaded by the server
+			f.FilterRowRoles(sources.RowRoles)
+			states[i] = access.EntryStates(access.FileEntries(path, f))
+		}
+		printAccessListDiff(states[0], states[1])
+	},
+}
+
+func printAccessListDiff(old, new map[string]access.EntryState) {
+	// The files replace each other, so every user would have moved
+	changes := slices.DeleteFunc(access.DiffStates(old, new), func(c storage.AccessChange) bool {
+		return c.Change == storage.AccessChangeMoved
+	})
+
+	// Users active in both with other roles
+	var roleChanges []string
+	for user, n := range new {
+		if o, ok := old[user]; ok && o.Active && n.Active && !slices.Equal(sortedRoles(o.Roles), sortedRoles(n.Roles)) {
+			roleChanges = append(roleChanges, user)
+		}
+	}
+	slices.Sort(roleChanges)
+
+	counts := map[string]int{}
+	for _, c := range changes {
+		counts[c.Change]++
+		switch c.Change {
+		case storage.AccessChangeAdded:
+			if c.Active {
+				fmt.Printf("+ %s\n", c.UserID)
+			} else {
+				fmt.Printf("+ %s (inactive)\n", c.UserID)
+			}
+		case storage.AccessChangeRemoved:
+			if old[c.UserID].Active {
+				fmt.Printf("- %s (lost access)\n", c.UserID)
+			} else {
+				fmt.Printf("- %s (was inactive)\n", c.UserID)
+			}
+		case storage.AccessChangeActivated:
+			fmt.Printf("↑ %s activated\n", c.UserID)
+		case storage.AccessChangeDeactivated:
+			fmt.Printf("↓ %s deactivated\n", c.UserID)
+		}
+	}
+	for _, user := range roleChanges {
+		fmt.Printf("~ %s roles %s -> %s\n", user, strings.Join(sortedRoles(old[user].Roles), ", "), strings.Join(sortedRoles(new[user].Roles), ", "))
+	}
+
+	if len(changes) == 0 && len(roleChanges) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+	fmt.Printf("\n%d added, %d removed, %d activated, %d deactivated, %d with changed roles\n",
+		counts[storage.AccessChangeAdded], counts[storage.AccessChangeRemoved],
+		counts[storage.AccessChangeActivated], counts[storage.AccessChangeDeactivated], len(roleChanges))
+}
+
+func sortedRoles(roles []string) []string {
+	roles = slices.Clone(roles)
+	slices.Sort(roles)
+	return roles
+}
+
+func formatMetadataTime(t *time.Time) string {
+	if t == nil {
+		return "..."
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func init() {
+	validateAccessListCmd.Flags().StringVar(&validateColumnsFile, "columns", "", "Column definitions file, defaults to the configured ACCESS_LIST_COLUMNS")
+
+	rootCmd.AddCommand(accessListCmd)
+	accessListCmd.AddCommand(validateAccessListCmd)
+	accessListCmd.AddCommand(diffAccessListCmd)
+}
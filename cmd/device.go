@@ -2,11 +2,15 @@ package cmd
 
 import (
 	"context"
+	"entry-access-control/internal/monitor"
+	"entry-access-control/internal/sas"
 	"entry-access-control/internal/storage"
+	"entry-access-control/internal/utils"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/user"
+	"slices"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -23,8 +27,9 @@ var deviceCmd = &cobra.Command{
 var deviceListCmd = &cobra.Command{
 	Use:   "list [status]",
 	Short: "List pending devices",
-	Long:  `List devices by status. Valid statuses: pending, approved, rejected. Defaults to pending.`,
-	Args:  cobra.MaximumNArgs(1),
+	Long: `List devices by status. Valid statuses: pending, approved, rejected. Defaults to pending.
+Use --tag and --from-network to narrow down the list.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
 
@@ -51,29 +56,18 @@ var deviceListCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		devices, err = getDeviceSelector(cmd).Filter(ctx, devices)
+		if err != nil {
+			slog.Error("Invalid device selection", "error", err)
+			os.Exit(1)
+		}
+
 		if len(devices) == 0 {
 			fmt.Printf("No %s devices found\n", status)
 			return
 		}
 
-		// Print table
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(w, "DEVICE ID\tSTATUS\tCLIENT IP\tCREATED AT\tUPDATED AT\tAPPROVED BY")
-		for _, device := range devices {
-			approvedBy := ""
-			if device.ApprovedBy != nil {
-				approvedBy = *device.ApprovedBy
-			}
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
-				device.DeviceID,
-				device.Status,
-				device.ClientIP,
-				device.CreatedAt.Format("2006-01-02 15:04:05"),
-				device.UpdatedAt.Format("2006-01-02 15:04:05"),
-				approvedBy,
-			)
-		}
-		w.Flush()
+		printDevices(ctx, devices)
 	},
 }
 
@@ -100,12 +94,46 @@ func getActiveUser() string {
 }
 
 var deviceApproveCmd = &cobra.Command{
-	Use:   "approve <device_id> <entry_id>",
+	Use:   "approve [<device_id> <entry_id>] [--sas CODE] [--all-pending] [--tag TAG] [--from-network CIDR] [--entry ID]",
 	Short: "Approve a pending device for a specific entry",
-	Long:  `Approve a pending device and associate it with an entry point. The entry_id must be a valid entry ID.`,
-	Args:  cobra.ExactArgs(2),
+	Long: `Approve a pending device and associate it with an entry point. The entry_id must be a valid entry ID.
+Use --sas to give the emoji code shown on the device, either as emojis or as their
+names (e.g. "Dog, Cat, Lion, Horse, Unicorn, Pig"). Approval is refused if the codes don't match.
+
+Several devices can be approved at once by selecting them with --all-pending or --tag,
+optionally narrowed with --from-network, and giving the entry with --entry:
+
+  device approve --all-pending --from-network 10.1.0.0/16 --entry 7
+
+Selected devices are listed for confirmation. Use --yes to skip it.`,
+	Args: cobra.MatchAll(cobra.MaximumNArgs(2), func(cmd *cobra.Command, args []string) error {
+		if getDeviceSelector(cmd).IsSet() {
+			if len(args) > 0 {
+				return fmt.Errorf("device_id and entry_id can't be used with device selection flags")
+			}
+			if !cmd.Flags().Changed("entry") {
+				return fmt.Errorf("--entry is required when approving several devices")
+			}
+			// The emoji code verifies one device
+			if cmd.Flags().Changed("sas") {
+				return fmt.Errorf("--sas can't be used with device selection flags")
+			}
+			return nil
+		}
+		return cobra.ExactArgs(2)(cmd, args)
+	}),
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
+
+		// Get approver info
+		approver := getActiveUser()
+
+		if len(args) == 0 {
+			entryID, _ := cmd.Flags().GetInt64("entry")
+			bulkApprove(ctx, cmd, entryID, approver)
+			return
+		}
+
 		deviceID := args[0]
 
 		var entryID int64
@@ -121,47 +149,140 @@ var deviceApproveCmd = &cobra.Command{
 			slog.Error("Device not found", "device_id", deviceID, "error", err)
 			os.Exit(1)
 		}
+		requireEntry(ctx, entryID)
 
 		if device.Status == storage.DeviceStatusApproved {
 			fmt.Printf("Device %s is already approved\n", deviceID)
 			return
 		}
 
-		// Get approver info
-		approver := getActiveUser()
+		// Verify the device is the one shown on the screen
+		if code, _ := cmd.Flags().GetString("sas"); code != "" {
+			if !sas.VerifySAS(deviceID, code) {
+				expected, _ := sas.Describe(deviceID)
+				slog.Error("SAS code mismatch", "device_id", deviceID)
+				fmt.Printf("Emoji code does not match device %s. Expected: %s\n", deviceID, expected)
+				os.Exit(1)
+			}
+		} else {
+			code, _ := sas.UUIDtoSAS(deviceID)
+			names, _ := sas.Describe(deviceID)
+			fmt.Printf("Device emoji code: %s (%s)\n", code, names)
+		}
 
-		// Approve device
-		err = provider.UpdateDeviceStatus(ctx, deviceID, storage.DeviceStatusApproved, &approver)
+		// Approve device and associate it with the entry
+		err = provider.ApproveDevice(ctx, deviceID, entryID, approver)
 		if err != nil {
-			slog.Error("Failed to approve device", "device_id", deviceID, "error", err)
+			slog.Error("Failed to approve device", "device_id", deviceID, "entry_id", entryID, "error", err)
 			os.Exit(1)
 		}
 
-		// Create approved device entry
-		approvedDevice := storage.ApprovedDevice{
-			DeviceID:   deviceID,
-			EntryID:    entryID,
-			ApprovedBy: approver,
+		fmt.Printf("Device %s approved successfully for entry %d by %s\n", deviceID, entryID, approver)
+	},
+}
+
+// requireEntry exits if the entry doesn't exist.
+func requireEntry(ctx context.Context, entryID int64) {
+	entries, err := provider.ListEntries(ctx)
+	if err != nil {
+		slog.Error("Failed to list entries", "error", err)
+		os.Exit(1)
+	}
+	if !slices.ContainsFunc(entries, func(e storage.Entry) bool { return e.ID == entryID }) {
+		slog.Error("Entry not found", "entry_id", entryID)
+		fmt.Printf("Entry %d does not exist\n", entryID)
+		os.Exit(1)
+	}
+}
+
+// bulkApprove approves the selected devices for the entry. Devices already
+// approved for the entry, and rejected devices, are skipped.
+func bulkApprove(ctx context.Context, cmd *cobra.Command, entryID int64, approver string) {
+	requireEntry(ctx, entryID)
+
+	devices, err := getDeviceSelector(cmd).Select(ctx)
+	if err != nil {
+		slog.Error("Failed to select devices", "error", err)
+		os.Exit(1)
+	}
+
+	devices = slices.DeleteFunc(devices, func(d storage.Device) bool {
+		if d.Status == storage.DeviceStatusRejected {
+			return true
 		}
+		_, err := provider.GetApprovedDevice(ctx, d.DeviceID, entryID)
+		return err == nil
+	})
 
-		err = provider.CreateApprovedDevice(ctx, approvedDevice)
-		if err != nil {
-			slog.Error("Failed to create approved device entry", "device_id", deviceID, "entry_id", entryID, "error", err)
-			// Note: Device is already marked as approved in devices table, but association failed
-			fmt.Printf("Warning: Device %s marked as approved but failed to associate with entry %d: %v\n", deviceID, entryID, err)
-			os.Exit(1)
+	if !confirmDevices(ctx, cmd, fmt.Sprintf("Approve for entry %d", entryID), devices) {
+		return
+	}
+
+	failed := 0
+	for _, device := range devices {
+		if err := provider.ApproveDevice(ctx, device.DeviceID, entryID, approver); err != nil {
+			slog.Error("Failed to approve device", "device_id", device.DeviceID, "entry_id", entryID, "error", err)
+			failed++
+			continue
 		}
+		fmt.Printf("Device %s approved for entry %d\n", device.DeviceID, entryID)
+	}
 
-		fmt.Printf("Device %s approved successfully for entry %d by %s\n", deviceID, entryID, approver)
-	},
+	fmt.Printf("Approved %d device(s) for entry %d by %s\n", len(devices)-failed, entryID, approver)
+	if failed > 0 {
+		os.Exit(1)
+	}
 }
 
 var deviceRejectCmd = &cobra.Command{
-	Use:   "reject <device_id>",
+	Use:   "reject [<device_id>] [--all-pending] [--tag TAG] [--from-network CIDR]",
 	Short: "Reject a pending device",
-	Args:  cobra.ExactArgs(1),
+	Long: `Reject a device. Several devices can be rejected at once by selecting them with
+--all-pending or --tag, optionally narrowed with --from-network.
+Selected devices are listed for confirmation. Use --yes to skip it.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if getDeviceSelector(cmd).IsSet() {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
+
+		// Get approver info (person who rejected)
+		approver := getActiveUser()
+
+		if len(args) == 0 {
+			devices, err := getDeviceSelector(cmd).Select(ctx)
+			if err != nil {
+				slog.Error("Failed to select devices", "error", err)
+				os.Exit(1)
+			}
+			devices = slices.DeleteFunc(devices, func(d storage.Device) bool {
+				return d.Status == storage.DeviceStatusRejected
+			})
+
+			if !confirmDevices(ctx, cmd, "Reject", devices) {
+				return
+			}
+
+			failed := 0
+			for _, device := range devices {
+				if err := provider.UpdateDeviceStatus(ctx, device.DeviceID, storage.DeviceStatusRejected, &approver); err != nil {
+					slog.Error("Failed to reject device", "device_id", device.DeviceID, "error", err)
+					failed++
+					continue
+				}
+				fmt.Printf("Device %s rejected\n", device.DeviceID)
+			}
+
+			fmt.Printf("Rejected %d device(s) by %s\n", len(devices)-failed, approver)
+			if failed > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+
 		deviceID := args[0]
 
 		// Check if device exists
@@ -176,9 +297,6 @@ var deviceRejectCmd = &cobra.Command{
 			return
 		}
 
-		// Get approver info (person who rejected)
-		approver := getActiveUser()
-
 		// Reject device
 		err = provider.UpdateDeviceStatus(ctx, deviceID, storage.DeviceStatusRejected, &approver)
 		if err != nil {
@@ -191,12 +309,34 @@ var deviceRejectCmd = &cobra.Command{
 }
 
 var deviceRevokeCmd = &cobra.Command{
-	Use:   "revoke <device_id> <entry_id>",
+	Use:   "revoke [<device_id> <entry_id>] [--entry ID] [--tag TAG] [--from-network CIDR]",
 	Short: "Revoke device access to a specific entry",
-	Long:  `Revoke a previously approved device's access to a specific entry point.`,
-	Args:  cobra.ExactArgs(2),
+	Long: `Revoke a previously approved device's access to a specific entry point.
+
+Use --entry without device_id to revoke all devices approved for the entry, optionally
+narrowed with --tag and --from-network:
+
+  device revoke --entry 7
+
+Selected devices are listed for confirmation. Use --yes to skip it.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("entry") {
+			return cobra.NoArgs(cmd, args)
+		}
+		if getDeviceSelector(cmd).IsSet() {
+			return fmt.Errorf("--entry is required when revoking several devices")
+		}
+		return cobra.ExactArgs(2)(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
+
+		if len(args) == 0 {
+			entryID, _ := cmd.Flags().GetInt64("entry")
+			bulkRevoke(ctx, cmd, entryID)
+			return
+		}
+
 		deviceID := args[0]
 
 		var entryID int64
@@ -225,6 +365,210 @@ var deviceRevokeCmd = &cobra.Command{
 	},
 }
 
+// bulkRevoke revokes the entry from all devices approved for it, narrowed by the selector.
+func bulkRevoke(ctx context.Context, cmd *cobra.Command, entryID int64) {
+	approved, err := provider.ListApprovedDevicesByEntry(ctx, entryID)
+	if err != nil {
+		slog.Error("Failed to list approved devices", "entry_id", entryID, "error", err)
+		os.Exit(1)
+	}
+
+	var devices []storage.Device
+	for _, a := range approved {
+		device, err := provider.GetDevice(ctx, a.DeviceID)
+		if err != nil {
+			slog.Warn("Approved device not found", "device_id", a.DeviceID, "error", err)
+			continue
+		}
+		devices = append(devices, *device)
+	}
+
+	devices, err = getDeviceSelector(cmd).Filter(ctx, devices)
+	if err != nil {
+		slog.Error("Invalid device selection", "error", err)
+		os.Exit(1)
+	}
+
+	if !confirmDevices(ctx, cmd, fmt.Sprintf("Revoke entry %d from", entryID), devices) {
+		return
+	}
+
+	failed := 0
+	for _, device := range devices {
+		if err := provider.RevokeApprovedDevice(ctx, device.DeviceID, entryID); err != nil {
+			slog.Error("Failed to revoke device", "device_id", device.DeviceID, "entry_id", entryID, "error", err)
+			failed++
+			continue
+		}
+		fmt.Printf("Device %s access to entry %d revoked\n", device.DeviceID, entryID)
+	}
+
+	fmt.Printf("Revoked entry %d from %d device(s)\n", entryID, len(devices)-failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+var deviceStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show online status of approved devices",
+	Long: `Show approved devices with their entries and when they were last seen.
+Devices are marked online, stale or offline, using MONITOR.STALE_AFTER and MONITOR.OFFLINE_AFTER.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		now := time.Now()
+
+		devices, err := provider.ListDevices(ctx, storage.DeviceStatusApproved)
+		if err != nil {
+			slog.Error("Failed to list devices", "error", err)
+			os.Exit(1)
+		}
+
+		entries, err := provider.ListEntries(ctx)
+		if err != nil {
+			slog.Error("Failed to list entries", "error", err)
+			os.Exit(1)
+		}
+		entryNames := make(map[int64]string, len(entries))
+		for _, e := range entries {
+			entryNames[e.ID] = e.Name
+		}
+
+		if len(devices) == 0 {
+			fmt.Println("No approved devices found")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DEVICE ID\tSTATE\tLAST SEEN\tCLIENT IP\tENTRIES")
+		for _, device := range devices {
+			approved, err := provider.ListApprovedDevicesByDevice(ctx, device.DeviceID)
+			if err != nil {
+				slog.Error("Failed to list device entries", "device_id", device.DeviceID, "error", err)
+				os.Exit(1)
+			}
+			var names []string
+			for _, a := range approved {
+				if name, ok := entryNames[a.EntryID]; ok {
+					names = append(names, name)
+				} else {
+					names = append(names, fmt.Sprintf("#%d", a.EntryID))
+				}
+			}
+
+			lastSeen := "never"
+			if device.LastSeenAt != nil {
+				lastSeen = fmt.Sprintf("%s (%s ago)", device.LastSeenAt.Local().Format("2006-01-02 15:04:05"), now.Sub(*device.LastSeenAt).Round(time.Second))
+			}
+
+			state := string(monitor.DeviceState(device.LastSeenAt, now, cfg.Monitor))
+			if device.AlertedAt != nil {
+				state += " (alerted)"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				device.DeviceID,
+				state,
+				lastSeen,
+				device.ClientIP,
+				strings.Join(names, ", "),
+			)
+		}
+		w.Flush()
+	},
+}
+
+var deviceTagCmd = &cobra.Command{
+	Use:   "tag <tag> [<device_id>...] [--all-pending] [--tag TAG] [--from-network CIDR]",
+	Short: "Tag devices",
+	Long: `Add a tag to devices, for grouping them e.g. by building. Devices are given as arguments,
+or selected with --all-pending or --tag, optionally narrowed with --from-network.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		tag := args[0]
+
+		devices := tagTargets(ctx, cmd, args[1:])
+		if !confirmDevices(ctx, cmd, fmt.Sprintf("Tag %q", tag), devices) {
+			return
+		}
+
+		for _, device := range devices {
+			if err := provider.TagDevice(ctx, device.DeviceID, tag); err != nil {
+				slog.Error("Failed to tag device", "device_id", device.DeviceID, "tag", tag, "error", err)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("Tagged %d device(s) with %q\n", len(devices), tag)
+	},
+}
+
+var deviceUntagCmd = &cobra.Command{
+	Use:   "untag <tag> [<device_id>...] [--all-pending] [--tag TAG] [--from-network CIDR]",
+	Short: "Remove a tag from devices",
+	Long:  `Remove a tag from devices. Without device IDs or selection flags, the tag is removed from all devices.`,
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		tag := args[0]
+
+		var devices []storage.Device
+		if len(args) == 1 && !getDeviceSelector(cmd).IsSet() {
+			var err error
+			if devices, err = provider.ListDevicesByTag(ctx, tag); err != nil {
+				slog.Error("Failed to list devices by tag", "tag", tag, "error", err)
+				os.Exit(1)
+			}
+		} else {
+			devices = tagTargets(ctx, cmd, args[1:])
+		}
+
+		if !confirmDevices(ctx, cmd, fmt.Sprintf("Remove tag %q from", tag), devices) {
+			return
+		}
+
+		removed := 0
+		for _, device := range devices {
+			if err := provider.UntagDevice(ctx, device.DeviceID, tag); err != nil {
+				slog.Warn("Failed to untag device", "device_id", device.DeviceID, "tag", tag, "error", err)
+				continue
+			}
+			removed++
+		}
+		fmt.Printf("Removed tag %q from %d device(s)\n", tag, removed)
+	},
+}
+
+// tagTargets resolves devices given as arguments, or by the selection flags.
+func tagTargets(ctx context.Context, cmd *cobra.Command, deviceIDs []string) []storage.Device {
+	selector := getDeviceSelector(cmd)
+	if len(deviceIDs) > 0 && selector.IsSet() {
+		fmt.Println("Give either device IDs or device selection flags, not both")
+		os.Exit(1)
+	}
+
+	if len(deviceIDs) == 0 {
+		devices, err := selector.Select(ctx)
+		if err != nil {
+			slog.Error("Failed to select devices", "error", err)
+			os.Exit(1)
+		}
+		return devices
+	}
+
+	var devices []storage.Device
+	for _, deviceID := range deviceIDs {
+		device, err := provider.GetDevice(ctx, deviceID)
+		if err != nil {
+			slog.Error("Device not found", "device_id", deviceID, "error", err)
+			os.Exit(1)
+		}
+		devices = append(devices, *device)
+	}
+	return devices
+}
+
 var devicePruneCmd = &cobra.Command{
 	Use:   "prune [--days N] [--status STATUS]",
 	Short: "Remove old devices",
@@ -277,7 +621,117 @@ Use --days to specify the age threshold (default: 7).`,
 	},
 }
 
+var deviceIPPolicyCmd = &cobra.Command{
+	Use:   "ip-policy <device_id> [policy]",
+	Short: "Show or set device IP policy",
+	Long: `Show or set the policy applied when the device connects from a different IP address.
+Valid policies: strict, subnet, allowed_networks, ignore. Use "default" to follow the global DEVICE_IP_POLICY.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		deviceID := args[0]
+
+		device, err := provider.GetDevice(ctx, deviceID)
+		if err != nil {
+			slog.Error("Device not found", "device_id", deviceID, "error", err)
+			os.Exit(1)
+		}
+
+		if len(args) == 1 {
+			if device.IPPolicy != nil {
+				fmt.Printf("Device %s IP policy: %s\n", deviceID, *device.IPPolicy)
+			} else {
+				fmt.Printf("Device %s IP policy: default (%s)\n", deviceID, cfg.DeviceIPPolicy)
+			}
+			return
+		}
+
+		var policy *string
+		if args[1] != "default" {
+			p, err := utils.ParseIPPolicy(args[1])
+			if err != nil {
+				slog.Error("Invalid IP policy", "policy", args[1], "error", err)
+				os.Exit(1)
+			}
+			if networks, _ := utils.ParseCIDRs(cfg.AllowedNetworks); p == utils.IPPolicyAllowedNetworks && len(networks) == 0 {
+				slog.Error("IP policy allowed_networks requires ALLOWED_NETWORKS", "device_id", deviceID)
+				os.Exit(1)
+			}
+			policyStr := string(p)
+			policy = &policyStr
+		}
+
+		if err := provider.SetDeviceIPPolicy(ctx, deviceID, policy); err != nil {
+			slog.Error("Failed to set device IP policy", "device_id", deviceID, "error", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Device %s IP policy set to %s\n", deviceID, args[1])
+	},
+}
+
+var deviceHistoryCmd = &cobra.Command{
+	Use:   "history <device_id>",
+	Short: "Show device history",
+	Long:  `Show recorded changes for the device, such as IP address and IP policy changes.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		deviceID := args[0]
+
+		history, err := provider.ListDeviceHistory(ctx, deviceID)
+		if err != nil {
+			slog.Error("Failed to list device history", "device_id", deviceID, "error", err)
+			os.Exit(1)
+		}
+
+		if len(history) == 0 {
+			fmt.Printf("No history for device %s\n", deviceID)
+			return
+		}
+
+		value := func(v *string) string {
+			if v == nil {
+				return "-"
+			}
+			return *v
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tEVENT\tOLD\tNEW")
+		for _, h := range history {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+				h.CreatedAt.Format("2006-01-02 15:04:05"),
+				h.Event,
+				value(h.OldValue),
+				value(h.NewValue),
+			)
+		}
+		w.Flush()
+	},
+}
+
 func init() {
+	deviceApproveCmd.Flags().String("sas", "", "Emoji code shown on the device, verified before approving")
+	deviceApproveCmd.Flags().Int64("entry", 0, "Entry to approve the selected devices for")
+	addDeviceSelectorFlags(deviceApproveCmd)
+
+	addDeviceSelectorFlags(deviceRejectCmd)
+
+	deviceRevokeCmd.Flags().Int64("entry", 0, "Revoke all devices approved for this entry")
+	addDeviceSelectorFlags(deviceRevokeCmd)
+
+	addDeviceSelectorFlags(deviceTagCmd)
+	addDeviceSelectorFlags(deviceUntagCmd)
+
+	deviceListCmd.Flags().String("tag", "", "List only devices with this tag")
+	deviceListCmd.Flags().String("from-network", "", "List only devices from these comma separated CIDR networks")
+
+	for _, c := range []*cobra.Command{deviceConfigSetCmd, deviceConfigUnsetCmd, deviceConfigShowCmd} {
+		c.Flags().Int64("entry", 0, "Entry to configure instead of a device")
+		deviceConfigCmd.AddCommand(c)
+	}
+
 	// Add flags to prune command
 	devicePruneCmd.Flags().IntP("days", "d", 7, "Remove devices older than this many days")
 	devicePruneCmd.Flags().StringP("status", "s", "pending", "Filter by device status (pending, approved, rejected)")
@@ -287,5 +741,11 @@ func init() {
 	deviceCmd.AddCommand(deviceRejectCmd)
 	deviceCmd.AddCommand(deviceRevokeCmd)
 	deviceCmd.AddCommand(devicePruneCmd)
+	deviceCmd.AddCommand(deviceIPPolicyCmd)
+	deviceCmd.AddCommand(deviceHistoryCmd)
+	deviceCmd.AddCommand(deviceStatusCmd)
+	deviceCmd.AddCommand(deviceTagCmd)
+	deviceCmd.AddCommand(deviceUntagCmd)
+	deviceCmd.AddCommand(deviceConfigCmd)
 	rootCmd.AddCommand(deviceCmd)
 }
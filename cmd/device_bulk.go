@@ -0,0 +1,161 @@
+package cmd
+
+// Bulk device operations. Devices are selected by status, network or tag,
+// and the affected devices are listed for confirmation before changes.
+
+import (
+	"bufio"
+	"context"
+	"entry-access-control/internal/sas"
+	"entry-access-control/internal/storage"
+	"entry-access-control/internal/utils"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// deviceSelector selects devices for bulk operations
+type deviceSelector struct {
+	// Select all pending devices
+	AllPending bool
+	// Comma separated list of CIDR networks the device IP must be in
+	Networks string
+	// Device must have the tag
+	Tag string
+}
+
+// addDeviceSelectorFlags adds device selection flags to the command.
+func addDeviceSelectorFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("all-pending", false, "Select all pending devices")
+	cmd.Flags().String("from-network", "", "Select devices from these comma separated CIDR networks")
+	cmd.Flags().String("tag", "", "Select devices with this tag")
+	cmd.Flags().BoolP("yes", "y", false, "Don't ask for confirmation")
+}
+
+func getDeviceSelector(cmd *cobra.Command) deviceSelector {
+	allPending, _ := cmd.Flags().GetBool("all-pending")
+	networks, _ := cmd.Flags().GetString("from-network")
+	tag, _ := cmd.Flags().GetString("tag")
+	return deviceSelector{
+		AllPending: allPending,
+		Networks:   networks,
+		Tag:        tag,
+	}
+}
+
+// IsSet reports whether any device selection flag was given.
+func (s deviceSelector) IsSet() bool {
+	return s.AllPending || s.Networks != "" || s.Tag != ""
+}
+
+// Select returns the devices matching the selector. Either --all-pending or
+// --tag is required, --from-network only narrows the selection.
+func (s deviceSelector) Select(ctx context.Context) ([]storage.Device, error) {
+	var devices []storage.Device
+	var err error
+
+	switch {
+	case s.Tag != "":
+		devices, err = provider.ListDevicesByTag(ctx, s.Tag)
+	case s.AllPending:
+		devices, err = provider.ListDevices(ctx, storage.DeviceStatusPending)
+	default:
+		return nil, fmt.Errorf("no devices selected, use --all-pending or --tag")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Filter(ctx, devices)
+}
+
+// Filter narrows down the devices using the selector.
+func (s deviceSelector) Filter(ctx context.Context, devices []storage.Device) ([]storage.Device, error) {
+	networks, err := utils.ParseCIDRs(s.Networks)
+	if err != nil {
+		return nil, err
+	}
+
+	var tagged []storage.Device
+	if s.Tag != "" {
+		if tagged, err = provider.ListDevicesByTag(ctx, s.Tag); err != nil {
+			return nil, err
+		}
+	}
+
+	return slices.DeleteFunc(devices, func(d storage.Device) bool {
+		if s.AllPending && d.Status != storage.DeviceStatusPending {
+			return true
+		}
+		if s.Tag != "" && !slices.ContainsFunc(tagged, func(t storage.Device) bool { return t.DeviceID == d.DeviceID }) {
+			return true
+		}
+		if len(networks) > 0 {
+			ip := net.ParseIP(d.ClientIP)
+			if ip == nil || !slices.ContainsFunc(networks, func(n *net.IPNet) bool { return n.Contains(ip) }) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// printDevices prints the devices as a table.
+func printDevices(ctx context.Context, devices []storage.Device) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE ID\tSTATUS\tCLIENT IP\tCREATED AT\tUPDATED AT\tAPPROVED BY\tTAGS\tSAS")
+	for _, device := range devices {
+		approvedBy := ""
+		if device.ApprovedBy != nil {
+			approvedBy = *device.ApprovedBy
+		}
+		status := string(device.Status)
+		if device.StatusReason != nil {
+			status += " (" + *device.StatusReason + ")"
+		}
+		tags, _ := provider.ListDeviceTags(ctx, device.DeviceID)
+		code, _ := sas.UUIDtoSAS(device.DeviceID)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			device.DeviceID,
+			status,
+			device.ClientIP,
+			device.CreatedAt.Format("2006-01-02 15:04:05"),
+			device.UpdatedAt.Format("2006-01-02 15:04:05"),
+			approvedBy,
+			strings.Join(tags, ","),
+			code,
+		)
+	}
+	w.Flush()
+}
+
+// confirmDevices lists the affected devices and asks for confirmation, unless
+// --yes was given. Returns false if there is nothing to do or the user declined.
+func confirmDevices(ctx context.Context, cmd *cobra.Command, action string, devices []storage.Device) bool {
+	if len(devices) == 0 {
+		fmt.Println("No devices selected")
+		return false
+	}
+
+	printDevices(ctx, devices)
+	fmt.Println()
+
+	if yes, _ := cmd.Flags().GetBool("yes"); yes {
+		return true
+	}
+
+	fmt.Printf("%s %d device(s)? [y/N]: ", action, len(devices))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		fmt.Println("Aborted")
+		return false
+	}
+}
@@ -0,0 +1,163 @@
+package cmd
+
+// Kiosk display settings for devices and entries. Device settings override
+// the settings of the entries the device is approved for.
+
+import (
+	"context"
+	"entry-access-control/internal/display"
+	"entry-access-control/internal/storage"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var deviceConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage kiosk display settings",
+	Long: fmt.Sprintf(`Manage kiosk display settings for a device, or for all devices of an entry with --entry.
+Device settings override entry settings. Kiosks pick up changes on their next poll.
+
+Settings:
+  %s: Kiosk language, one of %s
+  %s: Message shown below the QR code
+  %s: Daily period the display is dimmed, e.g. 22:00-06:00
+  %s: Seconds before QR code expiry to fetch a new one
+  %s: Header text shown above the QR code`,
+		display.KeyLocale, strings.Join(display.Locales, ", "),
+		display.KeyIdleMessage,
+		display.KeyDarkHours,
+		display.KeyQRRefreshMargin,
+		display.KeyHeaderText,
+	),
+}
+
+// displayScope returns the settings scope from --entry, or the device ID in
+// the first argument. Remaining arguments are returned.
+func displayScope(ctx context.Context, cmd *cobra.Command, args []string) (storage.DisplayScope, string, []string) {
+	if cmd.Flags().Changed("entry") {
+		entryID, _ := cmd.Flags().GetInt64("entry")
+		requireEntry(ctx, entryID)
+		return storage.DisplayScopeEntry, strconv.FormatInt(entryID, 10), args
+	}
+
+	if len(args) == 0 {
+		fmt.Println("Give a device ID, or an entry with --entry")
+		os.Exit(1)
+	}
+	if _, err := provider.GetDevice(ctx, args[0]); err != nil {
+		slog.Error("Device not found", "device_id", args[0], "error", err)
+		os.Exit(1)
+	}
+	return storage.DisplayScopeDevice, args[0], args[1:]
+}
+
+var deviceConfigSetCmd = &cobra.Command{
+	Use:   "set [<device_id>] <key>=<value>... [--entry ID]",
+	Short: "Set display settings",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		scope, scopeID, pairs := displayScope(ctx, cmd, args)
+		if len(pairs) == 0 {
+			fmt.Println("No settings given")
+			os.Exit(1)
+		}
+
+		// Validate all before setting any
+		var settings []storage.DisplaySetting
+		for _, pair := range pairs {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				slog.Error("Setting must be given as key=value", "setting", pair)
+				os.Exit(1)
+			}
+			if err := display.Validate(key, value); err != nil {
+				slog.Error("Invalid display setting", "key", key, "error", err)
+				os.Exit(1)
+			}
+			settings = append(settings, storage.DisplaySetting{Scope: scope, ScopeID: scopeID, Key: key, Value: value})
+		}
+
+		for _, setting := range settings {
+			if err := provider.SetDisplaySetting(ctx, setting); err != nil {
+				slog.Error("Failed to set display setting", "key", setting.Key, "error", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Set %s for %s %s\n", setting.Key, scope, scopeID)
+		}
+	},
+}
+
+var deviceConfigUnsetCmd = &cobra.Command{
+	Use:   "unset [<device_id>] <key>... [--entry ID]",
+	Short: "Remove display settings",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		scope, scopeID, keys := displayScope(ctx, cmd, args)
+		if len(keys) == 0 {
+			fmt.Println("No settings given")
+			os.Exit(1)
+		}
+
+		for _, key := range keys {
+			if err := provider.UnsetDisplaySetting(ctx, scope, scopeID, key); err != nil {
+				slog.Error("Failed to unset display setting", "key", key, "error", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Unset %s for %s %s\n", key, scope, scopeID)
+		}
+	},
+}
+
+var deviceConfigShowCmd = &cobra.Command{
+	Use:   "show [<device_id>] [--entry ID]",
+	Short: "Show display settings",
+	Long:  `Show stored display settings. For a device, the settings resolved from its entries are shown too.`,
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		scope, scopeID, _ := displayScope(ctx, cmd, args)
+
+		settings, err := provider.ListDisplaySettings(ctx, scope, scopeID)
+		if err != nil {
+			slog.Error("Failed to list display settings", "error", err)
+			os.Exit(1)
+		}
+
+		if len(settings) == 0 {
+			fmt.Printf("No display settings for %s %s\n", scope, scopeID)
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "KEY\tVALUE\tUPDATED AT")
+			for _, s := range settings {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", s.Key, s.Value, s.UpdatedAt.Format("2006-01-02 15:04:05"))
+			}
+			w.Flush()
+		}
+
+		if scope != storage.DisplayScopeDevice {
+			return
+		}
+
+		resolved, err := display.ForDevice(ctx, provider, scopeID)
+		if err != nil {
+			slog.Error("Failed to resolve display settings", "error", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nResolved settings (version %s):\n", resolved.Version())
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\t%s\n", display.KeyLocale, resolved.Locale)
+		fmt.Fprintf(w, "%s\t%s\n", display.KeyIdleMessage, resolved.IdleMessage)
+		fmt.Fprintf(w, "%s\t%s\n", display.KeyDarkHours, resolved.DarkHours)
+		fmt.Fprintf(w, "%s\t%d\n", display.KeyQRRefreshMargin, resolved.QRRefreshMargin)
+		fmt.Fprintf(w, "%s\t%s\n", display.KeyHeaderText, resolved.HeaderText)
+		w.Flush()
+	},
+}
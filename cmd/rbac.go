@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"entry-access-control/internal/access"
+	"entry-access-control/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var rbacCmd = &cobra.Command{
+	Use:   "rbac",
+	Short: "Inspect the RBAC policy",
+}
+
+var validateRBACSkipAccessList bool
+
+var validateRBACCmd = &cobra.Command{
+	Use:   "validate [policy-file]",
+	Short: "Check the RBAC policy for unknown roles and resources, cycles and unused roles",
+	Long: `Report roles in users and inheritance that aren't defined, inheritance cycles,
+roles no one is assigned, and permissions on unknown resources. Roles granted by
+the access list count as used, and must be defined.
+
+Defaults to the configured RBAC_POLICY_FILE. Exits with 1 on errors.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		file := config.Cfg.RBAC.PolicyFile
+		if len(args) > 0 {
+			file = args[0]
+		}
+		policy, err := access.ParsePolicyFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load RBAC policy: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Policy: %s\n", file)
+		fmt.Printf("Roles: %d, users: %d\n", len(policy.Roles), len(policy.Users))
+
+		issues := policy.Validate(grantedRoles())
+		errors := 0
+		for _, issue := range issues {
+			fmt.Printf("  %s\n", issue)
+			if issue.Severity == access.POLICY_ERROR {
+				errors++
+			}
+		}
+		switch {
+		case errors > 0:
+			fmt.Printf("Result: %d errors, %d warnings\n", errors, len(issues)-errors)
+			os.Exit(1)
+		case len(issues) > 0:
+			fmt.Printf("Result: ok, %d warnings\n", len(issues))
+		default:
+			fmt.Println("Result: ok")
+		}
+	},
+}
+
+// grantedRoles returns the roles the access list grants: the default student
+// role, roles of the source rules and row roles, and roles of the loaded users.
+func grantedRoles() []string {
+	roles := []string{"student"}
+	sources, err := access.LoadSources(config.Cfg.AccessListSources)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load access list sources: %v\n", err)
+		os.Exit(1)
+	}
+	for _, rule := range sources.Sources {
+		roles = append(roles, rule.Roles...)
+	}
+	roles = append(roles, sources.RowRoles...)
+	if validateRBACSkipAccessList {
+		return roles
+	}
+
+	// Quiet, the access list logs every file it loads
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+	accessList := NewAccessListFromConfig(config.Cfg)
+	if accessList == nil {
+		fmt.Fprintln(os.Stderr, "Failed to initialize access list, roles of its users not checked")
+		return roles
+	}
+	entries, err := accessList.ListAllEntries()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list access list users, roles of its users not checked: %v\n", err)
+		return roles
+	}
+	for _, entry := range entries {
+		if entry.CanAccess("") {
+			roles = append(roles, entry.GetUserRoles()...)
+		}
+	}
+	slices.Sort(roles)
+	return slices.Compact(roles)
+}
+
+func init() {
+	validateRBACCmd.Flags().BoolVar(&validateRBACSkipAccessList, "skip-access-list", false, "Don't load the access list, e.g. when LDAP is unreachable")
+
+	rootCmd.AddCommand(rbacCmd)
+	rbacCmd.AddCommand(validateRBACCmd)
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"entry-access-control/internal/access"
 	"entry-access-control/internal/config"
 	"entry-access-control/internal/storage"
 	"fmt"
@@ -45,6 +46,14 @@ var rootCmd = &cobra.Command{
 			slog.Error("Failed to initialize storage provider")
 			os.Exit(1)
 		}
+
+		// Identity normalisation, before access lists are loaded
+		normalizer, err := access.NewNormalizer(cfg.Identity, provider)
+		if err != nil {
+			slog.Error("Invalid identity configuration", "error", err)
+			os.Exit(1)
+		}
+		access.SetNormalizer(normalizer)
 	},
 	PersistentPostRun: func(cmd *cobra.Command, args []string) {
 		// Cleanup
@@ -7,12 +7,17 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	. "entry-access-control/internal"
 	"entry-access-control/internal/access"
 	"entry-access-control/internal/config"
+	"entry-access-control/internal/monitor"
 	"entry-access-control/internal/nonce"
 	"entry-access-control/internal/routes"
 	"entry-access-control/internal/storage"
@@ -128,8 +133,26 @@ func ensureSecretKey(cfg *config.Config) error {
 
 func NewAccessListFromConfig(cfg *config.Config) access.AccessList {
 	// Initialize access list
-	// TODO: Load type from config
-	accessList := access.NewAccessList("csv", cfg)
+	var accessList access.AccessList
+	switch cfg.AccessListType {
+	case "scim":
+		// Users provisioned over SCIM live in storage
+		directory, err := access.NewDirectoryAccessList(provider, cfg.SCIM)
+		if err != nil {
+			slog.Error("Initializing SCIM access list failed", "error", err)
+			return nil
+		}
+		accessList = directory
+	case "composite":
+		composite, err := access.NewCompositeAccessList(cfg, provider)
+		if err != nil {
+			slog.Error("Initializing composite access list failed", "error", err)
+			return nil
+		}
+		accessList = composite
+	default:
+		accessList = access.NewAccessList(cfg.AccessListType, cfg)
+	}
 	if accessList == nil {
 		slog.Error("Failed to initialize access list")
 		return nil
@@ -137,18 +160,11 @@ func NewAccessListFromConfig(cfg *config.Config) access.AccessList {
 	return accessList
 }
 
-func LoadAccessRBAC(cfg *config.Config) *access.RBAC {
-	// Initialize access list
-	accessList := NewAccessListFromConfig(cfg)
-	if accessList == nil {
-		slog.Error("Failed to initialize access list")
-		os.Exit(1)
-	}
-
+func LoadAccessRBAC(cfg *config.Config, accessList access.AccessList) *access.RBAC {
 	// Initialize RBAC
 	rbac := access.GetRBAC()
-	if err := rbac.LoadPolicy(config.Cfg.RBAC.PolicyFile); err != nil {
-		slog.Error("Failed to load RBAC policy", "error", err, "file", config.Cfg.RBAC.PolicyFile)
+	if err := rbac.LoadPolicy(cfg.RBAC.PolicyFile); err != nil {
+		slog.Error("Failed to load RBAC policy", "error", err, "file", cfg.RBAC.PolicyFile)
 		os.Exit(1)
 	}
 	// Inject students from access list as "student" role
@@ -157,12 +173,80 @@ func LoadAccessRBAC(cfg *config.Config) *access.RBAC {
 		slog.Error("Failed to list access list entries", "error", err)
 		os.Exit(1)
 	}
-	for _, entry := range accessListEntries {
-		rbac.AssignRole(entry.GetUserID(), entry.GetUserRoles()...)
-	}
+	syncRoles(rbac, accessList, accessListEntries)
 	return rbac
 }
 
+// syncRoles updates the roles from the access list entries, and the users its
+// deny backends deny, who lose all roles.
+func syncRoles(rbac *access.RBAC, accessList access.AccessList, entries []access.EntryRecord) {
+	if denyList, ok := accessList.(access.DenyList); ok {
+		denied, err := denyList.DeniedUsers()
+		if err != nil {
+			slog.Error("Failed to list denied users, keeping the previous ones", "error", err)
+		} else {
+			rbac.SetDeniedUsers(denied)
+		}
+	}
+	rbac.SyncUserRoles(entries)
+}
+
+// Serialises history recording, as reloads may overlap
+var historyMu sync.Mutex
+
+// recordAccessHistory stores the changes since the last recorded state of each
+// user. Comparing against storage catches changes made while the server was down.
+func recordAccessHistory(storageProvider storage.Provider, backend string, entries []access.EntryRecord) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	ctx := context.Background()
+	latest, err := storageProvider.ListAccessStates(ctx)
+	if err != nil {
+		slog.Error("Failed to load access history", "error", err)
+		return
+	}
+
+	states := access.EntryStates(entries)
+	for user, state := range states {
+		// Directory backends have no files
+		if state.Source == "" {
+			state.Source = backend
+			states[user] = state
+		}
+	}
+
+	changes := access.DiffStates(access.StatesFromHistory(latest), states)
+	if err := storageProvider.RecordAccessChanges(ctx, changes); err != nil {
+		slog.Error("Failed to record access history", "error", err)
+		return
+	}
+	if len(changes) > 0 {
+		slog.Info("Access list changes recorded", "changes", len(changes))
+	}
+}
+
+// pruneUserActivityDaily removes sessions and access events older than the
+// retention in days, on start and then once a day. 0 keeps them forever.
+func pruneUserActivityDaily(ctx context.Context, storageProvider storage.Provider, retention uint) {
+	if retention == 0 {
+		return
+	}
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	for {
+		olderThan := time.Now().AddDate(0, 0, -int(retention))
+		if _, _, err := pruneUserActivity(ctx, storageProvider, olderThan); err != nil {
+			slog.Error("Failed to prune user activity", "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func ServerMain(ctx context.Context, storageProvider storage.Provider) {
 
 	if config.Cfg == nil {
@@ -191,7 +275,52 @@ func ServerMain(ctx context.Context, storageProvider storage.Provider) {
 	server := HTTPServer()
 
 	// Initialize RBAC and access list
-	rbac := LoadAccessRBAC(config.Cfg)
+	accessList := NewAccessListFromConfig(config.Cfg)
+	if accessList == nil {
+		os.Exit(1)
+	}
+	rbac := LoadAccessRBAC(config.Cfg, accessList)
+
+	if entries, err := accessList.ListAllEntries(); err == nil {
+		recordAccessHistory(storageProvider, config.Cfg.AccessListType, entries)
+	}
+
+	// Reload access list when files change, and update roles to match
+	if watcher, ok := accessList.(access.Watcher); ok {
+		watcher.OnReload(func(entries []access.EntryRecord) {
+			syncRoles(rbac, accessList, entries)
+		})
+		watcher.OnReload(func(entries []access.EntryRecord) {
+			recordAccessHistory(storageProvider, config.Cfg.AccessListType, entries)
+		})
+		go func() {
+			if err := watcher.Watch(ctx); err != nil {
+				slog.Error("Access list watcher stopped", "error", err)
+			}
+		}()
+	}
+
+	// Reload the RBAC policy when the file changes, or on SIGHUP. Access list roles are kept.
+	go func() {
+		if err := rbac.Watch(ctx, config.Cfg.RBAC.PolicyFile); err != nil {
+			slog.Error("RBAC policy watcher stopped", "error", err)
+		}
+	}()
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				if err := rbac.LoadPolicy(config.Cfg.RBAC.PolicyFile); err != nil {
+					slog.Error("RBAC policy reload failed, keeping the current policy", "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
 	// Middleware to inject storage provider into context
 	server.Use(func(c *gin.Context) {
@@ -200,10 +329,19 @@ func ServerMain(ctx context.Context, storageProvider storage.Provider) {
 	}, func(c *gin.Context) {
 		c.Set("RBAC", rbac)
 		c.Next()
+	}, func(c *gin.Context) {
+		c.Set("AccessList", accessList)
+		c.Next()
 	}, routes.ErrorHandler())
 
 	RegisterRoutes(server)
 
+	// Alert on entry devices that stop contacting the server
+	checker := monitor.NewChecker(storageProvider, config.Cfg.Monitor, monitor.NotifiersFromConfig(config.Cfg)...)
+	go checker.Run(ctx)
+
+	go pruneUserActivityDaily(ctx, storageProvider, config.Cfg.UserActivityRetention)
+
 	server.Run()
 }
 
@@ -2,12 +2,24 @@ package cmd
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"text/tabwriter"
+	"time"
 
+	"entry-access-control/internal/access"
 	"entry-access-control/internal/config"
+	"entry-access-control/internal/storage"
 
 	"github.com/spf13/cobra"
 )
@@ -18,16 +30,75 @@ var usersCmd = &cobra.Command{
 	Long:  `List users from the access list and display their roles and permissions.`,
 }
 
-var listUsersCmd = &cobra.Command{
-	Use:   "list",
-	Short: "List all users with their roles and status",
-	Run: func(cmd *cobra.Command, args []string) {
-		ctx := context.Background()
-		listUsers(ctx)
-	},
+// userFilter selects the users listed or exported
+type userFilter struct {
+	role   string
+	status string
+	source string
+}
+
+var (
+	listFilter   userFilter
+	exportFilter userFilter
+	exportFormat string
+	exportOutput string
+	showEvents   int
+)
+
+func addUserFilterFlags(cmd *cobra.Command, f *userFilter) {
+	cmd.Flags().StringVar(&f.role, "role", "", "Only users with the role, including inherited roles")
+	cmd.Flags().StringVar(&f.status, "status", "", "Only active or inactive users")
+	cmd.Flags().StringVar(&f.source, "source", "", "Only users from access list files matching the glob pattern or containing the text")
 }
 
-func listUsers(ctx context.Context) {
+func (f userFilter) validate() error {
+	switch f.status {
+	case "", "active", "inactive":
+		return nil
+	default:
+		return fmt.Errorf("invalid status %q, expected active or inactive", f.status)
+	}
+}
+
+func (f userFilter) match(entry access.EntryRecord, roles []string) bool {
+	if f.role != "" && !slices.Contains(roles, f.role) {
+		return false
+	}
+	if f.status != "" && entry.CanAccess("") != (f.status == "active") {
+		return false
+	}
+	if f.source != "" {
+		granted, ok := entry.(access.GrantedEntry)
+		if !ok || !matchSource(f.source, granted.GetSource()) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchSource matches the pattern to the source, its file name, or part of it.
+func matchSource(pattern, source string) bool {
+	if source == "" {
+		return false
+	}
+	display := displaySource(source)
+	for _, s := range []string{display, filepath.Base(display)} {
+		if ok, _ := filepath.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return strings.Contains(display, pattern)
+}
+
+// effectiveRoles returns the sorted roles of the user, including inherited and policy roles.
+func effectiveRoles(rbac *access.RBAC, userID string) []string {
+	roles := rbac.GetUserRoles(userID)
+	slices.Sort(roles)
+	return roles
+}
+
+// loadUsers loads the access list and RBAC for CLI commands, with minimal log output.
+func loadUsers() (access.AccessList, *access.RBAC, []access.EntryRecord) {
 	if config.Cfg == nil {
 		fmt.Fprintln(os.Stderr, "Configuration not initialized")
 		os.Exit(1)
@@ -39,21 +110,36 @@ func listUsers(ctx context.Context) {
 	}))
 	slog.SetDefault(logger)
 
-	// Load RBAC and access list (reusing server initialization logic)
-	rbac := LoadAccessRBAC(config.Cfg)
-
-	// Get access list
+	// Load access list and RBAC (reusing server initialization logic)
 	accessList := NewAccessListFromConfig(config.Cfg)
 	if accessList == nil {
 		fmt.Fprintln(os.Stderr, "Failed to initialize access list")
 		os.Exit(1)
 	}
+	rbac := LoadAccessRBAC(config.Cfg, accessList)
 
 	entries, err := accessList.ListAllEntries()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to list entries: %v\n", err)
 		os.Exit(1)
 	}
+	return accessList, rbac, entries
+}
+
+var listUsersCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all users with their roles and status",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := listFilter.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		listUsers(listFilter)
+	},
+}
+
+func listUsers(filter userFilter) {
+	_, rbac, entries := loadUsers()
 
 	if len(entries) == 0 {
 		fmt.Println("No users found in access list")
@@ -62,36 +148,573 @@ func listUsers(ctx context.Context) {
 
 	// Print table header
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	fmt.Fprintln(w, "USER ID\tSTATUS\tROLES")
-	fmt.Fprintln(w, "-------\t------\t-----")
+	fmt.Fprintln(w, "USER ID\tSTATUS\tROLES\tSOURCE\tVALID UNTIL")
+	fmt.Fprintln(w, "-------\t------\t-----\t------\t-----------")
 
 	// Print each user
+	listed := 0
 	for _, entry := range entries {
 		userID := entry.GetUserID()
+		roles := effectiveRoles(rbac, userID)
+		if !filter.match(entry, roles) {
+			continue
+		}
+		listed++
+
 		status := "Inactive"
 		if entry.CanAccess("") {
 			status = "Active"
 		}
 
-		roles := rbac.GetUserRoles(userID)
-		rolesStr := ""
-		if len(roles) > 0 {
-			rolesStr = roles[0]
-			for i := 1; i < len(roles); i++ {
-				rolesStr += ", " + roles[i]
-			}
-		} else {
+		rolesStr := strings.Join(roles, ", ")
+		if rolesStr == "" {
 			rolesStr = "-"
 		}
 
-		fmt.Fprintf(w, "%s\t%s\t%s\n", userID, status, rolesStr)
+		source, validUntil := "-", "-"
+		if granted, ok := entry.(access.GrantedEntry); ok {
+			if src := granted.GetSource(); src != "" {
+				source = displaySource(src)
+			}
+			if until := granted.GetValidUntil(); until != nil {
+				validUntil = until.Local().Format("2006-01-02 15:04")
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", userID, status, rolesStr, source, validUntil)
 	}
 
 	w.Flush()
+	if listed != len(entries) {
+		fmt.Printf("\nUsers: %d of %d\n", listed, len(entries))
+		return
+	}
 	fmt.Printf("\nTotal users: %d\n", len(entries))
 }
 
+// exportedUser is a user in the export for auditors
+type exportedUser struct {
+	UserID string   `json:"user_id"`
+	Name   string   `json:"name,omitempty"`
+	Active bool     `json:"active"`
+	Status string   `json:"status,omitempty"`
+	Roles  []string `json:"roles"`
+	// IDs of the entries the user can access, null for all
+	Entries    []string   `json:"entries"`
+	Source     string     `json:"source,omitempty"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+var exportUsersCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export users with their status, roles and entries as CSV or JSON",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exportFilter.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		out := os.Stdout
+		if exportOutput != "" && exportOutput != "-" {
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := exportUsers(out, exportFormat, exportFilter); err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func exportUsers(out io.Writer, format string, filter userFilter) error {
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("invalid format %q, expected csv or json", format)
+	}
+	_, rbac, entries := loadUsers()
+
+	users := []exportedUser{}
+	for _, entry := range entries {
+		roles := effectiveRoles(rbac, entry.GetUserID())
+		if !filter.match(entry, roles) {
+			continue
+		}
+		user := exportedUser{
+			UserID: entry.GetUserID(),
+			Active: entry.CanAccess(""),
+			Roles:  roles,
+		}
+		if composite, ok := entry.(*access.CompositeEntry); ok {
+			if student, ok := composite.Primary().EntryRecord.(*access.StudentEntry); ok {
+				user.Name = student.Name
+				user.Status = student.RawStatus
+			}
+			user.Entries = composite.GrantedEntries()
+		}
+		if student, ok := entry.(*access.StudentEntry); ok {
+			user.Name = student.Name
+			user.Status = student.RawStatus
+			user.Entries = student.Entries
+		}
+		if granted, ok := entry.(access.GrantedEntry); ok {
+			user.Source = displaySource(granted.GetSource())
+			user.ValidUntil = granted.GetValidUntil()
+		}
+		users = append(users, user)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(users)
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"user_id", "name", "active", "status", "roles", "entries", "source", "valid_until"})
+	for _, u := range users {
+		entries, validUntil := "all", ""
+		if u.Entries != nil {
+			entries = strings.Join(u.Entries, ";")
+		}
+		if u.ValidUntil != nil {
+			validUntil = u.ValidUntil.Format(time.RFC3339)
+		}
+		w.Write([]string{u.UserID, u.Name, strconv.FormatBool(u.Active), u.Status, strings.Join(u.Roles, ";"), entries, u.Source, validUntil})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvLists returns the file based access lists, also the backends of a composite access list.
+func csvLists(accessList access.AccessList) []*access.CSVAccessList {
+	if list, ok := accessList.(*access.CSVAccessList); ok {
+		return []*access.CSVAccessList{list}
+	}
+	var lists []*access.CSVAccessList
+	if composite, ok := accessList.(*access.CompositeAccessList); ok {
+		for _, b := range composite.Backends() {
+			if list, ok := b.List.(*access.CSVAccessList); ok {
+				lists = append(lists, list)
+			}
+		}
+	}
+	return lists
+}
+
+var showUsersCmd = &cobra.Command{
+	Use:   "show <email>",
+	Short: "Show where a user's access comes from, and their entries, sessions and access events",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		showUser(context.Background(), args[0])
+	},
+}
+
+func showUser(ctx context.Context, email string) {
+	accessList, rbac, _ := loadUsers()
+	key := access.CanonicalID(email)
+
+	entry, err := accessList.Find(email)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find user: %v\n", err)
+		os.Exit(1)
+	}
+
+	userID := key
+	if entry != nil {
+		userID = entry.GetUserID()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "User:\t%s\n", userID)
+	switch {
+	case entry == nil:
+		fmt.Fprintf(w, "Status:\tNot in access list\n")
+	case entry.CanAccess(""):
+		fmt.Fprintf(w, "Status:\tActive\n")
+	default:
+		fmt.Fprintf(w, "Status:\tInactive\n")
+	}
+	if student, ok := entry.(*access.StudentEntry); ok {
+		if student.Name != "" {
+			fmt.Fprintf(w, "Name:\t%s\n", student.Name)
+		}
+		if student.Source != "" {
+			fmt.Fprintf(w, "Source:\t%s\n", displaySource(student.Source))
+		}
+		if student.ValidUntil != nil {
+			fmt.Fprintf(w, "Valid until:\t%s\n", student.ValidUntil.Local().Format("2006-01-02 15:04"))
+		}
+	}
+	if composite, ok := entry.(*access.CompositeEntry); ok {
+		fmt.Fprintf(w, "Backend:\t%s\n", composite.GetBackend())
+		if granted, ok := entry.(access.GrantedEntry); ok {
+			fmt.Fprintf(w, "Source:\t%s\n", displaySource(granted.GetSource()))
+		}
+		if student, ok := composite.Primary().EntryRecord.(*access.StudentEntry); ok && student.Name != "" {
+			fmt.Fprintf(w, "Name:\t%s\n", student.Name)
+		}
+		if denied := composite.DeniedBy(""); denied != "" {
+			fmt.Fprintf(w, "Denied by:\t%s\n", denied)
+		}
+	}
+	if entry != nil {
+		fmt.Fprintf(w, "Access list roles:\t%s\n", orDash(strings.Join(scopedRoles(entry), ", ")))
+	}
+	fmt.Fprintf(w, "Effective roles:\t%s\n", orDash(strings.Join(effectiveRoles(rbac, userID), ", ")))
+	w.Flush()
+
+	if composite, ok := entry.(*access.CompositeEntry); ok {
+		fmt.Println("\nBackends:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "BACKEND\tEFFECT\tACCESS\tROLES")
+		fmt.Fprintln(w, "-------\t------\t------\t-----")
+		for i, r := range slices.Concat(composite.Records, composite.Denials) {
+			effect, status, roles := "allow", "Inactive", "-"
+			if i >= len(composite.Records) {
+				effect = "deny"
+			}
+			if r.CanAccess("") {
+				status = "Active"
+				if effect == "allow" {
+					roles = orDash(strings.Join(r.GetUserRoles(), ", "))
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Backend, effect, status, roles)
+		}
+		w.Flush()
+	}
+
+	// Every row of the user, also in files outside their validity period
+	if lists := csvLists(accessList); len(lists) > 0 {
+		files := map[string]*access.CSVFile{}
+		for _, list := range lists {
+			maps.Copy(files, list.Files())
+		}
+		paths := slices.Sorted(maps.Keys(files))
+
+		fmt.Println("\nAccess list rows:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "FILE\tDEFINITION\tSTATUS VALUE\tACCESS\tROLES\tENTRIES")
+		fmt.Fprintln(w, "----\t----------\t------------\t------\t-----\t-------")
+		rows := 0
+		for _, path := range paths {
+			f := files[path]
+			for _, e := range f.Entries {
+				if access.NormalizeEmail(e.Email) != key {
+					continue
+				}
+				rows++
+				status := "Inactive"
+				if e.Status {
+					status = "Active"
+				}
+				if !f.Metadata.ValidAt(time.Now()) {
+					status += " (file not valid now)"
+				}
+				roles := e.Roles
+				if len(roles) == 0 {
+					roles = f.Grant.Roles
+				}
+				entries := "all"
+				if f.Grant.Entries != nil {
+					entries = strings.Join(f.Grant.Entries, ", ")
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", displaySource(path), orDash(f.FieldDefinitions.Name), orDash(e.RawStatus), status, orDash(strings.Join(roles, ", ")), entries)
+			}
+		}
+		w.Flush()
+		if rows == 0 {
+			fmt.Println("(none)")
+		}
+	}
+
+	// Decisions as made by the entry route
+	doors, err := provider.ListEntries(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list entries: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("\nEntries:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tACCESS")
+	fmt.Fprintln(w, "--\t----\t------")
+	for _, door := range doors {
+		decision := "Granted"
+		id := strconv.FormatInt(door.ID, 10)
+		composite, _ := entry.(*access.CompositeEntry)
+		switch {
+		case entry == nil:
+			decision = "Denied: not in access list"
+		case composite != nil && composite.DeniedBy(id) != "":
+			decision = "Denied: deny list " + composite.DeniedBy(id)
+		case !entry.CanAccess(""):
+			decision = "Denied: inactive"
+		case !entry.CanAccess(id):
+			decision = "Denied: not granted by access list"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", door.ID, door.Name, decision)
+	}
+	w.Flush()
+
+	sessions, err := provider.ListUserSessions(ctx, key, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list sessions: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("\nActive sessions:")
+	if len(sessions) == 0 {
+		fmt.Println("(none)")
+	} else {
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "STARTED\tEXPIRES\tCLIENT IP\tUSER AGENT")
+		fmt.Fprintln(w, "-------\t-------\t---------\t----------")
+		for _, s := range sessions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.CreatedAt.Local().Format("2006-01-02 15:04"), s.ExpiresAt.Local().Format("2006-01-02 15:04"), orDash(s.ClientIP), orDash(s.UserAgent))
+		}
+		w.Flush()
+	}
+
+	events, err := provider.ListAccessEvents(ctx, key, showEvents)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list access events: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("\nRecent access:")
+	if len(events) == 0 {
+		fmt.Println("(none)")
+		return
+	}
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TIME\tENTRY\tDEVICE\tRESULT")
+	fmt.Fprintln(w, "----\t-----\t------\t------")
+	for _, e := range events {
+		result := "Granted"
+		if !e.Granted {
+			result = "Denied: " + e.Reason
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.CreatedAt.Local().Format("2006-01-02 15:04:05"), e.EntryID, orDash(e.DeviceID), result)
+	}
+	w.Flush()
+}
+
+// scopedRoles returns the roles of the entry, with the entries of roles limited to some entries.
+func scopedRoles(entry access.EntryRecord) []string {
+	roles := slices.Clone(entry.GetUserRoles())
+	scoped, ok := entry.(access.ScopedEntry)
+	if !ok {
+		return roles
+	}
+	for i, role := range roles {
+		if entries := scoped.GetRoleEntries()[role]; entries != nil {
+			roles[i] = fmt.Sprintf("%s (entries %s)", role, strings.Join(entries, ", "))
+		}
+	}
+	return roles
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// displaySource shortens access list files to paths relative to the folder.
+func displaySource(src string) string {
+	// Composite access list backends have their folders in the instance folder
+	if config.Cfg.AccessListType == "composite" {
+		return access.RelativePath(config.Cfg.InstancePath, src)
+	}
+	return access.RelativePath(config.Cfg.AccessListFolder, src)
+}
+
+var historyUsersCmd = &cobra.Command{
+	Use:   "history <email>",
+	Short: "Show when a user gained or lost access, and from which access list",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		userHistory(context.Background(), args[0])
+	},
+}
+
+func userHistory(ctx context.Context, email string) {
+	userID := access.CanonicalID(email)
+	changes, err := provider.ListAccessChanges(ctx, userID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load history: %v\n", err)
+		os.Exit(1)
+	}
+	if len(changes) == 0 {
+		fmt.Printf("No access list history for %s\n", userID)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TIME\tCHANGE\tACCESS\tSOURCE")
+	fmt.Fprintln(w, "----\t------\t------\t------")
+	for _, c := range changes {
+		status := "Inactive"
+		if c.Active {
+			status = "Active"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.ChangedAt.Local().Format("2006-01-02 15:04"), c.Change, status, displaySource(c.Source))
+	}
+	w.Flush()
+
+	last := changes[len(changes)-1]
+	if last.Active {
+		fmt.Printf("\n%s has access, from %s\n", userID, displaySource(last.Source))
+		return
+	}
+	// Find when the access was lost, the user may have been inactive already before removal
+	lost := last
+	for i := len(changes) - 1; i >= 0 && !changes[i].Active; i-- {
+		lost = changes[i]
+	}
+	if lost.Change == storage.AccessChangeAdded {
+		fmt.Printf("\n%s has never had access\n", userID)
+		return
+	}
+	fmt.Printf("\n%s lost access on %s (%s from %s)\n", userID, lost.ChangedAt.Local().Format("2006-01-02 15:04"), lost.Change, displaySource(lost.Source))
+}
+
+var pruneUsersCmd = &cobra.Command{
+	Use:   "prune [--days N]",
+	Short: "Remove old login sessions and entry attempts",
+	Long: `Remove login sessions ended or expired, and entry attempts recorded, more than
+a number of days ago. They store IP addresses and user agents.
+Defaults to USER_ACTIVITY_RETENTION days, which the server also prunes daily.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		days, _ := cmd.Flags().GetUint("days")
+		if !cmd.Flags().Changed("days") {
+			days = config.Cfg.UserActivityRetention
+			if days == 0 {
+				fmt.Fprintln(os.Stderr, "USER_ACTIVITY_RETENTION is 0, activity is kept forever. Use --days to prune.")
+				os.Exit(1)
+			}
+		}
+		if days == 0 {
+			fmt.Fprintln(os.Stderr, "--days must be at least 1")
+			os.Exit(1)
+		}
+
+		olderThan := time.Now().AddDate(0, 0, -int(days))
+		fmt.Printf("Pruning user activity older than %d days (before %s)...\n", days, olderThan.Format("2006-01-02 15:04:05"))
+
+		sessions, events, err := pruneUserActivity(context.Background(), provider, olderThan)
+		if err != nil {
+			slog.Error("Failed to prune user activity", "error", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Pruned %d session(s) and %d access event(s)\n", sessions, events)
+	},
+}
+
+// pruneUserActivity removes sessions and access events older than the time.
+func pruneUserActivity(ctx context.Context, storageProvider storage.Provider, olderThan time.Time) (int64, int64, error) {
+	sessions, err := storageProvider.PruneUserSessions(ctx, olderThan)
+	if err != nil {
+		return 0, 0, err
+	}
+	events, err := storageProvider.PruneAccessEvents(ctx, olderThan)
+	if err != nil {
+		return sessions, 0, err
+	}
+	return sessions, events, nil
+}
+
+var aliasUsersCmd = &cobra.Command{
+	Use:   "alias",
+	Short: "Manage alternate identifiers of users, e.g. student numbers or old addresses",
+}
+
+var addAliasCmd = &cobra.Command{
+	Use:   "add <alias> <email>",
+	Short: "Map the alias to the user",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := access.NormalizeEmail(args[0])
+		// Resolve the user too, so aliases of aliases point to the user
+		userID := access.CanonicalID(args[1])
+		if alias == "" || userID == "" {
+			fmt.Fprintln(os.Stderr, "Alias and user are required")
+			os.Exit(1)
+		}
+		if alias == userID {
+			fmt.Fprintf(os.Stderr, "%s is the user ID itself\n", alias)
+			os.Exit(1)
+		}
+		if err := provider.SetUserAlias(context.Background(), alias, userID); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add alias: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s is now an alias of %s\n", alias, userID)
+	},
+}
+
+var removeAliasCmd = &cobra.Command{
+	Use:   "remove <alias>",
+	Short: "Remove the alias",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		alias := access.NormalizeEmail(args[0])
+		err := provider.DeleteUserAlias(context.Background(), alias)
+		if errors.Is(err, storage.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "No alias %s\n", alias)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove alias: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Alias %s removed\n", alias)
+	},
+}
+
+var listAliasesCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List aliases and their users",
+	Run: func(cmd *cobra.Command, args []string) {
+		aliases, err := provider.ListUserAliases(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list aliases: %v\n", err)
+			os.Exit(1)
+		}
+		if len(aliases) == 0 {
+			fmt.Println("No aliases")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "ALIAS\tUSER ID\tADDED")
+		fmt.Fprintln(w, "-----\t-------\t-----")
+		for _, a := range aliases {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", a.Alias, a.UserID, a.CreatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		w.Flush()
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(usersCmd)
 	usersCmd.AddCommand(listUsersCmd)
+	usersCmd.AddCommand(showUsersCmd)
+	usersCmd.AddCommand(exportUsersCmd)
+	usersCmd.AddCommand(historyUsersCmd)
+	usersCmd.AddCommand(pruneUsersCmd)
+	usersCmd.AddCommand(aliasUsersCmd)
+	aliasUsersCmd.AddCommand(addAliasCmd)
+	aliasUsersCmd.AddCommand(removeAliasCmd)
+	aliasUsersCmd.AddCommand(listAliasesCmd)
+
+	addUserFilterFlags(listUsersCmd, &listFilter)
+	addUserFilterFlags(exportUsersCmd, &exportFilter)
+	pruneUsersCmd.Flags().Uint("days", 0, "Remove activity older than this many days, defaults to USER_ACTIVITY_RETENTION")
+	exportUsersCmd.Flags().StringVar(&exportFormat, "format", "csv", "Export format: csv or json")
+	exportUsersCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "File to write, default standard output")
+	showUsersCmd.Flags().IntVar(&showEvents, "events", 10, "Number of recent access events to show")
 }
@@ -0,0 +1,505 @@
+package access
+
+// Composite access list, combining backends like Sisu exports, a manual allow
+// list, LDAP, and a deny list of banned users. Backends are queried in the
+// order of the backends file:
+//
+//	merge: first
+//	backends:
+//	  - name: manual
+//	    type: csv
+//	    folder: access_lists/manual
+//	  - name: sisu
+//	    type: csv
+//	    folder: access_lists/sisu
+//	  - name: directory
+//	    type: ldap
+//	  - name: banned
+//	    type: csv
+//	    folder: access_lists/banned
+//	    deny: true
+//
+// With the first merge, the first backend listing the user decides. With union,
+// the user can access the entries of every backend where they are active, with
+// the roles of all of them. Deny wins: users active in a deny backend can't
+// access the entries it grants, and have no roles, whatever the other backends say.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	. "entry-access-control/internal/config"
+	"entry-access-control/internal/storage"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Merge rules of the composite access list
+const (
+	MERGE_FIRST = "first"
+	MERGE_UNION = "union"
+)
+
+// BackendConfig is an access list queried by the composite access list.
+type BackendConfig struct {
+	Name string `yaml:"name"`
+	// csv, ldap or scim. LDAP uses the LDAP settings.
+	Type string `yaml:"type"`
+	// Folder of a csv backend, relative to the instance folder
+	Folder string `yaml:"folder"`
+	// Users active in the backend are denied instead of allowed
+	Deny bool `yaml:"deny"`
+}
+
+type CompositeConfig struct {
+	// first or union. Default is first.
+	Merge    string          `yaml:"merge"`
+	Backends []BackendConfig `yaml:"backends"`
+}
+
+// LoadCompositeConfig reads and validates the backends file.
+func LoadCompositeConfig(file string) (*CompositeConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access list backends: %w", err)
+	}
+
+	var cfg CompositeConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse access list backends %s: %w", file, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("access list backends %s: %w", file, err)
+	}
+	return &cfg, nil
+}
+
+func (c *CompositeConfig) validate() error {
+	switch c.Merge {
+	case "":
+		c.Merge = MERGE_FIRST
+	case MERGE_FIRST, MERGE_UNION:
+	default:
+		return fmt.Errorf("invalid merge %q, expected %s or %s", c.Merge, MERGE_FIRST, MERGE_UNION)
+	}
+
+	names := map[string]bool{}
+	allow := false
+	for i := range c.Backends {
+		b := &c.Backends[i]
+		if b.Name == "" {
+			b.Name = b.Type
+		}
+		if names[b.Name] {
+			return fmt.Errorf("backend %d: duplicate name %q", i+1, b.Name)
+		}
+		names[b.Name] = true
+
+		switch b.Type {
+		case "csv":
+			if b.Folder == "" {
+				return fmt.Errorf("backend %s: folder is required", b.Name)
+			}
+		case "ldap", "scim":
+		default:
+			return fmt.Errorf("backend %s: invalid type %q, expected csv, ldap or scim", b.Name, b.Type)
+		}
+		allow = allow || !b.Deny
+	}
+	if !allow {
+		return errors.New("no backends allowing access")
+	}
+	return nil
+}
+
+// Backend is a loaded backend of the composite access list.
+type Backend struct {
+	Name string
+	Type string
+	Deny bool
+	List AccessList
+}
+
+// BackendEntry is implemented by entries that know which backend produced them.
+type BackendEntry interface {
+	GetBackend() string
+}
+
+// BackendRecord is the entry of a user in one backend.
+type BackendRecord struct {
+	Backend string
+	EntryRecord
+}
+
+// CompositeEntry is a user of the composite access list.
+type CompositeEntry struct {
+	UserID string
+	// Backend that produced the entry. With the union merge, the first backend listing the user.
+	Backend string
+	// Entries of the allow backends listing the user, in backend order
+	Records []BackendRecord
+	// Entries of the deny backends listing the user
+	Denials []BackendRecord
+}
+
+func (e *CompositeEntry) GetUserID() string {
+	return e.UserID
+}
+
+func (e *CompositeEntry) GetBackend() string {
+	return e.Backend
+}
+
+// DeniedBy returns the deny backend denying the entry, or "" if none does.
+// Empty EntryID checks any entry.
+func (e *CompositeEntry) DeniedBy(EntryID string) string {
+	for _, d := range e.Denials {
+		if d.CanAccess(EntryID) {
+			return d.Backend
+		}
+	}
+	return ""
+}
+
+func (e *CompositeEntry) CanAccess(EntryID string) bool {
+	if e.DeniedBy(EntryID) != "" {
+		return false
+	}
+	return slices.ContainsFunc(e.Records, func(r BackendRecord) bool {
+		return r.CanAccess(EntryID)
+	})
+}
+
+// GetUserRoles returns the roles of the backends where the user is active.
+func (e *CompositeEntry) GetUserRoles() []string {
+	roles := []string{}
+	if e.DeniedBy("") != "" {
+		return roles
+	}
+	for _, r := range e.active() {
+		for _, role := range r.GetUserRoles() {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// GetRoleEntries returns the entries the roles of the active entries apply to.
+// Roles of backends without entry scopes apply to all entries.
+func (e *CompositeEntry) GetRoleEntries() map[string][]string {
+	if e.DeniedBy("") != "" {
+		return nil
+	}
+	var roles []string
+	var roleEntries map[string][]string
+	for _, r := range e.active() {
+		var scopes map[string][]string
+		if scoped, ok := r.EntryRecord.(ScopedEntry); ok {
+			scopes = scoped.GetRoleEntries()
+		}
+		roleEntries = mergeRoleEntries(roles, roleEntries, r.GetUserRoles(), scopes)
+		roles = unionIDs(roles, r.GetUserRoles())
+	}
+	return roleEntries
+}
+
+// GetSource returns the source of the first active entry, or the first entry.
+// Backends without sources report their name.
+func (e *CompositeEntry) GetSource() string {
+	r := e.Primary()
+	if granted, ok := r.EntryRecord.(GrantedEntry); ok && granted.GetSource() != "" {
+		return granted.GetSource()
+	}
+	return r.Backend
+}
+
+// GetValidUntil returns when the last of the active entries ends.
+func (e *CompositeEntry) GetValidUntil() *time.Time {
+	var until *time.Time
+	for i, r := range e.active() {
+		granted, ok := r.EntryRecord.(GrantedEntry)
+		if !ok {
+			return nil
+		}
+		if i == 0 || laterUntil(granted.GetValidUntil(), until) {
+			until = granted.GetValidUntil()
+		}
+		if until == nil {
+			return nil
+		}
+	}
+	return until
+}
+
+// Primary returns the first active entry, or the first entry if none is active.
+func (e *CompositeEntry) Primary() BackendRecord {
+	if active := e.active(); len(active) > 0 {
+		return active[0]
+	}
+	return e.Records[0]
+}
+
+// GrantedEntries returns the IDs of entries the active entries grant, nil for all.
+func (e *CompositeEntry) GrantedEntries() []string {
+	var granted []string
+	for _, r := range e.active() {
+		student, ok := r.EntryRecord.(*StudentEntry)
+		if !ok || student.Entries == nil {
+			return nil
+		}
+		for _, id := range student.Entries {
+			if !slices.Contains(granted, id) {
+				granted = append(granted, id)
+			}
+		}
+	}
+	return granted
+}
+
+func (e *CompositeEntry) active() []BackendRecord {
+	var active []BackendRecord
+	for _, r := range e.Records {
+		if r.CanAccess("") {
+			active = append(active, r)
+		}
+	}
+	return active
+}
+
+// add adds the entry of the backend, following the merge rule.
+func (e *CompositeEntry) add(b *Backend, record EntryRecord, merge string) {
+	r := BackendRecord{Backend: b.Name, EntryRecord: record}
+	switch {
+	case b.Deny:
+		e.Denials = append(e.Denials, r)
+	case len(e.Records) == 0:
+		e.Backend = b.Name
+		e.Records = append(e.Records, r)
+	case merge == MERGE_UNION:
+		e.Records = append(e.Records, r)
+	}
+}
+
+type CompositeAccessList struct {
+	merge    string
+	backends []*Backend
+
+	mu       sync.Mutex
+	onReload []func(entries []EntryRecord)
+	logger   *slog.Logger
+}
+
+// NewCompositeAccessList loads the backends in the backends file. Directory
+// backends use the provider.
+func NewCompositeAccessList(cfg *Config, provider storage.Provider) (*CompositeAccessList, error) {
+	composite, err := LoadCompositeConfig(cfg.AccessListBackends)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CompositeAccessList{
+		merge:  composite.Merge,
+		logger: slog.Default().WithGroup("access").With("type", "composite"),
+	}
+	for _, bc := range composite.Backends {
+		b := &Backend{Name: bc.Name, Type: bc.Type, Deny: bc.Deny}
+		switch bc.Type {
+		case "csv":
+			folder := bc.Folder
+			if !filepath.IsAbs(folder) {
+				folder = filepath.Join(cfg.InstancePath, folder)
+			}
+			if err := os.MkdirAll(folder, 0755); err != nil {
+				return nil, fmt.Errorf("backend %s: unable to create access list folder: %w", bc.Name, err)
+			}
+			if list := newFolderAccessList(folder, cfg); list != nil {
+				b.List = list
+			}
+		case "ldap":
+			list, err := NewLDAPAccessList(cfg.LDAP)
+			if err != nil {
+				return nil, fmt.Errorf("backend %s: %w", bc.Name, err)
+			}
+			b.List = list
+		case "scim":
+			list, err := NewDirectoryAccessList(provider, cfg.SCIM)
+			if err != nil {
+				return nil, fmt.Errorf("backend %s: %w", bc.Name, err)
+			}
+			b.List = list
+		}
+		if b.List == nil {
+			return nil, fmt.Errorf("backend %s: failed to load access list", bc.Name)
+		}
+
+		// Changes in any backend change the combined entries
+		if w, ok := b.List.(Watcher); ok {
+			w.OnReload(func(entries []EntryRecord) {
+				c.reloaded(b)
+			})
+		}
+		c.backends = append(c.backends, b)
+	}
+
+	c.logger.Info("Composite access list initialized", "merge", c.merge, "backends", len(c.backends))
+	return c, nil
+}
+
+// Backends returns the backends in query order.
+func (c *CompositeAccessList) Backends() []*Backend {
+	return slices.Clone(c.backends)
+}
+
+// Find queries the backends in order. Deny backends are always queried, and
+// their errors fail the lookup. Errors of allow backends are only returned if
+// no other backend lists the user.
+func (c *CompositeAccessList) Find(UserID string) (EntryRecord, error) {
+	entry := &CompositeEntry{UserID: UserID}
+	var errs []error
+	for _, b := range c.backends {
+		if !b.Deny && c.merge == MERGE_FIRST && len(entry.Records) > 0 {
+			continue
+		}
+		record, err := b.List.Find(UserID)
+		if err != nil {
+			if b.Deny {
+				return nil, fmt.Errorf("deny backend %s: %w", b.Name, err)
+			}
+			c.logger.Warn("Access list backend lookup failed", "backend", b.Name, "user_id", UserID, "error", err)
+			errs = append(errs, fmt.Errorf("backend %s: %w", b.Name, err))
+			continue
+		}
+		if record == nil {
+			continue
+		}
+		entry.add(b, record, c.merge)
+	}
+
+	// Users only in deny backends are not in the access list
+	if len(entry.Records) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	entry.UserID = entry.Records[0].GetUserID()
+	return entry, nil
+}
+
+// ListAllEntries combines the entries of all backends. Any backend failing
+// fails the listing, so a backend being down doesn't look like its users were removed.
+func (c *CompositeAccessList) ListAllEntries() ([]EntryRecord, error) {
+	index := map[string]*CompositeEntry{}
+	var entries []*CompositeEntry
+	for _, b := range c.backends {
+		records, err := b.List.ListAllEntries()
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %w", b.Name, err)
+		}
+		for _, record := range records {
+			key := NormalizeEmail(record.GetUserID())
+			entry, ok := index[key]
+			if !ok {
+				entry = &CompositeEntry{UserID: record.GetUserID()}
+				index[key] = entry
+				entries = append(entries, entry)
+			}
+			entry.add(b, record, c.merge)
+		}
+	}
+
+	records := make([]EntryRecord, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry.Records) == 0 {
+			continue
+		}
+		entry.UserID = entry.Records[0].GetUserID()
+		records = append(records, entry)
+	}
+	return records, nil
+}
+
+// DenyList is implemented by access lists denying users, e.g. bans
+type DenyList interface {
+	// DeniedUsers returns the normalised IDs of users denied any entry.
+	DeniedUsers() ([]string, error)
+}
+
+// DeniedUsers returns the users active in a deny backend, also the ones listed
+// in no allow backend.
+func (c *CompositeAccessList) DeniedUsers() ([]string, error) {
+	var denied []string
+	for _, b := range c.backends {
+		if !b.Deny {
+			continue
+		}
+		records, err := b.List.ListAllEntries()
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %w", b.Name, err)
+		}
+		for _, record := range records {
+			if key := NormalizeEmail(record.GetUserID()); record.CanAccess("") && !slices.Contains(denied, key) {
+				denied = append(denied, key)
+			}
+		}
+	}
+	return denied, nil
+}
+
+// reloaded recombines the entries after the backend has reloaded.
+func (c *CompositeAccessList) reloaded(b *Backend) {
+	entries, err := c.ListAllEntries()
+	if err != nil {
+		c.logger.Error("Failed to combine access list backends", "backend", b.Name, "error", err)
+		return
+	}
+	c.logger.Debug("Access list backend reloaded", "backend", b.Name, "users", len(entries))
+
+	c.mu.Lock()
+	callbacks := slices.Clone(c.onReload)
+	c.mu.Unlock()
+	for _, cb := range callbacks {
+		cb(slices.Clone(entries))
+	}
+}
+
+func (c *CompositeAccessList) OnReload(fn func(entries []EntryRecord)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReload = append(c.onReload, fn)
+}
+
+// Watch watches the backends that reload, until the context is cancelled.
+func (c *CompositeAccessList) Watch(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, b := range c.backends {
+		w, ok := b.List.(Watcher)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Watch(ctx); err != nil {
+				c.logger.Error("Access list backend watcher stopped", "backend", b.Name, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Invalidate tells the backends provisioned over SCIM that the directory has changed.
+func (c *CompositeAccessList) Invalidate() {
+	for _, b := range c.backends {
+		if inv, ok := b.List.(Invalidator); ok {
+			inv.Invalidate()
+		}
+	}
+}
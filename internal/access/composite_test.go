@@ -0,0 +1,170 @@
+package access
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	. "entry-access-control/internal/config"
+)
+
+func newTestCSVBackend(t *testing.T, name string, deny bool, rows ...string) *Backend {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name+".csv")
+	writeAccessList(t, path, rows...)
+	list := NewCSVAccessList()
+	if err := list.Reload([]string{path}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	return &Backend{Name: name, Type: "csv", Deny: deny, List: list}
+}
+
+func TestCompositeAccessList(t *testing.T) {
+	manual := newTestCSVBackend(t, "manual", false,
+		"guest@example.com\tActive - Attending",
+		"dropped@example.com\tActive - Attending",
+	)
+	// Roles from the source grant of the file
+	manual.List.(*CSVAccessList).update(func(files map[string]*CSVFile) {
+		for _, f := range files {
+			f.Grant.Roles = []string{"guest"}
+		}
+	})
+	sisu := newTestCSVBackend(t, "sisu", false,
+		"student@example.com\tActive - Attending",
+		"guest@example.com\tActive - Attending",
+		"dropped@example.com\tPassive",
+		"banned@example.com\tActive - Attending",
+	)
+	banned := newTestCSVBackend(t, "banned", true,
+		"banned@example.com\tActive - Attending",
+		"outsider@example.com\tActive - Attending",
+	)
+	backends := []*Backend{manual, sisu, banned}
+
+	tests := []struct {
+		merge, user string
+		backend     string
+		canAccess   bool
+		roles       []string
+	}{
+		{MERGE_FIRST, "student@example.com", "sisu", true, []string{"student"}},
+		{MERGE_FIRST, "guest@example.com", "manual", true, []string{"guest"}},
+		{MERGE_FIRST, "dropped@example.com", "manual", true, []string{"guest"}},
+		{MERGE_FIRST, "banned@example.com", "sisu", false, []string{}},
+		{MERGE_UNION, "guest@example.com", "manual", true, []string{"guest", "student"}},
+		{MERGE_UNION, "dropped@example.com", "manual", true, []string{"guest"}},
+		{MERGE_UNION, "banned@example.com", "sisu", false, []string{}},
+	}
+	for _, tt := range tests {
+		list := &CompositeAccessList{merge: tt.merge, backends: backends}
+		record, err := list.Find(tt.user)
+		if err != nil || record == nil {
+			t.Fatalf("%s: Find(%q) = %v, %v", tt.merge, tt.user, record, err)
+		}
+		entry := record.(*CompositeEntry)
+		if entry.GetBackend() != tt.backend {
+			t.Errorf("%s: %s backend = %q, want %q", tt.merge, tt.user, entry.GetBackend(), tt.backend)
+		}
+		if entry.CanAccess("") != tt.canAccess {
+			t.Errorf("%s: %s CanAccess = %v, want %v", tt.merge, tt.user, entry.CanAccess(""), tt.canAccess)
+		}
+		roles := entry.GetUserRoles()
+		slices.Sort(roles)
+		if !slices.Equal(roles, tt.roles) {
+			t.Errorf("%s: %s roles = %v, want %v", tt.merge, tt.user, roles, tt.roles)
+		}
+	}
+
+	list := &CompositeAccessList{merge: MERGE_FIRST, backends: backends}
+	if record, err := list.Find("outsider@example.com"); record != nil || err != nil {
+		t.Errorf("Find of user only in deny list = %v, %v, want nil", record, err)
+	}
+	if denied := list.mustFind(t, "banned@example.com").DeniedBy("3"); denied != "banned" {
+		t.Errorf("DeniedBy = %q, want banned", denied)
+	}
+
+	entries, err := list.ListAllEntries()
+	if err != nil {
+		t.Fatalf("ListAllEntries failed: %v", err)
+	}
+	var users []string
+	for _, e := range entries {
+		users = append(users, e.GetUserID()+"@"+e.(BackendEntry).GetBackend())
+	}
+	want := []string{"guest@example.com@manual", "dropped@example.com@manual", "student@example.com@sisu", "banned@example.com@sisu"}
+	if !slices.Equal(users, want) {
+		t.Errorf("ListAllEntries = %v, want %v", users, want)
+	}
+
+	// Denied users lose the roles of the policy too
+	denied, err := list.DeniedUsers()
+	if err != nil || !slices.Equal(denied, []string{"banned@example.com", "outsider@example.com"}) {
+		t.Errorf("DeniedUsers = %v, %v, want banned and outsider", denied, err)
+	}
+	rbac := &RBAC{policy: &RBACPolicy{
+		DefaultRole: "guest",
+		Users: map[string]struct {
+			Roles []string `yaml:"roles"`
+		}{"banned@example.com": {Roles: []string{"admin"}}},
+	}, userRoles: map[string][]string{}, policyCache: map[string]map[string]bool{}}
+	rbac.SetDeniedUsers(denied)
+	rbac.SyncUserRoles(entries)
+	for _, user := range []string{"banned@example.com", "outsider@example.com"} {
+		if roles := rbac.GetUserRoles(user); len(roles) != 0 {
+			t.Errorf("roles of denied %s = %v, want none", user, roles)
+		}
+	}
+}
+
+func (c *CompositeAccessList) mustFind(t *testing.T, userID string) *CompositeEntry {
+	t.Helper()
+	record, err := c.Find(userID)
+	if err != nil || record == nil {
+		t.Fatalf("Find(%q) = %v, %v", userID, record, err)
+	}
+	return record.(*CompositeEntry)
+}
+
+func TestLoadCompositeConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		yaml string
+		ok   bool
+	}{
+		{"backends:\n  - type: csv\n    folder: sisu\n  - name: banned\n    type: csv\n    folder: banned\n    deny: true\n", true},
+		{"merge: union\nbackends:\n  - type: ldap\n", true},
+		{"merge: all\nbackends:\n  - type: ldap\n", false},
+		{"backends:\n  - type: csv\n", false},
+		{"backends:\n  - type: ldap\n  - type: ldap\n", false},
+		{"backends:\n  - type: sql\n", false},
+		{"backends:\n  - type: ldap\n    deny: true\n", false},
+	}
+	for i, tt := range tests {
+		file := filepath.Join(dir, "backends.yaml")
+		if err := os.WriteFile(file, []byte(tt.yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadCompositeConfig(file)
+		if (err == nil) != tt.ok {
+			t.Errorf("case %d: LoadCompositeConfig error = %v, want ok %v", i+1, err, tt.ok)
+			continue
+		}
+		if err == nil && cfg.Merge == "" {
+			t.Errorf("case %d: merge not defaulted", i+1)
+		}
+	}
+
+	// Folders of csv backends are in the instance folder
+	instance := t.TempDir()
+	file := filepath.Join(instance, "backends.yaml")
+	os.WriteFile(file, []byte("backends:\n  - name: manual\n    type: csv\n    folder: lists/manual\n"), 0644)
+	list, err := NewCompositeAccessList(&Config{InstancePath: instance, AccessListBackends: file}, nil)
+	if err != nil {
+		t.Fatalf("NewCompositeAccessList failed: %v", err)
+	}
+	if folder := list.Backends()[0].List.(*CSVAccessList).Folder(); folder != filepath.Join(instance, "lists/manual") {
+		t.Errorf("backend folder = %q", folder)
+	}
+}
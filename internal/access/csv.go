@@ -1,7 +1,5 @@
 package access
 
-// TODO: Implement Close() method to close opened CSV files
-
 // Usage:
 //  accessList := access.NewAccessList("csv")
 //  user, _ := accessList.Find("user@example.com")
@@ -10,72 +8,84 @@ package access
 //  }
 
 import (
-	"encoding/csv"
+	"context"
 	. "entry-access-control/internal/config"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"golang.org/x/text/encoding/unicode"
-	"golang.org/x/text/transform"
+	"github.com/fsnotify/fsnotify"
 )
 
 // CSV based access control
 
-// Definition of fields in CSV access list
-type CSVListDefinition struct {
-	EmailField  string
-	StatusField string
-
-	ActiveStatus string
-
-	Language string // Language code, e.g. "en", "fi"
-}
-
-// Known field names in CSV access lists, in different languages
-// Note: The actual values should be filled in according to the specific CSV formats used. Funidata might change these nillywilly
-var CSVListDefinitions = []CSVListDefinition{
-	// English student list definition
-	{
-		EmailField:   "PRIMARY E-MAIL",
-		StatusField:  "STUDY RIGHT STATUS",
-		ActiveStatus: "Active - Attending",
-		Language:     "en",
-	},
-	// Finnish student list definition
-	{
-		EmailField:   "ENSISIJAINEN SÄHKÖPOSTI",
-		StatusField:  "ILMOITTAUTUMISEN TILA",
-		ActiveStatus: "Vahvistettu",
-		Language:     "fi",
-	},
-	// Missing: Swedish student list definition
-}
-
 type EntryRecord interface {
 	GetUserID() string
 	GetUserRoles() []string
 	CanAccess(EntryID string) bool
 }
 
+// ScopedEntry is implemented by entries with roles limited to some entries
+type ScopedEntry interface {
+	// GetRoleEntries returns the IDs of entries each role applies to. Roles
+	// not in it apply to all entries.
+	GetRoleEntries() map[string][]string
+}
+
+// GrantedEntry is implemented by entries that know which source grants them access
+type GrantedEntry interface {
+	GetSource() string
+	// GetValidUntil returns when the access ends, or nil if it doesn't.
+	GetValidUntil() *time.Time
+}
+
 type StudentEntry struct {
 	UserID string
 	Email  string
+	Name   string
 	Roles  []string
 	Status bool
+	// Status value in the file, e.g. "Active - Attending". Empty without a status column.
+	RawStatus string
+	// IDs of entries the user can access, nil for all
+	Entries []string
+	// IDs of entries each role applies to. Roles not in it apply to all entries.
+	RoleEntries map[string][]string
+	// Access list file granting the access
+	Source     string
+	ValidUntil *time.Time
+}
+
+func (s *StudentEntry) GetSource() string {
+	return s.Source
+}
+
+func (s *StudentEntry) GetValidUntil() *time.Time {
+	return s.ValidUntil
+}
+
+func (s *StudentEntry) GetRoleEntries() map[string][]string {
+	return s.RoleEntries
 }
 
 func (s *StudentEntry) GetUserID() string {
 	return s.UserID
 }
 
+// CanAccess reports whether the user is active and can access the entry. Empty EntryID checks any entry.
 func (s *StudentEntry) CanAccess(EntryID string) bool {
-	// Check if the student is active
-	return s.Status
+	if !s.Status {
+		return false
+	}
+	return EntryID == "" || s.Entries == nil || slices.Contains(s.Entries, EntryID)
 }
 
 func (s *StudentEntry) GetUserRoles() []string {
@@ -96,230 +106,518 @@ type AccessList interface {
 	ListAllEntries() ([]EntryRecord, error)
 }
 
+// Watcher is implemented by access lists that reload when their source changes.
+type Watcher interface {
+	// OnReload registers a function called with the new entries after each reload.
+	OnReload(fn func(entries []EntryRecord))
+	// Watch reloads the access list on changes, until the context is cancelled.
+	Watch(ctx context.Context) error
+}
+
 func NewAccessList(typ string, cfg *Config) AccessList {
 	switch typ {
 	case "csv":
-		_logger := slog.Default().WithGroup("access").With("type", "csv")
-		files, err := getLists(cfg)
-		if err != nil {
-			_logger.Error("getLists failed", "error", err)
+		accessList := newFolderAccessList(cfg.AccessListFolder, cfg)
+		if accessList == nil {
 			return nil
 		}
-		if len(files) < 1 {
-			_logger.Warn("Expected one or more CSV files", "folder", cfg.AccessListFolder)
-		}
-
-		accessList := NewCSVAccessList()
-		for _, file := range files {
-			_logger.Debug("Adding CSV file", "file", file)
-			err := accessList.AddFile(file)
-			if err != nil {
-				_logger.Error("AddFile failed", "file", file, "error", err)
-				return nil
-			}
-		}
-
-		// For debug, print number of entries
-		accessList.mu.RLock()
-		numReaders := len(accessList.csvReaders)
-		_logger.Info("CSV access list initialized", "num_files", numReaders)
-		for file, reader := range accessList.csvReaders {
-			_logger.Info("CSV file loaded", "file", file, "language", reader.FieldDefinitions.Language)
+		return accessList
+	case "ldap":
+		accessList, err := NewLDAPAccessList(cfg.LDAP)
+		if err != nil {
+			slog.Error("Initializing LDAP access list failed", "error", err)
+			return nil
 		}
-		accessList.mu.RUnlock()
 		return accessList
 	default:
 		return nil
 	}
 }
 
+// newFolderAccessList loads the access list files in the folder. Returns nil,
+// and logs the reason, if the folder or definitions can't be loaded.
+func newFolderAccessList(folder string, cfg *Config) *CSVAccessList {
+	_logger := slog.Default().WithGroup("access").With("type", "csv")
+	files, err := listFiles(folder)
+	if err != nil {
+		_logger.Error("Listing access list files failed", "error", err, "folder", folder)
+		return nil
+	}
+	if len(files) < 1 {
+		_logger.Warn("Expected one or more CSV files", "folder", folder)
+	}
+
+	definitions, err := LoadListDefinitions(cfg.AccessListColumns)
+	if err != nil {
+		_logger.Error("Loading access list definitions failed", "error", err, "file", cfg.AccessListColumns)
+		return nil
+	}
+
+	sources, err := LoadSources(cfg.AccessListSources)
+	if err != nil {
+		_logger.Error("Loading access list sources failed", "error", err, "file", cfg.AccessListSources)
+		return nil
+	}
+
+	accessList := NewCSVAccessList()
+	accessList.folder = folder
+	accessList.definitions = definitions
+	accessList.rules = sources.Sources
+	accessList.rowRoles = sources.RowRoles
+	if err := accessList.Reload(files); err != nil {
+		_logger.Error("Loading CSV access list failed", "error", err)
+		return nil
+	}
+
+	snap := accessList.snapshot.Load()
+	_logger.Info("CSV access list initialized", "num_files", len(snap.files))
+	for file, f := range snap.files {
+		_logger.Info("CSV file loaded", "file", file, "definition", f.FieldDefinitions.Name, "rows", len(f.Entries), "valid", f.Metadata.ValidAt(time.Now()), "roles", f.Grant.Roles, "entries", f.Grant.Entries)
+	}
+	return accessList
+}
+
+// CSVFile is a parsed access list file
 type CSVFile struct {
+	// Format of the file, e.g. "csv" or "xlsx"
+	Format           string
 	FieldDefinitions CSVListDefinition
 	HeaderMap        map[string]int
-	*csv.Reader
+	Entries          []*StudentEntry
+	// Roles and entries for the users, from metadata or source rules
+	Grant SourceGrant
+	// Role column values not allowed by the sources file, see FilterRowRoles
+	RejectedRoles []string
+	// Rows skipped for missing columns or email
+	Skipped int
+	// Sidecar metadata, nil if the file has none
+	Metadata *FileMetadata
 }
 
-type CSVAccessList struct {
-	// Lock
-	mu sync.RWMutex
-	// Map of CSV readers, one per file
-	csvReaders map[string]*CSVFile
+// csvSnapshot is an immutable view of the loaded CSV files, indexed by
+// normalised email. A new snapshot is built on every change, and when a file
+// validity window opens or closes.
+type csvSnapshot struct {
+	files   map[string]*CSVFile
+	index   map[string]*StudentEntry
+	entries []EntryRecord
+	// Next time a file validity window opens or closes, nil if none
+	nextChange *time.Time
 }
 
-// From entry lists, find if student with UserID exists
-func (s *CSVAccessList) Find(UserID string) (EntryRecord, error) {
-	slog.Debug("Finding user in CSV access list", "user_id", UserID)
+// laterUntil reports whether access until a lasts longer than until b. nil is forever.
+func laterUntil(a, b *time.Time) bool {
+	return b != nil && (a == nil || a.After(*b))
+}
 
-	entries, err := s.ListAllEntries()
+// newCSVSnapshot indexes the files valid at the time. Users listed in several
+// files are merged, and are active if active in any of them, see mergeEntry.
+func newCSVSnapshot(files map[string]*CSVFile, now time.Time) *csvSnapshot {
+	snap := &csvSnapshot{
+		files: files,
+		index: make(map[string]*StudentEntry),
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("error listing all entries: %w", err)
+	// Sort for stable entry order
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
 	}
+	slices.Sort(paths)
 
-	for _, user := range entries {
-		if strings.EqualFold(user.GetUserID(), UserID) {
-			slog.Debug("User found in CSV access list", "user_id", UserID)
-			return user, nil
+	for _, path := range paths {
+		file := files[path]
+		if next := file.Metadata.NextChange(now); next != nil && (snap.nextChange == nil || next.Before(*snap.nextChange)) {
+			snap.nextChange = next
+		}
+		if !file.Metadata.ValidAt(now) {
+			continue
 		}
-	}
-	slog.Debug("User not found in CSV access list", "user_id", UserID)
-	return nil, nil
-}
 
-// List all entries from all CSV files
-func (s *CSVAccessList) ListAllEntries() ([]EntryRecord, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	var entries []EntryRecord
-	for _, reader := range s.csvReaders {
-		for {
-			record, err := reader.Read()
-			if err == io.EOF {
-				break
+		var validUntil *time.Time
+		if file.Metadata != nil {
+			validUntil = file.Metadata.ValidUntil
+		}
+
+		for _, e := range file.Entries {
+			// Copy, so entries shared with the previous snapshot are not modified
+			entry := *e
+			entry.Source = path
+			entry.ValidUntil = validUntil
+			entry.Entries = file.Grant.Entries
+			if len(entry.Roles) == 0 {
+				entry.Roles = file.Grant.Roles
 			}
-			if err != nil {
-				return nil, fmt.Errorf("error reading CSV: %w", err)
+			// Roles of files granting some entries only apply to them
+			entry.RoleEntries = nil
+			if entry.Status && file.Grant.Entries != nil {
+				entry.RoleEntries = make(map[string][]string)
+				for _, role := range entry.GetUserRoles() {
+					entry.RoleEntries[role] = file.Grant.Entries
+				}
 			}
-			if len(record) <= 0 {
+
+			key := NormalizeEmail(e.Email)
+			if existing, ok := snap.index[key]; ok {
+				mergeEntry(existing, &entry)
 				continue
 			}
+			snap.index[key] = &entry
+			snap.entries = append(snap.entries, &entry)
+		}
+	}
+	return snap
+}
 
-			for i, field := range record {
-				if i == reader.HeaderMap[reader.FieldDefinitions.EmailField] {
-					// Check that the email field is not empty
-					if strings.TrimSpace(field) == "" {
-						continue
-					}
+// Debounce for file change events. Exports are often written in several chunks.
+const CSV_RELOAD_DEBOUNCE = 2 * time.Second
 
-					// Found the entry, check status if applicable
-					status := false
-					if reader.HeaderMap[reader.FieldDefinitions.StatusField] != -1 {
-						status = strings.TrimSpace(record[reader.HeaderMap[reader.FieldDefinitions.StatusField]]) == reader.FieldDefinitions.ActiveStatus
-						slog.Debug("Found entry in CSV", slog.String("email", field), slog.Bool("status", status), slog.String("status_field", record[reader.HeaderMap[reader.FieldDefinitions.StatusField]]))
-					}
-					entry := &StudentEntry{
-						UserID: field,
-						Email:  field,
-						Status: status,
-					}
-					entries = append(entries, entry)
-				}
+type CSVAccessList struct {
+	// Serialises snapshot updates. Readers load the snapshot without locking.
+	mu       sync.Mutex
+	snapshot atomic.Pointer[csvSnapshot]
+	// Folder scanned for CSV files on reload
+	folder string
+	// Column definitions tried for each file
+	definitions []CSVListDefinition
+	// Rules granting roles and entries to files
+	rules []SourceRule
+	// Roles rows can have in the role column
+	rowRoles []string
+
+	onReload []func(entries []EntryRecord)
+	logger   *slog.Logger
+}
+
+// From entry lists, find if student with UserID, or its alias, exists. Returns nil if not found.
+func (s *CSVAccessList) Find(UserID string) (EntryRecord, error) {
+	snap := s.snapshot.Load()
+	entry, ok := snap.index[CanonicalID(UserID)]
+	if !ok {
+		// Aliases still listed in files, e.g. an old address
+		entry, ok = snap.index[NormalizeEmail(UserID)]
+	}
+	if !ok {
+		slog.Debug("User not found in CSV access list", "user_id", UserID)
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// List all entries from all CSV files
+func (s *CSVAccessList) ListAllEntries() ([]EntryRecord, error) {
+	return slices.Clone(s.snapshot.Load().entries), nil
+}
+
+func (s *CSVAccessList) OnReload(fn func(entries []EntryRecord)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onReload = append(s.onReload, fn)
+}
+
+// update builds a new snapshot from the files modified by fn, and swaps it in.
+func (s *CSVAccessList) update(fn func(files map[string]*CSVFile)) *csvSnapshot {
+	s.mu.Lock()
+	files := make(map[string]*CSVFile)
+	for path, f := range s.snapshot.Load().files {
+		files[path] = f
+	}
+	fn(files)
+	snap := newCSVSnapshot(files, time.Now())
+	s.snapshot.Store(snap)
+	callbacks := slices.Clone(s.onReload)
+	s.mu.Unlock()
+
+	for _, cb := range callbacks {
+		cb(slices.Clone(snap.entries))
+	}
+	return snap
+}
+
+func (s *CSVAccessList) RemoveFile(csvFile string) error {
+	s.update(func(files map[string]*CSVFile) {
+		delete(files, csvFile)
+	})
+	return nil
+}
+
+// Read CSV file and add entries to access list.
+func (c *CSVAccessList) AddFile(csvFile string) error {
+	f, err := c.parseFile(csvFile)
+	if err != nil {
+		return err
+	}
+	c.update(func(files map[string]*CSVFile) {
+		files[csvFile] = f
+	})
+	return nil
+}
+
+// Reload replaces the loaded files with the given ones. Files failing to parse
+// keep their previously loaded version, as they may be only partially written.
+func (s *CSVAccessList) Reload(csvFiles []string) error {
+	start := time.Now()
+
+	var errs []error
+	parsed := make(map[string]*CSVFile, len(csvFiles))
+	for _, path := range csvFiles {
+		f, err := s.parseFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		parsed[path] = f
+	}
+
+	snap := s.update(func(files map[string]*CSVFile) {
+		for path := range files {
+			if !slices.Contains(csvFiles, path) {
+				delete(files, path)
 			}
 		}
+		for path, f := range parsed {
+			files[path] = f
+		}
+	})
+
+	active := 0
+	for _, e := range snap.index {
+		if e.Status {
+			active++
+		}
+	}
+	outOfWindow := 0
+	for _, f := range snap.files {
+		if !f.Metadata.ValidAt(start) {
+			outOfWindow++
+		}
 	}
-	return entries, nil
+	s.logger.Info("CSV access list loaded",
+		"files", len(snap.files),
+		"out_of_window", outOfWindow,
+		"users", len(snap.index),
+		"active", active,
+		"errors", len(errs),
+		"duration", time.Since(start),
+	)
+
+	return errors.Join(errs...)
 }
 
-func (s *CSVAccessList) RemoveFile(csvFile string) error {
-	for i, reader := range s.csvReaders {
-		if reader == nil {
+// withLoadedFiles adds the loaded files still in the folder, but not
+// recognised any more, e.g. while being written. They fail to parse, and
+// keep their last good version until replaced or removed.
+func (s *CSVAccessList) withLoadedFiles(files []string) []string {
+	for path := range s.snapshot.Load().files {
+		if slices.Contains(files, path) {
 			continue
 		}
-		// Remove the reader from the map
-		if i == csvFile {
-			delete(s.csvReaders, i)
-			return nil
+		if _, err := os.Stat(path); err == nil {
+			s.logger.Warn("Access list file not recognised, keeping the loaded version", "file", path)
+			files = append(files, path)
 		}
 	}
-	return nil
+	return files
 }
 
-// Read CSV file and add entries to access list.
-func (c *CSVAccessList) AddFile(csvFile string) error {
-	f, err := os.Open(csvFile)
+// Watch reloads the access list when CSV files in the folder change.
+func (s *CSVAccessList) Watch(ctx context.Context) error {
+	if s.folder == "" {
+		return fmt.Errorf("no access list folder to watch")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		return fmt.Errorf("failed to open CSV file: %w", err)
-	}
-	//TODO: Close file when destructor called
-
-	// Detect BOM and decode UTF-16 if present. SISU exports UTF-16 with BOM.
-	bom := make([]byte, 2)
-	n, err := f.Read(bom)
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("failed to read BOM: %w", err)
-	}
-
-	var reader *csv.Reader
-	if n == 2 && (bom[0] == 0xFE && bom[1] == 0xFF || bom[0] == 0xFF && bom[1] == 0xFE) {
-		// UTF-16 BOM detected
-		utf16bom := unicode.BOMOverride(unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder())
-		utf16Reader := transform.NewReader(io.MultiReader(
-			// Prepend BOM bytes back to stream
-			strings.NewReader(string(bom)),
-			f,
-		), utf16bom)
-		reader = csv.NewReader(utf16Reader)
-	} else {
-		// No BOM, assume sensible UTF-8
-		_, err := f.Seek(0, io.SeekStart)
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	// Files are searched recursively, so watch the subfolders too
+	err = filepath.WalkDir(s.folder, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return fmt.Errorf("failed to seek file: %w", err)
+			return err
+		}
+		if d.IsDir() {
+			return watcher.Add(path)
 		}
-		reader = csv.NewReader(f)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch access list folder: %w", err)
 	}
+	s.logger.Info("Watching access list folder for changes", "folder", s.folder)
+
+	var reload <-chan time.Time
+	for {
+		// Rebuild the snapshot when a file validity window opens or closes
+		var windowChange <-chan time.Time
+		if next := s.snapshot.Load().nextChange; next != nil {
+			windowChange = time.After(time.Until(*next))
+		}
 
-	// Set reader options for tab-delimited, quoted fields
-	reader.Comma = '\t'
-	reader.LazyQuotes = true
-	//reader.FieldsPerRecord = -1
-	reader.FieldsPerRecord = 0
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if err := watcher.Add(ev.Name); err != nil {
+						s.logger.Warn("Failed to watch access list subfolder", "folder", ev.Name, "error", err)
+					}
+				}
+			}
+			if ev.Has(fsnotify.Chmod) {
+				continue
+			}
+			s.logger.Debug("Access list folder changed", "file", ev.Name, "op", ev.Op.String())
+			reload = time.After(CSV_RELOAD_DEBOUNCE)
 
-	// Read header
-	headers, err := reader.Read()
-	if err != nil {
-		return fmt.Errorf("failed to read CSV header: %w", err)
-	}
-
-	// Find index of relevant fields
-	var idxStatus, idxEmail int = -1, -1
-	var langdef CSVListDefinition
-	var csvHeaders = make(map[string]int)
-
-	for _, langdef = range CSVListDefinitions {
-		// Reset indexes for each definition
-		idxStatus, idxEmail = -1, -1
-
-		// Search for fields in header
-		for i, h := range headers {
-			csvHeaders[strings.TrimSpace(h)] = i
-			switch strings.TrimSpace(h) {
-			case langdef.StatusField:
-				idxStatus = i
-			case langdef.EmailField:
-				idxEmail = i
+		case <-reload:
+			reload = nil
+			files, err := listFiles(s.folder)
+			if err != nil {
+				s.logger.Error("Failed to scan access list folder", "error", err)
+				continue
 			}
-		}
-		if idxStatus != -1 && idxEmail != -1 {
-			// Found a matching definition
-			break
+			files = s.withLoadedFiles(files)
+			if err := s.Reload(files); err != nil {
+				s.logger.Error("Access list reload had errors", "error", err)
+			}
+
+		case <-windowChange:
+			s.logger.Info("Access list file validity changed")
+			s.update(func(files map[string]*CSVFile) {})
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			s.logger.Warn("Access list watcher error", "error", err)
+
+		case <-ctx.Done():
+			return nil
 		}
 	}
-	if idxStatus == -1 || idxEmail == -1 {
-		return fmt.Errorf("CSV file missing required fields")
+}
+
+// ParseCSVFile reads the whole file into memory, and parses it with the parser
+// sniffed from the content. Tabular files use the first matching definition,
+// *NoDefinitionError is returned if none match.
+func ParseCSVFile(csvFile string, definitions []CSVListDefinition) (*CSVFile, error) {
+	data, err := os.ReadFile(csvFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %w", err)
 	}
 
-	// Store the reader in the map
-	if c.csvReaders == nil {
-		c.csvReaders = make(map[string]*CSVFile)
+	file, err := ParseCSVData(data, definitions)
+	if err != nil {
+		return nil, err
 	}
-	c.csvReaders[csvFile] = &CSVFile{
-		FieldDefinitions: langdef,
-		HeaderMap:        csvHeaders,
-		Reader:           reader,
+
+	if file.Metadata, err = LoadFileMetadata(csvFile); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return file, nil
+}
+
+// ParseCSVData parses file content, e.g. an upload, like ParseCSVFile. Metadata is not loaded.
+func ParseCSVData(data []byte, definitions []CSVListDefinition) (*CSVFile, error) {
+	parser := sniffParser(data)
+	if parser == nil {
+		return nil, ErrUnknownListFormat
+	}
+	file, err := parser.Parse(data, definitions)
+	if err != nil {
+		return nil, err
+	}
+	file.Format = parser.Name()
+	return file, nil
+}
+
+// parseFile parses the file, and resolves its grant from the metadata and
+// source rules. Row roles not allowed by the sources file are dropped.
+func (s *CSVAccessList) parseFile(path string) (*CSVFile, error) {
+	f, err := ParseCSVFile(path, s.definitions)
+	if err != nil {
+		return nil, err
+	}
+	f.Grant = ResolveGrant(RelativePath(s.folder, path), f.Metadata, s.rules)
+	if f.FilterRowRoles(s.rowRoles); len(f.RejectedRoles) > 0 {
+		s.logger.Warn("Ignoring roles not in row_roles of the access list sources", "file", path, "roles", f.RejectedRoles)
+	}
+	return f, nil
+}
+
+// RelativePath returns the file path relative to the folder, or the path itself if it isn't under the folder.
+func RelativePath(folder, path string) string {
+	absFolder, err := filepath.Abs(folder)
+	if err != nil || folder == "" {
+		return path
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(absFolder, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
 }
 
 func NewCSVAccessList() *CSVAccessList {
-	return &CSVAccessList{}
+	s := &CSVAccessList{
+		definitions: CSVListDefinitions,
+		logger:      slog.Default().WithGroup("access").With("type", "csv"),
+	}
+	s.snapshot.Store(newCSVSnapshot(map[string]*CSVFile{}, time.Now()))
+	return s
 }
 
 // Scan folder for CSV files and return list of paths.
 func getLists(cfg *Config) ([]string, error) {
+	return listFiles(cfg.AccessListFolder)
+}
+
+// isSkippedFile reports whether the file is never read as an access list:
+// sidecar metadata, hidden files and Excel lock files.
+func isSkippedFile(name string) bool {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~$") {
+		return true
+	}
+	for _, suffix := range MetadataSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isListFile reports whether the file name is accepted for access lists
+// written through the upload API.
+func isListFile(name string) bool {
+	return !isSkippedFile(name) && slices.Contains(ListFileExtensions, filepath.Ext(strings.ToLower(name)))
+}
+
+// isListContent reports whether the content of the file is recognised as an
+// access list format, whatever the file is named.
+func isListContent(path string) bool {
+	parser, err := sniffFile(path)
+	if err != nil {
+		slog.Warn("Failed to read file in access list folder", "file", path, "error", err)
+		return false
+	}
+	return parser != nil
+}
+
+// listFiles returns paths of access list files in the folder and its
+// subfolders. Every regular file is sniffed, and files in no recognised format
+// are skipped.
+func listFiles(root string) ([]string, error) {
 	var files []string
-	root := cfg.AccessListFolder
 
 	// If path is relative, resolve using cwd
 	if !filepath.IsAbs(root) {
@@ -346,10 +644,14 @@ func getLists(cfg *Config) ([]string, error) {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && strings.HasSuffix(strings.ToLower(info.Name()), ".csv") {
-			// TODO Verify that it's a valid access list.
-			files = append(files, path)
+		if !info.Mode().IsRegular() || isSkippedFile(info.Name()) {
+			return nil
+		}
+		if !isListContent(path) {
+			slog.Debug("Skipping file not recognised as an access list", "file", path)
+			return nil
 		}
+		files = append(files, path)
 		return nil
 	})
 	if err != nil {
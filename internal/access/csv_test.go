@@ -3,8 +3,21 @@ package access
 // Test for CSV access list
 
 import (
+	"archive/zip"
+	"bytes"
 	. "entry-access-control/internal/config"
+	"entry-access-control/internal/storage"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/unicode"
 )
 
 func TestCSVAccessList_AddFile_ParsesCSV(t *testing.T) {
@@ -30,3 +43,573 @@ func TestCSVAccessList_AddFile_ParsesCSV(t *testing.T) {
 		}
 	}
 }
+
+func writeAccessList(t *testing.T, path string, rows ...string) {
+	t.Helper()
+	content := "PRIMARY E-MAIL\tSTUDY RIGHT STATUS\n"
+	for _, row := range rows {
+		content += row + "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestCSVAccessList_Reload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "students.csv")
+	writeAccessList(t, path,
+		"Matti.Meikalainen@example.com\tActive - Attending",
+		"inactive@example.com\tPassive",
+	)
+
+	accessList := NewCSVAccessList()
+	if err := accessList.Reload([]string{path}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	// Lookups must keep working after the first one
+	for range 2 {
+		user, err := accessList.Find(" matti.meikalainen@EXAMPLE.com")
+		if err != nil || user == nil {
+			t.Fatalf("Find = %v, %v; want user", user, err)
+		}
+		if !user.CanAccess("") {
+			t.Error("active user cannot access")
+		}
+		entries, _ := accessList.ListAllEntries()
+		if len(entries) != 2 {
+			t.Errorf("ListAllEntries returned %d entries, want 2", len(entries))
+		}
+	}
+
+	if user, _ := accessList.Find("nobody@example.com"); user != nil {
+		t.Errorf("Find returned %v for unknown user", user)
+	}
+
+	var reloaded []EntryRecord
+	accessList.OnReload(func(entries []EntryRecord) { reloaded = entries })
+
+	// Broken file keeps its previous version
+	if err := os.WriteFile(path, []byte("garbage\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := accessList.Reload([]string{path}); err == nil {
+		t.Error("expected error on broken file")
+	}
+	if user, _ := accessList.Find("inactive@example.com"); user == nil {
+		t.Error("previous version of broken file was dropped")
+	}
+
+	// File partially written, not recognised by the folder scan
+	if err := os.WriteFile(path, []byte("\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := listFiles(dir)
+	if files = accessList.withLoadedFiles(files); !slices.Equal(files, []string{path}) {
+		t.Errorf("files to reload = %v, want the loaded %s", files, path)
+	}
+	if err := accessList.Reload(files); err == nil {
+		t.Error("expected error on unrecognised file")
+	}
+	if user, _ := accessList.Find("inactive@example.com"); user == nil {
+		t.Error("previous version of unrecognised file was dropped")
+	}
+
+	writeAccessList(t, path, "new@example.com\tActive - Attending")
+	if err := accessList.Reload([]string{path}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if user, _ := accessList.Find("matti.meikalainen@example.com"); user != nil {
+		t.Error("removed user still found")
+	}
+	if len(reloaded) != 1 || reloaded[0].GetUserID() != "new@example.com" {
+		t.Errorf("OnReload got %v, want new@example.com", reloaded)
+	}
+}
+
+func TestCSVAccessList_WriteFile(t *testing.T) {
+	accessList := NewCSVAccessList()
+	accessList.folder = t.TempDir()
+
+	for _, rel := range []string{"../outside.csv", "/etc/passwd.csv", "notes.md", ".hidden.csv", ""} {
+		if _, err := accessList.ListPath(rel); !errors.Is(err, ErrInvalidListPath) {
+			t.Errorf("ListPath(%q) = %v, want ErrInvalidListPath", rel, err)
+		}
+	}
+	path, err := accessList.ListPath("courses/chem.csv")
+	if err != nil {
+		t.Fatalf("ListPath failed: %v", err)
+	}
+
+	if _, err := accessList.WriteFile(path, []byte("garbage\n")); !errors.Is(err, ErrInvalidListFile) {
+		t.Errorf("WriteFile of invalid file = %v, want ErrInvalidListFile", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("invalid file was written")
+	}
+
+	// Row roles are limited to the row roles of the sources file
+	accessList.rowRoles = []string{"teacher"}
+	roles := []byte(`{"users": [{"email": "new@example.com", "roles": ["teacher"]}, {"email": "me@example.com", "roles": ["admin"]}]}`)
+	if _, err := accessList.WriteFile(path, roles); !errors.Is(err, ErrInvalidListFile) || !strings.Contains(err.Error(), "admin") {
+		t.Errorf("WriteFile with admin row role = %v, want ErrInvalidListFile", err)
+	}
+
+	data := []byte("PRIMARY E-MAIL\tSTUDY RIGHT STATUS\nnew@example.com\tActive - Attending\n")
+	if _, err := accessList.WriteFile(path, data); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if user, _ := accessList.Find("new@example.com"); user == nil {
+		t.Error("written file is not loaded")
+	}
+	if files, _ := listFiles(accessList.folder); !slices.Equal(files, []string{path}) {
+		t.Errorf("folder has %v, want only %s", files, path)
+	}
+
+	if err := accessList.DeleteFile(path); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if user, _ := accessList.Find("new@example.com"); user != nil {
+		t.Error("user of deleted file still found")
+	}
+	if err := accessList.DeleteFile(path); !errors.Is(err, ErrListFileNotFound) {
+		t.Errorf("DeleteFile of missing file = %v, want ErrListFileNotFound", err)
+	}
+}
+
+func TestCSVAccessList_ValidityWindow(t *testing.T) {
+	dir := t.TempDir()
+	expired := filepath.Join(dir, "expired.csv")
+	course := filepath.Join(dir, "course.csv")
+	writeAccessList(t, expired, "old@example.com\tActive - Attending", "both@example.com\tActive - Attending")
+	writeAccessList(t, course, "both@example.com\tActive - Attending")
+
+	yesterday := time.Now().AddDate(0, 0, -1).Format(time.DateOnly)
+	nextWeek := time.Now().AddDate(0, 0, 7).Format(time.DateOnly)
+	if err := os.WriteFile(expired+".meta.yaml", []byte("valid_until: "+yesterday+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(course+".meta.json", []byte(`{"valid_until": "`+nextWeek+`", "owner": "teacher@example.com"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	accessList := NewCSVAccessList()
+	if err := accessList.Reload([]string{expired, course}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if user, _ := accessList.Find("old@example.com"); user != nil {
+		t.Error("user from expired file found")
+	}
+	user, _ := accessList.Find("both@example.com")
+	if user == nil {
+		t.Fatal("user from valid file not found")
+	}
+	granted := user.(GrantedEntry)
+	if granted.GetSource() != course {
+		t.Errorf("source = %q, want %q", granted.GetSource(), course)
+	}
+	// valid_until includes the whole day
+	if until := granted.GetValidUntil(); until == nil || until.Format(time.DateOnly) != time.Now().AddDate(0, 0, 8).Format(time.DateOnly) {
+		t.Errorf("valid until = %v, want start of the day after %s", until, nextWeek)
+	}
+	if next := accessList.snapshot.Load().nextChange; next == nil || !next.Equal(*granted.GetValidUntil()) {
+		t.Errorf("next change = %v, want end of course window", next)
+	}
+}
+
+func TestCSVAccessList_SourceGrants(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "courses"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	students := filepath.Join(dir, "students.csv")
+	teachers := filepath.Join(dir, "teachers-2026.csv")
+	chem := filepath.Join(dir, "courses", "chem-101.csv")
+	writeAccessList(t, students, "student@example.com\tActive - Attending", "both@example.com\tActive - Attending")
+	writeAccessList(t, teachers, "teacher@example.com\tActive - Attending", "both@example.com\tActive - Attending")
+	writeAccessList(t, chem, "student@example.com\tActive - Attending", "late@example.com\tCancelled")
+	// Metadata overrides the rule matching the file
+	if err := os.WriteFile(chem+".meta.yaml", []byte("entries: [5]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rulesFile := filepath.Join(dir, "sources.yaml")
+	if err := os.WriteFile(rulesFile, []byte(`
+sources:
+  - pattern: "teachers*.csv"
+    roles: [teacher]
+    entries: ["3", "4"]
+  - pattern: "courses/chem-*"
+    entries: ["3"]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := LoadSources(rulesFile)
+	if err != nil {
+		t.Fatalf("LoadSources failed: %v", err)
+	}
+
+	accessList := NewCSVAccessList()
+	accessList.folder = dir
+	accessList.rules = sources.Sources
+	if err := accessList.Reload([]string{students, teachers, chem}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	tests := []struct {
+		user    string
+		roles   []string
+		granted []string
+		denied  []string
+	}{
+		{"teacher@example.com", []string{"teacher"}, []string{"3", "4", ""}, []string{"1", "5"}},
+		// Student list grants all entries
+		{"student@example.com", []string{"student"}, []string{"1", "5"}, nil},
+		{"both@example.com", []string{"student", "teacher"}, []string{"1", "3"}, nil},
+		{"late@example.com", []string{}, nil, []string{"5", ""}},
+	}
+	for _, tt := range tests {
+		user, _ := accessList.Find(tt.user)
+		if user == nil {
+			t.Errorf("%s not found", tt.user)
+			continue
+		}
+		roles := slices.Clone(user.GetUserRoles())
+		slices.Sort(roles)
+		if !slices.Equal(roles, tt.roles) {
+			t.Errorf("%s: roles = %v, want %v", tt.user, roles, tt.roles)
+		}
+		for _, entry := range tt.granted {
+			if !user.CanAccess(entry) {
+				t.Errorf("%s: entry %q denied", tt.user, entry)
+			}
+		}
+		for _, entry := range tt.denied {
+			if user.CanAccess(entry) {
+				t.Errorf("%s: entry %q granted", tt.user, entry)
+			}
+		}
+	}
+
+	// Roles of files limited to some entries only apply to them, also when
+	// another file grants all entries
+	for user, want := range map[string]map[string][]string{
+		"teacher@example.com": {"teacher": {"3", "4"}},
+		"both@example.com":    {"teacher": {"3", "4"}},
+		"student@example.com": nil,
+	} {
+		entry, _ := accessList.Find(user)
+		if got := entry.(*StudentEntry).RoleEntries; !maps.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("%s: role entries = %v, want %v", user, got, want)
+		}
+	}
+
+	if _, err := LoadSources(filepath.Join(dir, "missing.yaml")); err != nil {
+		t.Errorf("missing sources file: %v", err)
+	}
+	if err := os.WriteFile(rulesFile, []byte("sources:\n  - roles: [teacher]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSources(rulesFile); err == nil {
+		t.Error("expected error on rule without pattern")
+	}
+}
+
+func TestCSVAccessList_RowRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, []byte(`{"users": [{"email": "teacher@example.com", "roles": ["teacher"]}, {"email": "me@example.com", "roles": ["admin", "teacher"]}, {"email": "root@example.com", "roles": ["admin"]}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Roles not in the row roles are dropped on load
+	accessList := NewCSVAccessList()
+	accessList.rowRoles = []string{"teacher"}
+	accessList.rules = []SourceRule{{Pattern: "users.json", SourceGrant: SourceGrant{Roles: []string{"guest"}}}}
+	if err := accessList.Reload([]string{path}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	for user, want := range map[string][]string{
+		"teacher@example.com": {"teacher"},
+		"me@example.com":      {"teacher"},
+		"root@example.com":    {"guest"},
+	} {
+		entry, _ := accessList.Find(user)
+		if entry == nil || !slices.Equal(entry.GetUserRoles(), want) {
+			t.Errorf("%s: entry = %+v, want roles %v", user, entry, want)
+		}
+	}
+	if f := accessList.Files()[path]; f == nil || !slices.Equal(f.RejectedRoles, []string{"admin"}) {
+		t.Errorf("rejected roles = %+v, want admin", f)
+	}
+}
+
+func TestParseCSVFile_Definitions(t *testing.T) {
+	definitions, err := ParseListDefinitions([]byte(`
+definitions:
+  - name: manual
+    delimiter: ";"
+    encoding: windows-1252
+    email_column: Email
+    status_column: Status
+    active_status: [Enrolled, Teaching]
+    role_column: Roles
+    name_column: Name
+`))
+	if err != nil {
+		t.Fatalf("ParseListDefinitions failed: %v", err)
+	}
+	definitions = append(definitions, CSVListDefinitions...)
+
+	dir := t.TempDir()
+	manual := filepath.Join(dir, "manual.csv")
+	// "Jäävi" in Windows-1252
+	content := "Name;Email;Status;Roles\nJ\xe4\xe4vi;teacher@example.com;teaching;teacher, staff\nshort;row\n;;Enrolled;\n"
+	if err := os.WriteFile(manual, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ParseCSVFile(manual, definitions)
+	if err != nil {
+		t.Fatalf("ParseCSVFile failed: %v", err)
+	}
+	if f.FieldDefinitions.Name != "manual" || len(f.Entries) != 1 || f.Skipped != 2 {
+		t.Fatalf("matched %s with %d entries and %d skipped, want manual with 1 and 2", f.FieldDefinitions.Name, len(f.Entries), f.Skipped)
+	}
+	entry := f.Entries[0]
+	if !entry.Status || entry.Name != "Jäävi" || !slices.Equal(entry.Roles, []string{"teacher", "staff"}) {
+		t.Errorf("entry = %+v, want active Jäävi with roles teacher, staff", entry)
+	}
+
+	// Swedish export, UTF-16 with BOM
+	swedish := filepath.Join(dir, "swedish.csv")
+	utf16, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String("PRIMÄR E-POST\tANMÄLNINGENS STATUS\nanna@example.com\tBekräftad\n")
+	if err := os.WriteFile(swedish, []byte(utf16), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err = ParseCSVFile(swedish, definitions)
+	if err != nil {
+		t.Fatalf("ParseCSVFile failed: %v", err)
+	}
+	if f.FieldDefinitions.Language != "sv" || len(f.Entries) != 1 || !f.Entries[0].Status {
+		t.Errorf("matched %s with %v, want sv with one active user", f.FieldDefinitions.Name, f.Entries)
+	}
+
+	// Rejected file reports every definition
+	unknown := filepath.Join(dir, "unknown.csv")
+	if err := os.WriteFile(unknown, []byte("EMAIL,STATUS\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseCSVFile(unknown, definitions)
+	var noDef *NoDefinitionError
+	if !errors.As(err, &noDef) || len(noDef.Mismatches) != len(definitions) {
+		t.Fatalf("ParseCSVFile error = %v, want NoDefinitionError for each definition", err)
+	}
+	if !strings.Contains(noDef.Mismatches[0].Reason, `"Email"`) {
+		t.Errorf("mismatch reason %q does not name the missing column", noDef.Mismatches[0].Reason)
+	}
+
+	if _, err := ParseListDefinitions([]byte("definitions:\n  - email_column: Email\n    delimiter: ab\n")); err == nil {
+		t.Error("expected error on multi-character delimiter")
+	}
+}
+
+// writeXLSX writes a minimal workbook, with the header in shared strings and rows as inline strings.
+func writeXLSX(t *testing.T, path string, header []string, rows ...[]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name, content string) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	add("xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Export" sheetId="1" r:id="rId3"/></sheets></workbook>`)
+	add("xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId3" Target="worksheets/export.xml"/></Relationships>`)
+
+	sst := `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`
+	sheet := `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData><row r="1">`
+	for i, h := range header {
+		sst += "<si><t>" + h + "</t></si>"
+		sheet += fmt.Sprintf(`<c r="%c1" t="s"><v>%d</v></c>`, 'A'+i, i)
+	}
+	sheet += "</row>"
+	for n, row := range rows {
+		sheet += fmt.Sprintf(`<row r="%d">`, n+2)
+		for i, v := range row {
+			if v != "" {
+				sheet += fmt.Sprintf(`<c r="%c%d" t="inlineStr"><is><t>%s</t></is></c>`, 'A'+i, n+2, v)
+			}
+		}
+		sheet += "</row>"
+	}
+	add("xl/sharedStrings.xml", sst+"</sst>")
+	add("xl/worksheets/export.xml", sheet+"</sheetData></worksheet>")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseCSVFile_Formats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// Excel re-save, UTF-8 with BOM. Extension does not matter.
+		"comma.txt":     "\xef\xbb\xbfFIRST NAME,PRIMARY E-MAIL,STUDY RIGHT STATUS\n\"Meikäläinen, Matti\",matti@example.com,Active - Attending\n",
+		"semicolon.csv": "ENSISIJAINEN SÄHKÖPOSTI;ILMOITTAUTUMISEN TILA\nmatti@example.com;Vahvistettu\n",
+		"users.json":    `{"users": [{"email": "matti@example.com", "roles": ["teacher"]}, {"email": "passive@example.com", "active": false}]}`,
+		// Recognised from the start of the file
+		"large.json": `[{"email": "matti@example.com"}` + strings.Repeat(`, {"email": "other@example.com"}`, LIST_SNIFF_BYTES/32) + `]`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Sisu export without an extension
+	utf16, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String("PRIMARY E-MAIL\tSTUDY RIGHT STATUS\nmatti@example.com\tActive - Attending\n")
+	if err := os.WriteFile(filepath.Join(dir, "sisu-export"), []byte(utf16), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"export.xlsx", "export.dat"} {
+		writeXLSX(t, filepath.Join(dir, name),
+			[]string{"FIRST NAME", "PRIMARY E-MAIL", "STUDY RIGHT STATUS"},
+			[]string{"", "matti@example.com", "Active - Attending"},
+		)
+	}
+	// Sidecars, lock files and content in no recognised format are not access lists
+	for name, content := range map[string]string{
+		"users.json.meta.json": "{}",
+		"~$export.xlsx":        "{}",
+		"logo.png":             "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"empty.csv":            "",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := listFiles(dir)
+	if err != nil {
+		t.Fatalf("listFiles failed: %v", err)
+	}
+	if len(paths) != 7 {
+		t.Fatalf("listFiles = %v, want 7 access lists", paths)
+	}
+
+	want := map[string]string{"large.json": "json", "comma.txt": "csv", "semicolon.csv": "csv", "users.json": "json", "export.xlsx": "xlsx", "export.dat": "xlsx", "sisu-export": "csv"}
+	for _, path := range paths {
+		f, err := ParseCSVFile(path, CSVListDefinitions)
+		if err != nil {
+			t.Errorf("%s: ParseCSVFile failed: %v", filepath.Base(path), err)
+			continue
+		}
+		if format := want[filepath.Base(path)]; f.Format != format {
+			t.Errorf("%s: format = %s, want %s", filepath.Base(path), f.Format, format)
+		}
+		if len(f.Entries) == 0 || f.Entries[0].Email != "matti@example.com" || !f.Entries[0].Status {
+			t.Errorf("%s: entries = %v, want active matti@example.com first", filepath.Base(path), f.Entries)
+		} else if f.Format != "json" && f.Entries[0].RawStatus == "" {
+			t.Errorf("%s: raw status missing", filepath.Base(path))
+		}
+	}
+
+	f, _ := ParseCSVFile(filepath.Join(dir, "users.json"), CSVListDefinitions)
+	if len(f.Entries) != 2 || f.Entries[1].Status || !slices.Equal(f.Entries[0].Roles, []string{"teacher"}) {
+		t.Errorf("JSON entries = %+v, want teacher and inactive user", f.Entries)
+	} else if f.Entries[0].RawStatus != "" || f.Entries[1].RawStatus != "false" {
+		t.Errorf("JSON raw statuses = %q, %q, want none and false", f.Entries[0].RawStatus, f.Entries[1].RawStatus)
+	}
+}
+
+func TestReadXLSXRows_Limits(t *testing.T) {
+	xlsx := func(sheet string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range map[string]string{
+			"xl/workbook.xml":          `<workbook/>`,
+			"xl/worksheets/sheet1.xml": `<worksheet><sheetData>` + sheet + `</sheetData></worksheet>`,
+		} {
+			w, _ := zw.Create(name)
+			w.Write([]byte(content))
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	rows, err := readXLSXRows(xlsx(`<row><c r="XFD1"><v>1</v></c></row>`))
+	if err != nil || len(rows[0]) != XLSX_MAX_COLUMNS {
+		t.Errorf("last column: %d cells, %v", len(rows[0]), err)
+	}
+	for _, ref := range []string{"XFE1", "AAAAAAAAAAAAAAAAAAAA1"} {
+		if _, err := readXLSXRows(xlsx(`<row><c r="` + ref + `"><v>1</v></c></row>`)); err == nil {
+			t.Errorf("cell %s accepted", ref)
+		}
+	}
+	// Every row padded to the last column
+	padded := strings.Repeat(`<row><c r="XFD1"><v>1</v></c></row>`, XLSX_MAX_CELLS/XLSX_MAX_COLUMNS+1)
+	if _, err := readXLSXRows(xlsx(padded)); err == nil {
+		t.Error("worksheet over the cell limit accepted")
+	}
+	// Compresses to a fraction of the limit
+	huge := `<row><c t="inlineStr"><is><t>` + strings.Repeat("a", XLSX_MAX_PART_SIZE) + `</t></is></c></row>`
+	if _, err := readXLSXRows(xlsx(huge)); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("oversized worksheet: %v", err)
+	}
+}
+
+func TestDiffStates(t *testing.T) {
+	entry := func(email string, active bool, source string) EntryRecord {
+		return &StudentEntry{UserID: email, Email: email, Status: active, Source: source}
+	}
+	old := EntryStates([]EntryRecord{
+		entry("kept@example.com", true, "a.csv"),
+		entry("gone@example.com", true, "a.csv"),
+		entry("ended@example.com", true, "a.csv"),
+		entry("back@example.com", false, "a.csv"),
+	})
+	new := EntryStates([]EntryRecord{
+		entry("Kept@example.com", true, "b.csv"),
+		entry("ended@example.com", false, "b.csv"),
+		entry("back@example.com", true, "b.csv"),
+		// Listed twice, the active row wins
+		entry("new@example.com", true, "b.csv"),
+		entry("new@example.com", false, "c.csv"),
+	})
+
+	got := DiffStates(old, new)
+	want := []struct {
+		user, change, source string
+	}{
+		{"back@example.com", storage.AccessChangeActivated, "b.csv"},
+		{"ended@example.com", storage.AccessChangeDeactivated, "a.csv"},
+		{"gone@example.com", storage.AccessChangeRemoved, "a.csv"},
+		{"kept@example.com", storage.AccessChangeMoved, "b.csv"},
+		{"new@example.com", storage.AccessChangeAdded, "b.csv"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("DiffStates = %+v, want %d changes", got, len(want))
+	}
+	for i, w := range want {
+		if got[i].UserID != w.user || got[i].Change != w.change || got[i].Source != w.source {
+			t.Errorf("change %d = %s %s from %s, want %s %s from %s",
+				i, got[i].UserID, got[i].Change, got[i].Source, w.user, w.change, w.source)
+		}
+	}
+	if !got[3].Active || !got[4].Active {
+		t.Errorf("moved or added user is inactive, want active")
+	}
+
+	// History of the new states has no changes
+	var latest []storage.AccessChange
+	for user, s := range new {
+		latest = append(latest, storage.AccessChange{UserID: user, Change: storage.AccessChangeAdded, Active: s.Active, Source: s.Source})
+	}
+	if changes := DiffStates(StatesFromHistory(latest), new); len(changes) != 0 {
+		t.Errorf("DiffStates from history = %+v, want none", changes)
+	}
+}
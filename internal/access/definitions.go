@@ -0,0 +1,207 @@
+package access
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"gopkg.in/yaml.v3"
+)
+
+// Default column definitions, for Sisu exports in English, Finnish and Swedish
+//
+//go:embed access_list_columns.yaml
+var defaultDefinitionsYAML []byte
+
+// Definition of fields in CSV access list
+type CSVListDefinition struct {
+	Name     string `yaml:"name"`
+	Language string `yaml:"language"` // Language code, e.g. "en", "fi"
+
+	// Field delimiter of text files. Empty or auto detects tab, semicolon or comma.
+	Delimiter string `yaml:"delimiter"`
+	// File encoding, see ENCODINGS. Empty is auto.
+	Encoding string `yaml:"encoding"`
+
+	EmailField string `yaml:"email_column"`
+	// Empty status column marks every listed user active
+	StatusField    string   `yaml:"status_column"`
+	ActiveStatuses []string `yaml:"active_status"`
+	// Column with comma separated roles. Used when present in the file.
+	RoleField string `yaml:"role_column"`
+	// Column with the user display name. Used when present in the file.
+	NameField string `yaml:"name_column"`
+}
+
+// Supported file encodings
+var ENCODINGS = []string{"auto", "utf-8", "utf-16", "utf-16le", "utf-16be", "windows-1252"}
+
+type listDefinitionsYAML struct {
+	Definitions []CSVListDefinition `yaml:"definitions"`
+}
+
+// Known field names in CSV access lists, in different languages. Replaced by
+// LoadListDefinitions when the instance has its own definitions.
+var CSVListDefinitions = mustParseListDefinitions(defaultDefinitionsYAML)
+
+func mustParseListDefinitions(data []byte) []CSVListDefinition {
+	defs, err := ParseListDefinitions(data)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse default access list definitions: %v", err))
+	}
+	return defs
+}
+
+// ParseListDefinitions parses and validates YAML column definitions.
+func ParseListDefinitions(data []byte) ([]CSVListDefinition, error) {
+	var doc listDefinitionsYAML
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid access list definitions: %w", err)
+	}
+	if len(doc.Definitions) == 0 {
+		return nil, fmt.Errorf("no access list definitions")
+	}
+	for i := range doc.Definitions {
+		def := &doc.Definitions[i]
+		if def.Name == "" {
+			def.Name = fmt.Sprintf("definition-%d", i+1)
+		}
+		if err := def.validate(); err != nil {
+			return nil, fmt.Errorf("access list definition %s: %w", def.Name, err)
+		}
+	}
+	return doc.Definitions, nil
+}
+
+// LoadListDefinitions reads column definitions from the file. Built-in
+// definitions are returned if the file does not exist.
+func LoadListDefinitions(path string) ([]CSVListDefinition, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return CSVListDefinitions, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access list definitions: %w", err)
+	}
+	return ParseListDefinitions(data)
+}
+
+func (d *CSVListDefinition) validate() error {
+	if strings.TrimSpace(d.EmailField) == "" {
+		return fmt.Errorf("email_column is required")
+	}
+	if d.StatusField != "" && len(d.ActiveStatuses) == 0 {
+		return fmt.Errorf("active_status is required with status_column")
+	}
+	if _, err := d.comma(); err != nil {
+		return err
+	}
+	if _, err := d.decoder(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// comma returns the field delimiter, or 0 if it should be detected.
+func (d *CSVListDefinition) comma() (rune, error) {
+	if d.Delimiter == "" || strings.EqualFold(d.Delimiter, "auto") {
+		return 0, nil
+	}
+	r, size := utf8.DecodeRuneInString(d.Delimiter)
+	if size != len(d.Delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("invalid delimiter %q, expected a single character", d.Delimiter)
+	}
+	return r, nil
+}
+
+// decoder returns the decoder for the encoding. Auto follows a UTF-8 or UTF-16 BOM, and defaults to UTF-8.
+func (d *CSVListDefinition) decoder() (transform.Transformer, error) {
+	switch strings.ToLower(d.Encoding) {
+	case "", "auto":
+		return unicode.BOMOverride(unicode.UTF8.NewDecoder()), nil
+	case "utf-8", "utf8":
+		return unicode.UTF8BOM.NewDecoder(), nil
+	case "utf-16", "utf16":
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder(), nil
+	case "utf-16le":
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder(), nil
+	case "utf-16be":
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder(), nil
+	case "windows-1252", "cp1252":
+		return charmap.Windows1252.NewDecoder(), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q, expected one of %s", d.Encoding, strings.Join(ENCODINGS, ", "))
+	}
+}
+
+// isActive reports whether the status value grants access.
+func (d *CSVListDefinition) isActive(status string) bool {
+	for _, s := range d.ActiveStatuses {
+		if strings.EqualFold(strings.TrimSpace(s), status) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefinitionMismatch explains why a definition did not match a file.
+type DefinitionMismatch struct {
+	Definition string
+	Reason     string
+}
+
+// NoDefinitionError is returned when no definition matches the file header.
+type NoDefinitionError struct {
+	Mismatches []DefinitionMismatch
+}
+
+func (e *NoDefinitionError) Error() string {
+	reasons := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		reasons[i] = fmt.Sprintf("%s: %s", m.Definition, m.Reason)
+	}
+	return "no access list definition matches the file (" + strings.Join(reasons, "; ") + ")"
+}
+
+// csvColumns holds indexes of the defined columns in a file, -1 for columns not in use.
+type csvColumns struct {
+	email, status, role, name int
+}
+
+// columns finds the defined columns in the header. Mismatch reason is
+// returned when the header lacks required columns.
+func (d *CSVListDefinition) columns(header []string) (csvColumns, string) {
+	cols := csvColumns{-1, -1, -1, -1}
+
+	headerMap := make(map[string]int, len(header))
+	for i, h := range header {
+		headerMap[strings.TrimSpace(h)] = i
+	}
+
+	var missing []string
+	find := func(column string, idx *int, required bool) {
+		if column == "" {
+			return
+		}
+		if i, ok := headerMap[column]; ok {
+			*idx = i
+		} else if required {
+			missing = append(missing, fmt.Sprintf("%q", column))
+		}
+	}
+	find(d.EmailField, &cols.email, true)
+	find(d.StatusField, &cols.status, true)
+	find(d.RoleField, &cols.role, false)
+	find(d.NameField, &cols.name, false)
+
+	if len(missing) > 0 {
+		return cols, "missing column " + strings.Join(missing, ", ")
+	}
+	return cols, ""
+}
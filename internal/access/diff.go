@@ -0,0 +1,95 @@
+package access
+
+// Changes between two versions of the access list, for comparing exports and
+// for recording the access history on reload.
+
+import (
+	"slices"
+	"strings"
+
+	"entry-access-control/internal/storage"
+)
+
+// EntryState is the access of a user at one time.
+type EntryState struct {
+	Active bool
+	// File or backend granting the access
+	Source string
+	Roles  []string
+}
+
+// EntryStates indexes the entries by normalised email. Users listed twice are
+// active if any of their rows is.
+func EntryStates(entries []EntryRecord) map[string]EntryState {
+	states := make(map[string]EntryState, len(entries))
+	for _, e := range entries {
+		key := NormalizeEmail(e.GetUserID())
+		if existing, ok := states[key]; ok && existing.Active {
+			continue
+		}
+		state := EntryState{Active: e.CanAccess("")}
+		if granted, ok := e.(GrantedEntry); ok {
+			state.Source = granted.GetSource()
+		}
+		if state.Active {
+			state.Roles = e.GetUserRoles()
+		}
+		states[key] = state
+	}
+	return states
+}
+
+// StatesFromHistory rebuilds the states from the latest recorded change of each user.
+func StatesFromHistory(latest []storage.AccessChange) map[string]EntryState {
+	states := make(map[string]EntryState, len(latest))
+	for _, c := range latest {
+		if c.Change == storage.AccessChangeRemoved {
+			continue
+		}
+		states[c.UserID] = EntryState{Active: c.Active, Source: c.Source}
+	}
+	return states
+}
+
+// DiffStates returns the users added, removed, activated, deactivated and moved
+// to another source, by user. Removed and deactivated users have the source
+// they lost access from, moved users the source they have now.
+func DiffStates(old, new map[string]EntryState) []storage.AccessChange {
+	var changes []storage.AccessChange
+	for user, n := range new {
+		o, existed := old[user]
+		switch {
+		case !existed:
+			changes = append(changes, storage.AccessChange{UserID: user, Change: storage.AccessChangeAdded, Active: n.Active, Source: n.Source})
+		case n.Active && !o.Active:
+			changes = append(changes, storage.AccessChange{UserID: user, Change: storage.AccessChangeActivated, Active: true, Source: n.Source})
+		case !n.Active && o.Active:
+			changes = append(changes, storage.AccessChange{UserID: user, Change: storage.AccessChangeDeactivated, Active: false, Source: o.Source})
+		case n.Source != o.Source:
+			changes = append(changes, storage.AccessChange{UserID: user, Change: storage.AccessChangeMoved, Active: n.Active, Source: n.Source})
+		}
+	}
+	for user, o := range old {
+		if _, ok := new[user]; !ok {
+			changes = append(changes, storage.AccessChange{UserID: user, Change: storage.AccessChangeRemoved, Active: false, Source: o.Source})
+		}
+	}
+	slices.SortFunc(changes, func(a, b storage.AccessChange) int {
+		return strings.Compare(a.UserID, b.UserID)
+	})
+	return changes
+}
+
+// FileEntries returns the entries of a parsed file, with the file as their source.
+func FileEntries(path string, file *CSVFile) []EntryRecord {
+	entries := make([]EntryRecord, len(file.Entries))
+	for i, e := range file.Entries {
+		entry := *e
+		entry.Source = path
+		if len(entry.Roles) == 0 {
+			entry.Roles = file.Grant.Roles
+		}
+		entries[i] = &entry
+	}
+	return entries
+}
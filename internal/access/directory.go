@@ -0,0 +1,183 @@
+package access
+
+// Access list of users provisioned over SCIM into the storage directory.
+// Groups are mapped to roles by their display names, and deactivated users
+// can't access.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	. "entry-access-control/internal/config"
+	"entry-access-control/internal/storage"
+)
+
+// Source reported for directory users
+const DIRECTORY_SOURCE = "scim"
+
+// Invalidator is implemented by access lists that are told when their source changes.
+type Invalidator interface {
+	Invalidate()
+}
+
+type DirectoryAccessList struct {
+	provider storage.Provider
+	// Roles by lower case group display name
+	groupRoles map[string][]string
+	changed    chan struct{}
+	onReload   []func(entries []EntryRecord)
+	mu         sync.Mutex
+	logger     *slog.Logger
+}
+
+func NewDirectoryAccessList(provider storage.Provider, cfg SCIMConfig) (*DirectoryAccessList, error) {
+	groupRoles, err := ParseSCIMGroupRoles(cfg.GroupRoles)
+	if err != nil {
+		return nil, err
+	}
+	return &DirectoryAccessList{
+		provider:   provider,
+		groupRoles: groupRoles,
+		changed:    make(chan struct{}, 1),
+		logger:     slog.Default().WithGroup("access").With("type", "scim"),
+	}, nil
+}
+
+// ParseSCIMGroupRoles parses a "group=role;group=role" mapping of group display
+// names, compared case-insensitively. The role is after the last "=", and a
+// group can be mapped to several roles.
+func ParseSCIMGroupRoles(mapping string) (map[string][]string, error) {
+	roles := make(map[string][]string)
+	for _, item := range strings.Split(mapping, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, "=")
+		if i < 1 || i == len(item)-1 {
+			return nil, fmt.Errorf("invalid group role mapping %q, expected group=role", item)
+		}
+		group := strings.ToLower(strings.TrimSpace(item[:i]))
+		roles[group] = append(roles[group], strings.TrimSpace(item[i+1:]))
+	}
+	return roles, nil
+}
+
+// toEntry maps the user to an entry. Groups not in the mapping give no roles,
+// as anyone able to create groups in the identity provider could name one after
+// a role.
+func (d *DirectoryAccessList) toEntry(user storage.DirectoryUser, groups []storage.DirectoryGroup) *StudentEntry {
+	entry := &StudentEntry{
+		UserID: user.Email,
+		Email:  user.Email,
+		Name:   user.DisplayName,
+		Status: user.Active,
+		Source: DIRECTORY_SOURCE,
+	}
+	for _, g := range groups {
+		for _, role := range d.groupRoles[strings.ToLower(g.DisplayName)] {
+			if !slices.Contains(entry.Roles, role) {
+				entry.Roles = append(entry.Roles, role)
+			}
+		}
+	}
+	return entry
+}
+
+func (d *DirectoryAccessList) Find(UserID string) (EntryRecord, error) {
+	ctx := context.Background()
+	user, err := d.provider.FindDirectoryUser(ctx, CanonicalID(UserID))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := d.provider.ListDirectoryUserGroups(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return d.toEntry(*user, groups), nil
+}
+
+func (d *DirectoryAccessList) ListAllEntries() ([]EntryRecord, error) {
+	ctx := context.Background()
+	users, err := d.provider.ListDirectoryUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := d.provider.ListDirectoryGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := d.provider.ListDirectoryMemberships(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]storage.DirectoryGroup, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+	}
+	userGroups := make(map[string][]storage.DirectoryGroup)
+	for _, m := range memberships {
+		if g, ok := byID[m.GroupID]; ok {
+			userGroups[m.UserID] = append(userGroups[m.UserID], g)
+		}
+	}
+
+	records := make([]EntryRecord, 0, len(users))
+	for _, u := range users {
+		if u.Email == "" {
+			continue
+		}
+		records = append(records, d.toEntry(u, userGroups[u.ID]))
+	}
+	return records, nil
+}
+
+// Invalidate schedules a reload after the directory has changed.
+func (d *DirectoryAccessList) Invalidate() {
+	select {
+	case d.changed <- struct{}{}:
+	default:
+		// Reload already pending
+	}
+}
+
+func (d *DirectoryAccessList) OnReload(fn func(entries []EntryRecord)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onReload = append(d.onReload, fn)
+}
+
+// Watch reloads users after each change, until the context is cancelled.
+func (d *DirectoryAccessList) Watch(ctx context.Context) error {
+	for {
+		select {
+		case <-d.changed:
+			entries, err := d.ListAllEntries()
+			if err != nil {
+				d.logger.Error("Failed to reload directory users", "error", err)
+				continue
+			}
+			d.logger.Debug("Directory users reloaded", "users", len(entries))
+
+			d.mu.Lock()
+			callbacks := slices.Clone(d.onReload)
+			d.mu.Unlock()
+			for _, cb := range callbacks {
+				cb(slices.Clone(entries))
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
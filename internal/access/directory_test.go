@@ -0,0 +1,30 @@
+package access
+
+import (
+	"slices"
+	"testing"
+
+	. "entry-access-control/internal/config"
+	"entry-access-control/internal/storage"
+)
+
+func TestDirectoryAccessList_GroupRoles(t *testing.T) {
+	list, err := NewDirectoryAccessList(nil, SCIMConfig{GroupRoles: "Lab staff=teacher; lab staff=assistant; R&D = x=admin"})
+	if err != nil {
+		t.Fatalf("NewDirectoryAccessList failed: %v", err)
+	}
+
+	user := storage.DirectoryUser{Email: "matti@example.com", Active: true}
+	groups := []storage.DirectoryGroup{{DisplayName: "LAB STAFF"}, {DisplayName: "R&D = x"}, {DisplayName: "admin"}}
+	if roles := list.toEntry(user, groups).Roles; !slices.Equal(roles, []string{"teacher", "assistant", "admin"}) {
+		t.Errorf("roles = %v, want mapped roles only", roles)
+	}
+	// Group named after a role gives nothing
+	if roles := list.toEntry(user, groups[2:]).Roles; len(roles) != 0 {
+		t.Errorf("unmapped group roles = %v", roles)
+	}
+
+	if _, err := NewDirectoryAccessList(nil, SCIMConfig{GroupRoles: "admins"}); err == nil {
+		t.Error("invalid mapping accepted")
+	}
+}
@@ -27,3 +27,8 @@ func ValidEmail(email string) error {
 
 	return nil
 }
+
+// NormalizeEmail returns the email in the form used as lookup key, see Normalizer.Normalize.
+func NormalizeEmail(email string) string {
+	return identity.Load().Normalize(email)
+}
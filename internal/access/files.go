@@ -0,0 +1,119 @@
+package access
+
+// Management of the files in the access list folder, for uploads over the
+// admin API. Changes are loaded right away, and the folder watcher picks them
+// up again after its debounce.
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrInvalidListPath  = errors.New("invalid access list file path")
+	ErrInvalidListFile  = errors.New("invalid access list file")
+	ErrListFileNotFound = errors.New("access list file not found")
+)
+
+// Folder returns the absolute path of the access list folder.
+func (s *CSVAccessList) Folder() string {
+	folder, err := filepath.Abs(s.folder)
+	if err != nil {
+		return s.folder
+	}
+	return folder
+}
+
+// Files returns the loaded files by path.
+func (s *CSVAccessList) Files() map[string]*CSVFile {
+	return maps.Clone(s.snapshot.Load().files)
+}
+
+// ListPath resolves a path relative to the access list folder. The path must
+// stay in the folder, and have a name read as an access list.
+func (s *CSVAccessList) ListPath(rel string) (string, error) {
+	rel = filepath.FromSlash(rel)
+	if s.folder == "" || !filepath.IsLocal(rel) || !isListFile(filepath.Base(rel)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidListPath, rel)
+	}
+	return filepath.Join(s.Folder(), rel), nil
+}
+
+// Preview parses the content as if it was written to the path, with the
+// metadata and grant the file would have. Files with row roles not allowed by
+// the sources file are rejected. Errors wrap ErrInvalidListFile.
+func (s *CSVAccessList) Preview(path string, data []byte) (*CSVFile, error) {
+	f, err := ParseCSVData(data, s.definitions)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidListFile, err)
+	}
+	if f.Metadata, err = LoadFileMetadata(path); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidListFile, err)
+	}
+	f.Grant = ResolveGrant(RelativePath(s.folder, path), f.Metadata, s.rules)
+	if f.FilterRowRoles(s.rowRoles); len(f.RejectedRoles) > 0 {
+		return nil, fmt.Errorf("%w: roles %s are not allowed in the role column, see row_roles of the access list sources", ErrInvalidListFile, strings.Join(f.RejectedRoles, ", "))
+	}
+	return f, nil
+}
+
+// WriteFile validates the content, and replaces the file atomically. Partial
+// writes are never seen by reloads, as the temporary file is hidden.
+func (s *CSVAccessList) WriteFile(path string, data []byte) (*CSVFile, error) {
+	f, err := s.Preview(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create access list folder: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to write access list file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to write access list file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write access list file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write access list file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("failed to replace access list file: %w", err)
+	}
+
+	s.update(func(files map[string]*CSVFile) {
+		files[path] = f
+	})
+	s.logger.Debug("Access list file written", "file", path, "rows", len(f.Entries))
+	return f, nil
+}
+
+// DeleteFile removes the file, and its users lose the access it granted.
+// Sidecar metadata is kept for a replacement file.
+func (s *CSVAccessList) DeleteFile(path string) error {
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrListFileNotFound, path)
+		}
+		return fmt.Errorf("failed to delete access list file: %w", err)
+	}
+	s.RemoveFile(path)
+	s.logger.Debug("Access list file deleted", "file", path)
+	return nil
+}
@@ -0,0 +1,382 @@
+package access
+
+// Access list file formats. The parser is picked by sniffing the file content,
+// so an export re-saved in Excel keeps working whatever its extension.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	stdunicode "unicode"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// ErrUnknownListFormat is returned for content no parser recognises.
+var ErrUnknownListFormat = errors.New("unknown access list format")
+
+// Bytes read from the start of the file for recognising text
+const TEXT_SNIFF_BYTES = 4096
+
+// Bytes read from the start of a file in the access list folder for
+// recognising its format, so large files are not read on every scan
+const LIST_SNIFF_BYTES = 64 << 10
+
+// ListParser parses access list files of one format.
+type ListParser interface {
+	// Name of the format, e.g. "csv"
+	Name() string
+	// Sniff reports whether the content is in the format. data may be only the
+	// first LIST_SNIFF_BYTES of the content.
+	Sniff(data []byte) bool
+	// Parse reads the entries. Tabular formats use the first matching column definition.
+	Parse(data []byte, definitions []CSVListDefinition) (*CSVFile, error)
+}
+
+// Registered parsers, in sniffing order. Delimited text is the last one, and
+// recognises any text file.
+var listParsers = []ListParser{xlsxParser{}, jsonParser{}, delimitedParser{}}
+
+// RegisterParser adds a parser, tried before the built-in ones.
+func RegisterParser(p ListParser) {
+	listParsers = append([]ListParser{p}, listParsers...)
+}
+
+// Extensions of files written through the upload API. Files in the access list
+// folder are read whatever their name, if the content is recognised.
+var ListFileExtensions = []string{".csv", ".tsv", ".txt", ".xlsx", ".json"}
+
+// archiveSniffer is implemented by parsers of archive formats, recognised by
+// the archive directory at the end of the file rather than its start.
+type archiveSniffer interface {
+	SniffArchive(r io.ReaderAt, size int64) bool
+}
+
+// sniffFile returns the parser for the file, or nil if none recognises it.
+// Only the start of the file, and the directory of archives, is read.
+func sniffFile(path string) (ListParser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, min(info.Size(), LIST_SNIFF_BYTES))
+	if _, err := io.ReadFull(f, head); err != nil {
+		return nil, err
+	}
+	for _, p := range listParsers {
+		if archive, ok := p.(archiveSniffer); ok {
+			if archive.SniffArchive(f, info.Size()) {
+				return p, nil
+			}
+			continue
+		}
+		if p.Sniff(head) {
+			return p, nil
+		}
+	}
+	return nil, nil
+}
+
+// sniffParser returns the parser for the content, or nil if none recognises it.
+func sniffParser(data []byte) ListParser {
+	for _, p := range listParsers {
+		if p.Sniff(data) {
+			return p
+		}
+	}
+	return nil
+}
+
+// rowReader yields table rows, and io.EOF after the last one.
+type rowReader interface {
+	Read() ([]string, error)
+}
+
+// parseTable reads the header from the rows opened for each definition, and
+// parses the rows with the first definition matching it.
+func parseTable(definitions []CSVListDefinition, open func(def *CSVListDefinition) (rowReader, error)) (*CSVFile, error) {
+	var mismatches []DefinitionMismatch
+	for _, def := range definitions {
+		rows, err := open(&def)
+		if err != nil {
+			return nil, err
+		}
+
+		header, err := rows.Read()
+		if err == io.EOF {
+			mismatches = append(mismatches, DefinitionMismatch{Definition: def.Name, Reason: "file is empty"})
+			continue
+		}
+		if err != nil {
+			mismatches = append(mismatches, DefinitionMismatch{Definition: def.Name, Reason: fmt.Sprintf("failed to read header: %v", err)})
+			continue
+		}
+
+		cols, reason := def.columns(header)
+		if reason != "" {
+			if len(header) == 1 {
+				reason += " (header has a single column, delimiter may be wrong)"
+			}
+			mismatches = append(mismatches, DefinitionMismatch{Definition: def.Name, Reason: reason})
+			continue
+		}
+		return readEntries(def, header, cols, rows)
+	}
+	return nil, &NoDefinitionError{Mismatches: mismatches}
+}
+
+func readEntries(def CSVListDefinition, header []string, cols csvColumns, rows rowReader) (*CSVFile, error) {
+	file := &CSVFile{
+		FieldDefinitions: def,
+		HeaderMap:        make(map[string]int, len(header)),
+	}
+	for i, h := range header {
+		file.HeaderMap[strings.TrimSpace(h)] = i
+	}
+
+	required := max(cols.email, cols.status)
+	for {
+		record, err := rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading rows: %w", err)
+		}
+		if len(record) <= required {
+			file.Skipped++
+			continue
+		}
+
+		email := strings.TrimSpace(record[cols.email])
+		if email == "" {
+			file.Skipped++
+			continue
+		}
+		entry := &StudentEntry{
+			UserID: email,
+			Email:  email,
+		}
+		if cols.status == -1 {
+			entry.Status = true
+		} else {
+			entry.RawStatus = strings.TrimSpace(record[cols.status])
+			entry.Status = def.isActive(entry.RawStatus)
+		}
+		if cols.name != -1 && cols.name < len(record) {
+			entry.Name = strings.TrimSpace(record[cols.name])
+		}
+		if cols.role != -1 && cols.role < len(record) {
+			entry.Roles = splitRoles(record[cols.role])
+		}
+		file.Entries = append(file.Entries, entry)
+	}
+	return file, nil
+}
+
+func splitRoles(value string) []string {
+	var roles []string
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// delimitedParser reads tab, semicolon or comma separated text. Sisu exports
+// tab separated UTF-16, Excel re-saves as comma or semicolon separated UTF-8.
+type delimitedParser struct{}
+
+func (delimitedParser) Name() string { return "csv" }
+
+// Sniff accepts text: the start of the content has no control characters
+// other than line breaks and tabs, and the first line is not blank.
+func (delimitedParser) Sniff(data []byte) bool {
+	text, ok := textHead(data)
+	if !ok {
+		return false
+	}
+	for _, r := range text {
+		if stdunicode.IsControl(r) && r != '\t' && r != '\r' && r != '\n' {
+			return false
+		}
+	}
+	line, _, _ := strings.Cut(text, "\n")
+	return strings.TrimSpace(line) != ""
+}
+
+// textHead decodes the start of the content. UTF-16 is recognised by its byte
+// order mark, or by the zero bytes of ASCII characters. Other content is left
+// as is, so 8-bit encodings are accepted too.
+func textHead(data []byte) (string, bool) {
+	head := data[:min(len(data), TEXT_SNIFF_BYTES)]
+
+	fallback := encoding.Nop
+	switch {
+	case len(head) >= 2 && head[0] != 0 && head[1] == 0:
+		fallback = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case len(head) >= 2 && head[0] == 0 && head[1] != 0:
+		fallback = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	}
+	if fallback != encoding.Nop || bytes.HasPrefix(head, []byte("\xff\xfe")) || bytes.HasPrefix(head, []byte("\xfe\xff")) {
+		// Don't split the last UTF-16 code unit
+		head = head[:len(head)&^1]
+	}
+
+	text, _, err := transform.Bytes(unicode.BOMOverride(fallback.NewDecoder()), head)
+	if err != nil {
+		return "", false
+	}
+	return string(text), true
+}
+
+func (delimitedParser) Parse(data []byte, definitions []CSVListDefinition) (*CSVFile, error) {
+	return parseTable(definitions, func(def *CSVListDefinition) (rowReader, error) {
+		decoder, err := def.decoder()
+		if err != nil {
+			return nil, err
+		}
+		comma, err := def.comma()
+		if err != nil {
+			return nil, err
+		}
+
+		text, _, err := transform.Bytes(decoder, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode file as %s: %w", def.Encoding, err)
+		}
+		if comma == 0 {
+			comma = sniffDelimiter(text)
+		}
+
+		reader := csv.NewReader(bytes.NewReader(text))
+		reader.Comma = comma
+		reader.LazyQuotes = true
+		// Short rows are skipped
+		reader.FieldsPerRecord = -1
+		return reader, nil
+	})
+}
+
+// sniffDelimiter picks the most common of tab, semicolon and comma outside
+// quotes on the first line. Tab wins ties, as in Sisu exports.
+func sniffDelimiter(text []byte) rune {
+	line, _ := bufio.NewReader(bytes.NewReader(text)).ReadString('\n')
+
+	counts := map[rune]int{}
+	quoted := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == '\t' || r == ';' || r == ','):
+			counts[r]++
+		}
+	}
+
+	best := '\t'
+	for _, r := range []rune{';', ','} {
+		if counts[r] > counts[best] {
+			best = r
+		}
+	}
+	return best
+}
+
+// JSON access lists have a fixed schema, column definitions are not used:
+//
+//	{"users": [{"email": "user@example.com", "active": true, "name": "User", "roles": ["student"]}]}
+//
+// A plain array of users is accepted too. Users are active unless "active" is false.
+type jsonParser struct{}
+
+type jsonListUser struct {
+	Email  string   `json:"email"`
+	Active *bool    `json:"active"`
+	Name   string   `json:"name"`
+	Roles  []string `json:"roles"`
+}
+
+// Definition reported for JSON files
+var JSONListDefinition = CSVListDefinition{
+	Name:           "json",
+	EmailField:     "email",
+	StatusField:    "active",
+	ActiveStatuses: []string{"true"},
+	RoleField:      "roles",
+	NameField:      "name",
+}
+
+func (jsonParser) Name() string { return "json" }
+
+func (jsonParser) Sniff(data []byte) bool {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if len(data) == 0 || (data[0] != '{' && data[0] != '[') {
+		return false
+	}
+	// Only the start of the content may be given, so the document can't be
+	// validated. A header such as "[email]" fails on the second token.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for range 2 {
+		if _, err := dec.Token(); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (jsonParser) Parse(data []byte, definitions []CSVListDefinition) (*CSVFile, error) {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+
+	var users []jsonListUser
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &users); err != nil {
+			return nil, fmt.Errorf("invalid JSON access list: %w", err)
+		}
+	} else {
+		var doc struct {
+			Users []jsonListUser `json:"users"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid JSON access list: %w", err)
+		}
+		users = doc.Users
+	}
+
+	file := &CSVFile{FieldDefinitions: JSONListDefinition}
+	for _, u := range users {
+		email := strings.TrimSpace(u.Email)
+		if email == "" {
+			file.Skipped++
+			continue
+		}
+		entry := &StudentEntry{
+			UserID: email,
+			Email:  email,
+			Name:   strings.TrimSpace(u.Name),
+			Roles:  splitRoles(strings.Join(u.Roles, ",")),
+			Status: u.Active == nil || *u.Active,
+		}
+		if u.Active != nil {
+			entry.RawStatus = strconv.FormatBool(*u.Active)
+		}
+		file.Entries = append(file.Entries, entry)
+	}
+	return file, nil
+}
@@ -0,0 +1,179 @@
+package access
+
+// Identity normalisation. Identifiers users log in with, and identifiers in
+// access lists, are mapped to one canonical user ID:
+//
+//  1. Unicode NFKC normalisation, trimming and lower case
+//  2. Plus address stripping, "matti+lab@jyu.fi" to "matti@jyu.fi", if enabled
+//  3. Domain aliases, e.g. "student.jyu.fi=jyu.fi"
+//  4. Alias table in storage, mapping e.g. student numbers to the user ID
+//
+// NormalizeEmail does steps 1-3, and is used for lookup keys. CanonicalID
+// also resolves aliases, and is used for identifiers given by users.
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "entry-access-control/internal/config"
+	"entry-access-control/internal/storage"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// AliasResolver lists alternate identifiers of users.
+type AliasResolver interface {
+	ListUserAliases(ctx context.Context) ([]storage.UserAlias, error)
+}
+
+// Aliases are loaded in memory, and loaded again when older than this. They
+// are changed with the CLI, so the server sees changes after this.
+const ALIAS_RELOAD_INTERVAL = 30 * time.Second
+
+// Timeout for loading the aliases
+const ALIAS_LOAD_TIMEOUT = 5 * time.Second
+
+// aliasCache is an immutable set of loaded aliases
+type aliasCache struct {
+	// Normalised alias -> user ID
+	aliases  map[string]string
+	loadedAt time.Time
+	// Error of the load, if it failed and the previous aliases are kept
+	err error
+}
+
+type Normalizer struct {
+	stripPlus bool
+	// Alternate domain -> canonical domain
+	domains map[string]string
+	aliases AliasResolver
+	// Serialises alias loads. Lookups load the cache without locking.
+	aliasMu    sync.Mutex
+	aliasCache atomic.Pointer[aliasCache]
+}
+
+var identity atomic.Pointer[Normalizer]
+
+func init() {
+	identity.Store(&Normalizer{})
+}
+
+// ParseDomainAliases parses an "alias=domain;alias=domain" mapping.
+func ParseDomainAliases(mapping string) (map[string]string, error) {
+	domains := make(map[string]string)
+	for item := range strings.SplitSeq(mapping, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		alias, domain, ok := strings.Cut(item, "=")
+		alias, domain = normalizeText(alias), normalizeText(domain)
+		if !ok || alias == "" || domain == "" || strings.Contains(alias, "@") || strings.Contains(domain, "@") {
+			return nil, fmt.Errorf("invalid domain alias %q, expected alias=domain", item)
+		}
+		domains[alias] = domain
+	}
+	return domains, nil
+}
+
+// NewNormalizer creates a normaliser from the configuration. aliases may be nil.
+func NewNormalizer(cfg IdentityConfig, aliases AliasResolver) (*Normalizer, error) {
+	domains, err := ParseDomainAliases(cfg.DomainAliases)
+	if err != nil {
+		return nil, err
+	}
+	return &Normalizer{
+		stripPlus: cfg.StripPlus,
+		domains:   domains,
+		aliases:   aliases,
+	}, nil
+}
+
+// SetNormalizer sets the normaliser used by NormalizeEmail and CanonicalID.
+// Access lists must be loaded after, as their indexes use it.
+func SetNormalizer(n *Normalizer) {
+	identity.Store(n)
+}
+
+func normalizeText(s string) string {
+	return strings.ToLower(strings.TrimSpace(norm.NFKC.String(s)))
+}
+
+// Normalize returns the lookup key of the identifier, without alias lookups.
+func (n *Normalizer) Normalize(id string) string {
+	id = normalizeText(id)
+	at := strings.LastIndex(id, "@")
+	if at < 0 {
+		// Not an email, e.g. a student number
+		return id
+	}
+	local, domain := id[:at], id[at+1:]
+	if n.stripPlus {
+		if plus := strings.Index(local, "+"); plus > 0 {
+			local = local[:plus]
+		}
+	}
+	if canonical, ok := n.domains[domain]; ok {
+		domain = canonical
+	}
+	return local + "@" + domain
+}
+
+// Canonical returns the user ID of the identifier, resolving aliases. Aliases
+// failing to load are logged, and the previously loaded ones are used.
+func (n *Normalizer) Canonical(ctx context.Context, id string) string {
+	key := n.Normalize(id)
+	if n.aliases == nil || key == "" {
+		return key
+	}
+	cache := n.aliasCache.Load()
+	if cache == nil || time.Since(cache.loadedAt) > ALIAS_RELOAD_INTERVAL {
+		cache = n.loadAliases(ctx)
+	}
+	if userID, ok := cache.aliases[key]; ok {
+		return n.Normalize(userID)
+	}
+	return key
+}
+
+// loadAliases loads the aliases from storage, unless another lookup did
+// meanwhile. On errors, the previous aliases are kept until the next reload.
+func (n *Normalizer) loadAliases(ctx context.Context) *aliasCache {
+	n.aliasMu.Lock()
+	defer n.aliasMu.Unlock()
+
+	previous := n.aliasCache.Load()
+	if previous != nil && time.Since(previous.loadedAt) <= ALIAS_RELOAD_INTERVAL {
+		return previous
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ALIAS_LOAD_TIMEOUT)
+	defer cancel()
+
+	cache := &aliasCache{loadedAt: time.Now()}
+	aliases, err := n.aliases.ListUserAliases(ctx)
+	if err != nil {
+		slog.Warn("Loading user aliases failed", "error", err)
+		cache.err = err
+		if previous != nil {
+			cache.aliases = previous.aliases
+		}
+	} else {
+		cache.aliases = make(map[string]string, len(aliases))
+		for _, alias := range aliases {
+			cache.aliases[n.Normalize(alias.Alias)] = alias.UserID
+		}
+	}
+	n.aliasCache.Store(cache)
+	return cache
+}
+
+// CanonicalID returns the user ID of the identifier, see Normalizer.Canonical.
+func CanonicalID(id string) string {
+	return identity.Load().Canonical(context.Background(), id)
+}
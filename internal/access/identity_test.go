@@ -0,0 +1,168 @@
+package access
+
+// Tests for identity normalisation
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "entry-access-control/internal/config"
+	"entry-access-control/internal/storage"
+)
+
+type aliasMap map[string]string
+
+func (m aliasMap) ListUserAliases(ctx context.Context) ([]storage.UserAlias, error) {
+	var aliases []storage.UserAlias
+	for alias, userID := range m {
+		aliases = append(aliases, storage.UserAlias{Alias: alias, UserID: userID})
+	}
+	return aliases, nil
+}
+
+// countingAliases counts the loads of the aliases
+type countingAliases struct {
+	AliasResolver
+	loads atomic.Int32
+}
+
+func (c *countingAliases) ListUserAliases(ctx context.Context) ([]storage.UserAlias, error) {
+	c.loads.Add(1)
+	return c.AliasResolver.ListUserAliases(ctx)
+}
+
+// newAliasStorage creates a database of its own for the test, with the aliases.
+func newAliasStorage(t *testing.T, aliases map[string]string) storage.Provider {
+	t.Helper()
+	provider := storage.NewProvider(&Storage{
+		SQLite: &SQLLiteStorage{Path: filepath.Join(t.TempDir(), "test.db")},
+	})
+	if provider == nil {
+		t.Fatal("NewProvider failed")
+	}
+	t.Cleanup(func() { provider.Close() })
+	for alias, userID := range aliases {
+		if err := provider.SetUserAlias(context.Background(), alias, userID); err != nil {
+			t.Fatalf("SetUserAlias failed: %v", err)
+		}
+	}
+	return provider
+}
+
+// checkAliasesLoaded fails the test if the last alias load failed, as the
+// previous aliases would be used silently.
+func checkAliasesLoaded(t *testing.T, n *Normalizer) {
+	t.Helper()
+	if cache := n.aliasCache.Load(); cache == nil || cache.err != nil {
+		t.Fatalf("aliases not loaded: %+v", cache)
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	n, err := NewNormalizer(IdentityConfig{
+		DomainAliases: "student.jyu.fi=jyu.fi; Alumni.JYU.fi = jyu.fi",
+		StripPlus:     true,
+	}, aliasMap{"1234567": "Matti.Meikalainen@student.jyu.fi"})
+	if err != nil {
+		t.Fatalf("NewNormalizer failed: %v", err)
+	}
+
+	tests := []struct {
+		id, normalized, canonical string
+	}{
+		{" Matti.Meikalainen@JYU.fi ", "matti.meikalainen@jyu.fi", "matti.meikalainen@jyu.fi"},
+		{"matti.meikalainen@student.jyu.fi", "matti.meikalainen@jyu.fi", "matti.meikalainen@jyu.fi"},
+		{"matti.meikalainen+lab@alumni.jyu.fi", "matti.meikalainen@jyu.fi", "matti.meikalainen@jyu.fi"},
+		// Fullwidth characters, e.g. from a Japanese keyboard
+		{"ｍａｔｔｉ@jyu.fi", "matti@jyu.fi", "matti@jyu.fi"},
+		// Plus at the start is not an address tag
+		{"+tag@example.com", "+tag@example.com", "+tag@example.com"},
+		{"1234567", "1234567", "matti.meikalainen@jyu.fi"},
+		{"7654321", "7654321", "7654321"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := n.Normalize(tt.id); got != tt.normalized {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.id, got, tt.normalized)
+		}
+		if got := n.Canonical(context.Background(), tt.id); got != tt.canonical {
+			t.Errorf("Canonical(%q) = %q, want %q", tt.id, got, tt.canonical)
+		}
+	}
+
+	for _, mapping := range []string{"jyu.fi", "=jyu.fi", "a@b.fi=jyu.fi"} {
+		if _, err := ParseDomainAliases(mapping); err == nil {
+			t.Errorf("ParseDomainAliases(%q) succeeded, want error", mapping)
+		}
+	}
+}
+
+func TestNormalizer_AliasCache(t *testing.T) {
+	provider := newAliasStorage(t, map[string]string{"1234567": "matti@jyu.fi"})
+	aliases := &countingAliases{AliasResolver: provider}
+	n, err := NewNormalizer(IdentityConfig{}, aliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent lookups share one load
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			if got := n.Canonical(context.Background(), "1234567"); got != "matti@jyu.fi" {
+				t.Errorf("Canonical = %q, want matti@jyu.fi", got)
+			}
+		})
+	}
+	wg.Wait()
+	checkAliasesLoaded(t, n)
+	if loads := aliases.loads.Load(); loads != 1 {
+		t.Errorf("aliases loaded %d times, want once", loads)
+	}
+
+	// Expired aliases are loaded again, and kept if that fails
+	n.aliasCache.Load().loadedAt = time.Now().Add(-2 * ALIAS_RELOAD_INTERVAL)
+	provider.Close()
+	if got := n.Canonical(context.Background(), "1234567"); got != "matti@jyu.fi" || aliases.loads.Load() != 2 {
+		t.Errorf("Canonical after failed reload = %q with %d loads, want matti@jyu.fi with 2", got, aliases.loads.Load())
+	}
+	if cache := n.aliasCache.Load(); cache.err == nil {
+		t.Error("failed reload has no error")
+	}
+}
+
+func TestCSVAccessList_FindAlias(t *testing.T) {
+	provider := newAliasStorage(t, map[string]string{"1234567": "matti@jyu.fi", "old@jyu.fi": "new@jyu.fi"})
+	n, err := NewNormalizer(IdentityConfig{DomainAliases: "student.jyu.fi=jyu.fi"}, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetNormalizer(n)
+	defer SetNormalizer(&Normalizer{})
+
+	path := filepath.Join(t.TempDir(), "students.csv")
+	writeAccessList(t, path, "Matti@student.jyu.fi\tActive - Attending", "old@jyu.fi\tActive - Attending")
+	accessList := NewCSVAccessList()
+	if err := accessList.Reload([]string{path}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	// Aliases still listed in the file are found
+	for _, id := range []string{"matti@jyu.fi", "MATTI@student.jyu.fi", "1234567", "old@jyu.fi"} {
+		if user, _ := accessList.Find(id); user == nil {
+			t.Errorf("Find(%q) = nil, want user", id)
+		}
+	}
+
+	rbac := &RBAC{policy: &RBACPolicy{DefaultRole: "guest"}, userRoles: map[string][]string{}, policyCache: map[string]map[string]bool{}}
+	entries, _ := accessList.ListAllEntries()
+	rbac.SyncUserRoles(entries)
+	if roles := rbac.GetUserRoles("1234567"); len(roles) != 1 || roles[0] != "student" {
+		t.Errorf("GetUserRoles of alias = %v, want student", roles)
+	}
+	checkAliasesLoaded(t, n)
+}
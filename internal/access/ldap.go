@@ -0,0 +1,552 @@
+package access
+
+// LDAP / Active Directory access list. Users are looked up by their mail
+// attribute under the base DN, and cached for the TTL. Account state decides
+// whether the user can access, and group memberships map to roles. Lookups
+// share one connection, which is dialled again when it breaks.
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "entry-access-control/internal/config"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Timeout for connecting to and querying the LDAP server
+const LDAP_TIMEOUT = 10 * time.Second
+
+// Page size for listing all users
+const LDAP_PAGE_SIZE = 500
+
+// Watch fetches only users modified since the previous refresh. Users removed
+// from the directory, and accounts expiring without a change, are only seen by
+// listing all users, done every this many refreshes.
+const LDAP_FULL_REFRESH_TTLS = 12
+
+// Expired cache entries are used while the server is unreachable, until they
+// have been expired for this many TTLs. After that lookups fail.
+const LDAP_STALE_TTLS = 1
+
+// Account state attributes of Active Directory, OpenLDAP ppolicy, 389 DS and shadow accounts
+var ldapStateAttributes = []string{"userAccountControl", "accountExpires", "pwdAccountLockedTime", "nsAccountLock", "shadowExpire"}
+
+// AD userAccountControl flag for disabled accounts
+const adAccountDisable = 0x2
+
+// groupRole maps a group, by DN or common name, to a role.
+type groupRole struct {
+	dn   *ldap.DN
+	cn   string
+	role string
+}
+
+// ParseGroupRoles parses a "group=role;group=role" mapping. Group is a DN or a
+// common name, and the role is after the last "=".
+func ParseGroupRoles(mapping string) ([]groupRole, error) {
+	var roles []groupRole
+	for _, item := range strings.Split(mapping, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, "=")
+		if i < 1 || i == len(item)-1 {
+			return nil, fmt.Errorf("invalid group role mapping %q, expected group=role", item)
+		}
+		group, role := strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		if !strings.Contains(group, "=") {
+			roles = append(roles, groupRole{cn: group, role: role})
+			continue
+		}
+		dn, err := ldap.ParseDN(group)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group DN %q: %w", group, err)
+		}
+		roles = append(roles, groupRole{dn: dn, role: role})
+	}
+	return roles, nil
+}
+
+// matches reports whether the group DN is the mapped group.
+func (g groupRole) matches(group *ldap.DN) bool {
+	if g.dn != nil {
+		return g.dn.EqualFold(group)
+	}
+	if len(group.RDNs) == 0 {
+		return false
+	}
+	for _, attr := range group.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") && strings.EqualFold(attr.Value, g.cn) {
+			return true
+		}
+	}
+	return false
+}
+
+type ldapCacheItem struct {
+	// nil for users not in the directory
+	entry   *StudentEntry
+	expires time.Time
+}
+
+type LDAPAccessList struct {
+	cfg        LDAPConfig
+	groupRoles []groupRole
+	ttl        time.Duration
+
+	mu       sync.Mutex
+	cache    map[string]ldapCacheItem
+	onReload []func(entries []EntryRecord)
+
+	// Bound connection shared by searches, nil until the first one
+	connMu sync.Mutex
+	conn   *ldap.Conn
+
+	logger *slog.Logger
+}
+
+func NewLDAPAccessList(cfg LDAPConfig) (*LDAPAccessList, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, fmt.Errorf("LDAP URL and base DN are required")
+	}
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %w", err)
+	}
+	groupRoles, err := ParseGroupRoles(cfg.GroupRoles)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MailAttribute == "" {
+		cfg.MailAttribute = "mail"
+	}
+	return &LDAPAccessList{
+		cfg:        cfg,
+		groupRoles: groupRoles,
+		ttl:        time.Duration(cfg.CacheTTL) * time.Second,
+		cache:      make(map[string]ldapCacheItem),
+		logger:     slog.Default().WithGroup("access").With("type", "ldap"),
+	}, nil
+}
+
+// connect dials the server and binds with the service account.
+func (l *LDAPAccessList) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(l.cfg.URL, ldap.DialWithDialer(&net.Dialer{Timeout: LDAP_TIMEOUT}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	conn.SetTimeout(LDAP_TIMEOUT)
+
+	if l.cfg.StartTLS {
+		u, _ := url.Parse(l.cfg.URL)
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if l.cfg.BindDN != "" {
+		if err := conn.Bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to bind to LDAP server: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (l *LDAPAccessList) attributes() []string {
+	attrs := []string{l.cfg.MailAttribute, "displayName", "cn", "modifyTimestamp"}
+	if l.cfg.GroupAttribute != "" {
+		attrs = append(attrs, l.cfg.GroupAttribute)
+	}
+	return append(attrs, ldapStateAttributes...)
+}
+
+// searchFilter combines the user filter with the mail attribute match. Value is escaped by the caller.
+func (l *LDAPAccessList) searchFilter(value string) string {
+	return fmt.Sprintf("(&%s(%s=%s))", l.cfg.Filter, l.cfg.MailAttribute, value)
+}
+
+// addressFilter matches users with any of the addresses.
+func (l *LDAPAccessList) addressFilter(addresses ...string) string {
+	match := ""
+	for _, address := range addresses {
+		match += fmt.Sprintf("(%s=%s)", l.cfg.MailAttribute, ldap.EscapeFilter(address))
+	}
+	return fmt.Sprintf("(&%s(|%s))", l.cfg.Filter, match)
+}
+
+// modifiedFilter matches users modified at or after the directory timestamp.
+// Timestamps have a resolution of a second, so users modified at the same
+// second are fetched again, see ldapUsers.update.
+func (l *LDAPAccessList) modifiedFilter(since string) string {
+	return fmt.Sprintf("(&%s(%s=*)(modifyTimestamp>=%s))", l.cfg.Filter, l.cfg.MailAttribute, ldap.EscapeFilter(since))
+}
+
+// getConn returns the shared connection, connecting if there is none or it
+// was closed, e.g. by the server after idling.
+func (l *LDAPAccessList) getConn() (*ldap.Conn, error) {
+	l.connMu.Lock()
+	defer l.connMu.Unlock()
+	if l.conn != nil && !l.conn.IsClosing() {
+		return l.conn, nil
+	}
+	conn, err := l.connect()
+	if err != nil {
+		return nil, err
+	}
+	l.conn = conn
+	return conn, nil
+}
+
+// dropConn closes the connection, if it's still the shared one, so the next
+// search connects again.
+func (l *LDAPAccessList) dropConn(conn *ldap.Conn) {
+	l.connMu.Lock()
+	defer l.connMu.Unlock()
+	if l.conn == conn {
+		l.conn = nil
+	}
+	conn.Close()
+}
+
+// search returns the users matching the filter.
+func (l *LDAPAccessList) search(filter string, paged bool) ([]*StudentEntry, error) {
+	entries, _, err := l.searchModified(filter, paged)
+	return entries, err
+}
+
+// searchModified returns the users matching the filter, and the latest
+// modifyTimestamp of them, in the directory's clock. A search failing on a
+// broken connection is retried once on a new one.
+func (l *LDAPAccessList) searchModified(filter string, paged bool) ([]*StudentEntry, string, error) {
+	req := ldap.NewSearchRequest(
+		l.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(LDAP_TIMEOUT/time.Second), false,
+		filter, l.attributes(), nil,
+	)
+
+	var result *ldap.SearchResult
+	for attempt := 0; ; attempt++ {
+		conn, err := l.getConn()
+		if err != nil {
+			return nil, "", err
+		}
+		if paged {
+			result, err = conn.SearchWithPaging(req, LDAP_PAGE_SIZE)
+		} else {
+			result, err = conn.Search(req)
+		}
+		if err == nil {
+			break
+		}
+		if !ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+			return nil, "", fmt.Errorf("LDAP search failed: %w", err)
+		}
+		l.dropConn(conn)
+		if attempt > 0 {
+			return nil, "", fmt.Errorf("LDAP search failed: %w", err)
+		}
+		l.logger.Debug("LDAP connection lost, connecting again", "error", err)
+	}
+
+	now := time.Now()
+	var modified string
+	entries := make([]*StudentEntry, 0, len(result.Entries))
+	for _, e := range result.Entries {
+		if entry := l.toEntry(e, now); entry != nil {
+			entries = append(entries, entry)
+		}
+		// Generalized time of one server sorts as text
+		modified = max(modified, e.GetEqualFoldAttributeValue("modifyTimestamp"))
+	}
+	return entries, modified, nil
+}
+
+func (l *LDAPAccessList) toEntry(e *ldap.Entry, now time.Time) *StudentEntry {
+	email := strings.TrimSpace(e.GetEqualFoldAttributeValue(l.cfg.MailAttribute))
+	if email == "" {
+		return nil
+	}
+	name := e.GetEqualFoldAttributeValue("displayName")
+	if name == "" {
+		name = e.GetEqualFoldAttributeValue("cn")
+	}
+	return &StudentEntry{
+		UserID: email,
+		Email:  email,
+		Name:   name,
+		Roles:  l.mapRoles(e.GetEqualFoldAttributeValues(l.cfg.GroupAttribute)),
+		Status: ldapAccountActive(e, now),
+		Source: l.cfg.URL,
+	}
+}
+
+// mapRoles returns the roles of the groups. Unmapped groups are ignored.
+func (l *LDAPAccessList) mapRoles(groups []string) []string {
+	var roles []string
+	for _, group := range groups {
+		dn, err := ldap.ParseDN(group)
+		if err != nil {
+			l.logger.Debug("Ignoring invalid group DN", "group", group, "error", err)
+			continue
+		}
+		for _, gr := range l.groupRoles {
+			if gr.matches(dn) && !slices.Contains(roles, gr.role) {
+				roles = append(roles, gr.role)
+			}
+		}
+	}
+	return roles
+}
+
+// ldapAccountActive reports whether the account is enabled, unlocked and not expired.
+func ldapAccountActive(e *ldap.Entry, now time.Time) bool {
+	if v := e.GetEqualFoldAttributeValue("userAccountControl"); v != "" {
+		if uac, err := strconv.ParseInt(v, 10, 64); err == nil && uac&adAccountDisable != 0 {
+			return false
+		}
+	}
+	// AD accountExpires is in 100 ns intervals since 1601. 0 and max int64 mean never.
+	if v := e.GetEqualFoldAttributeValue("accountExpires"); v != "" {
+		if expires, err := strconv.ParseInt(v, 10, 64); err == nil && expires > 0 && expires != 1<<63-1 {
+			const epochDiff = 11644473600 // Seconds from 1601 to 1970
+			if time.Unix(expires/1e7-epochDiff, 0).Before(now) {
+				return false
+			}
+		}
+	}
+	if e.GetEqualFoldAttributeValue("pwdAccountLockedTime") != "" {
+		return false
+	}
+	if strings.EqualFold(e.GetEqualFoldAttributeValue("nsAccountLock"), "true") {
+		return false
+	}
+	// shadowExpire is in days since 1970. -1 means never.
+	if v := e.GetEqualFoldAttributeValue("shadowExpire"); v != "" {
+		if days, err := strconv.ParseInt(v, 10, 64); err == nil && days >= 0 && time.Unix(days*24*60*60, 0).Before(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// Find looks up the user by the email given, or its canonical ID, which may
+// not be an address in the directory, e.g. with plus addressing stripped.
+// Results are cached by the canonical ID until the TTL expires. If the server
+// is unreachable, an expired result is used instead, for LDAP_STALE_TTLS more
+// TTLs, so removed users don't keep access for long.
+func (l *LDAPAccessList) Find(UserID string) (EntryRecord, error) {
+	key := CanonicalID(UserID)
+	addresses := []string{strings.TrimSpace(UserID)}
+	if !strings.EqualFold(addresses[0], key) {
+		addresses = append(addresses, key)
+	}
+
+	l.mu.Lock()
+	cached, ok := l.cache[key]
+	l.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return recordOrNil(cached.entry), nil
+	}
+
+	entries, err := l.search(l.addressFilter(addresses...), false)
+	if err != nil {
+		if ok && time.Now().Before(cached.expires.Add(LDAP_STALE_TTLS*l.ttl)) {
+			l.logger.Warn("LDAP lookup failed, using expired cache entry", "user_id", UserID, "error", err)
+			return recordOrNil(cached.entry), nil
+		}
+		return nil, err
+	}
+
+	var entry *StudentEntry
+	if len(entries) > 0 {
+		// Prefer the address given
+		entry = entries[0]
+		if i := slices.IndexFunc(entries, func(e *StudentEntry) bool { return strings.EqualFold(e.Email, addresses[0]) }); i > 0 {
+			entry = entries[i]
+		}
+		if len(entries) > 1 {
+			l.logger.Warn("Several LDAP users match the email, using the first", "user_id", UserID, "count", len(entries))
+		}
+	}
+
+	l.mu.Lock()
+	l.cache[key] = ldapCacheItem{entry: entry, expires: time.Now().Add(l.ttl)}
+	l.mu.Unlock()
+
+	if entry == nil {
+		slog.Debug("User not found in LDAP", "user_id", UserID)
+	}
+	return recordOrNil(entry), nil
+}
+
+// recordOrNil avoids returning a typed nil pointer as EntryRecord.
+func recordOrNil(entry *StudentEntry) EntryRecord {
+	if entry == nil {
+		return nil
+	}
+	return entry
+}
+
+// ListAllEntries lists all users matching the filter, and refreshes the cache with them.
+func (l *LDAPAccessList) ListAllEntries() ([]EntryRecord, error) {
+	entries, err := l.search(l.searchFilter("*"), true)
+	if err != nil {
+		return nil, err
+	}
+	l.cacheEntries(entries)
+
+	records := make([]EntryRecord, len(entries))
+	for i, entry := range entries {
+		records[i] = entry
+	}
+	return records, nil
+}
+
+// cacheEntries refreshes the cache with the users.
+func (l *LDAPAccessList) cacheEntries(entries []*StudentEntry) {
+	expires := time.Now().Add(l.ttl)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range entries {
+		l.cache[CanonicalID(entry.Email)] = ldapCacheItem{entry: entry, expires: expires}
+	}
+}
+
+// ldapUsers is the user list kept up to date by Watch.
+type ldapUsers struct {
+	// Users by normalised email
+	users map[string]*StudentEntry
+	// Latest modifyTimestamp seen. Empty if the directory doesn't return it,
+	// and the next refresh lists all users.
+	modified string
+	// Refreshes since all users were listed
+	refreshes int
+}
+
+// update stores the users, and reports whether any of them is new or differs
+// from the stored one. The users modified last are fetched again on every
+// refresh, and are not changes unless they differ.
+func (u *ldapUsers) update(entries []*StudentEntry) bool {
+	changed := false
+	for _, entry := range entries {
+		key := NormalizeEmail(entry.Email)
+		if existing, ok := u.users[key]; !ok || !reflect.DeepEqual(existing, entry) {
+			changed = true
+		}
+		u.users[key] = entry
+	}
+	return changed
+}
+
+// refresh updates the users. All users are listed on the first refresh, every
+// LDAP_FULL_REFRESH_TTLS refreshes, and if the directory hasn't returned
+// modification times, otherwise only users modified since the previous
+// refresh are fetched. Reports whether all users were listed, or any user changed.
+func (l *LDAPAccessList) refresh(u *ldapUsers) (bool, error) {
+	full := u.users == nil || u.modified == "" || u.refreshes+1 >= LDAP_FULL_REFRESH_TTLS
+	filter := l.searchFilter("*")
+	if !full {
+		filter = l.modifiedFilter(u.modified)
+	}
+
+	entries, modified, err := l.searchModified(filter, true)
+	if err != nil {
+		return false, err
+	}
+	l.cacheEntries(entries)
+
+	if full {
+		u.users = make(map[string]*StudentEntry, len(entries))
+		u.refreshes = 0
+		if modified == "" {
+			l.logger.Warn("LDAP server returned no modifyTimestamp, all users are listed on every refresh")
+		}
+	} else {
+		u.refreshes++
+	}
+	changed := u.update(entries)
+	u.modified = max(u.modified, modified)
+	return full || changed, nil
+}
+
+// records returns the users as records.
+func (u *ldapUsers) records() []EntryRecord {
+	records := make([]EntryRecord, 0, len(u.users))
+	for _, entry := range u.users {
+		records = append(records, entry)
+	}
+	return records
+}
+
+func (l *LDAPAccessList) OnReload(fn func(entries []EntryRecord)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onReload = append(l.onReload, fn)
+}
+
+// Watch refreshes the user list every TTL, so role changes in the directory
+// reach RBAC, until the context is cancelled. After the first refresh, only
+// users modified since the previous one are fetched, see refresh. Callbacks
+// get the whole list, and are not called if no user changed.
+func (l *LDAPAccessList) Watch(ctx context.Context) error {
+	if l.ttl <= 0 {
+		return errors.New("LDAP cache TTL must be positive to refresh users")
+	}
+	ticker := time.NewTicker(l.ttl)
+	defer ticker.Stop()
+
+	var users ldapUsers
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			changed, err := l.refresh(&users)
+			if err != nil {
+				l.logger.Error("Failed to refresh LDAP users", "error", err)
+				continue
+			}
+			l.pruneCache(start)
+			if !changed {
+				l.logger.Debug("No LDAP users changed", "since", users.modified, "duration", time.Since(start))
+				continue
+			}
+			entries := users.records()
+			l.logger.Info("LDAP users refreshed", "users", len(entries), "duration", time.Since(start))
+
+			l.mu.Lock()
+			callbacks := slices.Clone(l.onReload)
+			l.mu.Unlock()
+			for _, cb := range callbacks {
+				cb(slices.Clone(entries))
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// pruneCache drops entries expired before the time.
+func (l *LDAPAccessList) pruneCache(before time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, item := range l.cache {
+		if item.expires.Before(before) {
+			delete(l.cache, key)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package access
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	. "entry-access-control/internal/config"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestLDAPAccountState(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	// AD timestamp for a time: 100 ns intervals since 1601
+	adTime := func(t time.Time) string {
+		return strconv.FormatInt((t.Unix()+11644473600)*1e7, 10)
+	}
+
+	tests := []struct {
+		name   string
+		attrs  map[string][]string
+		active bool
+	}{
+		{"plain", map[string][]string{}, true},
+		{"ad enabled", map[string][]string{"userAccountControl": {"512"}, "accountExpires": {"9223372036854775807"}}, true},
+		{"ad disabled", map[string][]string{"userAccountControl": {"514"}}, false},
+		{"ad expired", map[string][]string{"accountExpires": {adTime(now.AddDate(0, 0, -1))}}, false},
+		{"ad expires later", map[string][]string{"accountExpires": {adTime(now.AddDate(0, 0, 1))}}, true},
+		{"ppolicy locked", map[string][]string{"pwdAccountLockedTime": {"000001010000Z"}}, false},
+		{"389ds locked", map[string][]string{"nsAccountLock": {"TRUE"}}, false},
+		{"shadow expired", map[string][]string{"shadowExpire": {strconv.FormatInt(now.Unix()/86400-1, 10)}}, false},
+		{"shadow never", map[string][]string{"shadowExpire": {"-1"}}, true},
+	}
+	for _, tt := range tests {
+		e := ldap.NewEntry("uid=user,dc=example,dc=com", tt.attrs)
+		if got := ldapAccountActive(e, now); got != tt.active {
+			t.Errorf("%s: active = %v, want %v", tt.name, got, tt.active)
+		}
+	}
+}
+
+func TestLDAPGroupRoles(t *testing.T) {
+	list, err := NewLDAPAccessList(LDAPConfig{
+		URL:        "ldap://localhost",
+		BaseDN:     "dc=example,dc=com",
+		GroupRoles: "CN=Staff, OU=Groups, DC=example, DC=com=teacher; admins=admin; staff=staff",
+	})
+	if err != nil {
+		t.Fatalf("NewLDAPAccessList failed: %v", err)
+	}
+
+	roles := list.mapRoles([]string{
+		"cn=staff,ou=groups,dc=example,dc=com",
+		"cn=Admins,ou=other,dc=example,dc=com",
+		"cn=unmapped,dc=example,dc=com",
+	})
+	if want := []string{"teacher", "staff", "admin"}; !slices.Equal(roles, want) {
+		t.Errorf("roles = %v, want %v", roles, want)
+	}
+
+	if _, err := ParseGroupRoles("cn=staff,dc=example,dc=com"); err == nil {
+		t.Error("expected error on mapping without role")
+	}
+}
+
+// TestLDAPAccessList_Server runs against a real server, e.g. glauth or OpenLDAP:
+//
+//	LDAP_TEST_URL=ldap://localhost:3893 LDAP_TEST_BASE_DN=dc=glauth,dc=com \
+//	LDAP_TEST_BIND_DN=cn=serviceuser,ou=svcaccts,dc=glauth,dc=com LDAP_TEST_BIND_PASSWORD=mysecret \
+//	LDAP_TEST_FILTER='(objectClass=posixAccount)' LDAP_TEST_EMAIL=hackers@example.com go test ./internal/access
+//
+// LDAP_TEST_GROUP_ROLES and LDAP_TEST_ROLE check the group mapping of the user.
+func TestLDAPAccessList_Server(t *testing.T) {
+	url := os.Getenv("LDAP_TEST_URL")
+	if url == "" {
+		t.Skip("LDAP_TEST_URL not set")
+	}
+	email := os.Getenv("LDAP_TEST_EMAIL")
+	filter := os.Getenv("LDAP_TEST_FILTER")
+	if filter == "" {
+		filter = "(objectClass=person)"
+	}
+
+	list, err := NewLDAPAccessList(LDAPConfig{
+		URL:            url,
+		BindDN:         os.Getenv("LDAP_TEST_BIND_DN"),
+		BindPassword:   os.Getenv("LDAP_TEST_BIND_PASSWORD"),
+		BaseDN:         os.Getenv("LDAP_TEST_BASE_DN"),
+		Filter:         filter,
+		MailAttribute:  "mail",
+		GroupAttribute: "memberOf",
+		GroupRoles:     os.Getenv("LDAP_TEST_GROUP_ROLES"),
+		CacheTTL:       60,
+	})
+	if err != nil {
+		t.Fatalf("NewLDAPAccessList failed: %v", err)
+	}
+
+	user, err := list.Find(email)
+	if err != nil || user == nil {
+		t.Fatalf("Find(%q) = %v, %v; want user", email, user, err)
+	}
+	if !user.CanAccess("") {
+		t.Errorf("user %s is not active", email)
+	}
+	if role := os.Getenv("LDAP_TEST_ROLE"); role != "" && !slices.Contains(user.GetUserRoles(), role) {
+		t.Errorf("roles = %v, want %s", user.GetUserRoles(), role)
+	}
+
+	if user, err := list.Find("nobody@invalid.example"); err != nil || user != nil {
+		t.Errorf("Find(unknown) = %v, %v; want nil", user, err)
+	}
+
+	entries, err := list.ListAllEntries()
+	if err != nil {
+		t.Fatalf("ListAllEntries failed: %v", err)
+	}
+	if !slices.ContainsFunc(entries, func(e EntryRecord) bool { return NormalizeEmail(e.GetUserID()) == NormalizeEmail(email) }) {
+		t.Errorf("ListAllEntries does not include %s", email)
+	}
+
+	// Refreshes after the first one only fetch modified users
+	var users ldapUsers
+	if _, err := list.refresh(&users); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if len(users.users) != len(entries) {
+		t.Errorf("refresh listed %d users, want %d", len(users.users), len(entries))
+	}
+	if users.modified != "" {
+		changed, err := list.refresh(&users)
+		if err != nil {
+			t.Fatalf("modified refresh failed: %v", err)
+		}
+		if changed {
+			t.Errorf("modified refresh without changes reported changed users")
+		}
+		if users.refreshes != 1 || len(users.users) != len(entries) {
+			t.Errorf("modified refresh = %d refreshes, %d users; want 1, %d", users.refreshes, len(users.users), len(entries))
+		}
+	}
+
+	// Cached lookups don't need the server
+	list.cfg.URL = "ldap://127.0.0.1:1"
+	if user, err := list.Find(email); err != nil || user == nil {
+		t.Errorf("cached Find = %v, %v; want user", user, err)
+	}
+}
+
+func TestLDAPAccessList_StaleCache(t *testing.T) {
+	// Nothing listens on the port, so lookups fail
+	list, err := NewLDAPAccessList(LDAPConfig{URL: "ldap://127.0.0.1:1", BaseDN: "dc=example,dc=com", CacheTTL: 60})
+	if err != nil {
+		t.Fatalf("NewLDAPAccessList failed: %v", err)
+	}
+	entry := &StudentEntry{UserID: "matti@example.com", Email: "matti@example.com", Status: true}
+
+	// Expired for less than the stale window
+	list.cache["matti@example.com"] = ldapCacheItem{entry: entry, expires: time.Now().Add(-list.ttl / 2)}
+	if user, err := list.Find("matti@example.com"); err != nil || user == nil {
+		t.Errorf("Find with expired cache entry = %v, %v; want user", user, err)
+	}
+
+	// Expired for longer fails closed
+	list.cache["matti@example.com"] = ldapCacheItem{entry: entry, expires: time.Now().Add(-2 * list.ttl)}
+	if user, err := list.Find("matti@example.com"); err == nil || user != nil {
+		t.Errorf("Find with stale cache entry = %v, %v; want error", user, err)
+	}
+}
+
+func TestLDAPAccessList_Refresh(t *testing.T) {
+	list, err := NewLDAPAccessList(LDAPConfig{URL: "ldap://127.0.0.1:1", BaseDN: "dc=example,dc=com", Filter: "(objectClass=person)", CacheTTL: 60})
+	if err != nil {
+		t.Fatalf("NewLDAPAccessList failed: %v", err)
+	}
+
+	want := "(&(objectClass=person)(mail=*)(modifyTimestamp>=20260101120000Z))"
+	if filter := list.modifiedFilter("20260101120000Z"); filter != want {
+		t.Errorf("modifiedFilter = %s, want %s", filter, want)
+	}
+
+	// A failed refresh keeps the users
+	users := ldapUsers{
+		users:     map[string]*StudentEntry{"matti@example.com": {UserID: "matti@example.com", Email: "matti@example.com"}},
+		modified:  "20260101120000Z",
+		refreshes: 3,
+	}
+	if _, err := list.refresh(&users); err == nil {
+		t.Fatal("refresh without a server succeeded")
+	}
+	if len(users.users) != 1 || users.modified != "20260101120000Z" || users.refreshes != 3 {
+		t.Errorf("failed refresh changed the users to %+v", users)
+	}
+
+	// Users modified at the latest timestamp are fetched again, unchanged
+	if users.update([]*StudentEntry{{UserID: "matti@example.com", Email: "matti@example.com"}}) {
+		t.Errorf("update with the stored user reported a change")
+	}
+	if !users.update([]*StudentEntry{{UserID: "matti@example.com", Email: "matti@example.com", Status: true}}) {
+		t.Errorf("update with an activated user reported no change")
+	}
+	if !users.update([]*StudentEntry{{UserID: "maija@example.com", Email: "maija@example.com"}}) || len(users.users) != 2 {
+		t.Errorf("update with a new user = %d users, want 2 and a change", len(users.users))
+	}
+}
+
+func TestLDAPAccessList_FindAddresses(t *testing.T) {
+	n, err := NewNormalizer(IdentityConfig{StripPlus: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetNormalizer(n)
+	defer SetNormalizer(&Normalizer{})
+
+	list, err := NewLDAPAccessList(LDAPConfig{URL: "ldap://127.0.0.1:1", BaseDN: "dc=example,dc=com", Filter: "(objectClass=person)", MailAttribute: "mail", CacheTTL: 60})
+	if err != nil {
+		t.Fatalf("NewLDAPAccessList failed: %v", err)
+	}
+
+	// The given address may be the one in the directory
+	want := "(&(objectClass=person)(|(mail=matti+lab@example.com)(mail=matti@example.com)))"
+	if filter := list.addressFilter("matti+lab@example.com", "matti@example.com"); filter != want {
+		t.Errorf("addressFilter = %s, want %s", filter, want)
+	}
+
+	// Listed users are cached by their canonical ID
+	list.cacheEntries([]*StudentEntry{{UserID: "matti+lab@example.com", Email: "Matti+lab@example.com", Status: true}})
+	for _, id := range []string{"matti+lab@example.com", "matti@example.com", "Matti+other@example.com"} {
+		if user, err := list.Find(id); err != nil || user == nil {
+			t.Errorf("cached Find(%q) = %v, %v; want user", id, user, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package access
+
+// Access list file metadata, read from a sidecar file next to the access list,
+// e.g. "course.csv.meta.yaml" for "course.csv":
+//
+//	valid_from: 2026-01-12
+//	valid_until: 2026-05-31
+//	description: Chemistry lab course, spring 2026
+//	owner: teacher@example.com
+//	roles: [teacher]
+//	entries: ["3", "4"]
+//
+// Dates without time are local dates, and valid_until includes the whole day.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sidecar file suffixes, in lookup order. JSON is parsed as YAML.
+var MetadataSuffixes = []string{".meta.yaml", ".meta.yml", ".meta.json"}
+
+type FileMetadata struct {
+	// File grants access from this time on. nil means no start.
+	ValidFrom *time.Time
+	// File grants access until this time, exclusive. nil means no end.
+	ValidUntil  *time.Time
+	Description string
+	Owner       string
+	// Roles and entries granted by the file, see SourceGrant
+	Roles   []string
+	Entries []string
+}
+
+type fileMetadataYAML struct {
+	ValidFrom   string `yaml:"valid_from"`
+	ValidUntil  string `yaml:"valid_until"`
+	Description string `yaml:"description"`
+	Owner       string `yaml:"owner"`
+	SourceGrant `yaml:",inline"`
+}
+
+// parseMetadataTime parses a date or RFC 3339 time. Dates are at the start of the
+// day, or with endOfDay, at the start of the next day.
+func parseMetadataTime(value string, endOfDay bool) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or RFC 3339 time", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// LoadFileMetadata reads the sidecar metadata for the access list file.
+// Returns nil if the file has no sidecar.
+func LoadFileMetadata(path string) (*FileMetadata, error) {
+	for _, suffix := range MetadataSuffixes {
+		data, err := os.ReadFile(path + suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata: %w", err)
+		}
+
+		var raw fileMetadataYAML
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse metadata %s: %w", path+suffix, err)
+		}
+
+		meta := &FileMetadata{
+			Description: raw.Description,
+			Owner:       raw.Owner,
+			Roles:       raw.Roles,
+			Entries:     raw.Entries,
+		}
+		if err := raw.validate(); err != nil {
+			return nil, fmt.Errorf("metadata %s: %w", path+suffix, err)
+		}
+		if meta.ValidFrom, err = parseMetadataTime(raw.ValidFrom, false); err != nil {
+			return nil, fmt.Errorf("metadata %s: valid_from: %w", path+suffix, err)
+		}
+		if meta.ValidUntil, err = parseMetadataTime(raw.ValidUntil, true); err != nil {
+			return nil, fmt.Errorf("metadata %s: valid_until: %w", path+suffix, err)
+		}
+		if meta.ValidFrom != nil && meta.ValidUntil != nil && !meta.ValidFrom.Before(*meta.ValidUntil) {
+			return nil, fmt.Errorf("metadata %s: valid_from must be before valid_until", path+suffix)
+		}
+		return meta, nil
+	}
+	return nil, nil
+}
+
+// ValidAt reports whether the file grants access at the time. File without metadata is always valid.
+func (m *FileMetadata) ValidAt(t time.Time) bool {
+	if m == nil {
+		return true
+	}
+	if m.ValidFrom != nil && t.Before(*m.ValidFrom) {
+		return false
+	}
+	if m.ValidUntil != nil && !t.Before(*m.ValidUntil) {
+		return false
+	}
+	return true
+}
+
+// NextChange returns the next time after t when the validity changes, or nil if it won't.
+func (m *FileMetadata) NextChange(t time.Time) *time.Time {
+	if m == nil {
+		return nil
+	}
+	if m.ValidFrom != nil && t.Before(*m.ValidFrom) {
+		return m.ValidFrom
+	}
+	if m.ValidUntil != nil && t.Before(*m.ValidUntil) {
+		return m.ValidUntil
+	}
+	return nil
+}
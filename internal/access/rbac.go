@@ -1,17 +1,85 @@
 package access
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"gopkg.in/yaml.v3"
 )
 
+// Permission allows or denies actions on resources. Resources are patterns,
+// see matchResource.
 type Permission struct {
 	Resource string   `yaml:"resource"`
 	Actions  []string `yaml:"actions"`
+	// allow (default) or deny. Denies override allows of any role.
+	Effect string `yaml:"effect"`
+}
+
+// Permission effects
+const (
+	EFFECT_ALLOW = "allow"
+	EFFECT_DENY  = "deny"
+)
+
+// Scope of per entry resources, e.g. "entry:42:provisioning"
+const ENTRY_SCOPE = "entry"
+
+// EntryResource returns the resource in the scope of the entry.
+func EntryResource(entryID, resource string) string {
+	return ENTRY_SCOPE + ":" + entryID + ":" + resource
+}
+
+// matchResource reports whether the permission resource pattern covers the
+// resource. Resources are scoped by ":", e.g. "entry:42:provisioning", and
+// patterns are globs where * doesn't cross ":" or "/", e.g. "entry:*" or
+// "building:ag/*". A scope covers the resources in it, so "entry:42" covers
+// "entry:42:provisioning". Unscoped patterns, e.g. "provisioning", cover the
+// resource in every scope.
+func matchResource(pattern, resource string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if !strings.Contains(pattern, ":") {
+		if matchGlob(pattern, resource[strings.LastIndex(resource, ":")+1:]) {
+			return true
+		}
+	}
+	for r := resource; ; {
+		if matchGlob(pattern, r) {
+			return true
+		}
+		i := strings.LastIndex(r, ":")
+		if i < 0 {
+			return false
+		}
+		r = r[:i]
+	}
+}
+
+func matchGlob(pattern, name string) bool {
+	ok, _ := path.Match(strings.ReplaceAll(pattern, ":", "/"), strings.ReplaceAll(name, ":", "/"))
+	return ok
+}
+
+// covers reports whether the permission applies to the action on the resource.
+func (p Permission) covers(resource, action string) bool {
+	if !matchResource(p.Resource, resource) {
+		return false
+	}
+	return slices.ContainsFunc(p.Actions, func(act string) bool {
+		return act == "*" || act == action
+	})
 }
 
 type Role struct {
@@ -29,12 +97,24 @@ type RBACPolicy struct {
 }
 
 type RBAC struct {
-	policy      *RBACPolicy
-	userRoles   map[string][]string // userID -> roles
+	policy    *RBACPolicy
+	userRoles map[string][]string // userID -> roles
+	// Roles from the access list, kept when the policy is reloaded
+	listRoles map[string][]string
+	// Entries the access list roles apply to, see ScopedEntry
+	listRoleEntries map[string]map[string][]string
+	// userID -> role -> entries, for roles limited to some entries
+	roleEntries map[string]map[string][]string
+	// Users denied by the access list, e.g. banned. They have no roles, also
+	// none from the policy.
+	deniedUsers map[string]bool
 	mu          sync.RWMutex
 	policyCache map[string]map[string]bool // userID -> "resource:action" -> allowed
 }
 
+// Debounce for policy file change events, as editors write in several steps
+const RBAC_RELOAD_DEBOUNCE = 500 * time.Millisecond
+
 var (
 	rbacInstance *RBAC
 	rbacOnce     sync.Once
@@ -51,38 +131,170 @@ func GetRBAC() *RBAC {
 	return rbacInstance
 }
 
-// LoadPolicy loads RBAC policy from YAML file
-func (r *RBAC) LoadPolicy(filepath string) error {
-	data, err := os.ReadFile(filepath)
+// ParsePolicyFile reads and parses the RBAC policy file.
+func ParsePolicyFile(file string) (*RBACPolicy, error) {
+	data, err := os.ReadFile(file)
 	if err != nil {
-		return fmt.Errorf("failed to read policy file: %w", err)
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
 	}
 
 	var policy RBACPolicy
 	if err := yaml.Unmarshal(data, &policy); err != nil {
-		return fmt.Errorf("failed to parse policy file: %w", err)
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
 	}
+	return &policy, nil
+}
 
-	r.mu.Lock()
-	r.policy = &policy
-	// Load user role assignments from policy
-	r.userRoles = make(map[string][]string)
-	for userID, userData := range policy.Users {
-		r.userRoles[userID] = userData.Roles
+// LoadPolicy loads RBAC policy from YAML file, and swaps it in. Roles from the
+// access list are kept. The current policy stays if the file can't be parsed.
+func (r *RBAC) LoadPolicy(filepath string) error {
+	policy, err := ParsePolicyFile(filepath)
+	if err != nil {
+		return err
 	}
-	r.policyCache = make(map[string]map[string]bool) // Clear cache
+
+	// Typos in role names silently give no permissions
+	for _, issue := range policy.Validate(nil) {
+		if issue.Severity == POLICY_ERROR {
+			slog.Warn("RBAC policy problem", "file", filepath, "problem", issue.Message)
+		}
+	}
+
+	r.mu.Lock()
+	r.policy = policy
+	r.rebuildUserRoles()
 	r.mu.Unlock()
 
 	slog.Info("RBAC policy loaded", "roles", len(policy.Roles), "users", len(policy.Users))
 	return nil
 }
 
+// SyncUserRoles replaces role assignments with the ones from the policy file
+// and the access list entries. Used when the access list is (re)loaded.
+func (r *RBAC) SyncUserRoles(entries []EntryRecord) {
+	listRoles := make(map[string][]string)
+	listRoleEntries := make(map[string]map[string][]string)
+	for _, entry := range entries {
+		key := NormalizeEmail(entry.GetUserID())
+		var scopes map[string][]string
+		if scoped, ok := entry.(ScopedEntry); ok {
+			scopes = scoped.GetRoleEntries()
+		}
+		if scopes = mergeRoleEntries(listRoles[key], listRoleEntries[key], entry.GetUserRoles(), scopes); scopes != nil {
+			listRoleEntries[key] = scopes
+		} else {
+			delete(listRoleEntries, key)
+		}
+		listRoles[key] = append(listRoles[key], entry.GetUserRoles()...)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listRoles = listRoles
+	r.listRoleEntries = listRoleEntries
+	r.rebuildUserRoles()
+
+	slog.Debug("User roles synced", "users", len(r.userRoles))
+}
+
+// SetDeniedUsers replaces the users denied by the access list, see DenyList.
+// Denied users lose all roles, also the ones the policy assigns.
+func (r *RBAC) SetDeniedUsers(userIDs []string) {
+	denied := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		denied[NormalizeEmail(userID)] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deniedUsers = denied
+	r.rebuildUserRoles()
+
+	slog.Debug("Denied users synced", "users", len(denied))
+}
+
+// rebuildUserRoles combines the users of the policy and the access list roles,
+// and clears the cache. Roles the policy assigns apply to all entries. Caller
+// must hold the lock.
+func (r *RBAC) rebuildUserRoles() {
+	userRoles := make(map[string][]string)
+	if r.policy != nil {
+		for userID, userData := range r.policy.Users {
+			key := NormalizeEmail(userID)
+			userRoles[key] = append(userRoles[key], userData.Roles...)
+		}
+	}
+	roleEntries := make(map[string]map[string][]string)
+	for key, scopes := range r.listRoleEntries {
+		roleEntries[key] = mergeRoleEntries(userRoles[key], nil, slices.Collect(maps.Keys(scopes)), scopes)
+	}
+	for key, roles := range r.listRoles {
+		userRoles[key] = append(userRoles[key], roles...)
+	}
+	for key := range r.deniedUsers {
+		delete(userRoles, key)
+		delete(roleEntries, key)
+	}
+
+	r.userRoles = userRoles
+	r.roleEntries = roleEntries
+	r.policyCache = make(map[string]map[string]bool) // Clear cache
+}
+
+// Watch reloads the policy when the file changes, until the context is
+// cancelled. The folder is watched, as editors and config maps replace the file.
+func (r *RBAC) Watch(ctx context.Context, file string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		return fmt.Errorf("failed to watch RBAC policy folder: %w", err)
+	}
+	slog.Info("Watching RBAC policy for changes", "file", file)
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Base(ev.Name) != filepath.Base(file) || ev.Has(fsnotify.Chmod) {
+				continue
+			}
+			reload = time.After(RBAC_RELOAD_DEBOUNCE)
+
+		case <-reload:
+			reload = nil
+			if err := r.LoadPolicy(file); err != nil {
+				slog.Error("RBAC policy reload failed, keeping the current policy", "file", file, "error", err)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Warn("RBAC policy watcher error", "error", err)
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
 // AssignRole assigns one or more roles to a user
 func (r *RBAC) AssignRole(userID string, roles ...string) {
+	userID = NormalizeEmail(userID)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	r.userRoles[userID] = append(r.userRoles[userID], roles...)
+	for _, role := range roles {
+		delete(r.roleEntries[userID], role) // Assigned for all entries
+	}
 	delete(r.policyCache, userID) // Invalidate cache for this user
 
 	slog.Debug("Roles assigned", "userID", userID, "roles", roles)
@@ -90,10 +302,12 @@ func (r *RBAC) AssignRole(userID string, roles ...string) {
 
 // SetRoles replaces all roles for a user
 func (r *RBAC) SetRoles(userID string, roles ...string) {
+	userID = NormalizeEmail(userID)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	r.userRoles[userID] = roles
+	delete(r.roleEntries, userID)
 	delete(r.policyCache, userID) // Invalidate cache for this user
 
 	slog.Debug("Roles set", "userID", userID, "roles", roles)
@@ -101,6 +315,7 @@ func (r *RBAC) SetRoles(userID string, roles ...string) {
 
 // RemoveRole removes a role from a user
 func (r *RBAC) RemoveRole(userID string, role string) {
+	userID = NormalizeEmail(userID)
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -117,11 +332,19 @@ func (r *RBAC) RemoveRole(userID string, role string) {
 	slog.Debug("Role removed", "userID", userID, "role", role)
 }
 
-// GetUserRoles returns all roles for a user (including inherited)
+// GetUserRoles returns all roles for a user (including inherited). The user
+// ID may be an alias, see CanonicalID.
 func (r *RBAC) GetUserRoles(userID string) []string {
+	key := CanonicalID(userID)
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	return r.getUserRoles(key)
+}
+
+// getUserRoles returns all roles for a canonical user ID. Caller must hold the lock.
+func (r *RBAC) getUserRoles(userID string) []string {
 	if userID == "" {
 		if r.policy != nil && r.policy.DefaultRole != "" {
 			return []string{r.policy.DefaultRole}
@@ -131,6 +354,11 @@ func (r *RBAC) GetUserRoles(userID string) []string {
 		}
 	}
 
+	// Denied users don't get the default role either
+	if r.deniedUsers[userID] {
+		return []string{}
+	}
+
 	directRoles := r.userRoles[userID]
 
 	// If user has no roles and default role is defined, use default role
@@ -153,6 +381,43 @@ func (r *RBAC) GetUserRoles(userID string) []string {
 	return result
 }
 
+// getRoleEntries returns the entries each role of the user, with the roles it
+// inherits, is limited to. Roles also reached from a role of all entries are
+// not limited. Caller must hold the lock.
+func (r *RBAC) getRoleEntries(userID string) map[string][]string {
+	scopes := r.roleEntries[userID]
+	if len(scopes) == 0 {
+		return nil
+	}
+
+	global := make(map[string]bool)
+	for _, role := range r.userRoles[userID] {
+		if _, scoped := scopes[role]; !scoped && !global[role] {
+			global[role] = true
+			r.addInheritedRoles(role, global)
+		}
+	}
+	roleEntries := make(map[string][]string)
+	for role, entries := range scopes {
+		roles := map[string]bool{role: true}
+		r.addInheritedRoles(role, roles)
+		for inherited := range roles {
+			if !global[inherited] {
+				roleEntries[inherited] = unionIDs(roleEntries[inherited], entries)
+			}
+		}
+	}
+	return roleEntries
+}
+
+// inEntries reports whether the resource is in the scope of one of the entries.
+func inEntries(resource string, entryIDs []string) bool {
+	return slices.ContainsFunc(entryIDs, func(id string) bool {
+		scope := EntryResource(id, "")
+		return strings.HasPrefix(resource, scope) || resource == strings.TrimSuffix(scope, ":")
+	})
+}
+
 // addInheritedRoles recursively adds inherited roles
 func (r *RBAC) addInheritedRoles(role string, roles map[string]bool) {
 	if r.policy == nil || r.policy.Inheritance == nil {
@@ -168,10 +433,17 @@ func (r *RBAC) addInheritedRoles(role string, roles map[string]bool) {
 	}
 }
 
-// Can checks if a user can perform an action on a resource
+// Can checks if a user can perform an action on a resource, see matchResource.
+// Denies scoped to entries don't apply to unscoped resources, e.g. a deny on
+// "entry:9" doesn't deny "access_list". Check the resource of each entry acted
+// on, see EntryResource.
 func (r *RBAC) Can(userID, resource, action string) bool {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	// Resolve aliases before locking, as it may query storage
+	userID = CanonicalID(userID)
+
+	// Write lock, as the result is cached
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
 	if r.policy == nil {
 		slog.Warn("RBAC policy not loaded")
@@ -186,33 +458,31 @@ func (r *RBAC) Can(userID, resource, action string) bool {
 		}
 	}
 
-	// Get all roles for user
-	roles := r.GetUserRoles(userID)
+	// Get all roles for user. Any deny overrides the allows. Roles limited to
+	// some entries only apply to their resources.
+	roles := r.getUserRoles(userID)
+	roleEntries := r.getRoleEntries(userID)
 	allowed := false
 
+check:
 	for _, roleName := range roles {
 		role, exists := r.policy.Roles[roleName]
 		if !exists {
 			continue
 		}
+		if entries, scoped := roleEntries[roleName]; scoped && !inEntries(resource, entries) {
+			continue
+		}
 
 		for _, perm := range role.Permissions {
-			// Check wildcard resource
-			if perm.Resource == "*" || perm.Resource == resource {
-				// Check wildcard action or specific action
-				for _, act := range perm.Actions {
-					if act == "*" || act == action {
-						allowed = true
-						break
-					}
-				}
+			if !perm.covers(resource, action) {
+				continue
 			}
-			if allowed {
-				break
+			if perm.Effect == EFFECT_DENY {
+				allowed = false
+				break check
 			}
-		}
-		if allowed {
-			break
+			allowed = true
 		}
 	}
 
@@ -225,6 +495,37 @@ func (r *RBAC) Can(userID, resource, action string) bool {
 	return allowed
 }
 
+// CanGrant reports whether the user has every permission of the role and the
+// roles it inherits, so granting the role gives no more than the user has.
+// Denies of the role only take permissions away, and are not checked.
+func (r *RBAC) CanGrant(userID, role string) bool {
+	r.mu.RLock()
+	if r.policy == nil {
+		r.mu.RUnlock()
+		return false
+	}
+	roles := map[string]bool{role: true}
+	r.addInheritedRoles(role, roles)
+	var perms []Permission
+	for name := range roles {
+		for _, perm := range r.policy.Roles[name].Permissions {
+			if perm.Effect != EFFECT_DENY {
+				perms = append(perms, perm)
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, perm := range perms {
+		for _, action := range perm.Actions {
+			if !r.Can(userID, perm.Resource, action) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // Require is a helper that panics if user doesn't have permission
 func (r *RBAC) Require(userID, resource, action string) {
 	if !r.Can(userID, resource, action) {
@@ -0,0 +1,317 @@
+package access
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writePolicy(t *testing.T, path, policy string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(policy), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestRBAC_LoadPolicyKeepsAccessListRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac.yaml")
+	writePolicy(t, path, "default_role: guest\nroles:\n  guest: {}\n  student: {}\n")
+
+	rbac := &RBAC{userRoles: map[string][]string{}, policyCache: map[string]map[string]bool{}}
+	if err := rbac.LoadPolicy(path); err != nil {
+		t.Fatalf("LoadPolicy failed: %v", err)
+	}
+	rbac.SyncUserRoles([]EntryRecord{&StudentEntry{UserID: "matti@example.com", Status: true}})
+	if rbac.Can("matti@example.com", "provisioning", "write") {
+		t.Fatal("student can provision before reload")
+	}
+
+	writePolicy(t, path, `
+roles:
+  student:
+    permissions:
+      - resource: provisioning
+        actions: [write]
+  admin: {}
+users:
+  admin@example.com:
+    roles: [admin]
+`)
+	if err := rbac.LoadPolicy(path); err != nil {
+		t.Fatalf("LoadPolicy failed: %v", err)
+	}
+	if !rbac.Can("matti@example.com", "provisioning", "write") {
+		t.Error("access list role lost on reload")
+	}
+	if roles := rbac.GetUserRoles("admin@example.com"); !slices.Equal(roles, []string{"admin"}) {
+		t.Errorf("policy user roles = %v, want [admin]", roles)
+	}
+
+	// Broken file keeps the current policy
+	writePolicy(t, path, "roles: [\n")
+	if err := rbac.LoadPolicy(path); err == nil {
+		t.Error("LoadPolicy of broken file succeeded")
+	}
+	if !rbac.Can("matti@example.com", "provisioning", "write") {
+		t.Error("policy replaced by broken file")
+	}
+}
+
+func TestRBACPolicy_Validate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac.yaml")
+	writePolicy(t, path, `
+default_role: guest
+roles:
+  guest: {}
+  admin:
+    permissions:
+      - resource: "*"
+        actions: ["*"]
+  assistant:
+    permissions:
+      - resource: provisoning
+        actions: [write]
+  a: {}
+  b: {}
+  c: {}
+  unused: {}
+users:
+  x@example.com:
+    roles: [admn, admin]
+inheritance:
+  assistant: [a]
+  a: [b]
+  b: [c, ghost]
+  c: [a]
+  teacher: [guest]
+`)
+	policy, err := ParsePolicyFile(path)
+	if err != nil {
+		t.Fatalf("ParsePolicyFile failed: %v", err)
+	}
+
+	var got []string
+	for _, issue := range policy.Validate([]string{"student", "assistant"}) {
+		got = append(got, issue.String())
+	}
+	want := []string{
+		`error: users: x@example.com: unknown role "admn"`,
+		`error: inheritance: b: unknown role "ghost"`,
+		`error: inheritance: unknown role "teacher"`,
+		`error: inheritance: cycle a -> b -> c -> a`,
+		`error: access list grants unknown role "student"`,
+		`error: roles: assistant: permission on unknown resource "provisoning", expected * or one of provisioning, access_list, optionally scoped like entry:42:provisioning`,
+		`warning: roles: unused is not assigned, inherited or granted by the access list`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Validate =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Unused roles are only known with the access list roles
+	for _, issue := range policy.Validate(nil) {
+		if issue.Severity == POLICY_WARNING {
+			t.Errorf("Validate(nil) reported %s", issue)
+		}
+	}
+}
+
+func TestMatchResource(t *testing.T) {
+	tests := []struct {
+		pattern, resource string
+		want              bool
+	}{
+		{"*", "entry:42:provisioning", true},
+		{"provisioning", "provisioning", true},
+		{"provisioning", "entry:42:provisioning", true},
+		{"provisioning", "access_list", false},
+		{"entry:42:provisioning", "entry:42:provisioning", true},
+		{"entry:42:provisioning", "entry:4:provisioning", false},
+		{"entry:42:provisioning", "provisioning", false},
+		{"entry:42", "entry:42:provisioning", true},
+		{"entry:*", "entry:42:provisioning", true},
+		{"entry:*", "provisioning", false},
+		{"entry:4*:provisioning", "entry:42:provisioning", true},
+		{"entry:4*:provisioning", "entry:42:access_list", false},
+		{"building:ag/*", "building:ag/chem", true},
+		{"building:ag/*", "building:ag/chem/lab", false},
+		{"building:ag/*", "building:ag/chem:entry:3", true},
+	}
+	for _, tt := range tests {
+		if got := matchResource(tt.pattern, tt.resource); got != tt.want {
+			t.Errorf("matchResource(%q, %q) = %v, want %v", tt.pattern, tt.resource, got, tt.want)
+		}
+	}
+}
+
+func TestRBAC_ScopedPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac.yaml")
+	writePolicy(t, path, `
+roles:
+  lab_assistant:
+    permissions:
+      - resource: "entry:3:provisioning"
+        actions: [write]
+      - resource: "entry:4:provisioning"
+        actions: [write]
+  admin:
+    permissions:
+      - resource: "*"
+        actions: ["*"]
+      - resource: "entry:9"
+        actions: ["*"]
+        effect: deny
+users:
+  assistant@example.com:
+    roles: [lab_assistant]
+  admin@example.com:
+    roles: [admin]
+`)
+	rbac := &RBAC{userRoles: map[string][]string{}, policyCache: map[string]map[string]bool{}}
+	if err := rbac.LoadPolicy(path); err != nil {
+		t.Fatalf("LoadPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		user, resource, action string
+		want                   bool
+	}{
+		{"assistant@example.com", EntryResource("3", "provisioning"), "write", true},
+		{"assistant@example.com", EntryResource("4", "provisioning"), "write", true},
+		{"assistant@example.com", EntryResource("5", "provisioning"), "write", false},
+		{"assistant@example.com", EntryResource("3", "provisioning"), "read", false},
+		{"assistant@example.com", EntryResource("3", "access_list"), "write", false},
+		{"assistant@example.com", "provisioning", "write", false},
+		{"admin@example.com", "provisioning", "write", true},
+		{"admin@example.com", EntryResource("5", "provisioning"), "write", true},
+		// Deny overrides the allow of all resources
+		{"admin@example.com", EntryResource("9", "provisioning"), "write", false},
+		{"admin@example.com", EntryResource("9", "access_list"), "read", false},
+		// Entry denies only apply when the entry is checked
+		{"admin@example.com", "access_list", "read", true},
+	}
+	for _, tt := range tests {
+		if got := rbac.Can(tt.user, tt.resource, tt.action); got != tt.want {
+			t.Errorf("Can(%q, %q, %q) = %v, want %v", tt.user, tt.resource, tt.action, got, tt.want)
+		}
+	}
+
+	policy, _ := ParsePolicyFile(path)
+	if issues := policy.Validate(nil); len(issues) != 0 {
+		t.Errorf("Validate of scoped policy = %v, want none", issues)
+	}
+	for _, resource := range []string{"entry", "entry:*", "entry:4*:provisioning", "entry:3:*"} {
+		if !knownResource(resource) {
+			t.Errorf("knownResource(%q) = false", resource)
+		}
+	}
+	for _, resource := range []string{"entry:", "entry:3:provisoning", "room:3", "entry:[", "entry:3:provisioning:x"} {
+		if knownResource(resource) {
+			t.Errorf("knownResource(%q) = true", resource)
+		}
+	}
+}
+
+func TestRBAC_CanGrant(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac.yaml")
+	writePolicy(t, path, `
+roles:
+  student: {}
+  teacher:
+    permissions:
+      - resource: "entry:3:access_list"
+        actions: [write]
+      - resource: "entry:9"
+        actions: ["*"]
+        effect: deny
+  head_teacher:
+    permissions:
+      - resource: "entry:4:access_list"
+        actions: [write]
+  admin:
+    permissions:
+      - resource: "*"
+        actions: ["*"]
+inheritance:
+  head_teacher: [teacher]
+users:
+  teacher@example.com:
+    roles: [teacher]
+  head@example.com:
+    roles: [head_teacher]
+`)
+	rbac := &RBAC{userRoles: map[string][]string{}, policyCache: map[string]map[string]bool{}}
+	if err := rbac.LoadPolicy(path); err != nil {
+		t.Fatalf("LoadPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		user, role string
+		want       bool
+	}{
+		{"teacher@example.com", "student", true},
+		{"teacher@example.com", "teacher", true},
+		{"teacher@example.com", "head_teacher", false},
+		{"teacher@example.com", "admin", false},
+		// Inherited permissions count for both
+		{"head@example.com", "teacher", true},
+		{"head@example.com", "head_teacher", true},
+		{"head@example.com", "admin", false},
+	}
+	for _, tt := range tests {
+		if got := rbac.CanGrant(tt.user, tt.role); got != tt.want {
+			t.Errorf("CanGrant(%q, %q) = %v, want %v", tt.user, tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestRBAC_ScopedRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac.yaml")
+	writePolicy(t, path, `
+roles:
+  student: {}
+  assistant:
+    permissions:
+      - resource: provisioning
+        actions: [write]
+  teacher:
+    permissions:
+      - resource: access_list
+        actions: [write]
+inheritance:
+  teacher: [assistant]
+users:
+  head@example.com:
+    roles: [teacher]
+`)
+	rbac := &RBAC{userRoles: map[string][]string{}, policyCache: map[string]map[string]bool{}}
+	if err := rbac.LoadPolicy(path); err != nil {
+		t.Fatalf("LoadPolicy failed: %v", err)
+	}
+	scoped := map[string][]string{"teacher": {"3"}}
+	rbac.SyncUserRoles([]EntryRecord{
+		&StudentEntry{UserID: "teacher@example.com", Status: true, Roles: []string{"teacher"}, RoleEntries: scoped},
+		// The policy assigns the role for all entries
+		&StudentEntry{UserID: "head@example.com", Status: true, Roles: []string{"teacher"}, RoleEntries: scoped},
+	})
+
+	tests := []struct {
+		user, resource string
+		want           bool
+	}{
+		{"teacher@example.com", EntryResource("3", "access_list"), true},
+		{"teacher@example.com", EntryResource("4", "access_list"), false},
+		{"teacher@example.com", "access_list", false},
+		// Inherited roles have the same entries
+		{"teacher@example.com", EntryResource("3", "provisioning"), true},
+		{"teacher@example.com", EntryResource("4", "provisioning"), false},
+		{"head@example.com", EntryResource("4", "access_list"), true},
+		{"head@example.com", "access_list", true},
+	}
+	for _, tt := range tests {
+		if got := rbac.Can(tt.user, tt.resource, "write"); got != tt.want {
+			t.Errorf("Can(%q, %q) = %v, want %v", tt.user, tt.resource, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package access
+
+// Checks for RBAC policies. A typo in a role or resource name doesn't fail
+// anything, it just gives no permissions, so report them.
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Resources checked by the routes
+var RBAC_RESOURCES = []string{"provisioning", "access_list"}
+
+// Scopes of resources checked by the routes
+var RBAC_SCOPES = []string{ENTRY_SCOPE}
+
+// Severity of policy issues
+const (
+	// Policy doesn't do what it says
+	POLICY_ERROR = "error"
+	// Policy has leftovers
+	POLICY_WARNING = "warning"
+)
+
+type PolicyIssue struct {
+	Severity string
+	Message  string
+}
+
+func (i PolicyIssue) String() string {
+	return i.Severity + ": " + i.Message
+}
+
+// Validate reports unknown roles in users and inheritance, inheritance cycles,
+// unused roles, and permissions on unknown resources. Roles granted by the
+// access list count as used, and must be defined. Without granted roles, unused
+// roles are not reported.
+func (p *RBACPolicy) Validate(granted []string) []PolicyIssue {
+	var issues []PolicyIssue
+	errorf := func(format string, args ...any) {
+		issues = append(issues, PolicyIssue{Severity: POLICY_ERROR, Message: fmt.Sprintf(format, args...)})
+	}
+	known := func(role string) bool {
+		_, ok := p.Roles[role]
+		return ok
+	}
+
+	used := map[string]bool{}
+	if p.DefaultRole != "" {
+		used[p.DefaultRole] = true
+		if !known(p.DefaultRole) {
+			errorf("default_role: unknown role %q", p.DefaultRole)
+		}
+	}
+
+	for _, user := range slices.Sorted(maps.Keys(p.Users)) {
+		for _, role := range p.Users[user].Roles {
+			used[role] = true
+			if !known(role) {
+				errorf("users: %s: unknown role %q", user, role)
+			}
+		}
+	}
+
+	for _, role := range slices.Sorted(maps.Keys(p.Inheritance)) {
+		if !known(role) {
+			errorf("inheritance: unknown role %q", role)
+		}
+		for _, inherited := range p.Inheritance[role] {
+			used[inherited] = true
+			if !known(inherited) {
+				errorf("inheritance: %s: unknown role %q", role, inherited)
+			}
+		}
+	}
+	for _, cycle := range p.inheritanceCycles() {
+		errorf("inheritance: cycle %s", strings.Join(cycle, " -> "))
+	}
+
+	for _, role := range slices.Compact(slices.Sorted(slices.Values(granted))) {
+		used[role] = true
+		if !known(role) {
+			errorf("access list grants unknown role %q", role)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(p.Roles)) {
+		for _, perm := range p.Roles[name].Permissions {
+			if !knownResource(perm.Resource) {
+				errorf("roles: %s: permission on unknown resource %q, expected * or one of %s, optionally scoped like %s", name, perm.Resource, strings.Join(RBAC_RESOURCES, ", "), EntryResource("42", RBAC_RESOURCES[0]))
+			}
+			if perm.Effect != "" && perm.Effect != EFFECT_ALLOW && perm.Effect != EFFECT_DENY {
+				errorf("roles: %s: %s: invalid effect %q, expected %s or %s", name, perm.Resource, perm.Effect, EFFECT_ALLOW, EFFECT_DENY)
+			}
+		}
+		if granted != nil && !used[name] {
+			issues = append(issues, PolicyIssue{Severity: POLICY_WARNING, Message: fmt.Sprintf("roles: %s is not assigned, inherited or granted by the access list", name)})
+		}
+	}
+	return issues
+}
+
+// inheritanceCycles returns each inheritance cycle once, starting from its
+// alphabetically first role, e.g. [a b a].
+func (p *RBACPolicy) inheritanceCycles() [][]string {
+	var cycles [][]string
+	seen := map[string]bool{}
+	for _, start := range slices.Sorted(maps.Keys(p.Inheritance)) {
+		var path []string
+		var visit func(role string)
+		visit = func(role string) {
+			if i := slices.Index(path, role); i >= 0 {
+				cycle := append(slices.Clone(path[i:]), role)
+				if cycle[0] == start && !seen[strings.Join(cycle, ">")] {
+					seen[strings.Join(cycle, ">")] = true
+					cycles = append(cycles, cycle)
+				}
+				return
+			}
+			path = append(path, role)
+			for _, inherited := range p.Inheritance[role] {
+				// Roles before the start have had their cycles reported
+				if inherited >= start {
+					visit(inherited)
+				}
+			}
+			path = path[:len(path)-1]
+		}
+		visit(start)
+	}
+	return cycles
+}
+
+// knownResource reports whether the pattern can match a resource checked by
+// the routes: "*", a resource, a scope, e.g. "entry:*", or a resource in a
+// scope, e.g. "entry:4*:provisioning".
+func knownResource(pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return false
+	}
+	matchesAny := func(pattern string, names []string) bool {
+		return slices.ContainsFunc(names, func(name string) bool {
+			return matchGlob(pattern, name)
+		})
+	}
+
+	segments := strings.Split(pattern, ":")
+	switch {
+	case len(segments) == 1:
+		return matchesAny(pattern, RBAC_RESOURCES) || matchesAny(pattern, RBAC_SCOPES)
+	case !matchesAny(segments[0], RBAC_SCOPES) || segments[1] == "":
+		return false
+	case len(segments) == 2:
+		return true
+	case len(segments) == 3:
+		return matchesAny(segments[2], RBAC_RESOURCES)
+	}
+	return false
+}
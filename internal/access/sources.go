@@ -0,0 +1,236 @@
+package access
+
+// Roles and entries granted by access list files. A file declares them in its
+// sidecar metadata, or rules in the sources file match it by glob pattern:
+//
+//	sources:
+//	  - pattern: "teachers*.csv"
+//	    roles: [teacher]
+//	    entries: ["3", "4"]
+//	  - pattern: "courses/chem-*"
+//	    entries: ["3"]
+//	row_roles: [teacher]
+//
+// Patterns are relative to the access list folder, and patterns without a
+// slash match the file name in any subfolder. The first matching rule is used,
+// and metadata overrides it. Roles apply to rows without a role column value.
+// Role column values are limited to row_roles, as anyone uploading a file
+// could otherwise make themselves admin.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SourceGrant is what an access list file grants its active users.
+type SourceGrant struct {
+	// Roles of users without roles in the file. Empty gives the default student role.
+	Roles []string `yaml:"roles"`
+	// IDs of entries the users can access. Empty grants all entries.
+	Entries []string `yaml:"entries"`
+}
+
+// SourceRule assigns a grant to files matching the pattern.
+type SourceRule struct {
+	Pattern     string `yaml:"pattern"`
+	SourceGrant `yaml:",inline"`
+}
+
+// AccessListSources is the content of the sources file.
+type AccessListSources struct {
+	Sources []SourceRule `yaml:"sources"`
+	// Roles rows can have in the role column of files
+	RowRoles []string `yaml:"row_roles"`
+}
+
+// LoadSources reads the sources file. Missing file has no rules, and allows no row roles.
+func LoadSources(file string) (AccessListSources, error) {
+	var raw AccessListSources
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return raw, nil
+	}
+	if err != nil {
+		return raw, fmt.Errorf("failed to read access list sources: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return raw, fmt.Errorf("failed to parse access list sources %s: %w", file, err)
+	}
+	if err := (SourceGrant{Roles: raw.RowRoles}).validate(); err != nil {
+		return raw, fmt.Errorf("access list sources %s: row_roles: %w", file, err)
+	}
+	for i, rule := range raw.Sources {
+		if rule.Pattern == "" {
+			return raw, fmt.Errorf("access list sources %s: rule %d has no pattern", file, i+1)
+		}
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return raw, fmt.Errorf("access list sources %s: invalid pattern %q: %w", file, rule.Pattern, err)
+		}
+		if err := rule.validate(); err != nil {
+			return raw, fmt.Errorf("access list sources %s: %s: %w", file, rule.Pattern, err)
+		}
+	}
+	return raw, nil
+}
+
+func (g SourceGrant) validate() error {
+	for _, role := range g.Roles {
+		if strings.TrimSpace(role) == "" {
+			return errors.New("empty role")
+		}
+	}
+	for _, entry := range g.Entries {
+		if strings.TrimSpace(entry) == "" {
+			return errors.New("empty entry")
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to the file, given relative to the access list folder.
+func (r SourceRule) Matches(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if !strings.Contains(r.Pattern, "/") {
+		rel = path.Base(rel)
+	}
+	ok, _ := path.Match(r.Pattern, rel)
+	return ok
+}
+
+// ResolveGrant returns the grant of the file from its metadata, or the first
+// rule matching its path relative to the access list folder.
+func ResolveGrant(rel string, meta *FileMetadata, rules []SourceRule) SourceGrant {
+	var grant SourceGrant
+	for _, rule := range rules {
+		if rule.Matches(rel) {
+			grant = rule.SourceGrant
+			break
+		}
+	}
+	if meta != nil && len(meta.Roles) > 0 {
+		grant.Roles = meta.Roles
+	}
+	if meta != nil && len(meta.Entries) > 0 {
+		grant.Entries = meta.Entries
+	}
+	if len(grant.Entries) == 0 {
+		grant.Entries = nil
+	}
+	return grant
+}
+
+// FilterRowRoles drops row roles not in the allowed roles, and records them in
+// RejectedRoles. Rows left without roles get the roles of the file.
+func (f *CSVFile) FilterRowRoles(allowed []string) {
+	for _, e := range f.Entries {
+		e.Roles = slices.DeleteFunc(e.Roles, func(role string) bool {
+			if slices.Contains(allowed, role) {
+				return false
+			}
+			if !slices.Contains(f.RejectedRoles, role) {
+				f.RejectedRoles = append(f.RejectedRoles, role)
+			}
+			return true
+		})
+	}
+}
+
+// GrantedRoles returns the roles the file gives its active users: their row
+// roles, the roles of the file, or the default student role.
+func (f *CSVFile) GrantedRoles() []string {
+	var roles []string
+	for _, e := range FileEntries("", f) {
+		if !e.CanAccess("") {
+			continue
+		}
+		for _, role := range e.GetUserRoles() {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// mergeEntry merges the access of another row of the same user. Active rows
+// add their roles and entries, and the active grant lasting longest is
+// recorded as the source.
+func mergeEntry(existing, e *StudentEntry) {
+	if !e.Status {
+		return
+	}
+	if !existing.Status {
+		existing.Status = true
+		existing.Roles = e.Roles
+		existing.RoleEntries = e.RoleEntries
+		existing.Entries = e.Entries
+		existing.Source = e.Source
+		existing.RawStatus = e.RawStatus
+		existing.ValidUntil = e.ValidUntil
+		return
+	}
+
+	// Users without roles have the default role, which is kept
+	roles := existing.GetUserRoles()
+	existing.RoleEntries = mergeRoleEntries(roles, existing.RoleEntries, e.GetUserRoles(), e.RoleEntries)
+	existing.Roles = unionIDs(roles, e.GetUserRoles())
+
+	// A grant of all entries gives access to all, but its roles don't widen
+	// the roles of grants limited to some entries, see mergeRoleEntries
+	if existing.Entries == nil || e.Entries == nil {
+		existing.Entries = nil
+	} else {
+		existing.Entries = unionIDs(existing.Entries, e.Entries)
+	}
+
+	if laterUntil(e.ValidUntil, existing.ValidUntil) {
+		existing.Source = e.Source
+		existing.RawStatus = e.RawStatus
+		existing.ValidUntil = e.ValidUntil
+	}
+}
+
+// mergeRoleEntries combines the entries roles apply to, see
+// StudentEntry.RoleEntries. Roles either side grants on all entries apply to
+// all entries, and the entries of other roles are combined.
+func mergeRoleEntries(rolesA []string, a map[string][]string, rolesB []string, b map[string][]string) map[string][]string {
+	merged := make(map[string][]string)
+	for _, role := range rolesA {
+		if a[role] != nil {
+			merged[role] = a[role]
+		}
+	}
+	for _, role := range rolesB {
+		switch {
+		case slices.Contains(rolesA, role) && a[role] == nil:
+			// All entries already
+		case b[role] == nil:
+			delete(merged, role)
+		default:
+			merged[role] = unionIDs(merged[role], b[role])
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// unionIDs returns the IDs of both, without modifying either.
+func unionIDs(a, b []string) []string {
+	for _, id := range b {
+		if !slices.Contains(a, id) {
+			// Don't append to a slice shared with the file
+			a = append(a[:len(a):len(a)], id)
+		}
+	}
+	return a
+}
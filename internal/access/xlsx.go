@@ -0,0 +1,245 @@
+package access
+
+// Minimal XLSX reader for access lists. Only cell values of the first
+// worksheet are read, formatting and formulas are ignored.
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Limits for XLSX files. A small upload can decompress to gigabytes, and a
+// single cell far right pads its row with empty cells.
+const (
+	// Columns in Excel, A to XFD
+	XLSX_MAX_COLUMNS = 16384
+	// Uncompressed size of a file in the archive
+	XLSX_MAX_PART_SIZE = 64 << 20
+	// Cells in the worksheet, including the empty cells rows are padded with
+	XLSX_MAX_CELLS = 4 << 20
+)
+
+type xlsxParser struct{}
+
+func (xlsxParser) Name() string { return "xlsx" }
+
+func (p xlsxParser) Sniff(data []byte) bool {
+	return p.SniffArchive(bytes.NewReader(data), int64(len(data)))
+}
+
+// SniffArchive looks for the workbook in the zip directory. Only the start and
+// the directory of the file are read.
+func (xlsxParser) SniffArchive(r io.ReaderAt, size int64) bool {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil || string(magic) != "PK\x03\x04" {
+		return false
+	}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return false
+	}
+	for _, f := range zr.File {
+		if f.Name == "xl/workbook.xml" {
+			return true
+		}
+	}
+	return false
+}
+
+func (xlsxParser) Parse(data []byte, definitions []CSVListDefinition) (*CSVFile, error) {
+	rows, err := readXLSXRows(data)
+	if err != nil {
+		return nil, err
+	}
+	return parseTable(definitions, func(def *CSVListDefinition) (rowReader, error) {
+		return &sliceRows{rows: rows}, nil
+	})
+}
+
+// sliceRows reads rows from memory.
+type sliceRows struct {
+	rows [][]string
+}
+
+func (s *sliceRows) Read() ([]string, error) {
+	if len(s.rows) == 0 {
+		return nil, io.EOF
+	}
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row, nil
+}
+
+// Rich text is split in runs, each with its own text element
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	var sb strings.Builder
+	sb.WriteString(t.T)
+	for _, r := range t.Runs {
+		sb.WriteString(r.T)
+	}
+	return sb.String()
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSXRows returns cell values of the first worksheet as rows.
+func readXLSXRows(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLSX file: %w", err)
+	}
+
+	sheetPath, err := xlsxFirstSheet(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared xlsxSharedStrings
+	if err := readXLSXPart(zr, "xl/sharedStrings.xml", &shared); err != nil && !errors.Is(err, errXLSXPartMissing) {
+		return nil, err
+	}
+
+	var sheet xlsxWorksheet
+	if err := readXLSXPart(zr, sheetPath, &sheet); err != nil {
+		return nil, err
+	}
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	cells := 0
+	for _, r := range sheet.Rows {
+		var row []string
+		for i, c := range r.Cells {
+			col := i
+			if c.Ref != "" {
+				if col, err = xlsxColumn(c.Ref); err != nil {
+					return nil, err
+				}
+			}
+			if col >= XLSX_MAX_COLUMNS {
+				return nil, fmt.Errorf("cell %s is beyond the last column XFD", c.Ref)
+			}
+			if len(row) <= col {
+				if cells += col + 1 - len(row); cells > XLSX_MAX_CELLS {
+					return nil, fmt.Errorf("worksheet has more than %d cells", XLSX_MAX_CELLS)
+				}
+				row = append(row, make([]string, col+1-len(row))...)
+			}
+
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(c.Value)
+				if err != nil || idx < 0 || idx >= len(shared.Items) {
+					return nil, fmt.Errorf("invalid shared string %q in cell %s", c.Value, c.Ref)
+				}
+				row[col] = shared.Items[idx].String()
+			case "inlineStr":
+				row[col] = c.Inline.String()
+			default:
+				row[col] = c.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+var errXLSXPartMissing = errors.New("XLSX part missing")
+
+func readXLSXPart(zr *zip.Reader, name string, v any) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errXLSXPartMissing, name)
+	}
+	defer f.Close()
+	r := &io.LimitedReader{R: f, N: XLSX_MAX_PART_SIZE}
+	if err := xml.NewDecoder(r).Decode(v); err != nil {
+		if r.N == 0 {
+			return fmt.Errorf("XLSX %s is larger than %d MiB", name, XLSX_MAX_PART_SIZE>>20)
+		}
+		return fmt.Errorf("failed to read XLSX %s: %w", name, err)
+	}
+	return nil
+}
+
+// xlsxFirstSheet resolves the path of the first worksheet from the workbook.
+func xlsxFirstSheet(zr *zip.Reader) (string, error) {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	var workbook xlsxWorkbook
+	if err := readXLSXPart(zr, "xl/workbook.xml", &workbook); err != nil {
+		return "", err
+	}
+	var rels xlsxRelationships
+	if err := readXLSXPart(zr, "xl/_rels/workbook.xml.rels", &rels); err != nil || len(workbook.Sheets) == 0 {
+		return fallback, nil
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].ID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return fallback, nil
+}
+
+// xlsxColumn returns the zero based column index of a cell reference, e.g. 27 for "AB12".
+func xlsxColumn(ref string) (int, error) {
+	col := 0
+	for i, r := range ref {
+		if r >= 'A' && r <= 'Z' {
+			// Stop before overflowing, anything past the last column is rejected
+			if col = col*26 + int(r-'A') + 1; col > XLSX_MAX_COLUMNS {
+				return col - 1, nil
+			}
+			continue
+		}
+		if i == 0 {
+			break
+		}
+		return col - 1, nil
+	}
+	return 0, fmt.Errorf("invalid cell reference %q", ref)
+}
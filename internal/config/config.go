@@ -5,11 +5,14 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
 
 	"entry-access-control/internal/email"
+	"entry-access-control/internal/utils"
 )
 
 const DEFAULT_SUPPORT_URL = "https://github.com/isoteemu/entry-access"
@@ -19,6 +22,56 @@ type RBACConfig struct {
 	PolicyFile string `mapstructure:"policy_file"` // Path to the RBAC policy file
 }
 
+type MonitorConfig struct {
+	// Device is stale when it hasn't been seen for this many seconds
+	StaleAfter uint `mapstructure:"stale_after"`
+	// Device is offline, and an alert is sent, when it hasn't been seen for this many seconds
+	OfflineAfter uint `mapstructure:"offline_after"`
+	// How often devices are checked, in seconds. 0 disables the checker.
+	CheckInterval uint `mapstructure:"check_interval"`
+	// Comma separated list of email addresses to alert
+	AlertEmail string `mapstructure:"alert_email"`
+	// URL to POST alerts to as JSON
+	WebhookURL string `mapstructure:"webhook_url"`
+}
+
+type LDAPConfig struct {
+	// Server URL, e.g. ldaps://ldap.example.com or ldap://localhost:3893
+	URL string `mapstructure:"url"`
+	// Upgrade ldap:// connections with StartTLS
+	StartTLS bool `mapstructure:"start_tls"`
+	// Service account for searches. Empty binds anonymously.
+	BindDN       string `mapstructure:"bind_dn"`
+	BindPassword string `mapstructure:"bind_password"`
+	BaseDN       string `mapstructure:"base_dn"`
+	// Filter for user objects, combined with the mail attribute match
+	Filter        string `mapstructure:"filter"`
+	MailAttribute string `mapstructure:"mail_attribute"`
+	// Attribute listing the groups of the user
+	GroupAttribute string `mapstructure:"group_attribute"`
+	// Semicolon separated group to role mapping, e.g. "cn=staff,ou=groups,dc=example,dc=com=teacher;admins=admin".
+	// Groups are full DNs or common names.
+	GroupRoles string `mapstructure:"group_roles"`
+	// How long lookups are cached, and how often the user list is refreshed, in seconds
+	CacheTTL uint `mapstructure:"cache_ttl"`
+}
+
+type SCIMConfig struct {
+	// Bearer token of the identity provider. Empty disables the SCIM API.
+	Token string `mapstructure:"token"`
+	// Semicolon separated group to role mapping by group display name, e.g. "Lab staff=teacher;Admins=admin".
+	// Groups not in the mapping give no roles.
+	GroupRoles string `mapstructure:"group_roles"`
+}
+
+// IdentityConfig sets how user identifiers are mapped to the canonical user ID.
+type IdentityConfig struct {
+	// Alternate domains of the canonical domain, e.g. "student.jyu.fi=jyu.fi;alumni.jyu.fi=jyu.fi"
+	DomainAliases string `mapstructure:"domain_aliases"`
+	// Strip plus addressing, e.g. "matti+lab@jyu.fi" to "matti@jyu.fi"
+	StripPlus bool `mapstructure:"strip_plus"`
+}
+
 type Config struct {
 	// Secret key for signing tokens. Must be set in production.
 	Secret string `mapstructure:"secret"`
@@ -32,13 +85,41 @@ type Config struct {
 	InstancePath string `mapstructure:"instance_path"` // Path to instance folder, where deployment specific files are stored.
 
 	// Comma separated list of allowed CIDR networks. Empty means allow all.
-	AllowedNetworks  string `mapstructure:"allowed_networks"`
+	AllowedNetworks string `mapstructure:"allowed_networks"`
+	// Access list backend: csv, ldap, scim or composite
+	AccessListType   string `mapstructure:"access_list"`
 	AccessListFolder string `mapstructure:"access_list_folder"` // Folder for access list CSVs
+	// YAML column definitions for access list CSVs. Relative to the instance folder.
+	AccessListColumns string `mapstructure:"access_list_columns"`
+	// YAML rules granting roles and entries to access list files. Relative to the instance folder.
+	AccessListSources string `mapstructure:"access_list_sources"`
+	// YAML backends of the composite access list. Relative to the instance folder.
+	AccessListBackends string `mapstructure:"access_list_backends"`
+
+	// Policy for devices changing IP address: strict, subnet, allowed_networks or ignore.
+	// Can be overridden per device.
+	DeviceIPPolicy string `mapstructure:"device_ip_policy"`
+	// Prefix lengths used by the subnet policy
+	DeviceIPPrefixV4 int `mapstructure:"device_ip_prefix_v4"`
+	DeviceIPPrefixV6 int `mapstructure:"device_ip_prefix_v6"`
+
+	// Oldest entry device client version still served. Older clients get an error page. Empty allows all.
+	MinClientVersion string `mapstructure:"min_client_version"`
 
 	RBAC RBACConfig `mapstructure:"rbac"`
 
+	Monitor MonitorConfig `mapstructure:"monitor"`
+
+	LDAP LDAPConfig `mapstructure:"ldap"`
+
+	SCIM SCIMConfig `mapstructure:"scim"`
+
+	Identity IdentityConfig `mapstructure:"identity"`
+
 	// User authentication TTL in days.
 	UserAuthTTL uint `mapstructure:"user_auth_ttl"`
+	// Days login sessions and entry attempts are kept, as they store IP addresses and user agents. 0 keeps them forever.
+	UserActivityRetention uint `mapstructure:"user_activity_retention"`
 
 	BaseURL    string `mapstructure:"base_url"` // Base URL for the application. May be relative, e.g. /entry-acces/, or absolute, e.g. https://example.com/entry-access/
 	SupportURL string `mapstructure:"support_url"`
@@ -51,6 +132,24 @@ type Config struct {
 
 var Cfg *Config
 
+// Sections whose settings are read from environment variables named
+// SECTION_SETTING, e.g. MONITOR_WEBHOOK_URL for monitor.webhook_url. Other
+// nested settings keep the dotted name, e.g. STORAGE.SQLITE.PATH.
+var envSections = []string{"monitor", "ldap", "scim", "identity", "rbac"}
+
+// bindSectionEnv binds the settings of envSections to their environment
+// variables. The dotted name is still read, so existing deployments keep working.
+func bindSectionEnv(v *viper.Viper) {
+	for _, key := range v.AllKeys() {
+		section, _, nested := strings.Cut(key, ".")
+		if !nested || !slices.Contains(envSections, section) {
+			continue
+		}
+		env := strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		v.BindEnv(key, env, strings.ToUpper(key))
+	}
+}
+
 // Check if running in Docker container by checking for the presence of /.dockerenv file
 func runningInDocker() bool {
 	if _, err := os.Stat("/.dockerenv"); err == nil {
@@ -112,6 +211,7 @@ func LoadConfig(configFile ...string) (*Config, error) {
 
 	// Load configuration from environment variables
 	v.AutomaticEnv()
+	bindSectionEnv(v)
 
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("unable to decode into struct: %v", err)
@@ -140,6 +240,54 @@ func LoadConfig(configFile ...string) (*Config, error) {
 		cfg.TokenExpirySkew = maxSkew
 	}
 
+	ipPolicy, err := utils.ParseIPPolicy(cfg.DeviceIPPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEVICE_IP_POLICY: %v", err)
+	}
+	if ipPolicy == utils.IPPolicyAllowedNetworks {
+		networks, err := utils.ParseCIDRs(cfg.AllowedNetworks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ALLOWED_NETWORKS: %v", err)
+		}
+		// Without networks, any IP change would return the device to pending
+		if len(networks) == 0 {
+			return nil, fmt.Errorf("DEVICE_IP_POLICY=allowed_networks requires ALLOWED_NETWORKS")
+		}
+	}
+	if cfg.DeviceIPPrefixV4 < 0 || cfg.DeviceIPPrefixV4 > 32 {
+		return nil, fmt.Errorf("DEVICE_IP_PREFIX_V4 must be between 0 and 32, got %d", cfg.DeviceIPPrefixV4)
+	}
+	if cfg.DeviceIPPrefixV6 < 0 || cfg.DeviceIPPrefixV6 > 128 {
+		return nil, fmt.Errorf("DEVICE_IP_PREFIX_V6 must be between 0 and 128, got %d", cfg.DeviceIPPrefixV6)
+	}
+
+	if cfg.MinClientVersion != "" {
+		if _, err := utils.CompareVersions(cfg.MinClientVersion, cfg.MinClientVersion); err != nil {
+			return nil, fmt.Errorf("invalid MIN_CLIENT_VERSION: %v", err)
+		}
+	}
+
+	switch cfg.AccessListType {
+	case "csv":
+	case "ldap":
+		if cfg.LDAP.URL == "" || cfg.LDAP.BaseDN == "" {
+			return nil, fmt.Errorf("LDAP_URL and LDAP_BASE_DN are required with ACCESS_LIST=ldap")
+		}
+	case "scim":
+		if cfg.SCIM.Token == "" {
+			return nil, fmt.Errorf("SCIM_TOKEN is required with ACCESS_LIST=scim")
+		}
+	case "composite":
+		// Backends are validated when the access list is loaded
+	default:
+		return nil, fmt.Errorf("invalid ACCESS_LIST %q, expected csv, ldap, scim or composite", cfg.AccessListType)
+	}
+
+	if cfg.Monitor.StaleAfter > cfg.Monitor.OfflineAfter {
+		slog.Warn("MONITOR.STALE_AFTER must be at most MONITOR.OFFLINE_AFTER", slog.Int("stale_after", int(cfg.Monitor.StaleAfter)), slog.Int("offline_after", int(cfg.Monitor.OfflineAfter)))
+		cfg.Monitor.StaleAfter = cfg.Monitor.OfflineAfter
+	}
+
 	// Convert relative sqlite path to absolute instance folder
 	if cfg.Storage.SQLite != nil {
 		if cfg.Storage.SQLite.Path == ":memory:" {
@@ -149,5 +297,15 @@ func LoadConfig(configFile ...string) (*Config, error) {
 		}
 	}
 
+	if !filepath.IsAbs(cfg.AccessListColumns) {
+		cfg.AccessListColumns = filepath.Join(cfg.InstancePath, cfg.AccessListColumns)
+	}
+	if !filepath.IsAbs(cfg.AccessListSources) {
+		cfg.AccessListSources = filepath.Join(cfg.InstancePath, cfg.AccessListSources)
+	}
+	if !filepath.IsAbs(cfg.AccessListBackends) {
+		cfg.AccessListBackends = filepath.Join(cfg.InstancePath, cfg.AccessListBackends)
+	}
+
 	return &cfg, nil
 }
@@ -10,15 +10,59 @@ var defaults = map[string]any{
 
 	"allowed_networks": "",
 
+	"access_list":          "csv",
+	"access_list_columns":  "access_list_columns.yaml",
+	"access_list_sources":  "access_list_sources.yaml",
+	"access_list_backends": "access_list_backends.yaml",
+
+	"device_ip_policy":    "strict",
+	"device_ip_prefix_v4": 24,
+	"device_ip_prefix_v6": 64,
+
+	"min_client_version": "",
+
 	"user_auth_ttl": 8, // 8 days
 	"support_url":   DEFAULT_SUPPORT_URL,
 	"base_url":      "/",
 
+	"user_activity_retention": 90, // 90 days
+
 	"RBAC": map[string]any{
 		"policy_file": "./rbac.yaml",
 		"admins":      []string{},
 	},
 
+	"Monitor": map[string]any{
+		"stale_after":    60,     // 1 minute
+		"offline_after":  5 * 60, // 5 minutes
+		"check_interval": 60,     // 1 minute
+		"alert_email":    "",
+		"webhook_url":    "",
+	},
+
+	"LDAP": map[string]any{
+		"url":             "",
+		"start_tls":       false,
+		"bind_dn":         "",
+		"bind_password":   "",
+		"base_dn":         "",
+		"filter":          "(objectClass=person)",
+		"mail_attribute":  "mail",
+		"group_attribute": "memberOf",
+		"group_roles":     "",
+		"cache_ttl":       300, // 5 minutes
+	},
+
+	"SCIM": map[string]any{
+		"token":       "",
+		"group_roles": "",
+	},
+
+	"Identity": map[string]any{
+		"domain_aliases": "",
+		"strip_plus":     false,
+	},
+
 	"Storage": map[string]any{
 		"SQLite": map[string]any{
 			"Path": "./storage.db",
@@ -0,0 +1,156 @@
+// Package display resolves kiosk display settings for entry devices.
+//
+// Settings are stored per entry and per device. Device settings override the
+// settings of the entries the device is approved for. Kiosks re-fetch the
+// settings when the version stamp of the resolved settings changes.
+package display
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"entry-access-control/internal/storage"
+)
+
+// Setting keys
+const (
+	KeyLocale          = "locale"
+	KeyIdleMessage     = "idle_message"
+	KeyDarkHours       = "dark_hours"
+	KeyQRRefreshMargin = "qr_refresh_margin"
+	KeyHeaderText      = "header_text"
+)
+
+// Keys lists the known setting keys
+var Keys = []string{KeyLocale, KeyIdleMessage, KeyDarkHours, KeyQRRefreshMargin, KeyHeaderText}
+
+// Supported kiosk locales
+var Locales = []string{"en", "fi", "sv"}
+
+// Settings are the resolved display settings sent to the kiosk
+type Settings struct {
+	Locale      string `json:"locale,omitempty"`
+	IdleMessage string `json:"idle_message,omitempty"`
+	// Daily period the display is dimmed, e.g. "22:00-06:00"
+	DarkHours string `json:"dark_hours,omitempty"`
+	// Seconds before QR code expiry to fetch a new one
+	QRRefreshMargin uint   `json:"qr_refresh_margin,omitempty"`
+	HeaderText      string `json:"header_text,omitempty"`
+}
+
+// ParseDarkHours parses a "HH:MM-HH:MM" period into minutes since midnight.
+// Period may wrap over midnight.
+func ParseDarkHours(value string) (start int, end int, err error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("dark hours must be HH:MM-HH:MM, got %q", value)
+	}
+	if start, err = parseClock(from); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseClock(to); err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("dark hours start and end must differ, got %q", value)
+	}
+	return start, end, nil
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate checks the value for the setting key
+func Validate(key string, value string) error {
+	switch key {
+	case KeyLocale:
+		if !slices.Contains(Locales, value) {
+			return fmt.Errorf("unsupported locale %q, expected one of %s", value, strings.Join(Locales, ", "))
+		}
+	case KeyDarkHours:
+		_, _, err := ParseDarkHours(value)
+		return err
+	case KeyQRRefreshMargin:
+		if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+			return fmt.Errorf("QR refresh margin must be seconds, got %q", value)
+		}
+	case KeyIdleMessage, KeyHeaderText:
+		// Free text
+	default:
+		return fmt.Errorf("unknown display setting %q, expected one of %s", key, strings.Join(Keys, ", "))
+	}
+	return nil
+}
+
+// Apply sets the stored settings over the current ones. Invalid values are skipped.
+func (s *Settings) Apply(settings []storage.DisplaySetting) {
+	for _, setting := range settings {
+		if Validate(setting.Key, setting.Value) != nil {
+			continue
+		}
+		switch setting.Key {
+		case KeyLocale:
+			s.Locale = setting.Value
+		case KeyIdleMessage:
+			s.IdleMessage = setting.Value
+		case KeyDarkHours:
+			s.DarkHours = setting.Value
+		case KeyQRRefreshMargin:
+			margin, _ := strconv.ParseUint(setting.Value, 10, 32)
+			s.QRRefreshMargin = uint(margin)
+		case KeyHeaderText:
+			s.HeaderText = setting.Value
+		}
+	}
+}
+
+// Version returns a stamp that changes whenever the settings change.
+func (s Settings) Version() string {
+	data, _ := json.Marshal(s)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ForDevice resolves the settings for the device. Settings of the entries the
+// device is approved for are applied in entry ID order, then the device settings.
+func ForDevice(ctx context.Context, provider storage.Provider, deviceID string) (Settings, error) {
+	var settings Settings
+
+	approved, err := provider.ListApprovedDevicesByDevice(ctx, deviceID)
+	if err != nil {
+		return settings, err
+	}
+	entryIDs := make([]int64, 0, len(approved))
+	for _, a := range approved {
+		entryIDs = append(entryIDs, a.EntryID)
+	}
+	slices.Sort(entryIDs)
+
+	for _, entryID := range entryIDs {
+		entrySettings, err := provider.ListDisplaySettings(ctx, storage.DisplayScopeEntry, strconv.FormatInt(entryID, 10))
+		if err != nil {
+			return settings, err
+		}
+		settings.Apply(entrySettings)
+	}
+
+	deviceSettings, err := provider.ListDisplaySettings(ctx, storage.DisplayScopeDevice, deviceID)
+	if err != nil {
+		return settings, err
+	}
+	settings.Apply(deviceSettings)
+
+	return settings, nil
+}
@@ -0,0 +1,65 @@
+package display
+
+import (
+	"testing"
+
+	"entry-access-control/internal/storage"
+)
+
+func TestParseDarkHours(t *testing.T) {
+	start, end, err := ParseDarkHours("22:00-06:30")
+	if err != nil {
+		t.Fatalf("ParseDarkHours failed: %v", err)
+	}
+	if start != 22*60 || end != 6*60+30 {
+		t.Errorf("ParseDarkHours = %d, %d; want %d, %d", start, end, 22*60, 6*60+30)
+	}
+
+	for _, invalid := range []string{"", "22:00", "25:00-06:00", "22:00-22:00"} {
+		if _, _, err := ParseDarkHours(invalid); err == nil {
+			t.Errorf("ParseDarkHours(%q) expected error", invalid)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		key, value string
+		valid      bool
+	}{
+		{KeyLocale, "fi", true},
+		{KeyLocale, "de", false},
+		{KeyQRRefreshMargin, "10", true},
+		{KeyQRRefreshMargin, "-1", false},
+		{KeyHeaderText, "Ag C331", true},
+		{"brightness", "50", false},
+	}
+
+	for _, tt := range tests {
+		if err := Validate(tt.key, tt.value); (err == nil) != tt.valid {
+			t.Errorf("Validate(%q, %q) = %v, want valid %v", tt.key, tt.value, err, tt.valid)
+		}
+	}
+}
+
+func TestSettings_Apply(t *testing.T) {
+	var s Settings
+	s.Apply([]storage.DisplaySetting{
+		{Key: KeyLocale, Value: "fi"},
+		{Key: KeyHeaderText, Value: "Entry"},
+	})
+	before := s.Version()
+
+	// Device settings override entry settings, invalid values are skipped
+	s.Apply([]storage.DisplaySetting{
+		{Key: KeyHeaderText, Value: "Lab"},
+		{Key: KeyQRRefreshMargin, Value: "abc"},
+	})
+
+	if s.Locale != "fi" || s.HeaderText != "Lab" || s.QRRefreshMargin != 0 {
+		t.Errorf("Apply resulted in %+v", s)
+	}
+	if s.Version() == before {
+		t.Error("Version did not change with settings")
+	}
+}
@@ -0,0 +1,137 @@
+// Package events provides an in-memory event broker for entry devices.
+//
+// Events are keyed by device ID. Each device keeps a short history of recent
+// events, so a client reconnecting with the last event ID it has seen can catch
+// up on what it missed.
+//
+// Usage:
+//
+//	events.Default.Publish(deviceID, events.TypeRotate, nil)
+//
+//	ch, unsubscribe := events.Default.Subscribe(deviceID)
+//	defer unsubscribe()
+//	for ev := range ch { ... }
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of events kept per device for catching up.
+const HISTORY_SIZE = 32
+
+type Type string
+
+const (
+	// Entry token displayed on the device has been used, and a new one should be fetched.
+	TypeRotate Type = "rotate"
+	// Entry token shown on the device has been scanned, and access was granted or denied.
+	TypeAccess Type = "access"
+)
+
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type Type      `json:"type"`
+	Data any       `json:"data,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+type Broker struct {
+	mu      sync.Mutex
+	seq     uint64
+	history map[string][]Event
+	subs    map[string]map[chan Event]struct{}
+}
+
+// Default broker used by the HTTP routes.
+var Default = NewBroker()
+
+func NewBroker() *Broker {
+	return &Broker{
+		history: make(map[string][]Event),
+		subs:    make(map[string]map[chan Event]struct{}),
+	}
+}
+
+// Publish sends an event to all subscribers of the device and stores it in history.
+func (b *Broker) Publish(deviceID string, typ Type, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	ev := Event{
+		ID:   b.seq,
+		Type: typ,
+		Data: data,
+		Time: time.Now().UTC(),
+	}
+
+	history := append(b.history[deviceID], ev)
+	if len(history) > HISTORY_SIZE {
+		history = history[len(history)-HISTORY_SIZE:]
+	}
+	b.history[deviceID] = history
+
+	for ch := range b.subs[deviceID] {
+		// Never block the publisher on a slow subscriber. It can catch up from history.
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	return ev
+}
+
+// Subscribe returns a channel receiving events for the device, and a function
+// to cancel the subscription.
+func (b *Broker) Subscribe(deviceID string) (<-chan Event, func()) {
+	ch := make(chan Event, HISTORY_SIZE)
+
+	b.mu.Lock()
+	if b.subs[deviceID] == nil {
+		b.subs[deviceID] = make(map[chan Event]struct{})
+	}
+	b.subs[deviceID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs[deviceID], ch)
+			if len(b.subs[deviceID]) == 0 {
+				delete(b.subs, deviceID)
+			}
+			b.mu.Unlock()
+		})
+	}
+	return ch, unsubscribe
+}
+
+// Since returns events for the device newer than lastID.
+func (b *Broker) Since(deviceID string, lastID uint64) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Event IDs restart from zero with the server, so an ID from the future
+	// means the client saw a previous instance.
+	if lastID > b.seq {
+		lastID = 0
+	}
+
+	var result []Event
+	for _, ev := range b.history[deviceID] {
+		if ev.ID > lastID {
+			result = append(result, ev)
+		}
+	}
+	return result
+}
+
+// LastID returns the ID of the latest published event.
+func (b *Broker) LastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
@@ -0,0 +1,52 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBroker_PublishSubscribe(t *testing.T) {
+	b := NewBroker()
+	ch, unsubscribe := b.Subscribe("device1")
+	defer unsubscribe()
+
+	b.Publish("device2", TypeRotate, nil)
+	ev := b.Publish("device1", TypeRotate, nil)
+
+	select {
+	case got := <-ch:
+		if got.ID != ev.ID {
+			t.Fatalf("got event %d, want %d", got.ID, ev.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	select {
+	case got := <-ch:
+		t.Fatalf("received event for another device: %+v", got)
+	default:
+	}
+}
+
+func TestBroker_Since(t *testing.T) {
+	b := NewBroker()
+	first := b.Publish("device1", TypeRotate, nil)
+	second := b.Publish("device1", TypeRotate, nil)
+
+	if got := b.Since("device1", first.ID); len(got) != 1 || got[0].ID != second.ID {
+		t.Fatalf("Since(%d) = %+v, want only event %d", first.ID, got, second.ID)
+	}
+
+	// ID from a previous server instance returns the whole history
+	if got := b.Since("device1", second.ID+100); len(got) != 2 {
+		t.Fatalf("Since with future ID returned %d events, want 2", len(got))
+	}
+
+	for i := 0; i < HISTORY_SIZE*2; i++ {
+		b.Publish("device1", TypeRotate, nil)
+	}
+	if got := b.Since("device1", 0); len(got) != HISTORY_SIZE {
+		t.Fatalf("history size = %d, want %d", len(got), HISTORY_SIZE)
+	}
+}
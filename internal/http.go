@@ -189,20 +189,12 @@ func RegisterRoutes(r *gin.Engine) *gin.Engine {
 	// Serve config for client-side use
 	r.GET("/config.json", func(c *gin.Context) {
 		// Provide a initial config
-		SupportQRURL := UrlFor(c, "dist/assets/support_qr.png")
-		var clientCfg = gin.H{
-			"TokenTTL":        Cfg.TokenTTL,
-			"TokenExpirySkew": Cfg.TokenExpirySkew,
-			"SupportURL":      Cfg.SupportURL,
-			"SupportQRURL":    SupportQRURL,
-		}
-
-		c.JSON(http.StatusOK, clientCfg)
+		c.JSON(http.StatusOK, routes.ClientConfig(c))
 	})
 
 	r.GET("/", func(ctx *gin.Context) {
 		var qr_url = UrlFor(ctx, "/qr")
-		ctx.HTML(http.StatusOK, "qr.html.tmpl", gin.H{"QRCodeURL": qr_url})
+		routes.HTML(ctx, http.StatusOK, "qr.html.tmpl", gin.H{"QRCodeURL": qr_url})
 	})
 
 	apirg := r.Group(API_V1_PREFIX)
@@ -220,8 +212,16 @@ func RegisterRoutes(r *gin.Engine) *gin.Engine {
 	auth_rg := r.Group("/auth")
 	routes.AuthRoutes(auth_rg)
 
-	// Email login routes
-	routes.EmailLoginRoute(auth_rg)
+	// Email login routes. Login links and redirects point under /auth/email
+	routes.EmailLoginRoute(auth_rg.Group("/email"))
+
+	// Access list file management
+	routes.AccessListApi(apirg.Group("/access-lists"))
+
+	// SCIM provisioning, enabled by setting the token
+	if Cfg.SCIM.Token != "" {
+		routes.SCIMRoutes(r.Group("/scim/v2"))
+	}
 
 	return r
 }
@@ -19,15 +19,21 @@ var (
 
 var tokenSignatureAlg = jwt.SigningMethodHS256
 
+// Device credential TTL in seconds (2 days)
+const DEVICE_TOKEN_TTL uint = 2 * 24 * 60 * 60
+
 // Claim for entry access token
 type EntryClaim struct {
 	EntryID string `json:"entry_id"`
+	// Device displaying the token, if any. Used to rotate the QR code once the token is used.
+	DeviceID string `json:"device_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func NewEntryClaim(entryId string) EntryClaim {
+func NewEntryClaim(entryId string, deviceId string) EntryClaim {
 	return EntryClaim{
 		EntryID:          entryId,
+		DeviceID:         deviceId,
 		RegisteredClaims: mustCreateRegisteredClaim(Cfg.TokenTTL),
 	}
 }
@@ -38,6 +44,11 @@ func DecodeEntryJWT(tokenString string) (*EntryClaim, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Other claims signed with the same secret have no entry ID, like
+	// EntryLoginClaim, or an audience, like AccessCodeClaim
+	if claims.EntryID == "" || len(claims.Audience) > 0 {
+		return nil, ErrInvalidClaimType
+	}
 	ctx := context.Background()
 	// Consume nonce to prevent replay attacks
 	// Note: This must be done after validating the token to avoid DoS attacks
@@ -51,6 +62,37 @@ func DecodeEntryJWT(tokenString string) (*EntryClaim, error) {
 	return claims, nil
 }
 
+// Claim for a scanned entry token, when the user has to log in first. Carries
+// the scanned entry and device through the login, so access is decided for
+// them afterwards. Field names differ from EntryClaim, so it can't be used as one.
+type EntryLoginClaim struct {
+	EntryID  string `json:"login_entry_id"`
+	DeviceID string `json:"login_device_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func NewEntryLoginClaim(entryId string, deviceId string, ttl uint) EntryLoginClaim {
+	return EntryLoginClaim{
+		EntryID:          entryId,
+		DeviceID:         deviceId,
+		RegisteredClaims: mustCreateRegisteredClaim(ttl),
+	}
+}
+
+// DecodeEntryLoginJWT decodes the scan being logged in for. Nonce is not
+// consumed, as the login page can be reloaded. The entry token minted after
+// the login is single use.
+func DecodeEntryLoginJWT(tokenString string) (*EntryLoginClaim, error) {
+	claims, err := decodeJWT(tokenString, &EntryLoginClaim{})
+	if err != nil {
+		return nil, err
+	}
+	if claims.EntryID == "" {
+		return nil, ErrInvalidClaimType
+	}
+	return claims, nil
+}
+
 // AuthClaims represents the expected claims in the JWT token
 type AuthClaims struct {
 	UserID string `json:"uid"`
@@ -60,9 +102,12 @@ type AuthClaims struct {
 	jwt.RegisteredClaims
 }
 
-func NewAuthClaims(uid string) *AuthClaims {
+// NewAuthClaims creates claims for a login lasting ttl seconds. The ID is a
+// nonce, consumed when the token is renewed or the user logs out.
+func NewAuthClaims(uid string, ttl uint) *AuthClaims {
 	return &AuthClaims{
-		UserID: uid,
+		UserID:           uid,
+		RegisteredClaims: mustCreateRegisteredClaim(ttl),
 	}
 }
 
@@ -114,6 +159,42 @@ func DecodeDeviceProvisionJWT(tokenString string, options ...jwt.ParserOption) (
 	return claims, nil
 }
 
+// ParseDeviceProvisionJWT decodes and validates a device provision JWT token.
+// NOTE: Nonce is not consumed here. It must be consumed by the caller once the device is approved.
+func ParseDeviceProvisionJWT(tokenString string, options ...jwt.ParserOption) (*DeviceProvisionClaim, error) {
+	return decodeJWT(tokenString, &DeviceProvisionClaim{}, options...)
+}
+
+// Credential for an approved entry device
+type DeviceClaim struct {
+	DeviceID string  `json:"device_id"`
+	ClientIP string  `json:"client_ip"`
+	EntryIDs []int64 `json:"entry_ids"`
+	jwt.RegisteredClaims
+}
+
+func NewDeviceClaim(deviceId string, clientIP string, entryIDs []int64) DeviceClaim {
+	return DeviceClaim{
+		DeviceID:         deviceId,
+		ClientIP:         clientIP,
+		EntryIDs:         entryIDs,
+		RegisteredClaims: mustCreateRegisteredClaim(DEVICE_TOKEN_TTL),
+	}
+}
+
+// DecodeDeviceJWT decodes and validates a device credential.
+// Nonce must still exist, as it's consumed when the credential is renewed.
+func DecodeDeviceJWT(tokenString string) (*DeviceClaim, error) {
+	claims, err := decodeJWT(tokenString, &DeviceClaim{})
+	if err != nil {
+		return nil, err
+	}
+	if !nonce.Store.Exists(context.Background(), claims.ID) {
+		return nil, ErrInvalidNonce
+	}
+	return claims, nil
+}
+
 func mustCreateRegisteredClaim(ttl uint) jwt.RegisteredClaims {
 	nonce, err := nonce.Nonce(ttl + 10) // nonce TTL is slightly longer than token TTL to allow for clock skew
 	if err != nil {
@@ -129,18 +210,23 @@ func mustCreateRegisteredClaim(ttl uint) jwt.RegisteredClaims {
 
 // Claim when user is requesting access code
 type AccessCodeClaim struct {
-	Verify           string `json:"verify"`
-	Email            string `json:"email"`
-	EntryID          string `json:"entry_id"`
+	Verify  string `json:"verify"`
+	Email   string `json:"email"`
+	EntryID string `json:"entry_id"`
+	// Device that displayed the scanned entry token, notified of the access decision
+	DeviceID string `json:"device_id,omitempty"`
+	// Page to return to after logging in without scanning an entry
+	Next             string `json:"next,omitempty"`
 	AuthenticateOnly bool   `json:"auth,omitempty"` // Whether to send authentication token after verification
 	jwt.RegisteredClaims
 }
 
-func NewAccessCodeClaim(otpVerify string, email string, entryId string, ttl uint) AccessCodeClaim {
+func NewAccessCodeClaim(otpVerify string, email string, entryId string, deviceId string, ttl uint) AccessCodeClaim {
 	return AccessCodeClaim{
 		Verify:           otpVerify,
 		Email:            email,
 		EntryID:          entryId,
+		DeviceID:         deviceId,
 		RegisteredClaims: mustCreateRegisteredClaim(ttl),
 	}
 }
@@ -0,0 +1,55 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+
+	. "entry-access-control/internal/config"
+	"entry-access-control/internal/nonce"
+)
+
+func initTestNonceStore(t *testing.T) {
+	t.Helper()
+	Cfg = &Config{Secret: "test-secret", NonceStore: "memory"}
+	if err := nonce.InitNonceStore(Cfg, nil); err != nil {
+		t.Fatalf("InitNonceStore: %v", err)
+	}
+}
+
+func TestNewAuthClaims_ExpiresWithNonce(t *testing.T) {
+	initTestNonceStore(t)
+
+	claims := NewAuthClaims("user@example.com", 3600)
+	if claims.ID == "" {
+		t.Fatal("expected a nonce as the claims ID")
+	}
+	if claims.ExpiresAt == nil {
+		t.Fatal("expected an expiry")
+	}
+	if d := time.Until(claims.ExpiresAt.Time); d < 3590*time.Second || d > 3600*time.Second {
+		t.Errorf("expiry in %v, want about 1h", d)
+	}
+	if other := NewAuthClaims("user@example.com", 3600); other.ID == claims.ID {
+		t.Error("expected a new nonce for each login")
+	}
+
+	token, err := GenerateJWT(claims)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	decoded, err := DecodeAuthJWT(token)
+	if err != nil {
+		t.Fatalf("DecodeAuthJWT: %v", err)
+	}
+	if decoded.UserID != "user@example.com" || decoded.ID != claims.ID {
+		t.Errorf("decoded %q with ID %q, want user@example.com with ID %q", decoded.UserID, decoded.ID, claims.ID)
+	}
+
+	// The nonce is single-use, so a renewed or logged out token can't be reused
+	if err := ConsumeClaimNonce(&decoded.RegisteredClaims); err != nil {
+		t.Fatalf("ConsumeClaimNonce: %v", err)
+	}
+	if err := ConsumeClaimNonce(&decoded.RegisteredClaims); err == nil {
+		t.Error("expected the nonce to be consumed only once")
+	}
+}
@@ -0,0 +1,191 @@
+// Package monitor tracks entry device heartbeats, and alerts when an approved
+// device stops contacting the server.
+//
+// Devices are considered online, stale or offline based on when they were last
+// seen. When an approved device with an active entry goes offline, an alert is
+// sent by email and/or webhook. A recovery notice is sent when it comes back.
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"entry-access-control/internal/config"
+	"entry-access-control/internal/storage"
+)
+
+type State string
+
+const (
+	StateOnline  State = "online"
+	StateStale   State = "stale"
+	StateOffline State = "offline"
+)
+
+// DeviceState classifies the device by when it was last seen. Device never seen is offline.
+func DeviceState(lastSeen *time.Time, now time.Time, cfg config.MonitorConfig) State {
+	if lastSeen == nil {
+		return StateOffline
+	}
+
+	since := now.Sub(*lastSeen)
+	switch {
+	case since > time.Duration(cfg.OfflineAfter)*time.Second:
+		return StateOffline
+	case since > time.Duration(cfg.StaleAfter)*time.Second:
+		return StateStale
+	default:
+		return StateOnline
+	}
+}
+
+// Alert about a device going offline, or coming back online
+type Alert struct {
+	DeviceID   string     `json:"device_id"`
+	ClientIP   string     `json:"client_ip"`
+	State      State      `json:"state"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	Entries    []string   `json:"entries"`
+	// Set when the device is back online after an alert
+	Recovered bool `json:"recovered"`
+}
+
+// Notifier delivers alerts
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Checker periodically checks the approved devices, and alerts on offline ones.
+type Checker struct {
+	provider  storage.Provider
+	cfg       config.MonitorConfig
+	notifiers []Notifier
+	logger    *slog.Logger
+}
+
+func NewChecker(provider storage.Provider, cfg config.MonitorConfig, notifiers ...Notifier) *Checker {
+	return &Checker{
+		provider:  provider,
+		cfg:       cfg,
+		notifiers: notifiers,
+		logger:    slog.With("component", "monitor"),
+	}
+}
+
+// NotifiersFromConfig creates the notifiers enabled in the configuration.
+func NotifiersFromConfig(cfg *config.Config) []Notifier {
+	var notifiers []Notifier
+
+	if cfg.Monitor.AlertEmail != "" {
+		var to []string
+		for addr := range strings.SplitSeq(cfg.Monitor.AlertEmail, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				to = append(to, addr)
+			}
+		}
+		notifiers = append(notifiers, NewEmailNotifier(cfg.Email, to))
+	}
+	if cfg.Monitor.WebhookURL != "" {
+		notifiers = append(notifiers, NewWebhookNotifier(cfg.Monitor.WebhookURL))
+	}
+
+	return notifiers
+}
+
+// Run checks the devices every check interval, until the context is cancelled.
+func (c *Checker) Run(ctx context.Context) {
+	if c.cfg.CheckInterval == 0 {
+		c.logger.Info("Device monitoring disabled")
+		return
+	}
+	if len(c.notifiers) == 0 {
+		c.logger.Warn("No device alert notifiers configured, offline devices are only logged")
+	}
+
+	ticker := time.NewTicker(time.Duration(c.cfg.CheckInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := c.Check(ctx, now); err != nil {
+				c.logger.Error("Device check failed", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check alerts on approved devices with an active entry that have gone offline,
+// and on alerted devices that have come back online.
+func (c *Checker) Check(ctx context.Context, now time.Time) error {
+	devices, err := c.provider.ListDevices(ctx, storage.DeviceStatusApproved)
+	if err != nil {
+		return err
+	}
+
+	entries, err := c.provider.ListEntries(ctx)
+	if err != nil {
+		return err
+	}
+	entryNames := make(map[int64]string, len(entries))
+	for _, e := range entries {
+		entryNames[e.ID] = e.Name
+	}
+
+	for _, device := range devices {
+		approved, err := c.provider.ListApprovedDevicesByDevice(ctx, device.DeviceID)
+		if err != nil {
+			c.logger.Error("Failed to list device entries", "device_id", device.DeviceID, "error", err)
+			continue
+		}
+		// Device without an active entry isn't expected to be running
+		if len(approved) == 0 {
+			continue
+		}
+
+		alert := Alert{
+			DeviceID:   device.DeviceID,
+			ClientIP:   device.ClientIP,
+			State:      DeviceState(device.LastSeenAt, now, c.cfg),
+			LastSeenAt: device.LastSeenAt,
+		}
+		for _, a := range approved {
+			name, ok := entryNames[a.EntryID]
+			if !ok {
+				name = fmt.Sprintf("#%d", a.EntryID)
+			}
+			alert.Entries = append(alert.Entries, name)
+		}
+
+		switch {
+		case alert.State == StateOffline && device.AlertedAt == nil:
+			c.logger.Warn("Device is offline", "device_id", device.DeviceID, "last_seen_at", device.LastSeenAt, "entries", alert.Entries)
+			c.notify(ctx, alert)
+			if err := c.provider.SetDeviceAlerted(ctx, device.DeviceID, &now); err != nil {
+				c.logger.Error("Failed to record device alert", "device_id", device.DeviceID, "error", err)
+			}
+		case alert.State == StateOnline && device.AlertedAt != nil:
+			c.logger.Info("Device is back online", "device_id", device.DeviceID, "entries", alert.Entries)
+			alert.Recovered = true
+			c.notify(ctx, alert)
+			if err := c.provider.SetDeviceAlerted(ctx, device.DeviceID, nil); err != nil {
+				c.logger.Error("Failed to clear device alert", "device_id", device.DeviceID, "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Checker) notify(ctx context.Context, alert Alert) {
+	for _, n := range c.notifiers {
+		if err := n.Notify(ctx, alert); err != nil {
+			c.logger.Error("Failed to send device alert", "device_id", alert.DeviceID, "notifier", fmt.Sprintf("%T", n), "error", err)
+		}
+	}
+}
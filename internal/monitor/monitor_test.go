@@ -0,0 +1,71 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"entry-access-control/internal/config"
+)
+
+func TestDeviceState(t *testing.T) {
+	cfg := config.MonitorConfig{StaleAfter: 60, OfflineAfter: 300}
+	now := time.Now()
+	ago := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+
+	tests := []struct {
+		name     string
+		lastSeen *time.Time
+		want     State
+	}{
+		{"never seen", nil, StateOffline},
+		{"just seen", ago(10 * time.Second), StateOnline},
+		{"stale", ago(2 * time.Minute), StateStale},
+		{"offline", ago(10 * time.Minute), StateOffline},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DeviceState(tt.lastSeen, now, cfg); got != tt.want {
+				t.Errorf("DeviceState() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	var got Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode alert: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	alert := Alert{DeviceID: "device1", State: StateOffline, Entries: []string{"Ag C331"}}
+	if err := NewWebhookNotifier(server.URL).Notify(context.Background(), alert); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if got.DeviceID != alert.DeviceID || got.State != StateOffline || len(got.Entries) != 1 {
+		t.Errorf("webhook received %+v, want %+v", got, alert)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	if err := NewWebhookNotifier(failing.URL).Notify(context.Background(), alert); err == nil {
+		t.Error("expected error on non-2xx response")
+	}
+}
@@ -0,0 +1,93 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"entry-access-control/internal/email"
+)
+
+const WEBHOOK_TIMEOUT = 10 * time.Second
+
+// EmailNotifier sends alerts by email
+type EmailNotifier struct {
+	cfg email.SMTPConfig
+	to  []string
+}
+
+func NewEmailNotifier(cfg email.SMTPConfig, to []string) *EmailNotifier {
+	return &EmailNotifier{cfg: cfg, to: to}
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, alert Alert) error {
+	client, err := email.NewClient(n.cfg)
+	if err != nil {
+		return err
+	}
+
+	lastSeen := "never"
+	if alert.LastSeenAt != nil {
+		lastSeen = alert.LastSeenAt.Local().Format("2006-01-02 15:04:05")
+	}
+	entries := html.EscapeString(strings.Join(alert.Entries, ", "))
+
+	var subject, body string
+	if alert.Recovered {
+		subject = fmt.Sprintf("Entry device back online: %s", strings.Join(alert.Entries, ", "))
+		body = fmt.Sprintf("<p>Entry device for <b>%s</b> is back online.</p>", entries)
+	} else {
+		subject = fmt.Sprintf("Entry device offline: %s", strings.Join(alert.Entries, ", "))
+		body = fmt.Sprintf("<p>Entry device for <b>%s</b> has not contacted the server since %s.</p>", entries, lastSeen)
+	}
+	body += fmt.Sprintf("<p>Device ID: <code>%s</code><br>Client IP: <code>%s</code></p>",
+		html.EscapeString(alert.DeviceID), html.EscapeString(alert.ClientIP))
+
+	return client.Send(&email.Message{
+		To:      n.to,
+		Subject: subject,
+		HTML:    body,
+	})
+}
+
+// WebhookNotifier POSTs alerts as JSON to the URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+	}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -7,96 +7,169 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	. "entry-access-control/internal/config"
+	"entry-access-control/internal/events"
+	"entry-access-control/internal/storage"
 	. "entry-access-control/internal/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
-func genEntryToken(entryID string) (string, error) {
-	claim := NewEntryClaim(entryID)
+// Long-poll timeout for device events
+const EVENTS_POLL_TIMEOUT = 25 * time.Second
+
+func genEntryToken(entryID string, deviceID string) (string, error) {
+	claim := NewEntryClaim(entryID, deviceID)
 	return GenerateJWT(claim)
 }
 
-// Entry tokens store. entry_id -> token
+type entryToken struct {
+	Token     string
+	EntryID   string
+	ExpiresAt time.Time
+}
+
+// Entry tokens store. device_id -> token
 var entryTokens = struct {
-	sync.RWMutex
-	tokens map[string]string
+	sync.Mutex
+	tokens map[string]entryToken
 }{
-	tokens: make(map[string]string),
+	tokens: make(map[string]entryToken),
 }
 
-func getEntryToken(entryID string) (string, error) {
-	var createToggle bool = false
+// getEntryToken returns the token currently displayed on the device, creating
+// a new one if there is none, or it's about to expire.
+func getEntryToken(deviceID string, entryID string) (entryToken, error) {
 	entryTokens.Lock()
 	defer entryTokens.Unlock()
 
-	token, exists := entryTokens.tokens[entryID]
-	if !exists {
-		createToggle = true
-	} else if token != "" {
-		// Validate that the token is not expired
-		parts := strings.Split(token, ".")
-		if len(parts) != 3 {
-			slog.Debug("Invalid token format, expected 3 parts", "token", token)
-			return "", fmt.Errorf("invalid token format")
-		}
-		// Validate the token (assuming the token is a JWT)
-		claims, err := DecodeEntryJWT(token)
-		if err != nil {
-			return "", fmt.Errorf("invalid token payload")
-		}
-		// Check expiration (assuming the token is a JWT)
-		exp := claims.ExpiresAt.Time.Unix()
+	// Leave the device time to show the new code before the old one expires.
+	skew := time.Duration(Cfg.TokenExpirySkew) * time.Second
 
-		if time.Now().Unix() > exp {
-			slog.Debug("Entry token expired, creating a new one", "exp", exp, "entryID", entryID)
-			createToggle = true
-		}
-	} else {
-		panic("Unexpected token state")
+	token, exists := entryTokens.tokens[deviceID]
+	if exists && token.EntryID == entryID && time.Until(token.ExpiresAt) > skew {
+		return token, nil
 	}
 
-	if createToggle {
-		// Notice: To avoid shadowing, not `token, err := ...`
-		var err error
-		token, err = genEntryToken(entryID)
-		slog.Debug("Generated new entry token", "token", token, "entryID", entryID)
-		if err != nil {
-			return "", err
-		}
-		entryTokens.tokens[entryID] = token
+	claim := NewEntryClaim(entryID, deviceID)
+	signed, err := GenerateJWT(claim)
+	if err != nil {
+		return entryToken{}, err
+	}
+	token = entryToken{
+		Token:     signed,
+		EntryID:   entryID,
+		ExpiresAt: claim.ExpiresAt.Time,
 	}
+	entryTokens.tokens[deviceID] = token
+	slog.Debug("Generated new entry token", "entryID", entryID, "device_id", deviceID, "expires_at", token.ExpiresAt)
+
 	return token, nil
 }
 
-func userExists(c *gin.Context, userID string) (bool, error) {
+// rotateEntryToken forgets the token displayed on the device, and tells the
+// device to fetch a new one.
+func rotateEntryToken(deviceID string) {
+	entryTokens.Lock()
+	delete(entryTokens.tokens, deviceID)
+	entryTokens.Unlock()
+
+	events.Default.Publish(deviceID, events.TypeRotate, nil)
+	slog.Debug("Entry token rotated", "device_id", deviceID)
+}
+
+// Access decision shown on the entry device. Only initials are sent, so
+// passers-by can't read the full name from the door display.
+type accessNotice struct {
+	Granted  bool   `json:"granted"`
+	Initials string `json:"initials,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// userInitials derives initials from the local part of an email address,
+// e.g. "matti.meikalainen@example.com" -> "MM".
+func userInitials(userID string) string {
+	local, _, _ := strings.Cut(userID, "@")
+	initials := ""
+	for _, part := range strings.FieldsFunc(local, func(r rune) bool {
+		return r == '.' || r == '_' || r == '-' || r == '+'
+	}) {
+		initials += strings.ToUpper(string([]rune(part)[0]))
+	}
+	return initials
+}
+
+// notifyAccess tells the device that displayed the token whether access was granted.
+func notifyAccess(deviceID string, notice accessNotice) {
+	if deviceID == "" {
+		return
+	}
+	events.Default.Publish(deviceID, events.TypeAccess, notice)
+}
+
+// denyAccess notifies the device of denied access, using the error's stop code as the reason.
+func denyAccess(deviceID string, err error) {
+	reason := "ACCESS_DENIED"
+	if codes := GetErrorStopCodes(err); len(codes) > 0 {
+		reason = codes[0]
+	}
+	notifyAccess(deviceID, accessNotice{Granted: false, Reason: reason})
+}
+
+func findUser(c *gin.Context, userID string) (access.EntryRecord, error) {
 	accessListIface, exists := c.Get("AccessList")
 	if !exists {
 		slog.Warn("Access list not found in context")
-		return false, fmt.Errorf("access list not found in context")
+		return nil, fmt.Errorf("access list not found in context")
 	}
 	accessList, ok := accessListIface.(access.AccessList)
 	if !ok {
-		return false, fmt.Errorf("invalid access list type in context")
+		return nil, fmt.Errorf("invalid access list type in context")
 	}
+	return accessList.Find(userID)
+}
 
-	_, err := accessList.Find(userID)
+func userExists(c *gin.Context, userID string) (bool, error) {
+	user, err := findUser(c, userID)
 	if err != nil {
 		return false, err
 	}
-	return true, nil
+	return user != nil, nil
+}
+
+// recordAccessEvent stores the entry attempt of the user. denied is why access
+// was denied, nil if granted.
+func recordAccessEvent(c *gin.Context, userID string, claim *EntryClaim, denied error) {
+	err, storageProvider := GetStorageProvider(c)
+	if err != nil {
+		slog.Warn("Access event not recorded", "error", err)
+		return
+	}
+	event := storage.AccessEvent{
+		UserID:   access.CanonicalID(userID),
+		EntryID:  claim.EntryID,
+		DeviceID: claim.DeviceID,
+		Granted:  denied == nil,
+	}
+	if denied != nil {
+		event.Reason = denied.Error()
+	}
+	if err := storageProvider.RecordAccessEvent(c.Request.Context(), event); err != nil {
+		slog.Error("Failed to record access event", "userID", userID, "error", err)
+	}
 }
 
 func EntryRoute(r *gin.RouterGroup) {
 
 	// JSON endpoint for QR data (client-side generation)
-	r.GET("/qr.json", func(c *gin.Context) {
+	// Requires device credentials as Bearer token.
+	r.GET("/qr.json", ClientVersion(), RequireDevice(), DisplayVersion(), func(c *gin.Context) {
 		// Check for cache buster
 		if c.Query("cb") == "" {
 			slog.Debug("Cache buster not set, redirecting")
@@ -104,20 +177,25 @@ func EntryRoute(r *gin.RouterGroup) {
 			return
 		}
 
-		// Get device_id from query parameter or header
-		deviceID := c.Query("device_id")
-		if deviceID == "" {
-			deviceID = c.GetHeader("X-Device-ID")
+		claim, err := GetDeviceClaim(c)
+		if err != nil {
+			AbortWithError(c, err)
+			return
 		}
 
-		if err, _ := getProvisioning(c, deviceID); err != nil {
-			log.Printf("Provisioning check failed: %v", err)
-			c.JSON(http.StatusForbidden, gin.H{"error": "Provisioning check failed"})
-			return
+		// Device approved for several entries can choose which one to display
+		entryID := claim.EntryIDs[0]
+		if e := c.Query("entry_id"); e != "" {
+			requested, err := strconv.ParseInt(e, 10, 64)
+			if err != nil || !slices.Contains(claim.EntryIDs, requested) {
+				AbortWithHTTPError(c, http.StatusBadRequest, ErrInvalidParameter)
+				return
+			}
+			entryID = requested
 		}
 
-		// TODO: Extract from device provisioning data
-		token, err := getEntryToken("entry1")
+		deviceID := claim.DeviceID
+		token, err := getEntryToken(deviceID, strconv.FormatInt(entryID, 10))
 		if err != nil {
 			slog.Debug("Error getting entry token", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting entry token"})
@@ -125,19 +203,83 @@ func EntryRoute(r *gin.RouterGroup) {
 		}
 
 		// Generate URL pointing to self
-		url := UrlFor(c, r.BasePath()+"/entry/"+token)
-
-		// Calculate expiration time
-		expiresAt := time.Now().Add(time.Duration(Cfg.TokenTTL) * time.Second)
+		url := UrlFor(c, r.BasePath()+"/"+token.Token)
 
-		slog.Debug("Generated QR data", "url", url, "expires_at", expiresAt)
+		slog.Debug("Generated QR data", "url", url, "expires_at", token.ExpiresAt)
 
 		c.JSON(http.StatusOK, gin.H{
 			"url":        url,
-			"expires_at": expiresAt.Format(time.RFC3339),
+			"expires_at": token.ExpiresAt.Format(time.RFC3339),
 		})
 	})
 
+	// Long-poll endpoint for device events, such as QR code rotation.
+	// Returns events newer than `since`. If there are none, waits until one is
+	// published or the poll times out.
+	r.GET("/events.json", ClientVersion(), RequireDevice(), DisplayVersion(), func(c *gin.Context) {
+		claim, err := GetDeviceClaim(c)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		deviceID := claim.DeviceID
+
+		// Without `since`, only wait for events published from now on.
+		since := events.Default.LastID()
+		if s := c.Query("since"); s != "" {
+			if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+				AbortWithHTTPError(c, http.StatusBadRequest, ErrInvalidParameter)
+				return
+			}
+			// Event IDs restart from zero with the server. A cursor from a
+			// previous instance would never be reached, so start over.
+			if since > events.Default.LastID() {
+				since = 0
+			}
+		}
+
+		// Subscribe before checking history, so nothing is published in between.
+		ch, unsubscribe := events.Default.Subscribe(deviceID)
+		defer unsubscribe()
+
+		pending := events.Default.Since(deviceID, since)
+		if len(pending) == 0 {
+			timeout := time.NewTimer(EVENTS_POLL_TIMEOUT)
+			defer timeout.Stop()
+
+			select {
+			case ev := <-ch:
+				pending = append(pending, ev)
+			case <-timeout.C:
+			case <-c.Request.Context().Done():
+				return
+			}
+		}
+
+		lastID := since
+		for _, ev := range pending {
+			lastID = max(lastID, ev.ID)
+		}
+		if pending == nil {
+			pending = []events.Event{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"events":  pending,
+			"last_id": lastID,
+		})
+	})
+
+	// Page shown to devices refused for running an outdated client.
+	// Redirects back to the entry view once the client is recent enough.
+	r.GET("/outdated", func(c *gin.Context) {
+		if err := checkClientVersion(c.Query("v")); err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, UrlFor(c, "/"))
+	})
+
 	// TODO: Integrate token check, just to show sensible message.
 	r.GET("/success", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "access_granted.html.tmpl", H(c, gin.H{
@@ -147,21 +289,9 @@ func EntryRoute(r *gin.RouterGroup) {
 
 	// Router to decide if authentication is needed, or directly grant access
 	r.GET("/:token", func(c *gin.Context) {
-		// Get device_id from query parameter or header
-		deviceID := c.Query("device_id")
-		if deviceID == "" {
-			deviceID = c.GetHeader("X-Device-ID")
-		}
-
-		if err, _ := getProvisioning(c, deviceID); err != nil {
-			log.Printf("Provisioning check failed: %v", err)
-			c.JSON(http.StatusForbidden, gin.H{"error": "Provisioning check failed"})
-			return
-		}
-
 		token := c.Param("token")
 
-		// Verify token
+		// Verify token. Decoding consumes the nonce, so the token can't be used again.
 		claim, err := DecodeEntryJWT(token)
 		if err != nil {
 			slog.Debug("Invalid entry token", "error", err)
@@ -169,27 +299,63 @@ func EntryRoute(r *gin.RouterGroup) {
 			return
 		}
 
-		slog.Info("Entry token used", "entryID", claim.EntryID)
-		// TODO: Rotate QR code
+		slog.Info("Entry token used", "entryID", claim.EntryID, "device_id", claim.DeviceID)
+
+		if claim.DeviceID != "" {
+			// Token is spent, have the device display a new one before anyone else can photograph it.
+			rotateEntryToken(claim.DeviceID)
+
+			if err, _ := getProvisioning(c, claim.DeviceID, nil); err != nil {
+				log.Printf("Provisioning check failed: %v", err)
+				c.JSON(http.StatusForbidden, gin.H{"error": "Provisioning check failed"})
+				return
+			}
+		}
 
 		// Check if user is logged in
 		userID, err := verifyAuth(c)
 		if err != nil {
 			slog.Error("Failed to verify auth token", "error", err)
+			// Not a decision yet: the user logs in and is let in a moment later,
+			// so the device is only notified once access is granted or denied.
+			if c.GetHeader("Accept") != "application/json" {
+				// The scanned entry and device are decided on after the login
+				loginToken, err := GenerateJWT(NewEntryLoginClaim(claim.EntryID, claim.DeviceID, uint(LINK_TTL.Seconds())))
+				if err != nil {
+					AbortWithError(c, err)
+					return
+				}
+				c.Redirect(http.StatusFound, UrlFor(c, LOGIN_URL+"?entry="+loginToken))
+				return
+			}
 			AbortWithHTTPError(c, http.StatusUnauthorized, err, "AUTH_VERIFY_FAILED")
 			return
 		}
 
-		exists, err := userExists(c, userID)
-		if err != nil || !exists {
-			slog.Warn("User has authenticated, but not found in access list", "userID", userID, "error", err, "exists", exists)
+		user, err := findUser(c, userID)
+		if err != nil || user == nil {
+			slog.Warn("User has authenticated, but not found in access list", "userID", userID, "error", err)
+			denyAccess(claim.DeviceID, ErrNotInAccessList)
+			recordAccessEvent(c, userID, claim, ErrNotInAccessList)
 			// Destroy the token to avoid reuse
 			AuthLogout(c)
+			AbortWithError(c, ErrNotInAccessList)
+			return
+		}
+
+		// Access list files may grant only some entries
+		entryID := claim.EntryID
+		if !user.CanAccess(entryID) {
+			slog.Warn("User in access list, but not granted the entry", "userID", userID, "entryID", entryID)
+			denyAccess(claim.DeviceID, ErrEntryNotGranted)
+			recordAccessEvent(c, userID, claim, ErrEntryNotGranted)
+			AbortWithError(c, ErrEntryNotGranted)
 			return
 		}
-		slog.Debug("User authenticated and found in access list", "userID", userID)
+		slog.Debug("User authenticated and granted the entry", "userID", userID, "entryID", entryID)
 
-		// TODO: Check for access permissions
+		notifyAccess(claim.DeviceID, accessNotice{Granted: true, Initials: userInitials(userID)})
+		recordAccessEvent(c, userID, claim, nil)
 
 		c.JSON(http.StatusOK, gin.H{"token": token})
 	})
@@ -0,0 +1,359 @@
+package routes
+
+// Admin API for the files of the CSV access list. Uploads are validated, can be
+// previewed before they replace a file, and are loaded without a restart.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"entry-access-control/internal/access"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Largest access list file accepted for upload
+const MAX_ACCESS_LIST_UPLOAD = 32 << 20
+
+type accessListFile struct {
+	// Path relative to the access list folder
+	Path       string     `json:"path"`
+	Format     string     `json:"format"`
+	Definition string     `json:"definition,omitempty"`
+	Rows       int        `json:"rows"`
+	Active     int        `json:"active"`
+	Skipped    int        `json:"skipped"`
+	Valid      bool       `json:"valid"`
+	ValidFrom  *time.Time `json:"valid_from,omitempty"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	Roles      []string   `json:"roles,omitempty"`
+	Entries    []string   `json:"entries,omitempty"`
+}
+
+type accessListRow struct {
+	Email  string   `json:"email"`
+	Name   string   `json:"name,omitempty"`
+	Active bool     `json:"active"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+type accessListChange struct {
+	User   string `json:"user"`
+	Change string `json:"change"`
+	Active bool   `json:"active"`
+}
+
+func newAccessListFile(rel string, f *access.CSVFile) accessListFile {
+	info := accessListFile{
+		Path:       filepath.ToSlash(rel),
+		Format:     f.Format,
+		Definition: f.FieldDefinitions.Name,
+		Rows:       len(f.Entries),
+		Skipped:    f.Skipped,
+		Valid:      f.Metadata.ValidAt(time.Now()),
+		Roles:      f.Grant.Roles,
+		Entries:    f.Grant.Entries,
+	}
+	if f.Metadata != nil {
+		info.ValidFrom = f.Metadata.ValidFrom
+		info.ValidUntil = f.Metadata.ValidUntil
+	}
+	for _, e := range f.Entries {
+		if e.Status {
+			info.Active++
+		}
+	}
+	return info
+}
+
+// fileDiff compares the users of the loaded file to the new version.
+func fileDiff(path string, old, new *access.CSVFile) []accessListChange {
+	oldStates := map[string]access.EntryState{}
+	if old != nil {
+		oldStates = access.EntryStates(access.FileEntries(path, old))
+	}
+	changes := []accessListChange{}
+	for _, c := range access.DiffStates(oldStates, access.EntryStates(access.FileEntries(path, new))) {
+		changes = append(changes, accessListChange{User: c.UserID, Change: c.Change, Active: c.Active})
+	}
+	return changes
+}
+
+// fileAccessList returns the access list, if it's loaded from files. Composite
+// access lists use the csv backend named by the backend query parameter, or
+// the first csv backend.
+func fileAccessList(c *gin.Context) (*access.CSVAccessList, error) {
+	v, _ := c.Get("AccessList")
+	if composite, ok := v.(*access.CompositeAccessList); ok {
+		name := c.Query("backend")
+		for _, b := range composite.Backends() {
+			if list, ok := b.List.(*access.CSVAccessList); ok && (name == "" || name == b.Name) {
+				return list, nil
+			}
+		}
+		return nil, ErrAccessListNotFileBased
+	}
+	list, ok := v.(*access.CSVAccessList)
+	if !ok {
+		return nil, ErrAccessListNotFileBased
+	}
+	return list, nil
+}
+
+// readUpload reads the uploaded file, and resolves its path from the path
+// field, or the name of the upload.
+func readUpload(c *gin.Context, list *access.CSVAccessList) (string, []byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MAX_ACCESS_LIST_UPLOAD)
+	header, err := c.FormFile("file")
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: file: %v", ErrMissingParameter, err)
+	}
+
+	rel := c.PostForm("path")
+	if rel == "" {
+		rel = header.Filename
+	}
+	path, err := list.ListPath(rel)
+	if err != nil {
+		return "", nil, err
+	}
+
+	upload, err := header.Open()
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	defer upload.Close()
+	data, err := io.ReadAll(upload)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	return path, data, nil
+}
+
+// authorizeFile checks the user can write the access list of each entry the
+// file grants, and has the permissions of each role its rows grant. Files
+// granting all entries also grant entries added later, so they need the
+// unscoped permission, and the permission on every entry, as unscoped checks
+// don't see denies scoped to entries.
+func authorizeFile(c *gin.Context, f *access.CSVFile) error {
+	ids := f.Grant.Entries
+	if ids == nil {
+		err, storageProvider := GetStorageProvider(c)
+		if err != nil {
+			return err
+		}
+		entries, err := storageProvider.ListEntries(c.Request.Context())
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrDatabaseError, err)
+		}
+		for _, e := range entries {
+			ids = append(ids, strconv.FormatInt(e.ID, 10))
+		}
+	}
+
+	userID, _ := GetUser(c)
+	rbac := c.MustGet("RBAC").(*access.RBAC)
+	if f.Grant.Entries == nil && !rbac.Can(userID, "access_list", "write") {
+		return fmt.Errorf("%w: access list of all entries", ErrInsufficientPermissions)
+	}
+	for _, id := range ids {
+		if !rbac.Can(userID, access.EntryResource(id, "access_list"), "write") {
+			return fmt.Errorf("%w: access list of entry %s", ErrInsufficientPermissions, id)
+		}
+	}
+	for _, role := range f.GrantedRoles() {
+		if !rbac.CanGrant(userID, role) {
+			return fmt.Errorf("%w: role %s", ErrInsufficientPermissions, role)
+		}
+	}
+	return nil
+}
+
+// authorizeExisting checks the user can write the file in the path, if there
+// is one, and returns it. Files not loaded, e.g. written since the last reload,
+// are parsed from disk. Files that can't be parsed don't tell which entries
+// they grant, so they need the unscoped permission.
+func authorizeExisting(c *gin.Context, list *access.CSVAccessList, path string) (*access.CSVFile, error) {
+	if f := list.Files()[path]; f != nil {
+		return f, authorizeFile(c, f)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err == nil {
+		if f, err := list.Preview(path, data); err == nil {
+			return f, authorizeFile(c, f)
+		}
+	}
+
+	userID, _ := GetUser(c)
+	rbac := c.MustGet("RBAC").(*access.RBAC)
+	if !rbac.Can(userID, "access_list", "write") {
+		return nil, fmt.Errorf("%w: unreadable access list file", ErrInsufficientPermissions)
+	}
+	return nil, nil
+}
+
+// authorizeUpload checks the user can write both the file being replaced, if
+// any, and the upload. Returns the file being replaced.
+func authorizeUpload(c *gin.Context, list *access.CSVAccessList, path string, new *access.CSVFile) (*access.CSVFile, error) {
+	old, err := authorizeExisting(c, list, path)
+	if err != nil {
+		return nil, err
+	}
+	return old, authorizeFile(c, new)
+}
+
+// uploadError reports why the upload was rejected, e.g. which column
+// definitions didn't match.
+func uploadError(err error) error {
+	if errors.Is(err, access.ErrInvalidListFile) {
+		return NewHTTPError(http.StatusUnprocessableEntity, err, "", "ACCESS_LIST_INVALID")
+	}
+	return err
+}
+
+func AccessListApi(r *gin.RouterGroup) {
+	r.Use(AuthMiddleware(), RequireEntryPermission("access_list", "write"))
+
+	// Loaded files, with row counts and matched definitions
+	r.GET("", func(c *gin.Context) {
+		list, err := fileAccessList(c)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		files := []accessListFile{}
+		for path, f := range list.Files() {
+			if authorizeFile(c, f) != nil {
+				continue
+			}
+			files = append(files, newAccessListFile(access.RelativePath(list.Folder(), path), f))
+		}
+		slices.SortFunc(files, func(a, b accessListFile) int {
+			return strings.Compare(a.Path, b.Path)
+		})
+		c.JSON(http.StatusOK, gin.H{"folder": list.Folder(), "files": files})
+	})
+
+	// Parsed rows, and changes to the loaded version of the file, without writing it
+	r.POST("/preview", func(c *gin.Context) {
+		list, err := fileAccessList(c)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		path, data, err := readUpload(c, list)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		f, err := list.Preview(path, data)
+		if err != nil {
+			AbortWithError(c, uploadError(err))
+			return
+		}
+		old, err := authorizeUpload(c, list, path, f)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		rows := make([]accessListRow, 0, len(f.Entries))
+		for _, e := range f.Entries {
+			row := accessListRow{Email: e.Email, Name: e.Name, Active: e.Status, Roles: e.Roles}
+			if len(row.Roles) == 0 {
+				row.Roles = f.Grant.Roles
+			}
+			rows = append(rows, row)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"file":    newAccessListFile(access.RelativePath(list.Folder(), path), f),
+			"replace": old != nil,
+			"rows":    rows,
+			"changes": fileDiff(path, old, f),
+		})
+	})
+
+	// Upload a file, replacing the file in the same path
+	r.POST("", func(c *gin.Context) {
+		list, err := fileAccessList(c)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		path, data, err := readUpload(c, list)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		preview, err := list.Preview(path, data)
+		if err != nil {
+			AbortWithError(c, uploadError(err))
+			return
+		}
+		old, err := authorizeUpload(c, list, path, preview)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		f, err := list.WriteFile(path, data)
+		if err != nil {
+			AbortWithError(c, uploadError(err))
+			return
+		}
+
+		rel := access.RelativePath(list.Folder(), path)
+		slog.Info("Access list file uploaded", "file", rel, "rows", len(f.Entries), "user", c.GetString("userID"))
+
+		status := http.StatusCreated
+		if old != nil {
+			status = http.StatusOK
+		}
+		c.JSON(status, gin.H{
+			"file":    newAccessListFile(rel, f),
+			"changes": fileDiff(path, old, f),
+		})
+	})
+
+	r.DELETE("/*path", func(c *gin.Context) {
+		list, err := fileAccessList(c)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		path, err := list.ListPath(strings.TrimPrefix(c.Param("path"), "/"))
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		if _, err := authorizeExisting(c, list, path); err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		if err := list.DeleteFile(path); err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		slog.Info("Access list file deleted", "file", access.RelativePath(list.Folder(), path), "user", c.GetString("userID"))
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,291 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"entry-access-control/internal/access"
+	"entry-access-control/internal/config"
+	"entry-access-control/internal/jwt"
+	"entry-access-control/internal/nonce"
+	"entry-access-control/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testAccessListHeader = "PRIMARY E-MAIL\tSTUDY RIGHT STATUS\n"
+
+// Admins write every access list, lab assistants only the access list of entry 1
+const testAccessListPolicy = `
+roles:
+  admin:
+    permissions:
+      - resource: "*"
+        actions: ["*"]
+  lab_assistant:
+    permissions:
+      - resource: "entry:1:access_list"
+        actions: ["write"]
+users:
+  admin@example.com:
+    roles: [admin]
+  lab@example.com:
+    roles: [lab_assistant]
+`
+
+type accessListTestServer struct {
+	router *gin.Engine
+	list   *access.CSVAccessList
+	folder string
+}
+
+// newAccessListTestServer serves the access list API on a folder with lab.csv,
+// granting entry 1, and all.csv, granting all entries.
+func newAccessListTestServer(t *testing.T) *accessListTestServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+
+	prev := config.Cfg
+	config.Cfg = &config.Config{Secret: "test-secret", TokenTTL: 3600}
+	t.Cleanup(func() { config.Cfg = prev })
+
+	provider := storage.NewProvider(&config.Storage{
+		SQLite: &config.SQLLiteStorage{Path: filepath.Join(dir, "test.db")},
+	})
+	if provider == nil {
+		t.Fatal("NewProvider failed")
+	}
+	t.Cleanup(func() { provider.Close() })
+	prevStore := nonce.Store
+	nonce.Store = nonce.NewMemoryStore()
+	t.Cleanup(func() { nonce.Store = prevStore })
+	for _, name := range []string{"Lab", "Library"} {
+		if err := provider.CreateEntry(context.Background(), storage.Entry{Name: name}); err != nil {
+			t.Fatalf("CreateEntry: %v", err)
+		}
+	}
+
+	policy := filepath.Join(dir, "rbac.yaml")
+	writeTestFile(t, policy, testAccessListPolicy)
+	rbac := access.GetRBAC()
+	if err := rbac.LoadPolicy(policy); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+
+	folder := filepath.Join(dir, "access_lists")
+	writeTestFile(t, filepath.Join(folder, "lab.csv"), testAccessListHeader+"student@example.com\tActive - Attending\n")
+	writeTestFile(t, filepath.Join(folder, "lab.csv.meta.yaml"), "entries: [1]\n")
+	writeTestFile(t, filepath.Join(folder, "all.csv"), testAccessListHeader+"everyone@example.com\tActive - Attending\n")
+	list, ok := access.NewAccessList("csv", &config.Config{AccessListFolder: folder}).(*access.CSVAccessList)
+	if !ok {
+		t.Fatal("NewAccessList failed")
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("Storage", provider)
+		c.Set("RBAC", rbac)
+		c.Set("AccessList", list)
+		c.Next()
+	}, ErrorHandler())
+	AccessListApi(r.Group("/api/v1/access-lists"))
+	return &accessListTestServer{router: r, list: list, folder: folder}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// do sends the request as the user, and decodes the JSON response, if any.
+func (s *accessListTestServer) do(t *testing.T, user string, req *http.Request) (int, map[string]any) {
+	t.Helper()
+	token, err := jwt.GenerateJWT(jwt.NewAuthClaims(user, 3600))
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	req.AddCookie(&http.Cookie{Name: AUTH_COOKIE_NAME, Value: token})
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+
+	var resp map[string]any
+	if w.Body.Len() > 0 {
+		json.Unmarshal(w.Body.Bytes(), &resp)
+	}
+	return w.Code, resp
+}
+
+// upload posts the content as a file to the path, e.g. /api/v1/access-lists/preview.
+func (s *accessListTestServer) upload(t *testing.T, user, target, path, content string) (int, map[string]any) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("path", path)
+	part, err := form.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return s.do(t, user, req)
+}
+
+func (s *accessListTestServer) delete(t *testing.T, user, path string) int {
+	t.Helper()
+	status, _ := s.do(t, user, httptest.NewRequest(http.MethodDelete, "/api/v1/access-lists/"+path, nil))
+	return status
+}
+
+func filePaths(resp map[string]any) []string {
+	var paths []string
+	files, _ := resp["files"].([]any)
+	for _, f := range files {
+		paths = append(paths, f.(map[string]any)["path"].(string))
+	}
+	return paths
+}
+
+func TestAccessListApi_List(t *testing.T) {
+	s := newAccessListTestServer(t)
+
+	tests := []struct {
+		user string
+		want []string
+	}{
+		{"admin@example.com", []string{"all.csv", "lab.csv"}},
+		{"lab@example.com", []string{"lab.csv"}},
+	}
+	for _, tt := range tests {
+		status, resp := s.do(t, tt.user, httptest.NewRequest(http.MethodGet, "/api/v1/access-lists", nil))
+		if status != http.StatusOK {
+			t.Fatalf("%s: status %d %v", tt.user, status, resp)
+		}
+		if got := filePaths(resp); len(got) != len(tt.want) || got[0] != tt.want[0] {
+			t.Errorf("%s: files = %v, want %v", tt.user, got, tt.want)
+		}
+	}
+
+	status, _ := s.do(t, "nobody@example.com", httptest.NewRequest(http.MethodGet, "/api/v1/access-lists", nil))
+	if status != http.StatusForbidden {
+		t.Errorf("user without permission: status %d, want 403", status)
+	}
+}
+
+func TestAccessListApi_Preview(t *testing.T) {
+	s := newAccessListTestServer(t)
+	content := testAccessListHeader + "student@example.com\tPassive\nnew@example.com\tActive - Attending\n"
+
+	status, resp := s.upload(t, "lab@example.com", "/api/v1/access-lists/preview", "lab.csv", content)
+	if status != http.StatusOK {
+		t.Fatalf("status %d %v", status, resp)
+	}
+	if resp["replace"] != true {
+		t.Errorf("replace = %v, want true", resp["replace"])
+	}
+	if rows, _ := resp["rows"].([]any); len(rows) != 2 {
+		t.Errorf("rows = %v, want 2", rows)
+	}
+	if changes, _ := resp["changes"].([]any); len(changes) != 2 {
+		t.Errorf("changes = %v, want new@example.com added and student@example.com deactivated", changes)
+	}
+	if user, _ := s.list.Find("new@example.com"); user != nil {
+		t.Error("preview loaded the file")
+	}
+
+	status, _ = s.upload(t, "lab@example.com", "/api/v1/access-lists/preview", "all.csv", content)
+	if status != http.StatusForbidden {
+		t.Errorf("preview of all.csv as lab assistant: status %d, want 403", status)
+	}
+	status, _ = s.upload(t, "admin@example.com", "/api/v1/access-lists/preview", "lab.csv", "garbage\n")
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("preview of invalid file: status %d, want 422", status)
+	}
+}
+
+func TestAccessListApi_Upload(t *testing.T) {
+	s := newAccessListTestServer(t)
+	content := testAccessListHeader + "new@example.com\tActive - Attending\n"
+
+	status, resp := s.upload(t, "lab@example.com", "/api/v1/access-lists", "lab.csv", content)
+	if status != http.StatusOK {
+		t.Fatalf("replace lab.csv: status %d %v", status, resp)
+	}
+	if user, _ := s.list.Find("new@example.com"); user == nil {
+		t.Error("uploaded file is not loaded")
+	}
+
+	// New files without metadata grant all entries
+	status, _ = s.upload(t, "lab@example.com", "/api/v1/access-lists", "other.csv", content)
+	if status != http.StatusForbidden {
+		t.Errorf("new file as lab assistant: status %d, want 403", status)
+	}
+	status, _ = s.upload(t, "admin@example.com", "/api/v1/access-lists", "other.csv", content)
+	if status != http.StatusCreated {
+		t.Errorf("new file as admin: status %d, want 201", status)
+	}
+	status, _ = s.upload(t, "admin@example.com", "/api/v1/access-lists", "../outside.csv", content)
+	if status != http.StatusBadRequest {
+		t.Errorf("path outside the folder: status %d, want 400", status)
+	}
+
+	// Files on disk are authorized even if they aren't loaded
+	writeTestFile(t, filepath.Join(s.folder, "late.csv"), testAccessListHeader+"late@example.com\tActive - Attending\n")
+	writeTestFile(t, filepath.Join(s.folder, "late.csv.meta.yaml"), "entries: [2]\n")
+	if status, _ := s.upload(t, "lab@example.com", "/api/v1/access-lists", "late.csv", content); status != http.StatusForbidden {
+		t.Errorf("replace unloaded file of entry 2 as lab assistant: status %d, want 403", status)
+	}
+}
+
+func TestAccessListApi_Delete(t *testing.T) {
+	s := newAccessListTestServer(t)
+
+	if status := s.delete(t, "lab@example.com", "all.csv"); status != http.StatusForbidden {
+		t.Errorf("delete all.csv as lab assistant: status %d, want 403", status)
+	}
+
+	// Written after loading, granting all entries
+	writeTestFile(t, filepath.Join(s.folder, "late.csv"), testAccessListHeader+"late@example.com\tActive - Attending\n")
+	if status := s.delete(t, "lab@example.com", "late.csv"); status != http.StatusForbidden {
+		t.Errorf("delete unloaded late.csv as lab assistant: status %d, want 403", status)
+	}
+
+	// Not parsed, so the entries it grants are unknown
+	writeTestFile(t, filepath.Join(s.folder, "broken.csv"), "garbage\n")
+	writeTestFile(t, filepath.Join(s.folder, "broken.csv.meta.yaml"), "entries: [1]\n")
+	if status := s.delete(t, "lab@example.com", "broken.csv"); status != http.StatusForbidden {
+		t.Errorf("delete unparsed broken.csv as lab assistant: status %d, want 403", status)
+	}
+	if _, err := os.Stat(filepath.Join(s.folder, "broken.csv")); err != nil {
+		t.Errorf("broken.csv was deleted: %v", err)
+	}
+	if status := s.delete(t, "admin@example.com", "broken.csv"); status != http.StatusNoContent {
+		t.Errorf("delete broken.csv as admin: status %d, want 204", status)
+	}
+
+	if status := s.delete(t, "lab@example.com", "lab.csv"); status != http.StatusNoContent {
+		t.Errorf("delete lab.csv as lab assistant: status %d, want 204", status)
+	}
+	if user, _ := s.list.Find("student@example.com"); user != nil {
+		t.Error("user of deleted file still found")
+	}
+	if status := s.delete(t, "admin@example.com", "lab.csv"); status != http.StatusNotFound {
+		t.Errorf("delete missing file: status %d, want 404", status)
+	}
+}
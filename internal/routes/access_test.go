@@ -0,0 +1,187 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"entry-access-control/internal/events"
+	"entry-access-control/internal/jwt"
+)
+
+// scanEntry returns the entry token displayed on the device.
+func (s *provisioningTestServer) scanEntry(t *testing.T, token string) string {
+	t.Helper()
+	status, resp := s.do(t, httptest.NewRequest(http.MethodGet, "/entry/qr.json?cb=1", nil), token)
+	if status != http.StatusOK {
+		t.Fatalf("qr.json: status %d %v", status, resp)
+	}
+	u, err := url.Parse(resp["url"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path.Base(u.Path)
+}
+
+// loginForScan follows the login redirect of an entry token scanned without a
+// session, and logs in as the user with the email link. Returns the redirect
+// deciding access, and the session cookie.
+func (s *provisioningTestServer) loginForScan(t *testing.T, entryToken, email string) (string, *http.Cookie) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/entry/"+entryToken, nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("scan without login: status %d, want 302", w.Code)
+	}
+	loginURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || loginURL.Path != "/auth/email/login" {
+		t.Fatalf("scan without login redirected to %q", w.Header().Get("Location"))
+	}
+	scan, err := jwt.DecodeEntryLoginJWT(loginURL.Query().Get("entry"))
+	if err != nil {
+		t.Fatalf("DecodeEntryLoginJWT: %v", err)
+	}
+
+	// As the login link sent by POST /auth/email/login
+	claim := jwt.NewAccessCodeClaim("", email, scan.EntryID, scan.DeviceID, 600)
+	claim.Audience = []string{JWT_AUDIENCE_EMAIL_LOGIN}
+	claim.AuthenticateOnly = true
+	link, err := jwt.GenerateJWT(claim)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/email/verify/"+link, nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login link: status %d, want 302", w.Code)
+	}
+	var session *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == AUTH_COOKIE_NAME {
+			session = cookie
+		}
+	}
+	if session == nil {
+		t.Fatal("login link did not set the session cookie")
+	}
+	return w.Header().Get("Location"), session
+}
+
+// accessNotices returns the access notices published for the device within the timeout.
+func accessNotices(ch <-chan events.Event, timeout time.Duration) []accessNotice {
+	var notices []accessNotice
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev := <-ch:
+			if notice, ok := ev.Data.(accessNotice); ok {
+				notices = append(notices, notice)
+			}
+		case <-deadline:
+			return notices
+		}
+	}
+}
+
+func TestEntryRoute_EventsAfterRestart(t *testing.T) {
+	s := newProvisioningTestServer(t)
+	deviceID, token := s.enroll(t, 1)
+
+	// Fresh broker, as after a restart, with one event for the device
+	prev := events.Default
+	events.Default = events.NewBroker()
+	t.Cleanup(func() { events.Default = prev })
+	ev := events.Default.Publish(deviceID, events.TypeRotate, nil)
+
+	// Cursor from the previous instance
+	status, resp := s.do(t, httptest.NewRequest(http.MethodGet, "/entry/events.json?since=100", nil), token)
+	if status != http.StatusOK {
+		t.Fatalf("status %d %v", status, resp)
+	}
+	if got, _ := resp["events"].([]any); len(got) != 1 {
+		t.Errorf("events = %v, want the event published after the restart", resp["events"])
+	}
+	if resp["last_id"] != float64(ev.ID) {
+		t.Errorf("last_id = %v, want %d", resp["last_id"], ev.ID)
+	}
+
+	status, resp = s.do(t, httptest.NewRequest(http.MethodGet, "/entry/events.json?since=x", nil), token)
+	if status != http.StatusBadRequest {
+		t.Errorf("invalid since: status %d %v", status, resp)
+	}
+}
+
+func TestEntryRoute_LoginForScannedEntry(t *testing.T) {
+	s := newProvisioningTestServer(t)
+	deviceID, token := s.enroll(t, 1)
+	ch, unsubscribe := events.Default.Subscribe(deviceID)
+	defer unsubscribe()
+
+	redirect, session := s.loginForScan(t, s.scanEntry(t, token), "student@example.com")
+	u, err := url.Parse(redirect)
+	if err != nil || path.Dir(u.Path) != "/entry" {
+		t.Fatalf("login redirected to %q, want an entry token", redirect)
+	}
+	req := httptest.NewRequest(http.MethodGet, u.Path, nil)
+	req.AddCookie(session)
+	if status, resp := s.do(t, req, ""); status != http.StatusOK {
+		t.Fatalf("access after login: status %d %v", status, resp)
+	}
+
+	// The device that displayed the scanned token shows the decision
+	var granted []accessNotice
+	for _, notice := range accessNotices(ch, 100*time.Millisecond) {
+		if notice.Granted {
+			granted = append(granted, notice)
+		}
+	}
+	if len(granted) != 1 || granted[0].Initials != "S" {
+		t.Errorf("access notices on the scanning device = %+v, want access granted to S", granted)
+	}
+}
+
+func TestEntryRoute_LoginDecidesScannedEntry(t *testing.T) {
+	s := newProvisioningTestServer(t)
+	// student@example.com is only granted entry 1
+	deviceID, token := s.enroll(t, 2)
+	ch, unsubscribe := events.Default.Subscribe(deviceID)
+	defer unsubscribe()
+
+	redirect, session := s.loginForScan(t, s.scanEntry(t, token), "student@example.com")
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, u.Path, nil)
+	req.AddCookie(session)
+	if status, resp := s.do(t, req, ""); status != http.StatusForbidden {
+		t.Fatalf("access to entry 2 after login: status %d %v, want 403", status, resp)
+	}
+
+	var denied []accessNotice
+	for _, notice := range accessNotices(ch, 100*time.Millisecond) {
+		if !notice.Granted {
+			denied = append(denied, notice)
+		}
+	}
+	if len(denied) != 1 || denied[0].Reason != GetErrorStopCodes(ErrEntryNotGranted)[0] {
+		t.Errorf("access notices on the scanning device = %+v, want entry not granted", denied)
+	}
+}
+
+func TestEmailLogin_RequiresScan(t *testing.T) {
+	s := newProvisioningTestServer(t)
+
+	form := url.Values{"email": {"student@example.com"}}
+	req := httptest.NewRequest(http.MethodPost, "/auth/email/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if status, resp := s.do(t, req, ""); status != http.StatusBadRequest {
+		t.Errorf("login without a scan or a page to return to: status %d %v, want 400", status, resp)
+	}
+}
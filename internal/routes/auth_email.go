@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,9 +32,6 @@ import (
 	gojwt "github.com/golang-jwt/jwt/v5"
 )
 
-// TODO: Get actual entry ID
-const ENTRY_ID = "Ag C331"
-
 // If not runninng in production, use this test user to skip email sending
 // and just print the OTP code to the log.
 const TEST_USER = "user@example.com"
@@ -47,6 +45,9 @@ const LINK_TTL = time.Duration(10) * time.Minute
 
 const EMAIL_TITLE = "Access code for %s"
 
+// Named in the login email instead of an entry, when logging in without a scan
+const LOGIN_EMAIL_NAME = "your account"
+
 // Salt for SAS key derivation. Used to prevent rainbow table attacks.
 const SAS_KEY_SALT = "Ð¥ðVwj¯xR¨Øò\"9îzE5B:ëø1K*,EöþJjM"
 
@@ -72,7 +73,7 @@ var ErrorCodes = map[string]string{
 }
 
 type emailLoginLink struct {
-	EntryName  string  // Name of the entry point (e.g., Ag C331)
+	EntryName  string  // Name of the scanned entry, e.g. Ag C331
 	Link       string  // The actual login link URL
 	EntryCode  string  // The entry code for the login link
 	Created    string  // Creation time of the login link
@@ -122,8 +123,10 @@ func generateOTP() (string, error) {
 
 // Login user by renewing auth cookie and consuming the claim nonce
 func login(c *gin.Context, claim jwt.AccessCodeClaim) {
-	slog.Info("User logged in via email verification", "email", claim.Email)
-	renewAuth(c, claim.Email, true)
+	// Sessions are for the canonical user, whichever address was used
+	userID := access.CanonicalID(claim.Email)
+	slog.Info("User logged in via email verification", "email", claim.Email, "userID", userID)
+	renewAuth(c, userID, true)
 	jwt.ConsumeClaimNonce(&claim.RegisteredClaims)
 }
 
@@ -153,12 +156,12 @@ func eventMessage(c *gin.Context, data any) {
 	c.Writer.Flush()
 }
 
-// Generate a URL for showing door open
-func SuccessUrl(c *gin.Context, entryId string, data ...map[string]interface{}) string {
-	entryToken, err := genEntryToken(entryId)
+// Generate a URL for deciding access to the entry, once the user is logged in.
+// The device that displayed the scanned token is notified of the decision.
+func SuccessUrl(c *gin.Context, entryId string, deviceId string, data ...map[string]interface{}) (string, error) {
+	entryToken, err := genEntryToken(entryId, deviceId)
 	if err != nil {
-		slog.Error("Failed to generate entry token", "error", err)
-		c.AbortWithStatusJSON(500, gin.H{"error": "Internal server error"})
+		return "", fmt.Errorf("failed to generate entry token: %w", err)
 	}
 	// Convert data to URL parameters
 	params := ""
@@ -172,7 +175,43 @@ func SuccessUrl(c *gin.Context, entryId string, data ...map[string]interface{})
 		}
 	}
 
-	return utils.UrlFor(c, fmt.Sprintf("/entry/%s%s", entryToken, params))
+	return utils.UrlFor(c, fmt.Sprintf("/entry/%s%s", entryToken, params)), nil
+}
+
+// loginRedirect returns where the user goes once logged in: deciding access to
+// the scanned entry, or back to the page that required the login.
+func loginRedirect(c *gin.Context, claim jwt.AccessCodeClaim) (string, error) {
+	if claim.EntryID != "" {
+		return SuccessUrl(c, claim.EntryID, claim.DeviceID)
+	}
+	return utils.UrlFor(c, claim.Next), nil
+}
+
+// safeNext returns the page to return to after login, if it's on this site.
+func safeNext(c *gin.Context, next string) string {
+	if next == "" || !isSafeUrl(c, utils.UrlFor(c, next)) {
+		return ""
+	}
+	return next
+}
+
+// entryName returns the name of the entry for the login email.
+func entryName(c *gin.Context, entryID string) string {
+	err, storageProvider := GetStorageProvider(c)
+	if err != nil {
+		return entryID
+	}
+	entries, err := storageProvider.ListEntries(c.Request.Context())
+	if err != nil {
+		slog.Warn("Failed to list entries for login email", "error", err)
+		return entryID
+	}
+	for _, e := range entries {
+		if strconv.FormatInt(e.ID, 10) == entryID {
+			return e.Name
+		}
+	}
+	return entryID
 }
 
 // isSafeUrl checks if the target URL is within the same origin as the base URL
@@ -201,8 +240,19 @@ func EmailLoginRoute(r *gin.RouterGroup) {
 	r.GET("/login", func(c *gin.Context) {
 
 		var pageData = gin.H{
-			"LinkTTL": LINK_TTL.Minutes(),
-			"Error":   "",
+			"LinkTTL":  LINK_TTL.Minutes(),
+			"Error":    "",
+			"Redirect": safeNext(c, c.Query("next")),
+		}
+
+		// Scanned entry token, access is decided once logged in
+		if entry := c.Query("entry"); entry != "" {
+			if _, err := jwt.DecodeEntryLoginJWT(entry); err != nil {
+				slog.Info("Scanned entry for login is invalid or expired", "error", err)
+				pageData["Error"] = "The scanned QR code has expired. Please scan it again."
+			} else {
+				pageData["Entry"] = entry
+			}
 		}
 
 		// Check for error code in URL, display friendly message
@@ -257,14 +307,23 @@ func EmailLoginRoute(r *gin.RouterGroup) {
 			slog.Debug("User found in access list", "email", emailAddr, "userID", user)
 		}
 
-		// TODO: Check if user can access the entry
-		// if !access.CanAccessEntry(userId, entryId) {
-		// 	slog.Warn("User does not have access to the entry", "email", email, "entryId", entryId)
-		// 	c.HTML(403, "email_login.html.tmpl", gin.H{"error": "You do not have access to this entry"})
-		// 	return
-		// }
-
-		entryId := ENTRY_ID
+		// Access is decided for the entry scanned before logging in, once
+		// logged in. Without a scan, the user returns to the page that
+		// required the login.
+		var entryId, deviceId string
+		next := safeNext(c, c.PostForm("redirect"))
+		if entry := c.PostForm("entry"); entry != "" {
+			scan, err := jwt.DecodeEntryLoginJWT(entry)
+			if err != nil {
+				slog.Info("Scanned entry for login is invalid or expired", "email", emailAddr, "error", err)
+				loginErr(c, http.StatusBadRequest, "The scanned QR code has expired. Please scan it again.")
+				return
+			}
+			entryId, deviceId = scan.EntryID, scan.DeviceID
+		} else if next == "" {
+			loginErr(c, http.StatusBadRequest, "Scan the QR code at the door to log in.")
+			return
+		}
 
 		expires := time.Now().Add(LINK_TTL).Format(time.RFC3339)
 
@@ -285,7 +344,8 @@ func EmailLoginRoute(r *gin.RouterGroup) {
 		// Both claims have the same nonce, so consuming one will invalidate the other
 		// This prevents reuse of either method
 
-		baseClaim := jwt.NewAccessCodeClaim(code, emailAddr, entryId, uint(LINK_TTL.Seconds()))
+		baseClaim := jwt.NewAccessCodeClaim(code, emailAddr, entryId, deviceId, uint(LINK_TTL.Seconds()))
+		baseClaim.Next = next
 
 		otpClaim := baseClaim
 		otpClaim.Audience = []string{"email_otp"}
@@ -310,8 +370,12 @@ func EmailLoginRoute(r *gin.RouterGroup) {
 		slog.Debug("Generated email login link and OTP", "email", emailAddr, "link", link, "otp", otp, "expires", expires)
 
 		// Collect necessary info for email
+		name := LOGIN_EMAIL_NAME
+		if entryId != "" {
+			name = entryName(c, entryId)
+		}
 		data := emailLoginLink{
-			EntryName:  entryId, // TODO: Get actual entry name
+			EntryName:  name,
 			Link:       link,
 			EntryCode:  otp, // text version of the OTP
 			Created:    time.Now().Format(time.RFC3339),
@@ -509,19 +573,19 @@ func EmailLoginRoute(r *gin.RouterGroup) {
 
 		slog.Info("User logged in via email OTP", "email", emailClaim.Email)
 
-		// TODO: generate new EntryWay claim
-		// Quote the entry ID for URL
-		entry_url := template.URLQueryEscaper("...")
-
 		login(c, *emailClaim)
 
-		// TODO: Redirect to entryway page
-		// Generate new EntryWay claim
+		redirect, err := loginRedirect(c, *emailClaim)
+		if err != nil {
+			slog.Error("Failed to generate entry URL", "error", err)
+			loginErr(c, 500, "Internal server error")
+			return
+		}
 
 		c.JSON(200, gin.H{
 			"status":   "success",
 			"message":  "OTP verification successful",
-			"redirect": utils.UrlFor(c, "/entry/"+entry_url),
+			"redirect": redirect,
 		})
 	})
 
@@ -553,10 +617,15 @@ func EmailLoginRoute(r *gin.RouterGroup) {
 		// If the claim has AuthenticateOnly set, login user only and show success page
 		if emailClaim.AuthenticateOnly {
 			login(c, *emailClaim)
-			entryID := emailClaim.EntryID
 
-			// Redirect to entryway page
-			c.Redirect(http.StatusFound, SuccessUrl(c, entryID))
+			redirect, err := loginRedirect(c, *emailClaim)
+			if err != nil {
+				slog.Error("Failed to generate entry URL", "error", err)
+				c.AbortWithStatusJSON(500, gin.H{"error": "Internal server error"})
+				return
+			}
+			c.Redirect(http.StatusFound, redirect)
+			return
 		} else {
 			// Store the ID of the clicked link to allow polling to detect it
 			ttl := time.Duration(emailClaim.ExpiresAt.Unix()-time.Now().UTC().Unix()) * time.Second
@@ -5,9 +5,11 @@
 package routes
 
 import (
+	"entry-access-control/internal/access"
 	. "entry-access-control/internal/config"
 	. "entry-access-control/internal/jwt"
 	"entry-access-control/internal/nonce"
+	"entry-access-control/internal/storage"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -40,6 +42,8 @@ func setAuthCookie(c *gin.Context, token string) {
 
 	// Convert to int for SetCookie
 
+	// Not sent with posts from other sites, e.g. forged device approvals
+	c.SetSameSite(http.SameSiteLaxMode)
 	c.SetCookie(
 		AUTH_COOKIE_NAME,
 		token,
@@ -67,16 +71,48 @@ func GetUser(c *gin.Context) (string, error) {
 
 func NewAuth(c *gin.Context, userId string) error {
 	// Create new auth token
-	claim := NewAuthClaims(userId)
+	claim := NewAuthClaims(userId, authTTL())
 	token, err := GenerateJWT(claim)
 	if err != nil {
 		return err
 	}
 	// Set auth cookie
 	setAuthCookie(c, token)
+	startSession(c, claim)
 	return nil
 }
 
+// startSession records the login, so sessions of a user can be listed.
+func startSession(c *gin.Context, claim *AuthClaims) {
+	err, storageProvider := GetStorageProvider(c)
+	if err != nil {
+		slog.Warn("Session not recorded", "error", err)
+		return
+	}
+	session := storage.UserSession{
+		ID:        claim.ID,
+		UserID:    access.NormalizeEmail(claim.UserID),
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		ExpiresAt: claim.ExpiresAt.Time,
+	}
+	if err := storageProvider.CreateUserSession(c.Request.Context(), session); err != nil {
+		slog.Error("Failed to record session", "userID", claim.UserID, "error", err)
+	}
+}
+
+// endSession marks the session of the token ended.
+func endSession(c *gin.Context, id string) {
+	err, storageProvider := GetStorageProvider(c)
+	if err != nil {
+		slog.Warn("Session end not recorded", "error", err)
+		return
+	}
+	if err := storageProvider.EndUserSession(c.Request.Context(), id, time.Now()); err != nil {
+		slog.Error("Failed to end session", "error", err)
+	}
+}
+
 func verifyAuth(c *gin.Context) (string, error) {
 	// Get auth token from cookie
 	token, err := c.Cookie(AUTH_COOKIE_NAME)
@@ -100,7 +136,11 @@ func renewAuth(c *gin.Context, userId string, forceRenew bool) error {
 		oldClaims, err := DecodeAuthJWT(oldToken)
 		if err == nil {
 			nonceValue := oldClaims.ID
-			expiration := oldClaims.ExpiresAt.Time
+			// Tokens issued without expiry are replaced
+			var expiration time.Time
+			if oldClaims.ExpiresAt != nil {
+				expiration = oldClaims.ExpiresAt.Time
+			}
 
 			// Log odd behavior, where the user ID in the token does not match the expected user ID
 			// This could indicate token tampering attempt, but also benign issues like user ID change
@@ -121,6 +161,7 @@ func renewAuth(c *gin.Context, userId string, forceRenew bool) error {
 
 				// Invalidate old token by consuming its nonce
 				nonce.Store.Consume(c.Request.Context(), nonceValue)
+				endSession(c, nonceValue)
 
 				forceRenew = true
 			}
@@ -152,6 +193,7 @@ func AuthLogout(c *gin.Context) {
 		claims, err := DecodeAuthJWT(token)
 		if err == nil {
 			nonce.Store.Consume(c.Request.Context(), claims.ID)
+			endSession(c, claims.ID)
 		}
 	}
 
@@ -208,6 +250,17 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// LoadUser sets the user ID in context if a valid auth token is present.
+// Unlike AuthMiddleware, the request continues without authentication.
+func LoadUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if uid, err := verifyAuth(c); err == nil {
+			c.Set("userID", uid)
+		}
+		c.Next()
+	}
+}
+
 func AuthRoutes(r *gin.RouterGroup) {
 	// Route to renew authentication token
 	r.GET("/renew", AuthMiddleware(), func(c *gin.Context) {
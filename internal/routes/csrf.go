@@ -0,0 +1,58 @@
+package routes
+
+// CSRF tokens for forms posted with the auth cookie. The token is an HMAC of
+// the login session and the form action, so a token is only valid for the
+// session it was rendered for, and can't be forged by other sites.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	. "entry-access-control/internal/config"
+	. "entry-access-control/internal/jwt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Form field of the CSRF token
+const CSRF_FIELD = "csrf_token"
+
+// sessionID returns the ID of the login session of the request.
+func sessionID(c *gin.Context) (string, error) {
+	token, err := c.Cookie(AUTH_COOKIE_NAME)
+	if err != nil {
+		return "", err
+	}
+	claims, err := DecodeAuthJWT(token)
+	if err != nil {
+		return "", err
+	}
+	return claims.ID, nil
+}
+
+// csrfToken returns the CSRF token for forms posting to the action, e.g.
+// "authorize:<device_id>".
+func csrfToken(c *gin.Context, action string) (string, error) {
+	session, err := sessionID(c)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	h := hmac.New(sha256.New, []byte(Cfg.Secret))
+	h.Write([]byte("csrf:" + session + ":" + action))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyCSRFToken checks the CSRF token posted in the form is the one of the
+// session and action.
+func verifyCSRFToken(c *gin.Context, action string) error {
+	expected, err := csrfToken(c, action)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(c.PostForm(CSRF_FIELD)), []byte(expected)) {
+		return ErrCSRFTokenInvalid
+	}
+	return nil
+}
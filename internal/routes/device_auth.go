@@ -0,0 +1,214 @@
+package routes
+
+// Device credentials for approved entry devices
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	. "entry-access-control/internal/jwt"
+	"entry-access-control/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const DEVICE_CLAIM_CONTEXT_KEY = "deviceClaim"
+
+// Device last seen time is written at most this often
+const DEVICE_SEEN_THROTTLE = 10 * time.Second
+
+type deviceTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// genDeviceToken issues a device credential with the entries the device is
+// currently approved for. The credential replaces previousID, or without one,
+// is the single credential the device can enroll for after approval.
+func genDeviceToken(c *gin.Context, deviceID string, clientIP string, previousID string) (deviceTokenResponse, error) {
+	err, storageProvider := GetStorageProvider(c)
+	if err != nil {
+		return deviceTokenResponse{}, err
+	}
+
+	approved, err := storageProvider.ListApprovedDevicesByDevice(c.Request.Context(), deviceID)
+	if err != nil {
+		return deviceTokenResponse{}, fmt.Errorf("%w: %v", ErrDatabaseError, err)
+	}
+	if len(approved) == 0 {
+		return deviceTokenResponse{}, ErrDeviceNotApproved
+	}
+
+	entryIDs := make([]int64, 0, len(approved))
+	for _, a := range approved {
+		entryIDs = append(entryIDs, a.EntryID)
+	}
+
+	claim := NewDeviceClaim(deviceID, clientIP, entryIDs)
+	token, err := GenerateJWT(claim)
+	if err != nil {
+		return deviceTokenResponse{}, err
+	}
+
+	var issued bool
+	if previousID == "" {
+		issued, err = storageProvider.EnrollDevice(c.Request.Context(), deviceID, claim.ID)
+	} else {
+		issued, err = storageProvider.RenewDeviceCredential(c.Request.Context(), deviceID, previousID, claim.ID)
+	}
+	switch {
+	case err != nil:
+		return deviceTokenResponse{}, fmt.Errorf("%w: %v", ErrDatabaseError, err)
+	case !issued && previousID == "":
+		return deviceTokenResponse{}, ErrDeviceAlreadyEnrolled
+	case !issued:
+		return deviceTokenResponse{}, ErrDeviceTokenInvalid
+	}
+
+	slog.Debug("Issued device token", "device_id", deviceID, "entry_ids", entryIDs, "expires_at", claim.ExpiresAt.Time)
+	return deviceTokenResponse{
+		Token:     token,
+		ExpiresAt: claim.ExpiresAt.Time,
+	}, nil
+}
+
+// touchDevice records that the device has contacted the server, for monitoring.
+func touchDevice(ctx context.Context, storageProvider storage.Provider, device *storage.Device) {
+	now := time.Now()
+	if device.LastSeenAt != nil && now.Sub(*device.LastSeenAt) < DEVICE_SEEN_THROTTLE {
+		return
+	}
+	if err := storageProvider.TouchDevice(ctx, device.DeviceID, now); err != nil {
+		slog.Warn("Failed to update device last seen", "device_id", device.DeviceID, "error", err)
+		return
+	}
+	device.LastSeenAt = &now
+}
+
+func bearerToken(c *gin.Context) string {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// streamDeviceToken returns the device credential from the Authorization
+// header, or from the token query parameter, as EventSource can't set headers.
+// Query strings end up in access logs, so only the event stream accepts it.
+func streamDeviceToken(c *gin.Context) string {
+	if token := bearerToken(c); token != "" {
+		return token
+	}
+	return c.Query("token")
+}
+
+// RequireDevice creates middleware that requires a device credential as a
+// Bearer token. Device must still be approved in storage, the credential must
+// be the current one, or the one replaced by a renewal moments ago, and
+// entries revoked since the credential was issued are dropped from the claim.
+func RequireDevice() gin.HandlerFunc {
+	return requireDevice(bearerToken)
+}
+
+// RequireDeviceStream is RequireDevice for the event stream, also accepting the
+// credential as the token query parameter.
+func RequireDeviceStream() gin.HandlerFunc {
+	return requireDevice(streamDeviceToken)
+}
+
+func requireDevice(deviceToken func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := deviceToken(c)
+		if token == "" {
+			AbortWithError(c, ErrDeviceTokenRequired)
+			return
+		}
+
+		claim, err := DecodeDeviceJWT(token)
+		if err != nil {
+			slog.Debug("Invalid device token", "error", err)
+			AbortWithError(c, fmt.Errorf("%w: %v", ErrDeviceTokenInvalid, err))
+			return
+		}
+
+		err, storageProvider := GetStorageProvider(c)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		ctx := c.Request.Context()
+
+		device, err := storageProvider.GetDevice(ctx, claim.DeviceID)
+		if err != nil {
+			AbortWithError(c, fmt.Errorf("%w: %v", ErrDeviceNotFound, err))
+			return
+		}
+		if device.Status != storage.DeviceStatusApproved {
+			slog.Warn("Device token used by non-approved device", "device_id", device.DeviceID, "status", device.Status)
+			AbortWithError(c, ErrDeviceNotApproved)
+			return
+		}
+		if !device.AcceptsCredential(claim.ID, time.Now()) {
+			slog.Warn("Replaced device token used", "device_id", device.DeviceID)
+			AbortWithError(c, ErrDeviceTokenInvalid)
+			return
+		}
+		if err := checkDeviceIP(c, device); err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		approved, err := storageProvider.ListApprovedDevicesByDevice(ctx, device.DeviceID)
+		if err != nil {
+			AbortWithError(c, fmt.Errorf("%w: %v", ErrDatabaseError, err))
+			return
+		}
+
+		claim.EntryIDs = slices.DeleteFunc(claim.EntryIDs, func(entryID int64) bool {
+			return !slices.ContainsFunc(approved, func(a storage.ApprovedDevice) bool {
+				return a.EntryID == entryID
+			})
+		})
+		if len(claim.EntryIDs) == 0 {
+			AbortWithError(c, ErrDeviceNotApproved)
+			return
+		}
+
+		touchDevice(ctx, storageProvider, device)
+
+		c.Set(DEVICE_CLAIM_CONTEXT_KEY, claim)
+		c.Next()
+	}
+}
+
+// GetDeviceClaim returns the device credential set by RequireDevice.
+func GetDeviceClaim(c *gin.Context) (*DeviceClaim, error) {
+	v, exists := c.Get(DEVICE_CLAIM_CONTEXT_KEY)
+	if !exists {
+		return nil, ErrDeviceTokenRequired
+	}
+	claim, ok := v.(*DeviceClaim)
+	if !ok {
+		return nil, ErrInternalServer
+	}
+	return claim, nil
+}
+
+// authenticatedDeviceID returns the device_id path parameter, which must be the
+// device authenticated by RequireDevice.
+func authenticatedDeviceID(c *gin.Context) (string, error) {
+	claim, err := GetDeviceClaim(c)
+	if err != nil {
+		return "", err
+	}
+	if deviceID := c.Param("device_id"); deviceID != claim.DeviceID {
+		slog.Warn("Device token used for another device", "device_id", claim.DeviceID, "requested", deviceID)
+		return "", ErrDeviceTokenInvalid
+	}
+	return claim.DeviceID, nil
+}
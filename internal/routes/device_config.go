@@ -0,0 +1,87 @@
+package routes
+
+// Kiosk display settings for entry devices
+
+import (
+	"log/slog"
+	"net/http"
+
+	"entry-access-control/internal/display"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response header for the version stamp of the device display settings.
+// Kiosks re-fetch the settings when it changes.
+const DISPLAY_VERSION_HEADER = "X-Display-Version"
+
+// DeviceConfig serves the display settings for the device. Must be used after
+// RequireDevice.
+func DeviceConfig(c *gin.Context) {
+	deviceID, err := authenticatedDeviceID(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	err, storageProvider := GetStorageProvider(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	if _, err := storageProvider.GetDevice(ctx, deviceID); err != nil {
+		AbortWithError(c, ErrDeviceNotFound)
+		return
+	}
+
+	settings, err := display.ForDevice(ctx, storageProvider, deviceID)
+	if err != nil {
+		slog.Error("Failed to resolve display settings", "device_id", deviceID, "error", err)
+		AbortWithError(c, ErrDatabaseError)
+		return
+	}
+
+	version := settings.Version()
+	etag := `"` + version + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	c.Header(DISPLAY_VERSION_HEADER, version)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"version":  version,
+		"settings": settings,
+	})
+}
+
+// DisplayVersion adds the display settings version stamp of the authenticated
+// device to the response. Must be used after RequireDevice.
+func DisplayVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claim, err := GetDeviceClaim(c)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		err, storageProvider := GetStorageProvider(c)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		settings, err := display.ForDevice(c.Request.Context(), storageProvider, claim.DeviceID)
+		if err != nil {
+			slog.Warn("Failed to resolve display settings", "device_id", claim.DeviceID, "error", err)
+		} else {
+			c.Header(DISPLAY_VERSION_HEADER, settings.Version())
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,255 @@
+package routes
+
+// Server-sent events channel for entry devices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"entry-access-control/internal/display"
+	"entry-access-control/internal/events"
+	"entry-access-control/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SSE_HEARTBEAT_INTERVAL = 15 * time.Second
+	// How often device status is checked from storage. Approvals happen from
+	// the CLI, in another process, so they can't be published through the broker.
+	SSE_STATUS_INTERVAL = 2 * time.Second
+	// Reconnect delay suggested to the client, in milliseconds
+	SSE_RETRY_MS = 3000
+)
+
+// SSE event types for state snapshots. These are sent without an ID, as they
+// are re-sent on every connect.
+const (
+	SSE_EVENT_STATUS    = "status"
+	SSE_EVENT_ENTRIES   = "entries"
+	SSE_EVENT_CONFIG    = "config"
+	SSE_EVENT_HEARTBEAT = "heartbeat"
+)
+
+// Device state as seen by the SSE channel
+type deviceState struct {
+	Status   storage.DeviceStatus `json:"status"`
+	EntryIDs []int64              `json:"entry_ids"`
+	// Stop code explaining the status, e.g. DEVICE_IP_CHANGED
+	Reason *string `json:"reason,omitempty"`
+	// Version stamp of the display settings
+	DisplayVersion string `json:"display_version"`
+
+	device *storage.Device
+}
+
+func getDeviceState(ctx context.Context, storageProvider storage.Provider, deviceID string) (deviceState, error) {
+	device, err := storageProvider.GetDevice(ctx, deviceID)
+	if err != nil {
+		return deviceState{}, fmt.Errorf("%w: %v", ErrDeviceNotFound, err)
+	}
+
+	approved, err := storageProvider.ListApprovedDevicesByDevice(ctx, deviceID)
+	if err != nil {
+		return deviceState{}, fmt.Errorf("%w: %v", ErrDatabaseError, err)
+	}
+
+	state := deviceState{
+		Status:   device.Status,
+		EntryIDs: []int64{},
+		Reason:   device.StatusReason,
+		device:   device,
+	}
+	for _, a := range approved {
+		state.EntryIDs = append(state.EntryIDs, a.EntryID)
+	}
+	slices.Sort(state.EntryIDs)
+
+	settings, err := display.ForDevice(ctx, storageProvider, deviceID)
+	if err != nil {
+		return deviceState{}, fmt.Errorf("%w: %v", ErrDatabaseError, err)
+	}
+	state.DisplayVersion = settings.Version()
+
+	return state, nil
+}
+
+func setSSEHeaders(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("Transfer-Encoding", "chunked") // Important for streaming
+	c.Writer.Header().Set("X-Accel-Buffering", "no")      // Disable buffering for Nginx
+}
+
+// sseEvent writes a named event to the SSE client. Events with zero ID are sent without one.
+func sseEvent(c *gin.Context, id uint64, event string, data any) error {
+	serialized, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SSE event: %w", err)
+	}
+
+	msg := ""
+	if id != 0 {
+		msg += "id: " + strconv.FormatUint(id, 10) + "\n"
+	}
+	msg += "event: " + event + "\n"
+	msg += "data: " + string(serialized) + "\n\n"
+
+	if _, err := c.Writer.WriteString(msg); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// deviceClientConfig returns the client configuration with the device display settings version.
+func deviceClientConfig(c *gin.Context, state deviceState) gin.H {
+	cfg := ClientConfig(c)
+	cfg["DisplayVersion"] = state.DisplayVersion
+	return cfg
+}
+
+// DeviceSSE streams events to an entry device. Must be used after
+// RequireDevice.
+//
+// On connect, current device status, entries and configuration are sent.
+// Events missed since `Last-Event-ID` are replayed after that. The stream is
+// closed once the credential it was opened with would no longer be accepted.
+func DeviceSSE(c *gin.Context) {
+	claim, err := GetDeviceClaim(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+	deviceID, err := authenticatedDeviceID(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	err, storageProvider := GetStorageProvider(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	state, err := getDeviceState(ctx, storageProvider, deviceID)
+	if err != nil {
+		slog.Warn("Failed to get device state for SSE", "device_id", deviceID, "error", err)
+		AbortWithError(c, err)
+		return
+	}
+
+	var lastID uint64
+	if h := c.GetHeader("Last-Event-ID"); h != "" {
+		if lastID, err = strconv.ParseUint(h, 10, 64); err != nil {
+			AbortWithHTTPError(c, http.StatusBadRequest, ErrInvalidParameter)
+			return
+		}
+		// Event IDs restart from zero with the server, so an ID from a
+		// previous instance would skip every new event.
+		if lastID > events.Default.LastID() {
+			lastID = 0
+		}
+	} else {
+		// Fresh connection, only interested in events from now on.
+		lastID = events.Default.LastID()
+	}
+
+	ch, unsubscribe := events.Default.Subscribe(deviceID)
+	defer unsubscribe()
+
+	setSSEHeaders(c)
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.WriteString(fmt.Sprintf("retry: %d\n\n", SSE_RETRY_MS))
+
+	slog.Debug("Device SSE connected", "device_id", deviceID, "last_event_id", lastID)
+	if err := storageProvider.TouchDevice(ctx, deviceID, time.Now()); err != nil {
+		slog.Warn("Failed to update device last seen", "device_id", deviceID, "error", err)
+	}
+
+	send := func(id uint64, event string, data any) bool {
+		if err := sseEvent(c, id, event, data); err != nil {
+			slog.Debug("Device SSE write failed", "device_id", deviceID, "error", err)
+			return false
+		}
+		return true
+	}
+
+	if !send(0, SSE_EVENT_STATUS, state) ||
+		!send(0, SSE_EVENT_ENTRIES, state) ||
+		!send(0, SSE_EVENT_CONFIG, deviceClientConfig(c, state)) {
+		return
+	}
+
+	for _, ev := range events.Default.Since(deviceID, lastID) {
+		if !send(ev.ID, string(ev.Type), ev) {
+			return
+		}
+		lastID = ev.ID
+	}
+
+	heartbeat := time.NewTicker(SSE_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	statusCheck := time.NewTicker(SSE_STATUS_INTERVAL)
+	defer statusCheck.Stop()
+
+	for {
+		select {
+		case ev := <-ch:
+			// Replayed already
+			if ev.ID <= lastID {
+				continue
+			}
+			if !send(ev.ID, string(ev.Type), ev) {
+				return
+			}
+			lastID = ev.ID
+
+		case t := <-statusCheck.C:
+			current, err := getDeviceState(ctx, storageProvider, deviceID)
+			if err != nil {
+				slog.Warn("Device state check failed, closing SSE", "device_id", deviceID, "error", err)
+				return
+			}
+			if current.Status != state.Status && !send(0, SSE_EVENT_STATUS, current) {
+				return
+			}
+			if !slices.Equal(current.EntryIDs, state.EntryIDs) && !send(0, SSE_EVENT_ENTRIES, current) {
+				return
+			}
+			// Changes are sent first, so the device knows why it's closed.
+			// Reconnecting is refused by RequireDeviceStream.
+			if current.Status != storage.DeviceStatusApproved || len(current.EntryIDs) == 0 ||
+				!current.device.AcceptsCredential(claim.ID, t) {
+				slog.Info("Device credential no longer accepted, closing SSE", "device_id", deviceID, "status", current.Status)
+				return
+			}
+			if current.DisplayVersion != state.DisplayVersion && !send(0, SSE_EVENT_CONFIG, deviceClientConfig(c, current)) {
+				return
+			}
+			state = current
+
+		case t := <-heartbeat.C:
+			if !send(0, SSE_EVENT_HEARTBEAT, gin.H{"time": t.UTC().Format(time.RFC3339)}) {
+				return
+			}
+			// Heartbeat went through, so the device is still there
+			if err := storageProvider.TouchDevice(ctx, deviceID, t); err != nil {
+				slog.Warn("Failed to update device last seen", "device_id", deviceID, "error", err)
+			}
+
+		case <-ctx.Done():
+			slog.Debug("Device SSE client disconnected", "device_id", deviceID)
+			return
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package routes
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"entry-access-control/internal/events"
+	"entry-access-control/internal/storage"
+)
+
+type sseTestStream struct {
+	events chan string
+	cancel context.CancelFunc
+}
+
+// openSSE connects to the device event stream, and sends the name of each
+// received event to the channel, which is closed when the stream ends.
+func openSSE(t *testing.T, server *httptest.Server, deviceID, token, lastEventID string) *sseTestStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		server.URL+"/api/provision/sse/"+deviceID+"?token="+url.QueryEscape(token), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Devices are enrolled from the httptest.NewRequest address
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	req.Header.Set("Accept", "application/json")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("connect: status %d", resp.StatusCode)
+	}
+
+	stream := &sseTestStream{events: make(chan string, 16), cancel: cancel}
+	go func() {
+		defer resp.Body.Close()
+		defer close(stream.events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if event, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+				stream.events <- event
+			}
+		}
+	}()
+	return stream
+}
+
+// next returns the next event other than a heartbeat, or "" if the stream ended.
+func (s *sseTestStream) next(t *testing.T, timeout time.Duration) string {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				return ""
+			}
+			if event != SSE_EVENT_HEARTBEAT {
+				return event
+			}
+		case <-deadline:
+			t.Fatal("no event before timeout")
+			return ""
+		}
+	}
+}
+
+// connected reads the state snapshots sent on connect.
+func (s *sseTestStream) connected(t *testing.T) {
+	t.Helper()
+	for _, want := range []string{SSE_EVENT_STATUS, SSE_EVENT_ENTRIES, SSE_EVENT_CONFIG} {
+		if got := s.next(t, time.Second); got != want {
+			t.Fatalf("event on connect = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestDeviceSSE_EventsAfterRestart(t *testing.T) {
+	s := newProvisioningTestServer(t)
+	deviceID, token := s.enroll(t, 1)
+	// Closed after the stream, as Close waits for open connections
+	server := httptest.NewServer(s.router)
+	t.Cleanup(server.Close)
+
+	prev := events.Default
+	events.Default = events.NewBroker()
+	t.Cleanup(func() { events.Default = prev })
+	events.Default.Publish(deviceID, events.TypeRotate, nil)
+
+	// Last-Event-ID from the previous instance
+	stream := openSSE(t, server, deviceID, token, "100")
+	stream.connected(t)
+	if got := stream.next(t, time.Second); got != string(events.TypeRotate) {
+		t.Fatalf("replayed event = %q, want rotate", got)
+	}
+
+	events.Default.Publish(deviceID, events.TypeAccess, nil)
+	if got := stream.next(t, time.Second); got != string(events.TypeAccess) {
+		t.Errorf("event published after connecting = %q, want access", got)
+	}
+}
+
+func TestDeviceSSE_ClosedWhenRevoked(t *testing.T) {
+	s := newProvisioningTestServer(t)
+	deviceID, token := s.enroll(t, 1)
+	// Closed after the stream, as Close waits for open connections
+	server := httptest.NewServer(s.router)
+	t.Cleanup(server.Close)
+
+	stream := openSSE(t, server, deviceID, token, "")
+	stream.connected(t)
+
+	if err := s.provider.RevokeApprovedDevice(context.Background(), deviceID, 1); err != nil {
+		t.Fatalf("RevokeApprovedDevice: %v", err)
+	}
+	if got := stream.next(t, 2*SSE_STATUS_INTERVAL); got != SSE_EVENT_ENTRIES {
+		t.Errorf("event after revoking = %q, want entries", got)
+	}
+	if got := stream.next(t, 2*SSE_STATUS_INTERVAL); got != "" {
+		t.Errorf("stream still open after revoking, got %q", got)
+	}
+}
+
+func TestDeviceSSE_ClosedWhenCredentialReplaced(t *testing.T) {
+	s := newProvisioningTestServer(t)
+	deviceID, token := s.enroll(t, 1)
+	// Closed after the stream, as Close waits for open connections
+	server := httptest.NewServer(s.router)
+	t.Cleanup(server.Close)
+
+	stream := openSSE(t, server, deviceID, token, "")
+	stream.connected(t)
+
+	// Approving again issues a new credential, the current one is no longer accepted
+	if err := s.provider.UpdateDeviceStatus(context.Background(), deviceID, storage.DeviceStatusApproved, nil); err != nil {
+		t.Fatalf("UpdateDeviceStatus: %v", err)
+	}
+	if got := stream.next(t, 2*SSE_STATUS_INTERVAL); got != "" {
+		t.Errorf("stream still open after the credential was replaced, got %q", got)
+	}
+
+	if status, _ := s.do(t, httptest.NewRequest(http.MethodGet, "/api/provision/sse/"+deviceID+"?token="+url.QueryEscape(token), nil), ""); status != http.StatusUnauthorized {
+		t.Errorf("reconnect with replaced token: status %d, want 401", status)
+	}
+}
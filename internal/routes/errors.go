@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 
+	"entry-access-control/internal/access"
 	"entry-access-control/internal/jwt"
 )
 
@@ -62,15 +63,29 @@ var (
 	// Authorization errors
 	ErrForbidden               = errors.New("forbidden")
 	ErrInsufficientPermissions = errors.New("insufficient permissions")
+	ErrNotInAccessList         = errors.New("user not in access list")
+	ErrEntryNotGranted         = errors.New("access list does not grant the entry")
+	ErrCSRFTokenInvalid        = errors.New("invalid CSRF token")
+
+	// Access list file errors
+	ErrAccessListNotFileBased = errors.New("access list is not file based")
 
 	// Device provisioning errors
-	ErrDeviceIDRequired      = errors.New("device_id is required")
-	ErrDevicePendingApproval = errors.New("device pending approval")
-	ErrDeviceRejected        = errors.New("device rejected")
-	ErrDeviceStatusUnknown   = errors.New("unknown device status")
-	ErrFailedToCreateDevice  = errors.New("failed to create device")
-	ErrDeviceNotFound        = errors.New("device not found")
-	ErrClientIPMismatch      = errors.New("client IP mismatch")
+	ErrDeviceIDRequired         = errors.New("device_id is required")
+	ErrDevicePendingApproval    = errors.New("device pending approval")
+	ErrDeviceRejected           = errors.New("device rejected")
+	ErrDeviceStatusUnknown      = errors.New("unknown device status")
+	ErrFailedToCreateDevice     = errors.New("failed to create device")
+	ErrDeviceNotFound           = errors.New("device not found")
+	ErrClientIPMismatch         = errors.New("client IP mismatch")
+	ErrInvalidProvisioningToken = errors.New("invalid provisioning token")
+	ErrDeviceTokenRequired      = errors.New("device token required")
+	ErrDeviceTokenInvalid       = errors.New("invalid device token")
+	ErrDeviceNotApproved        = errors.New("device not approved")
+	ErrDeviceIPChanged          = errors.New("device IP changed")
+	ErrDeviceAlreadyEnrolled    = errors.New("device credential already issued")
+	ErrDeviceKeyMismatch        = errors.New("device enroll key mismatch")
+	ErrClientTooOld             = errors.New("client version too old")
 
 	// Validation errors
 	ErrInvalidRequest   = errors.New("invalid request")
@@ -98,27 +113,48 @@ var (
 // errorStatusMap maps errors to HTTP status codes
 var errorStatusMap = map[error]int{
 	// 400 Bad Request
-	ErrInvalidRequest:   http.StatusBadRequest,
-	ErrMissingParameter: http.StatusBadRequest,
-	ErrInvalidParameter: http.StatusBadRequest,
-	ErrDeviceIDRequired: http.StatusBadRequest,
+	ErrInvalidRequest:           http.StatusBadRequest,
+	ErrMissingParameter:         http.StatusBadRequest,
+	ErrInvalidParameter:         http.StatusBadRequest,
+	ErrDeviceIDRequired:         http.StatusBadRequest,
+	ErrInvalidProvisioningToken: http.StatusBadRequest,
+	access.ErrInvalidListPath:   http.StatusBadRequest,
 
 	// 401 Unauthorized
-	ErrUnauthorized:       http.StatusUnauthorized,
-	jwt.ErrNonValidToken:  http.StatusUnauthorized,
-	ErrTokenExpired:       http.StatusUnauthorized,
-	ErrInvalidCredentials: http.StatusUnauthorized,
-	jwt.ErrInvalidNonce:   http.StatusUnauthorized,
+	ErrUnauthorized:        http.StatusUnauthorized,
+	jwt.ErrNonValidToken:   http.StatusUnauthorized,
+	ErrTokenExpired:        http.StatusUnauthorized,
+	ErrInvalidCredentials:  http.StatusUnauthorized,
+	ErrDeviceTokenRequired: http.StatusUnauthorized,
+	ErrDeviceTokenInvalid:  http.StatusUnauthorized,
+	jwt.ErrInvalidNonce:    http.StatusUnauthorized,
 
 	// 403 Forbidden
 	ErrForbidden:               http.StatusForbidden,
 	ErrInsufficientPermissions: http.StatusForbidden,
+	ErrNotInAccessList:         http.StatusForbidden,
+	ErrEntryNotGranted:         http.StatusForbidden,
+	ErrCSRFTokenInvalid:        http.StatusForbidden,
 	ErrDeviceRejected:          http.StatusForbidden,
 	ErrClientIPMismatch:        http.StatusForbidden,
+	ErrDeviceNotApproved:       http.StatusForbidden,
+	ErrDeviceIPChanged:         http.StatusForbidden,
+	ErrDeviceKeyMismatch:       http.StatusForbidden,
 
 	// 404 Not Found
-	ErrUserNotFound:   http.StatusNotFound,
-	ErrDeviceNotFound: http.StatusNotFound,
+	ErrUserNotFound:            http.StatusNotFound,
+	ErrDeviceNotFound:          http.StatusNotFound,
+	access.ErrListFileNotFound: http.StatusNotFound,
+
+	// 409 Conflict
+	ErrAccessListNotFileBased: http.StatusConflict,
+	ErrDeviceAlreadyEnrolled:  http.StatusConflict,
+
+	// 422 Unprocessable Entity
+	access.ErrInvalidListFile: http.StatusUnprocessableEntity,
+
+	// 426 Upgrade Required
+	ErrClientTooOld: http.StatusUpgradeRequired,
 
 	// 202 Accepted (for pending operations)
 	ErrDevicePendingApproval: http.StatusAccepted,
@@ -168,6 +204,36 @@ var errorInfoMap = map[error]ErrorInfo{
 		Message:   "You don't have permission to perform this action",
 		StopCodes: []string{"INSUFFICIENT_PERMISSIONS"},
 	},
+	ErrNotInAccessList: {
+		Message:   "You are not on the access list",
+		StopCodes: []string{"NOT_IN_ACCESS_LIST"},
+	},
+	ErrEntryNotGranted: {
+		Message:   "Your access does not include this door",
+		StopCodes: []string{"ENTRY_NOT_GRANTED"},
+	},
+	ErrCSRFTokenInvalid: {
+		Message:   "The form has expired. Reload the page and try again.",
+		StopCodes: []string{"CSRF_TOKEN_INVALID"},
+	},
+
+	// Access list files
+	ErrAccessListNotFileBased: {
+		Message:   "Access list is not loaded from files",
+		StopCodes: []string{"ACCESS_LIST_NOT_FILE_BASED"},
+	},
+	access.ErrInvalidListPath: {
+		Message:   "Invalid access list file path",
+		StopCodes: []string{"ACCESS_LIST_INVALID_PATH"},
+	},
+	access.ErrListFileNotFound: {
+		Message:   "Access list file not found",
+		StopCodes: []string{"ACCESS_LIST_FILE_NOT_FOUND"},
+	},
+	access.ErrInvalidListFile: {
+		Message:   "File is not a valid access list",
+		StopCodes: []string{"ACCESS_LIST_INVALID"},
+	},
 
 	// Device provisioning
 	ErrDeviceIDRequired: {
@@ -190,6 +256,38 @@ var errorInfoMap = map[error]ErrorInfo{
 		Message:   "Request from unauthorized IP address",
 		StopCodes: []string{"IP_MISMATCH"},
 	},
+	ErrDeviceTokenRequired: {
+		Message:   "Device credentials are required",
+		StopCodes: []string{"DEVICE_TOKEN_REQUIRED"},
+	},
+	ErrDeviceTokenInvalid: {
+		Message:   "Device credentials are invalid or have expired",
+		StopCodes: []string{"DEVICE_TOKEN_INVALID"},
+	},
+	ErrDeviceNotApproved: {
+		Message:   "Device is not approved for any entry",
+		StopCodes: []string{"DEVICE_NOT_APPROVED"},
+	},
+	ErrDeviceIPChanged: {
+		Message:   "Device IP address has changed. Device must be re-approved.",
+		StopCodes: []string{"DEVICE_IP_CHANGED"},
+	},
+	ErrDeviceAlreadyEnrolled: {
+		Message:   "Device credentials have already been issued. Scan the provisioning code to approve the device again.",
+		StopCodes: []string{"DEVICE_ALREADY_ENROLLED"},
+	},
+	ErrDeviceKeyMismatch: {
+		Message:   "Device ID was registered by another device. Reset the device to register again.",
+		StopCodes: []string{"DEVICE_KEY_MISMATCH"},
+	},
+	ErrClientTooOld: {
+		Message:   "Entry device software is outdated and no longer supported. Restart the device browser, or contact support if the problem persists.",
+		StopCodes: []string{"CLIENT_TOO_OLD"},
+	},
+	ErrInvalidProvisioningToken: {
+		Message:   "Provisioning QR code is invalid or has expired. Scan the code again.",
+		StopCodes: []string{"PROVISIONING_TOKEN_INVALID"},
+	},
 
 	// Validation
 	ErrInvalidRequest: {
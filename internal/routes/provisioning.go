@@ -1,14 +1,17 @@
 package routes
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
 	. "entry-access-control/internal/config"
 	. "entry-access-control/internal/jwt"
+	"entry-access-control/internal/sas"
 	"entry-access-control/internal/storage"
 	"entry-access-control/internal/utils"
 
@@ -16,10 +19,14 @@ import (
 )
 
 type registrationResponse struct {
-	Status        string `json:"status"`
-	DeviceID      string `json:"device_id,omitempty"`
-	Message       string `json:"message"`
-	Authenticated bool   `json:"authenticated,omitempty"`
+	Status        string     `json:"status"`
+	DeviceID      string     `json:"device_id,omitempty"`
+	Message       string     `json:"message"`
+	Authenticated bool       `json:"authenticated,omitempty"`
+	Token         string     `json:"token,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	// Stop code explaining the status, e.g. DEVICE_IP_CHANGED
+	Reason string `json:"reason,omitempty"`
 }
 
 func genProvisioningJWT(deviceID string, clientIP string) (string, error) {
@@ -27,7 +34,9 @@ func genProvisioningJWT(deviceID string, clientIP string) (string, error) {
 	return GenerateJWT(claim)
 }
 
-func getProvisioning(c *gin.Context, deviceID string) (error, storage.Device) {
+// getProvisioning returns the device, creating it as pending if not found. A
+// new device is bound to keyHash, the hash of the key it registered with.
+func getProvisioning(c *gin.Context, deviceID string, keyHash *string) (error, storage.Device) {
 	if deviceID == "" {
 		return ErrDeviceIDRequired, storage.Device{}
 	}
@@ -48,9 +57,10 @@ func getProvisioning(c *gin.Context, deviceID string) (error, storage.Device) {
 
 		clientIP := c.ClientIP()
 		newDevice := storage.Device{
-			DeviceID: deviceID,
-			ClientIP: clientIP,
-			Status:   storage.DeviceStatusPending,
+			DeviceID:      deviceID,
+			ClientIP:      clientIP,
+			Status:        storage.DeviceStatusPending,
+			EnrollKeyHash: keyHash,
 		}
 
 		if err := storageProvider.CreateDevice(ctx, newDevice); err != nil {
@@ -78,6 +88,141 @@ func getProvisioning(c *gin.Context, deviceID string) (error, storage.Device) {
 	}
 }
 
+// checkEnrollKey verifies the device is the one the device ID was issued to,
+// by the key it generated and registered with. Devices registered before
+// enroll keys are bound to the first key presented.
+func checkEnrollKey(c *gin.Context, device *storage.Device, key string) error {
+	if device.EnrollKeyHash != nil {
+		if !utils.VerifyEnrollKey(key, *device.EnrollKeyHash) {
+			return ErrDeviceKeyMismatch
+		}
+		return nil
+	}
+
+	keyHash, ok := utils.HashEnrollKey(key)
+	if !ok {
+		return ErrInvalidRequest
+	}
+	err, storageProvider := GetStorageProvider(c)
+	if err != nil {
+		return err
+	}
+	set, err := storageProvider.SetDeviceEnrollKey(c.Request.Context(), device.DeviceID, keyHash)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDatabaseError, err)
+	}
+	if !set {
+		// Another request bound the device first
+		return ErrDeviceKeyMismatch
+	}
+	slog.Info("Device bound to enroll key", "device_id", device.DeviceID)
+	device.EnrollKeyHash = &keyHash
+	return nil
+}
+
+// deviceIPBinding returns the IP policy for the device. Global policy is used
+// unless the device has its own.
+func deviceIPBinding(device storage.Device) utils.IPBinding {
+	policy, _ := utils.ParseIPPolicy(Cfg.DeviceIPPolicy)
+	if device.IPPolicy != nil {
+		if p, err := utils.ParseIPPolicy(*device.IPPolicy); err == nil {
+			policy = p
+		} else {
+			slog.Warn("Invalid device IP policy, using global policy", "device_id", device.DeviceID, "error", err)
+		}
+	}
+
+	networks, err := utils.ParseCIDRs(Cfg.AllowedNetworks)
+	if err != nil {
+		slog.Warn("Invalid allowed networks for device IP policy", "error", err)
+	}
+
+	return utils.IPBinding{
+		Policy:   policy,
+		PrefixV4: Cfg.DeviceIPPrefixV4,
+		PrefixV6: Cfg.DeviceIPPrefixV6,
+		Networks: networks,
+	}
+}
+
+// checkDeviceIP applies the IP policy when an authenticated device connects
+// from a new address. The change is recorded in device history. If the policy doesn't
+// allow it, an approved device is returned to pending state and must be
+// re-approved.
+func checkDeviceIP(c *gin.Context, device *storage.Device) error {
+	clientIP := c.ClientIP()
+	if device.ClientIP == clientIP {
+		return nil
+	}
+
+	err, storageProvider := GetStorageProvider(c)
+	if err != nil {
+		return err
+	}
+
+	allowed := deviceIPBinding(*device).Allows(device.ClientIP, clientIP)
+	reapprove := !allowed && device.Status == storage.DeviceStatusApproved
+	reason := GetErrorStopCodes(ErrDeviceIPChanged)[0]
+
+	if err := storageProvider.UpdateDeviceIP(c.Request.Context(), device.DeviceID, clientIP, reapprove, reason); err != nil {
+		return fmt.Errorf("%w: %v", ErrDatabaseError, err)
+	}
+
+	if !reapprove {
+		slog.Info("Device IP changed", "device_id", device.DeviceID, "old_ip", device.ClientIP, "new_ip", clientIP)
+		device.ClientIP = clientIP
+		return nil
+	}
+
+	slog.Warn("Device IP changed, device requires re-approval", "device_id", device.DeviceID, "old_ip", device.ClientIP, "new_ip", clientIP)
+	device.ClientIP = clientIP
+	device.Status = storage.DeviceStatusPending
+	device.StatusReason = &reason
+	return ErrDeviceIPChanged
+}
+
+// getAuthorizationDevice resolves the device being authorized from the provisioning
+// token. Token is passed as the raw query string: authorize?<token>
+func getAuthorizationDevice(c *gin.Context) (*DeviceProvisionClaim, *storage.Device, error) {
+	token := c.Request.URL.RawQuery
+	if token == "" {
+		return nil, nil, ErrMissingParameter
+	}
+
+	claim, err := ParseDeviceProvisionJWT(token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidProvisioningToken, err)
+	}
+
+	if !utils.VerifyDeviceID(claim.DeviceID, []byte(Cfg.Secret)) {
+		return nil, nil, ErrDeviceIDVerificationFailed
+	}
+
+	err, storageProvider := GetStorageProvider(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	device, err := storageProvider.GetDevice(c.Request.Context(), claim.DeviceID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrDeviceNotFound, err)
+	}
+
+	// Rejected devices stay rejected, like in bulk approval from the CLI.
+	if device.Status == storage.DeviceStatusRejected {
+		slog.Warn("Authorization attempted for rejected device", "device_id", device.DeviceID)
+		return nil, nil, ErrDeviceRejected
+	}
+
+	// QR code must be shown by the device itself, not relayed from elsewhere.
+	if device.ClientIP != claim.ClientIP {
+		slog.Warn("Provisioning token IP does not match device", "device_id", device.DeviceID, "device_ip", device.ClientIP, "token_ip", claim.ClientIP)
+		return nil, nil, ErrClientIPMismatch
+	}
+
+	return claim, device, nil
+}
+
 // TODO: Implement device registration token generation
 // func genDeviceRegistrationToken(deviceID string) (string, error) {
 // 	claim := NewDeviceRegistrationClaim(deviceID)
@@ -140,6 +285,9 @@ func ProvisioningApi(r *gin.RouterGroup) {
 
 		type registrationRequest struct {
 			DeviceID string `form:"device_id" json:"device_id"`
+			// Key generated by the device. Device ID is shown in the provisioning
+			// QR code, so only the key proves the request is from the device.
+			EnrollKey string `form:"enroll_key" json:"enroll_key"`
 		}
 
 		var req registrationRequest
@@ -148,6 +296,12 @@ func ProvisioningApi(r *gin.RouterGroup) {
 			AbortWithHTTPError(c, http.StatusBadRequest, ErrInvalidRequest)
 			return
 		}
+		keyHash, ok := utils.HashEnrollKey(req.EnrollKey)
+		if !ok {
+			slog.Warn("Registration without a valid enroll key", "device_id", req.DeviceID)
+			AbortWithHTTPError(c, http.StatusBadRequest, ErrInvalidRequest)
+			return
+		}
 		if req.DeviceID != "" {
 			if !utils.VerifyDeviceID(req.DeviceID, []byte(Cfg.Secret)) {
 				slog.Warn("Existing device ID verification failed on registration", "device_id", req.DeviceID)
@@ -166,38 +320,86 @@ func ProvisioningApi(r *gin.RouterGroup) {
 		}
 
 		// Check if device is already registered, creates a new pending device if not found
-		err, provisioning := getProvisioning(c, deviceID)
-		if err != nil {
+		err, provisioning := getProvisioning(c, deviceID, &keyHash)
+		if err != nil && !errors.Is(err, ErrDevicePendingApproval) {
 			// Device is rejected
 			AbortWithError(c, err)
+			return
 		}
 
-		// Check IP match
-		clientIP := c.ClientIP()
-		if provisioning.ClientIP != clientIP {
-			slog.Warn("Client IP mismatch during device registration", "device_id", deviceID, "expected_ip", provisioning.ClientIP, "actual_ip", clientIP)
-			AbortWithError(c, ErrClientIPMismatch)
+		if err := checkEnrollKey(c, &provisioning, req.EnrollKey); err != nil {
+			slog.Warn("Registration with another enroll key", "device_id", deviceID, "client_ip", c.ClientIP(), "error", err)
+			AbortWithError(c, err)
 			return
 		}
 
+		// The IP is only changed once the device authenticates, see
+		// RequireDevice. Here a move to an address the policy doesn't allow
+		// is only reported.
+		clientIP := c.ClientIP()
+		ipChanged := provisioning.ClientIP != clientIP && !deviceIPBinding(provisioning).Allows(provisioning.ClientIP, clientIP)
+		if ipChanged {
+			slog.Warn("Device registering from a new IP", "device_id", deviceID, "device_ip", provisioning.ClientIP, "client_ip", clientIP)
+		}
+
+		// Approved devices are seen when they authenticate
+		if provisioning.Status == storage.DeviceStatusPending {
+			if err, storageProvider := GetStorageProvider(c); err == nil {
+				touchDevice(c.Request.Context(), storageProvider, &provisioning)
+			}
+		}
+
 		// Check if device is approved
 		switch provisioning.Status {
 		case storage.DeviceStatusApproved:
-			// TODO: Check authentication status
+			if ipChanged {
+				AbortWithError(c, ErrDeviceIPChanged)
+				return
+			}
+			// Credential is issued once after approval, after that it's renewed
+			token, err := genDeviceToken(c, deviceID, clientIP, "")
+			if errors.Is(err, ErrDeviceAlreadyEnrolled) {
+				slog.Warn("Device credential requested again", "device_id", deviceID, "client_ip", clientIP)
+				info := GetErrorInfo(ErrDeviceAlreadyEnrolled)
+				c.JSON(http.StatusOK, registrationResponse{
+					Status:   "approved",
+					DeviceID: deviceID,
+					Message:  info.Message,
+					Reason:   info.StopCodes[0],
+				})
+				return
+			}
+			if err != nil {
+				slog.Error("Failed to issue device token", "device_id", deviceID, "error", err)
+				AbortWithError(c, err)
+				return
+			}
+			slog.Info("Device enrolled", "device_id", deviceID, "expires_at", token.ExpiresAt)
 			c.JSON(http.StatusOK, registrationResponse{
 				Status:        "approved",
-				Authenticated: false,
+				Authenticated: true,
 				DeviceID:      deviceID,
-				Message:       "Device is already approved",
+				Message:       "Device is approved",
+				Token:         token.Token,
+				ExpiresAt:     &token.ExpiresAt,
 			})
 			return
 		case storage.DeviceStatusPending:
 			slog.Info("Device registration pending approval", "device_id", deviceID)
-			c.JSON(http.StatusAccepted, registrationResponse{
+			response := registrationResponse{
 				Status:   "pending",
 				DeviceID: deviceID,
 				Message:  "Device registration is pending approval",
-			})
+			}
+			if reason := provisioning.StatusReason; reason != nil {
+				response.Reason = *reason
+			} else if ipChanged {
+				response.Reason = GetErrorStopCodes(ErrDeviceIPChanged)[0]
+			}
+			if info := GetErrorInfo(ErrDeviceIPChanged); response.Reason == info.StopCodes[0] {
+				response.Message = info.Message
+			}
+			c.JSON(http.StatusAccepted, response)
 			return
 		case storage.DeviceStatusRejected:
 			slog.Warn("Device registration attempt for rejected device", "device_id", deviceID)
@@ -210,9 +412,152 @@ func ProvisioningApi(r *gin.RouterGroup) {
 		}
 	})
 
-	r.GET("/sse/:device_id", func(c *gin.Context) {
-		// Not implemented yet
-		AbortWithHTTPError(c, http.StatusNotImplemented, fmt.Errorf("SSE endpoint not implemented yet"))
-		return
+	// Renew device credentials before they expire. The old token is accepted
+	// for storage.DeviceCredentialGrace, so a renewal whose response is lost
+	// can be retried with it.
+	r.POST("/renew", RequireDevice(), func(c *gin.Context) {
+		claim, err := GetDeviceClaim(c)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		token, err := genDeviceToken(c, claim.DeviceID, c.ClientIP(), claim.ID)
+		if err != nil {
+			slog.Error("Failed to renew device token", "device_id", claim.DeviceID, "error", err)
+			AbortWithError(c, err)
+			return
+		}
+
+		slog.Info("Device token renewed", "device_id", claim.DeviceID, "expires_at", token.ExpiresAt)
+		c.JSON(http.StatusOK, token)
 	})
+
+	// Device authorization page, opened by scanning the provisioning QR code
+	authorizeGuard := []gin.HandlerFunc{LoadUser(), RequireAuth(), RequireEntryPermission("provisioning", "write")}
+
+	renderAuthorize := func(c *gin.Context, status int, device *storage.Device, data gin.H) {
+		err, storageProvider := GetStorageProvider(c)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		entries, err := storageProvider.ListEntries(c.Request.Context())
+		if err != nil {
+			slog.Error("Failed to list entries", "error", err)
+			AbortWithError(c, fmt.Errorf("%w: %v", ErrDatabaseError, err))
+			return
+		}
+		// Only entries the user may provision devices for
+		entries = slices.DeleteFunc(entries, func(e storage.Entry) bool {
+			return !CanEntry(c, e.ID, "provisioning", "write")
+		})
+
+		emojis, err := sas.Emojis(device.DeviceID)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		data["DeviceID"] = device.DeviceID
+		data["SAS"] = emojis
+		data["ClientIP"] = device.ClientIP
+		data["Status"] = device.Status
+		data["CreatedAt"] = device.CreatedAt.Format("2006-01-02 15:04:05")
+		data["Entries"] = entries
+		data["UserID"] = c.GetString("userID")
+		if data["CSRFToken"], err = csrfToken(c, "authorize:"+device.DeviceID); err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		HTML(c, status, "provisioning_authorize.html.tmpl", data)
+	}
+
+	r.GET("/authorize", append(authorizeGuard, func(c *gin.Context) {
+		_, device, err := getAuthorizationDevice(c)
+		if err != nil {
+			slog.Warn("Device authorization failed", "error", err)
+			AbortWithError(c, err)
+			return
+		}
+
+		renderAuthorize(c, http.StatusOK, device, gin.H{})
+	})...)
+
+	r.POST("/authorize", append(authorizeGuard, func(c *gin.Context) {
+		claim, device, err := getAuthorizationDevice(c)
+		if err != nil {
+			slog.Warn("Device authorization failed", "error", err)
+			AbortWithError(c, err)
+			return
+		}
+		// Approvals must come from the form rendered for the session
+		if err := verifyCSRFToken(c, "authorize:"+device.DeviceID); err != nil {
+			slog.Warn("Device authorization with invalid CSRF token", "device_id", device.DeviceID, "userID", c.GetString("userID"))
+			renderAuthorize(c, http.StatusForbidden, device, gin.H{"Error": GetErrorInfo(err).Message})
+			return
+		}
+
+		entryID, err := strconv.ParseInt(c.PostForm("entry_id"), 10, 64)
+		if err != nil {
+			renderAuthorize(c, http.StatusBadRequest, device, gin.H{"Error": "Select an entryway for the device."})
+			return
+		}
+
+		err, storageProvider := GetStorageProvider(c)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		ctx := c.Request.Context()
+
+		entries, err := storageProvider.ListEntries(ctx)
+		if err != nil {
+			AbortWithError(c, fmt.Errorf("%w: %v", ErrDatabaseError, err))
+			return
+		}
+		var entry *storage.Entry
+		for i := range entries {
+			if entries[i].ID == entryID {
+				entry = &entries[i]
+				break
+			}
+		}
+		if entry == nil {
+			renderAuthorize(c, http.StatusBadRequest, device, gin.H{"Error": "Selected entryway does not exist."})
+			return
+		}
+		if !CanEntry(c, entry.ID, "provisioning", "write") {
+			slog.Warn("Permission denied for entry", "userID", c.GetString("userID"), "entry_id", entry.ID, "resource", "provisioning")
+			renderAuthorize(c, http.StatusForbidden, device, gin.H{"Error": "You can't provision devices for the selected entryway."})
+			return
+		}
+
+		// Token can be used only once
+		if err := ConsumeClaimNonce(&claim.RegisteredClaims); err != nil {
+			slog.Warn("Provisioning token already used", "device_id", device.DeviceID, "error", err)
+			AbortWithError(c, fmt.Errorf("%w: %v", ErrInvalidProvisioningToken, err))
+			return
+		}
+
+		approver := c.GetString("userID")
+		if err := storageProvider.ApproveDevice(ctx, device.DeviceID, entry.ID, approver); err != nil {
+			slog.Error("Failed to approve device", "device_id", device.DeviceID, "entry_id", entry.ID, "error", err)
+			AbortWithError(c, fmt.Errorf("%w: %v", ErrDatabaseError, err))
+			return
+		}
+		slog.Info("Device approved", "device_id", device.DeviceID, "entry_id", entry.ID, "approved_by", approver)
+
+		device.Status = storage.DeviceStatusApproved
+		renderAuthorize(c, http.StatusOK, device, gin.H{
+			"Approved":  true,
+			"EntryName": entry.Name,
+		})
+	})...)
+
+	// Server-sent events channel for the device
+	r.GET("/sse/:device_id", RequireDeviceStream(), DeviceSSE)
+
+	// Kiosk display settings for the device
+	r.GET("/config/:device_id", RequireDevice(), DeviceConfig)
 }
@@ -0,0 +1,288 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"entry-access-control/internal/access"
+	"entry-access-control/internal/config"
+	"entry-access-control/internal/jwt"
+	"entry-access-control/internal/nonce"
+	"entry-access-control/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testEnrollKey = strings.Repeat("ab", 32)
+
+type provisioningTestServer struct {
+	router   *gin.Engine
+	provider storage.Provider
+}
+
+// newProvisioningTestServer serves the provisioning, device entry and email
+// login APIs on a fresh database with entries Lab (1) and Library (2).
+// student@example.com is granted entry 1 in the access list, and
+// admin@example.com can provision devices. Pages render only their form fields.
+func newProvisioningTestServer(t *testing.T) *provisioningTestServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+
+	prev := config.Cfg
+	config.Cfg = &config.Config{Secret: "test-secret", TokenTTL: 3600, UserAuthTTL: 1}
+	t.Cleanup(func() { config.Cfg = prev })
+
+	provider := storage.NewProvider(&config.Storage{
+		SQLite: &config.SQLLiteStorage{Path: filepath.Join(dir, "test.db")},
+	})
+	if provider == nil {
+		t.Fatal("NewProvider failed")
+	}
+	t.Cleanup(func() { provider.Close() })
+	prevStore := nonce.Store
+	nonce.Store = nonce.NewMemoryStore()
+	t.Cleanup(func() { nonce.Store = prevStore })
+	for _, name := range []string{"Lab", "Library"} {
+		if err := provider.CreateEntry(context.Background(), storage.Entry{Name: name}); err != nil {
+			t.Fatalf("CreateEntry: %v", err)
+		}
+	}
+
+	folder := filepath.Join(dir, "access_lists")
+	writeTestFile(t, filepath.Join(folder, "lab.csv"), testAccessListHeader+"student@example.com\tActive - Attending\n")
+	writeTestFile(t, filepath.Join(folder, "lab.csv.meta.yaml"), "entries: [1]\n")
+	list := access.NewAccessList("csv", &config.Config{AccessListFolder: folder})
+
+	policy := filepath.Join(dir, "rbac.yaml")
+	writeTestFile(t, policy, testAccessListPolicy)
+	rbac := access.GetRBAC()
+	if err := rbac.LoadPolicy(policy); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+
+	r := gin.New()
+	templates := template.Must(template.New("error.html.tmpl").Parse(`{{.error}}`))
+	template.Must(templates.New("provisioning_authorize.html.tmpl").Parse(`{{.CSRFToken}}`))
+	r.SetHTMLTemplate(templates)
+	r.Use(func(c *gin.Context) {
+		c.Set("Storage", provider)
+		c.Set("RBAC", rbac)
+		c.Set("AccessList", list)
+		c.Set("BaseURL", "http://localhost")
+		c.Next()
+	}, ErrorHandler())
+	ProvisioningApi(r.Group("/api/provision"))
+	EntryRoute(r.Group("/entry"))
+	EmailLoginRoute(r.Group("/auth/email"))
+	return &provisioningTestServer{router: r, provider: provider}
+}
+
+// do sends the request with the device token, if any, and decodes the JSON
+// response.
+func (s *provisioningTestServer) do(t *testing.T, req *http.Request, token string) (int, map[string]any) {
+	t.Helper()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+
+	var resp map[string]any
+	if w.Body.Len() > 0 {
+		json.Unmarshal(w.Body.Bytes(), &resp)
+	}
+	return w.Code, resp
+}
+
+func (s *provisioningTestServer) register(t *testing.T, deviceID string) (int, map[string]any) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"device_id": deviceID, "enroll_key": testEnrollKey})
+	req := httptest.NewRequest(http.MethodPost, "/api/provision/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return s.do(t, req, "")
+}
+
+func (s *provisioningTestServer) renew(t *testing.T, token string) (int, map[string]any) {
+	t.Helper()
+	return s.do(t, httptest.NewRequest(http.MethodPost, "/api/provision/renew", nil), token)
+}
+
+// enroll registers a new device, approves it for the entry, and returns the
+// device ID and the credential issued on the next registration.
+func (s *provisioningTestServer) enroll(t *testing.T, entryID int64) (string, string) {
+	t.Helper()
+	status, resp := s.register(t, "")
+	if status != http.StatusAccepted || resp["status"] != "pending" {
+		t.Fatalf("register new device: status %d %v", status, resp)
+	}
+	deviceID := resp["device_id"].(string)
+
+	if err := s.provider.ApproveDevice(context.Background(), deviceID, entryID, "admin@example.com"); err != nil {
+		t.Fatalf("ApproveDevice: %v", err)
+	}
+	status, resp = s.register(t, deviceID)
+	token, _ := resp["token"].(string)
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("register approved device: status %d %v", status, resp)
+	}
+	return deviceID, token
+}
+
+func TestProvisioningApi_Register(t *testing.T) {
+	s := newProvisioningTestServer(t)
+	deviceID, _ := s.enroll(t, 1)
+
+	// The credential is issued once per approval
+	status, resp := s.register(t, deviceID)
+	if status != http.StatusOK || resp["token"] != nil || resp["reason"] != "DEVICE_ALREADY_ENROLLED" {
+		t.Errorf("register enrolled device: status %d %v", status, resp)
+	}
+
+	body, _ := json.Marshal(map[string]string{"device_id": deviceID, "enroll_key": strings.Repeat("cd", 32)})
+	req := httptest.NewRequest(http.MethodPost, "/api/provision/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if status, resp := s.do(t, req, ""); status != http.StatusForbidden {
+		t.Errorf("register with another enroll key: status %d %v", status, resp)
+	}
+}
+
+func TestProvisioningApi_Renew(t *testing.T) {
+	s := newProvisioningTestServer(t)
+	_, first := s.enroll(t, 1)
+
+	status, resp := s.renew(t, first)
+	if status != http.StatusOK {
+		t.Fatalf("renew: status %d %v", status, resp)
+	}
+	lost := resp["token"].(string)
+
+	// The response was lost, renewing again with the old token replaces it
+	status, resp = s.renew(t, first)
+	if status != http.StatusOK {
+		t.Fatalf("retry renewal: status %d %v", status, resp)
+	}
+	second := resp["token"].(string)
+	if status, _ := s.renew(t, lost); status != http.StatusUnauthorized {
+		t.Errorf("renew with replaced token: status %d, want 401", status)
+	}
+
+	if status, resp := s.renew(t, second); status != http.StatusOK {
+		t.Fatalf("renew with new token: status %d %v", status, resp)
+	}
+	// Only the last replaced token is accepted
+	if status, _ := s.renew(t, first); status != http.StatusUnauthorized {
+		t.Errorf("renew with token replaced twice: status %d, want 401", status)
+	}
+
+	if status, _ := s.renew(t, "invalid"); status != http.StatusUnauthorized {
+		t.Errorf("renew with invalid token: status %d, want 401", status)
+	}
+}
+
+func TestProvisioningApi_ApproveAnotherEntry(t *testing.T) {
+	s := newProvisioningTestServer(t)
+	deviceID, token := s.enroll(t, 1)
+
+	if err := s.provider.ApproveDevice(context.Background(), deviceID, 2, "admin@example.com"); err != nil {
+		t.Fatalf("ApproveDevice: %v", err)
+	}
+
+	// The credential stays valid, and the entry is added on renewal
+	status, resp := s.renew(t, token)
+	if status != http.StatusOK {
+		t.Fatalf("renew after approving another entry: status %d %v", status, resp)
+	}
+	claim, err := jwt.DecodeDeviceJWT(resp["token"].(string))
+	if err != nil {
+		t.Fatalf("DecodeDeviceJWT: %v", err)
+	}
+	slices.Sort(claim.EntryIDs)
+	if !slices.Equal(claim.EntryIDs, []int64{1, 2}) {
+		t.Errorf("renewed entries = %v, want [1 2]", claim.EntryIDs)
+	}
+
+	// No new credential is issued on registering
+	status, resp = s.register(t, deviceID)
+	if status != http.StatusOK || resp["token"] != nil {
+		t.Errorf("register after approving another entry: status %d %v", status, resp)
+	}
+}
+
+// authorize sends the device authorization page request as the user, and
+// returns the status and the rendered CSRF token.
+func (s *provisioningTestServer) authorize(t *testing.T, req *http.Request, session string) (int, string) {
+	t.Helper()
+	req.AddCookie(&http.Cookie{Name: AUTH_COOKIE_NAME, Value: session})
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func TestProvisioningApi_Authorize(t *testing.T) {
+	s := newProvisioningTestServer(t)
+	status, resp := s.register(t, "")
+	if status != http.StatusAccepted {
+		t.Fatalf("register new device: status %d %v", status, resp)
+	}
+	deviceID := resp["device_id"].(string)
+
+	status, resp = s.do(t, httptest.NewRequest(http.MethodGet, "/api/provision/qr.json?device_id="+url.QueryEscape(deviceID), nil), "")
+	if status != http.StatusOK {
+		t.Fatalf("qr.json: status %d %v", status, resp)
+	}
+	provisioningURL, err := url.Parse(resp["url"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizeURL := "/api/provision/authorize?" + provisioningURL.RawQuery
+
+	login := func() string {
+		token, err := jwt.GenerateJWT(jwt.NewAuthClaims("admin@example.com", 3600))
+		if err != nil {
+			t.Fatalf("GenerateJWT: %v", err)
+		}
+		return token
+	}
+	session, other := login(), login()
+
+	status, csrf := s.authorize(t, httptest.NewRequest(http.MethodGet, authorizeURL, nil), session)
+	if status != http.StatusOK || csrf == "" {
+		t.Fatalf("authorization page: status %d %q", status, csrf)
+	}
+	_, otherCSRF := s.authorize(t, httptest.NewRequest(http.MethodGet, authorizeURL, nil), other)
+
+	approve := func(csrf string) int {
+		form := url.Values{"entry_id": {"1"}, CSRF_FIELD: {csrf}}
+		req := httptest.NewRequest(http.MethodPost, authorizeURL, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		status, _ := s.authorize(t, req, session)
+		return status
+	}
+	// Forged posts don't have the token of the session
+	for name, token := range map[string]string{"without a CSRF token": "", "with the token of another session": otherCSRF} {
+		if status := approve(token); status != http.StatusForbidden {
+			t.Errorf("approve %s: status %d, want 403", name, status)
+		}
+	}
+	if device, err := s.provider.GetDevice(context.Background(), deviceID); err != nil || device.Status != storage.DeviceStatusPending {
+		t.Fatalf("device after forged approvals = %+v, %v; want pending", device, err)
+	}
+
+	if status := approve(csrf); status != http.StatusOK {
+		t.Fatalf("approve: status %d", status)
+	}
+	if device, err := s.provider.GetDevice(context.Background(), deviceID); err != nil || device.Status != storage.DeviceStatusApproved {
+		t.Errorf("device after approval = %+v, %v; want approved", device, err)
+	}
+}
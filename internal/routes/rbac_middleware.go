@@ -1,16 +1,57 @@
 package routes
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 
 	"entry-access-control/internal/access"
+	"entry-access-control/internal/storage"
 
 	"github.com/gin-gonic/gin"
 )
 
 // RequirePermission creates middleware that checks for specific permission.
+// Denies scoped to entries are not seen by it, so routes acting on entries
+// check them with CanEntry.
 func RequirePermission(resource, action string, opts ...map[string]interface{}) gin.HandlerFunc {
+	return requirePermission(resource, action, func(c *gin.Context, rbac *access.RBAC, userID string) (bool, error) {
+		return rbac.Can(userID, resource, action), nil
+	})
+}
+
+// RequireEntryPermission creates middleware that checks the user has the
+// permission on the resource of any entry, e.g. "entry:42:provisioning". The
+// route must check the entry acted on, see CanEntry.
+func RequireEntryPermission(resource, action string) gin.HandlerFunc {
+	return requirePermission(resource, action, func(c *gin.Context, rbac *access.RBAC, userID string) (bool, error) {
+		if rbac.Can(userID, resource, action) {
+			return true, nil
+		}
+		err, storageProvider := GetStorageProvider(c)
+		if err != nil {
+			return false, err
+		}
+		entries, err := storageProvider.ListEntries(c.Request.Context())
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrDatabaseError, err)
+		}
+		return slices.ContainsFunc(entries, func(e storage.Entry) bool {
+			return CanEntry(c, e.ID, resource, action)
+		}), nil
+	})
+}
+
+// CanEntry checks if the user can perform the action on the resource of the entry.
+func CanEntry(c *gin.Context, entryID int64, resource, action string) bool {
+	userID, _ := GetUser(c)
+	rbac := c.MustGet("RBAC").(*access.RBAC)
+	return rbac.Can(userID, access.EntryResource(strconv.FormatInt(entryID, 10), resource), action)
+}
+
+func requirePermission(resource, action string, can func(c *gin.Context, rbac *access.RBAC, userID string) (bool, error)) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		userID, err := GetUser(c)
@@ -19,8 +60,13 @@ func RequirePermission(resource, action string, opts ...map[string]interface{})
 			return
 		}
 
-		rbac := c.MustGet("rbac").(*access.RBAC)
-		if !rbac.Can(userID, resource, action) {
+		rbac := c.MustGet("RBAC").(*access.RBAC)
+		allowed, err := can(c, rbac, userID)
+		if err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		if !allowed {
 			slog.Warn("Permission denied",
 				"userID", userID,
 				"resource", resource,
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"encoding/json"
+	. "entry-access-control/internal/config"
 	"entry-access-control/internal/utils"
 	"log/slog"
 	"net/http"
@@ -9,7 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-const LOGIN_URL = "/auth/login"
+const LOGIN_URL = "/auth/email/login"
 
 // Merge into existing gin.H
 func H(c *gin.Context, data any) gin.H {
@@ -36,7 +37,7 @@ func H(c *gin.Context, data any) gin.H {
 
 	// Add common data
 	h["BaseURL"] = c.MustGet("BaseURL").(string)
-	h["AppVersion"] = "v0.1.0" // TODO: set app version
+	h["AppVersion"] = utils.GetVersion()
 	return h
 }
 
@@ -46,7 +47,7 @@ func loginUrl(c *gin.Context) string {
 		return ""
 	}
 
-	return utils.UrlFor(c, "/auth/login", gin.H{"next": c.Request.URL.RequestURI()})
+	return utils.UrlFor(c, LOGIN_URL, gin.H{"next": c.Request.URL.RequestURI()})
 }
 
 // Returns a HTML response with merged data
@@ -57,3 +58,14 @@ func HTML(c *gin.Context, code int, name string, data any) {
 	data = H(c, data)
 	c.HTML(code, name, data)
 }
+
+// Configuration shared with the client-side application
+func ClientConfig(c *gin.Context) gin.H {
+	return gin.H{
+		"TokenTTL":        Cfg.TokenTTL,
+		"TokenExpirySkew": Cfg.TokenExpirySkew,
+		"SupportURL":      Cfg.SupportURL,
+		"AppVersion":      utils.GetVersion(),
+		"SupportQRURL":    utils.UrlFor(c, "dist/assets/support_qr.png"),
+	}
+}
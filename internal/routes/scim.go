@@ -0,0 +1,784 @@
+package routes
+
+// SCIM 2.0 provisioning API for the user directory. Identity providers
+// authenticate with the SCIM_TOKEN bearer token. Deleted users are deactivated
+// and hidden, so the history of their entries is kept.
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"entry-access-control/internal/access"
+	. "entry-access-control/internal/config"
+	"entry-access-control/internal/scim"
+	"entry-access-control/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Most resources returned by a single query
+const SCIM_MAX_RESULTS = 1000
+
+type scimAPI struct {
+	// Route prefix, e.g. /scim/v2
+	prefix string
+	logger *slog.Logger
+}
+
+func SCIMRoutes(r *gin.RouterGroup) {
+	api := &scimAPI{
+		prefix: r.BasePath(),
+		logger: slog.Default().WithGroup("scim"),
+	}
+
+	r.Use(scimAuth(Cfg.SCIM.Token))
+
+	r.GET("/ServiceProviderConfig", api.serviceProviderConfig)
+	r.GET("/ResourceTypes", api.resourceTypes)
+
+	users := r.Group("/Users")
+	users.GET("", api.listUsers)
+	users.POST("", api.createUser)
+	users.GET("/:id", api.getUser)
+	users.PUT("/:id", api.replaceUser)
+	users.PATCH("/:id", api.patchUser)
+	users.DELETE("/:id", api.deleteUser)
+
+	groups := r.Group("/Groups")
+	groups.GET("", api.listGroups)
+	groups.POST("", api.createGroup)
+	groups.GET("/:id", api.getGroup)
+	groups.PUT("/:id", api.replaceGroup)
+	groups.PATCH("/:id", api.patchGroup)
+	groups.DELETE("/:id", api.deleteGroup)
+}
+
+// scimAuth checks the bearer token of the identity provider.
+func scimAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth := c.GetHeader("Authorization")
+		bearer, found := strings.CutPrefix(auth, "Bearer ")
+		if token == "" || !found || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(bearer)), []byte(token)) != 1 {
+			slog.Warn("SCIM request with invalid token", "ip", c.ClientIP(), "path", c.Request.URL.Path)
+			c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+			scimError(c, scim.NewError(http.StatusUnauthorized, "", "invalid bearer token"))
+			return
+		}
+		c.Next()
+	}
+}
+
+func scimJSON(c *gin.Context, status int, body any) {
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(status, body)
+}
+
+func scimError(c *gin.Context, err error) {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		if errors.Is(err, storage.ErrNotFound) {
+			scimErr = scim.NewError(http.StatusNotFound, "", "resource %s not found", c.Param("id"))
+		} else {
+			slog.Error("SCIM request failed", "error", err, "path", c.Request.URL.Path, "method", c.Request.Method)
+			scimErr = scim.NewError(http.StatusInternalServerError, "", "internal error")
+		}
+	}
+	c.Header("Content-Type", scim.ContentType)
+	c.AbortWithStatusJSON(scimErr.StatusCode(), scimErr)
+}
+
+// invalidateAccessList tells the access list the directory has changed.
+func invalidateAccessList(c *gin.Context) {
+	if v, ok := c.Get("AccessList"); ok {
+		if inv, ok := v.(access.Invalidator); ok {
+			inv.Invalidate()
+		}
+	}
+}
+
+func (api *scimAPI) location(c *gin.Context, resourceType, id string) string {
+	return strings.TrimSuffix(c.GetString("BaseURL"), "/") + api.prefix + "/" + resourceType + "/" + id
+}
+
+func (api *scimAPI) meta(c *gin.Context, resourceType, id string, created, modified time.Time) map[string]any {
+	return map[string]any{
+		"resourceType": strings.TrimSuffix(resourceType, "s"),
+		"created":      created.UTC().Format(time.RFC3339),
+		"lastModified": modified.UTC().Format(time.RFC3339),
+		"location":     api.location(c, resourceType, id),
+		"version":      fmt.Sprintf(`W/"%d"`, modified.UnixNano()),
+	}
+}
+
+func (api *scimAPI) serviceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":          []string{scim.SchemaServiceProviderConfig},
+		"documentationUri": DEFAULT_SUPPORT_URL,
+		"patch":            gin.H{"supported": true},
+		"bulk":             gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           gin.H{"supported": true, "maxResults": SCIM_MAX_RESULTS},
+		"changePassword":   gin.H{"supported": false},
+		"sort":             gin.H{"supported": false},
+		"etag":             gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Token set in SCIM_TOKEN",
+			"primary":     true,
+		}},
+	})
+}
+
+func (api *scimAPI) resourceTypes(c *gin.Context) {
+	types := []any{
+		gin.H{
+			"schemas":  []string{scim.SchemaResourceType},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   scim.SchemaUser,
+		},
+		gin.H{
+			"schemas":  []string{scim.SchemaResourceType},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   scim.SchemaGroup,
+		},
+	}
+	scimJSON(c, http.StatusOK, scim.NewListResponse(types, 1, len(types)))
+}
+
+// --- Queries ---
+
+// query filters and pages resources by the filter, startIndex and count parameters,
+// and drops attributes listed in excludedAttributes.
+func query(c *gin.Context, resources []map[string]any) {
+	var filter scim.Filter
+	if s := c.Query("filter"); s != "" {
+		f, err := scim.ParseFilter(s)
+		if err != nil {
+			scimError(c, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, "%v", err))
+			return
+		}
+		filter = f
+	}
+
+	startIndex, count := 1, SCIM_MAX_RESULTS
+	if s := c.Query("startIndex"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil {
+			startIndex = n
+		}
+	}
+	if s := c.Query("count"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil {
+			count = min(n, SCIM_MAX_RESULTS)
+		}
+	}
+
+	var matched []any
+	for _, r := range resources {
+		if filter == nil || filter.Match(r) {
+			matched = append(matched, excludeAttributes(r, c.Query("excludedAttributes")))
+		}
+	}
+	scimJSON(c, http.StatusOK, scim.NewListResponse(matched, startIndex, count))
+}
+
+// excludeAttributes drops the comma separated attributes. id and schemas are always returned.
+func excludeAttributes(resource map[string]any, excluded string) map[string]any {
+	for _, name := range strings.Split(excluded, ",") {
+		path, err := scim.ParseAttrPath(strings.TrimSpace(name))
+		if err != nil || path.SubAttr != "" || path.Attr == "id" || path.Attr == "schemas" {
+			continue
+		}
+		for k := range resource {
+			if strings.EqualFold(k, path.Attr) {
+				delete(resource, k)
+			}
+		}
+	}
+	return resource
+}
+
+// readResource decodes the request body.
+func readResource(c *gin.Context) (map[string]any, error) {
+	var resource map[string]any
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "invalid JSON: %v", err)
+	}
+	return resource, nil
+}
+
+func readPatch(c *gin.Context) (scim.PatchRequest, error) {
+	var req scim.PatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "invalid JSON: %v", err)
+	}
+	if !slices.Contains(req.Schemas, scim.SchemaPatchOp) {
+		return req, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "missing schema %s", scim.SchemaPatchOp)
+	}
+	return req, nil
+}
+
+func stringAttr(resource map[string]any, name string) string {
+	v, _ := scim.Get(resource, name)
+	s, _ := v.(string)
+	return strings.TrimSpace(s)
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// --- Users ---
+
+func (api *scimAPI) userResource(c *gin.Context, u storage.DirectoryUser, groups []storage.DirectoryGroup) map[string]any {
+	r := map[string]any{
+		"schemas":  []any{scim.SchemaUser},
+		"id":       u.ID,
+		"userName": u.UserName,
+		"active":   u.Active,
+		"meta":     api.meta(c, "Users", u.ID, u.CreatedAt, u.UpdatedAt),
+	}
+	if u.ExternalID != nil {
+		r["externalId"] = *u.ExternalID
+	}
+	if u.DisplayName != "" {
+		r["displayName"] = u.DisplayName
+	}
+	if u.Email != "" {
+		r["emails"] = []any{map[string]any{"value": u.Email, "type": "work", "primary": true}}
+	}
+	memberOf := []any{}
+	for _, g := range groups {
+		memberOf = append(memberOf, map[string]any{
+			"value":   g.ID,
+			"display": g.DisplayName,
+			"$ref":    api.location(c, "Groups", g.ID),
+		})
+	}
+	r["groups"] = memberOf
+	return r
+}
+
+// parseUser reads the stored attributes of a user resource. Groups are read only.
+func parseUser(resource map[string]any) (storage.DirectoryUser, error) {
+	user := storage.DirectoryUser{
+		UserName:    stringAttr(resource, "userName"),
+		ExternalID:  optionalString(stringAttr(resource, "externalId")),
+		DisplayName: stringAttr(resource, "displayName"),
+		Active:      true,
+	}
+	if user.UserName == "" {
+		return user, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "userName is required")
+	}
+
+	if v, ok := scim.Get(resource, "active"); ok && v != nil {
+		switch v := v.(type) {
+		case bool:
+			user.Active = v
+		case string:
+			// Some providers send "True" and "False"
+			active, err := strconv.ParseBool(v)
+			if err != nil {
+				return user, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "invalid active %q", v)
+			}
+			user.Active = active
+		default:
+			return user, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "invalid active %v", v)
+		}
+	}
+
+	if user.DisplayName == "" {
+		if name, ok := scim.Get(resource, "name"); ok {
+			if name, ok := name.(map[string]any); ok {
+				user.DisplayName = stringAttr(name, "formatted")
+				if user.DisplayName == "" {
+					user.DisplayName = strings.TrimSpace(stringAttr(name, "givenName") + " " + stringAttr(name, "familyName"))
+				}
+			}
+		}
+	}
+
+	// Primary email, or the first one, or the user name if it is an email
+	emails, _ := scim.Get(resource, "emails")
+	list, _ := emails.([]any)
+	for _, e := range list {
+		m, ok := e.(map[string]any)
+		if !ok {
+			continue
+		}
+		value := stringAttr(m, "value")
+		if primary, _ := scim.Get(m, "primary"); primary == true || user.Email == "" {
+			user.Email = value
+		}
+	}
+	if user.Email == "" && strings.Contains(user.UserName, "@") {
+		user.Email = user.UserName
+	}
+	user.Email = access.NormalizeEmail(user.Email)
+
+	return user, nil
+}
+
+// userGroups maps user IDs to their groups.
+func userGroups(c *gin.Context, provider storage.Provider) (map[string][]storage.DirectoryGroup, error) {
+	ctx := c.Request.Context()
+	groups, err := provider.ListDirectoryGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := provider.ListDirectoryMemberships(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]storage.DirectoryGroup, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+	}
+	userGroups := make(map[string][]storage.DirectoryGroup)
+	for _, m := range memberships {
+		if g, ok := byID[m.GroupID]; ok {
+			userGroups[m.UserID] = append(userGroups[m.UserID], g)
+		}
+	}
+	return userGroups, nil
+}
+
+// groupMembers maps group IDs to their members. Only the ID and user name of the members are set.
+func groupMembers(c *gin.Context, provider storage.Provider) (map[string][]storage.DirectoryUser, error) {
+	memberships, err := provider.ListDirectoryMemberships(c.Request.Context())
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string][]storage.DirectoryUser)
+	for _, m := range memberships {
+		members[m.GroupID] = append(members[m.GroupID], storage.DirectoryUser{ID: m.UserID, UserName: m.UserName})
+	}
+	return members, nil
+}
+
+// checkUserConflict returns a uniqueness error if another user has the user name or email.
+func checkUserConflict(c *gin.Context, provider storage.Provider, user storage.DirectoryUser) error {
+	for _, key := range []string{user.UserName, user.Email} {
+		if key == "" {
+			continue
+		}
+		existing, err := provider.FindDirectoryUser(c.Request.Context(), key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if existing.ID != user.ID {
+			return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "user %s already exists", key)
+		}
+	}
+	return nil
+}
+
+func (api *scimAPI) sendUser(c *gin.Context, provider storage.Provider, status int, id string) {
+	ctx := c.Request.Context()
+	user, err := provider.GetDirectoryUser(ctx, id)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	groups, err := provider.ListDirectoryUserGroups(ctx, id)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	resource := api.userResource(c, *user, groups)
+	if status == http.StatusCreated {
+		c.Header("Location", api.location(c, "Users", id))
+	}
+	scimJSON(c, status, excludeAttributes(resource, c.Query("excludedAttributes")))
+}
+
+func (api *scimAPI) listUsers(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	users, err := provider.ListDirectoryUsers(c.Request.Context())
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	memberships, err := userGroups(c, provider)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	resources := make([]map[string]any, len(users))
+	for i, u := range users {
+		resources[i] = api.userResource(c, u, memberships[u.ID])
+	}
+	query(c, resources)
+}
+
+func (api *scimAPI) getUser(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	api.sendUser(c, provider, http.StatusOK, c.Param("id"))
+}
+
+func (api *scimAPI) createUser(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	resource, err := readResource(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	user, err := parseUser(resource)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	user.ID = uuid.NewString()
+	if err := checkUserConflict(c, provider, user); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	if err := provider.CreateDirectoryUser(c.Request.Context(), user); err != nil {
+		scimError(c, err)
+		return
+	}
+	api.logger.Info("User provisioned", "id", user.ID, "user_name", user.UserName, "active", user.Active)
+	invalidateAccessList(c)
+	api.sendUser(c, provider, http.StatusCreated, user.ID)
+}
+
+// updateUser stores the user, keeping the ID.
+func (api *scimAPI) updateUser(c *gin.Context, provider storage.Provider, resource map[string]any) {
+	user, err := parseUser(resource)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	user.ID = c.Param("id")
+	if err := checkUserConflict(c, provider, user); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	if err := provider.UpdateDirectoryUser(c.Request.Context(), user); err != nil {
+		scimError(c, err)
+		return
+	}
+	api.logger.Info("User updated", "id", user.ID, "user_name", user.UserName, "active", user.Active)
+	invalidateAccessList(c)
+	api.sendUser(c, provider, http.StatusOK, user.ID)
+}
+
+func (api *scimAPI) replaceUser(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	resource, err := readResource(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	api.updateUser(c, provider, resource)
+}
+
+func (api *scimAPI) patchUser(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	req, err := readPatch(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	user, err := provider.GetDirectoryUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	resource := api.userResource(c, *user, nil)
+	if err := scim.Apply(resource, req.Operations); err != nil {
+		scimError(c, err)
+		return
+	}
+	api.updateUser(c, provider, resource)
+}
+
+func (api *scimAPI) deleteUser(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	if err := provider.DeleteDirectoryUser(c.Request.Context(), c.Param("id")); err != nil {
+		scimError(c, err)
+		return
+	}
+	api.logger.Info("User deprovisioned", "id", c.Param("id"))
+	invalidateAccessList(c)
+	c.Status(http.StatusNoContent)
+}
+
+// --- Groups ---
+
+func (api *scimAPI) groupResource(c *gin.Context, g storage.DirectoryGroup, members []storage.DirectoryUser) map[string]any {
+	r := map[string]any{
+		"schemas":     []any{scim.SchemaGroup},
+		"id":          g.ID,
+		"displayName": g.DisplayName,
+		"meta":        api.meta(c, "Groups", g.ID, g.CreatedAt, g.UpdatedAt),
+	}
+	if g.ExternalID != nil {
+		r["externalId"] = *g.ExternalID
+	}
+	list := []any{}
+	for _, u := range members {
+		list = append(list, map[string]any{
+			"value":   u.ID,
+			"display": u.UserName,
+			"type":    "User",
+			"$ref":    api.location(c, "Users", u.ID),
+		})
+	}
+	r["members"] = list
+	return r
+}
+
+// parseGroup reads a group resource and the IDs of its members. Members not
+// provisioned as users are skipped.
+func (api *scimAPI) parseGroup(c *gin.Context, provider storage.Provider, resource map[string]any) (storage.DirectoryGroup, []string, error) {
+	group := storage.DirectoryGroup{
+		DisplayName: stringAttr(resource, "displayName"),
+		ExternalID:  optionalString(stringAttr(resource, "externalId")),
+	}
+	if group.DisplayName == "" {
+		return group, nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "displayName is required")
+	}
+
+	var members []string
+	for _, v := range scim.Values(resource, scim.AttrPath{Attr: "members"}) {
+		id, ok := v.(string)
+		if !ok || slices.Contains(members, id) {
+			continue
+		}
+		if _, err := provider.GetDirectoryUser(c.Request.Context(), id); errors.Is(err, storage.ErrNotFound) {
+			api.logger.Warn("Skipping unknown group member", "group", group.DisplayName, "member", id)
+			continue
+		} else if err != nil {
+			return group, nil, err
+		}
+		members = append(members, id)
+	}
+	return group, members, nil
+}
+
+// checkGroupConflict returns a uniqueness error if another group has the name.
+func checkGroupConflict(c *gin.Context, provider storage.Provider, group storage.DirectoryGroup) error {
+	existing, err := provider.FindDirectoryGroup(c.Request.Context(), group.DisplayName)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != group.ID {
+		return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "group %s already exists", group.DisplayName)
+	}
+	return nil
+}
+
+func (api *scimAPI) sendGroup(c *gin.Context, provider storage.Provider, status int, id string) {
+	ctx := c.Request.Context()
+	group, err := provider.GetDirectoryGroup(ctx, id)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	members, err := provider.ListDirectoryGroupMembers(ctx, id)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	resource := api.groupResource(c, *group, members)
+	if status == http.StatusCreated {
+		c.Header("Location", api.location(c, "Groups", id))
+	}
+	scimJSON(c, status, excludeAttributes(resource, c.Query("excludedAttributes")))
+}
+
+func (api *scimAPI) listGroups(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	groups, err := provider.ListDirectoryGroups(c.Request.Context())
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	members, err := groupMembers(c, provider)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	resources := make([]map[string]any, len(groups))
+	for i, g := range groups {
+		resources[i] = api.groupResource(c, g, members[g.ID])
+	}
+	query(c, resources)
+}
+
+func (api *scimAPI) getGroup(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	api.sendGroup(c, provider, http.StatusOK, c.Param("id"))
+}
+
+func (api *scimAPI) createGroup(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	resource, err := readResource(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	group, members, err := api.parseGroup(c, provider, resource)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	group.ID = uuid.NewString()
+	if err := checkGroupConflict(c, provider, group); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	if err := provider.CreateDirectoryGroup(c.Request.Context(), group, members); err != nil {
+		scimError(c, err)
+		return
+	}
+	api.logger.Info("Group provisioned", "id", group.ID, "display_name", group.DisplayName, "members", len(members))
+	invalidateAccessList(c)
+	api.sendGroup(c, provider, http.StatusCreated, group.ID)
+}
+
+// updateGroup stores the group and replaces its members.
+func (api *scimAPI) updateGroup(c *gin.Context, provider storage.Provider, resource map[string]any) {
+	group, members, err := api.parseGroup(c, provider, resource)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	group.ID = c.Param("id")
+	if err := checkGroupConflict(c, provider, group); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	if err := provider.UpdateDirectoryGroup(c.Request.Context(), group, members); err != nil {
+		scimError(c, err)
+		return
+	}
+	api.logger.Info("Group updated", "id", group.ID, "display_name", group.DisplayName, "members", len(members))
+	invalidateAccessList(c)
+	api.sendGroup(c, provider, http.StatusOK, group.ID)
+}
+
+func (api *scimAPI) replaceGroup(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	resource, err := readResource(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	api.updateGroup(c, provider, resource)
+}
+
+func (api *scimAPI) patchGroup(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	req, err := readPatch(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	group, err := provider.GetDirectoryGroup(ctx, c.Param("id"))
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	members, err := provider.ListDirectoryGroupMembers(ctx, group.ID)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	resource := api.groupResource(c, *group, members)
+	if err := scim.Apply(resource, req.Operations); err != nil {
+		scimError(c, err)
+		return
+	}
+	api.updateGroup(c, provider, resource)
+}
+
+func (api *scimAPI) deleteGroup(c *gin.Context) {
+	err, provider := GetStorageProvider(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+	if err := provider.DeleteDirectoryGroup(c.Request.Context(), c.Param("id")); err != nil {
+		scimError(c, err)
+		return
+	}
+	api.logger.Info("Group deleted", "id", c.Param("id"))
+	invalidateAccessList(c)
+	c.Status(http.StatusNoContent)
+}
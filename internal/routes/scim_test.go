@@ -0,0 +1,292 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"entry-access-control/internal/config"
+	"entry-access-control/internal/scim"
+	"entry-access-control/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testSCIMToken = "test-scim-token"
+
+// newSCIMTestServer serves the SCIM API on a fresh database.
+func newSCIMTestServer(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	provider := storage.NewProvider(&config.Storage{
+		SQLite: &config.SQLLiteStorage{Path: filepath.Join(t.TempDir(), "test.db")},
+	})
+	if provider == nil {
+		t.Fatal("NewProvider failed")
+	}
+	t.Cleanup(func() { provider.Close() })
+
+	prev := config.Cfg
+	config.Cfg = &config.Config{SCIM: config.SCIMConfig{Token: testSCIMToken}}
+	t.Cleanup(func() { config.Cfg = prev })
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("Storage", provider)
+		c.Next()
+	})
+	SCIMRoutes(r.Group("/scim/v2"))
+	return r
+}
+
+// scimDo sends the request and decodes the JSON response, if any.
+func scimDo(t *testing.T, r *gin.Engine, method, path string, body any) (int, map[string]any) {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Authorization", "Bearer "+testSCIMToken)
+	req.Header.Set("Content-Type", scim.ContentType)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]any
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s %s: invalid JSON %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code, resp
+}
+
+func createSCIMUser(t *testing.T, r *gin.Engine, userName string) string {
+	t.Helper()
+	status, resp := scimDo(t, r, http.MethodPost, "/scim/v2/Users", map[string]any{
+		"schemas":  []string{scim.SchemaUser},
+		"userName": userName,
+		"active":   true,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("create user %s: status %d %v", userName, status, resp)
+	}
+	return resp["id"].(string)
+}
+
+func createSCIMGroup(t *testing.T, r *gin.Engine, displayName string, members ...string) string {
+	t.Helper()
+	list := []map[string]any{}
+	for _, id := range members {
+		list = append(list, map[string]any{"value": id})
+	}
+	status, resp := scimDo(t, r, http.MethodPost, "/scim/v2/Groups", map[string]any{
+		"schemas":     []string{scim.SchemaGroup},
+		"displayName": displayName,
+		"members":     list,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("create group %s: status %d %v", displayName, status, resp)
+	}
+	return resp["id"].(string)
+}
+
+func memberIDs(resource map[string]any, attr string) []string {
+	var ids []string
+	list, _ := resource[attr].([]any)
+	for _, m := range list {
+		ids = append(ids, m.(map[string]any)["value"].(string))
+	}
+	return ids
+}
+
+func TestSCIM_Unauthorized(t *testing.T) {
+	r := newSCIMTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("missing WWW-Authenticate header")
+	}
+}
+
+func TestSCIM_ListUsers_Filter(t *testing.T) {
+	r := newSCIMTestServer(t)
+	alice := createSCIMUser(t, r, "alice@example.com")
+	createSCIMUser(t, r, "bob@example.com")
+	staff := createSCIMGroup(t, r, "Staff", alice)
+
+	status, resp := scimDo(t, r, http.MethodGet, `/scim/v2/Users?filter=userName+eq+%22ALICE@example.com%22`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d %v", status, resp)
+	}
+	if resp["totalResults"] != float64(1) {
+		t.Fatalf("totalResults = %v, want 1", resp["totalResults"])
+	}
+	user := resp["Resources"].([]any)[0].(map[string]any)
+	if user["id"] != alice {
+		t.Errorf("id = %v, want %s", user["id"], alice)
+	}
+	if groups := memberIDs(user, "groups"); len(groups) != 1 || groups[0] != staff {
+		t.Errorf("groups = %v, want [%s]", groups, staff)
+	}
+
+	status, resp = scimDo(t, r, http.MethodGet, `/scim/v2/Groups?filter=displayName+eq+%22staff%22`, nil)
+	if status != http.StatusOK || resp["totalResults"] != float64(1) {
+		t.Fatalf("group filter: status %d %v", status, resp)
+	}
+	group := resp["Resources"].([]any)[0].(map[string]any)
+	if members := memberIDs(group, "members"); len(members) != 1 || members[0] != alice {
+		t.Errorf("members = %v, want [%s]", members, alice)
+	}
+
+	status, resp = scimDo(t, r, http.MethodGet, `/scim/v2/Users?filter=userName+zz+%22x%22`, nil)
+	if status != http.StatusBadRequest || resp["scimType"] != scim.ErrInvalidFilter {
+		t.Errorf("invalid filter: status %d %v", status, resp)
+	}
+}
+
+func TestSCIM_PatchUser(t *testing.T) {
+	r := newSCIMTestServer(t)
+	id := createSCIMUser(t, r, "alice@example.com")
+
+	status, resp := scimDo(t, r, http.MethodPatch, "/scim/v2/Users/"+id, map[string]any{
+		"schemas": []string{scim.SchemaPatchOp},
+		"Operations": []map[string]any{
+			{"op": "replace", "path": "active", "value": false},
+			{"op": "replace", "value": map[string]any{"displayName": "Alice A"}},
+		},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d %v", status, resp)
+	}
+	if resp["active"] != false || resp["displayName"] != "Alice A" {
+		t.Errorf("patched user = %v", resp)
+	}
+
+	status, resp = scimDo(t, r, http.MethodPatch, "/scim/v2/Users/"+id, map[string]any{
+		"Operations": []map[string]any{{"op": "replace", "path": "active", "value": true}},
+	})
+	if status != http.StatusBadRequest {
+		t.Errorf("missing schema: status %d %v", status, resp)
+	}
+}
+
+func TestSCIM_PatchGroupMembers(t *testing.T) {
+	r := newSCIMTestServer(t)
+	alice := createSCIMUser(t, r, "alice@example.com")
+	bob := createSCIMUser(t, r, "bob@example.com")
+	group := createSCIMGroup(t, r, "Staff", alice)
+
+	status, resp := scimDo(t, r, http.MethodPatch, "/scim/v2/Groups/"+group, map[string]any{
+		"schemas": []string{scim.SchemaPatchOp},
+		"Operations": []map[string]any{
+			{"op": "add", "path": "members", "value": []map[string]any{{"value": bob}, {"value": "unknown"}}},
+		},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("add: status %d %v", status, resp)
+	}
+	if members := memberIDs(resp, "members"); len(members) != 2 {
+		t.Errorf("members after add = %v, want alice and bob", members)
+	}
+
+	status, resp = scimDo(t, r, http.MethodPatch, "/scim/v2/Groups/"+group, map[string]any{
+		"schemas": []string{scim.SchemaPatchOp},
+		"Operations": []map[string]any{
+			{"op": "remove", "path": `members[value eq "` + alice + `"]`},
+		},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("remove: status %d %v", status, resp)
+	}
+	if members := memberIDs(resp, "members"); len(members) != 1 || members[0] != bob {
+		t.Errorf("members after remove = %v, want [%s]", members, bob)
+	}
+
+	// The user side reflects the stored membership
+	_, resp = scimDo(t, r, http.MethodGet, "/scim/v2/Users/"+alice, nil)
+	if groups := memberIDs(resp, "groups"); len(groups) != 0 {
+		t.Errorf("alice groups = %v, want none", groups)
+	}
+}
+
+func TestSCIM_Conflicts(t *testing.T) {
+	r := newSCIMTestServer(t)
+	createSCIMUser(t, r, "alice@example.com")
+	createSCIMGroup(t, r, "Staff")
+	other := createSCIMGroup(t, r, "Students")
+
+	status, resp := scimDo(t, r, http.MethodPost, "/scim/v2/Users", map[string]any{
+		"schemas":  []string{scim.SchemaUser},
+		"userName": "Alice@example.com",
+	})
+	if status != http.StatusConflict || resp["scimType"] != scim.ErrUniqueness {
+		t.Errorf("duplicate user: status %d %v", status, resp)
+	}
+
+	status, resp = scimDo(t, r, http.MethodPost, "/scim/v2/Groups", map[string]any{
+		"schemas":     []string{scim.SchemaGroup},
+		"displayName": "STAFF",
+	})
+	if status != http.StatusConflict || resp["scimType"] != scim.ErrUniqueness {
+		t.Errorf("duplicate group: status %d %v", status, resp)
+	}
+
+	status, resp = scimDo(t, r, http.MethodPut, "/scim/v2/Groups/"+other, map[string]any{
+		"schemas":     []string{scim.SchemaGroup},
+		"displayName": "staff",
+	})
+	if status != http.StatusConflict {
+		t.Errorf("rename to existing group: status %d %v", status, resp)
+	}
+
+	// Renaming a group to its own name in another case is not a conflict
+	status, resp = scimDo(t, r, http.MethodPut, "/scim/v2/Groups/"+other, map[string]any{
+		"schemas":     []string{scim.SchemaGroup},
+		"displayName": "STUDENTS",
+	})
+	if status != http.StatusOK {
+		t.Errorf("rename own group: status %d %v", status, resp)
+	}
+}
+
+func TestSCIM_Errors(t *testing.T) {
+	r := newSCIMTestServer(t)
+
+	for _, path := range []string{"/scim/v2/Users/missing", "/scim/v2/Groups/missing"} {
+		status, resp := scimDo(t, r, http.MethodGet, path, nil)
+		if status != http.StatusNotFound || resp["status"] != "404" {
+			t.Errorf("GET %s: status %d %v", path, status, resp)
+		}
+	}
+
+	status, resp := scimDo(t, r, http.MethodPost, "/scim/v2/Users", map[string]any{
+		"schemas": []string{scim.SchemaUser},
+	})
+	if status != http.StatusBadRequest || resp["scimType"] != scim.ErrInvalidValue {
+		t.Errorf("missing userName: status %d %v", status, resp)
+	}
+
+	status, resp = scimDo(t, r, http.MethodPost, "/scim/v2/Groups", map[string]any{
+		"schemas": []string{scim.SchemaGroup},
+	})
+	if status != http.StatusBadRequest {
+		t.Errorf("missing displayName: status %d %v", status, resp)
+	}
+}
@@ -0,0 +1,69 @@
+package routes
+
+// Version negotiation with entry devices. Devices report the version of the
+// client assets they are running on every poll. Devices running another version
+// than the server are told to reload, and devices older than the configured
+// minimum version are refused.
+
+import (
+	"fmt"
+	"log/slog"
+
+	. "entry-access-control/internal/config"
+	"entry-access-control/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Request header for the client asset version
+	CLIENT_VERSION_HEADER = "X-Client-Version"
+	// Response header for the server version
+	SERVER_VERSION_HEADER = "X-Server-Version"
+	// Response header telling the client to reload, set when versions differ
+	CLIENT_RELOAD_HEADER = "X-Client-Reload"
+)
+
+// checkClientVersion returns ErrClientTooOld if the client version is older
+// than the configured minimum. Clients not reporting a version predate version
+// negotiation, and are refused too when a minimum is set.
+func checkClientVersion(client string) error {
+	minVersion := Cfg.MinClientVersion
+	if minVersion == "" || client == utils.GetVersion() {
+		return nil
+	}
+	if client == "" {
+		return fmt.Errorf("%w: client did not report version, minimum is %s", ErrClientTooOld, minVersion)
+	}
+
+	cmp, err := utils.CompareVersions(client, minVersion)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrClientTooOld, err)
+	}
+	if cmp < 0 {
+		return fmt.Errorf("%w: client %s, minimum is %s", ErrClientTooOld, client, minVersion)
+	}
+	return nil
+}
+
+// ClientVersion checks the client version reported by the device, and tells
+// the device to reload when it differs from the server version.
+func ClientVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		server := utils.GetVersion()
+		client := c.GetHeader(CLIENT_VERSION_HEADER)
+		c.Header(SERVER_VERSION_HEADER, server)
+
+		if err := checkClientVersion(client); err != nil {
+			AbortWithError(c, err)
+			return
+		}
+
+		if client != "" && client != server {
+			slog.Debug("Client version differs from server, requesting reload", "client_version", client, "server_version", server)
+			c.Header(CLIENT_RELOAD_HEADER, "true")
+		}
+
+		c.Next()
+	}
+}
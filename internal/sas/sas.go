@@ -0,0 +1,135 @@
+// Package sas derives short authentication strings (SAS) from device IDs.
+//
+// The code is the same as shown by `shortcode.js` on the device: the device
+// UUID is hashed with SHA-256, and the first 36 bits are mapped to 6 emojis
+// from the Matrix SAS emoji table. Comparing the codes lets an admin confirm
+// they are approving the device in front of them.
+package sas
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Number of emojis in the code
+const SAS_LENGTH = 6
+
+// Bits used per emoji. 64 emojis in the table.
+const bitsPerEmoji = 6
+
+var ErrEmptyUUID = errors.New("invalid UUID: cannot be empty")
+
+// Emoji table from the Matrix specification
+// https://github.com/matrix-org/matrix-spec/blob/main/data-definitions/sas-emoji.json
+//
+//go:embed sas-emoji.json
+var emojiJSON []byte
+
+type Emoji struct {
+	Number      int    `json:"number"`
+	Emoji       string `json:"emoji"`
+	Description string `json:"description"`
+	Unicode     string `json:"unicode"`
+}
+
+var emojiList = mustLoadEmojis()
+
+func mustLoadEmojis() []Emoji {
+	var list []Emoji
+	if err := json.Unmarshal(emojiJSON, &list); err != nil {
+		panic(fmt.Sprintf("failed to parse SAS emoji table: %v", err))
+	}
+	if len(list) != 1<<bitsPerEmoji {
+		panic(fmt.Sprintf("SAS emoji table must have %d emojis, has %d", 1<<bitsPerEmoji, len(list)))
+	}
+	return list
+}
+
+// Emojis returns the SAS emojis for the UUID.
+func Emojis(uuid string) ([]Emoji, error) {
+	if uuid == "" {
+		return nil, ErrEmptyUUID
+	}
+
+	hash := sha256.Sum256([]byte(uuid))
+
+	result := make([]Emoji, SAS_LENGTH)
+	for i := range SAS_LENGTH {
+		result[i] = emojiList[extract6Bits(hash[:], i*bitsPerEmoji)]
+	}
+	return result, nil
+}
+
+// UUIDtoSAS converts the UUID into a 6-emoji SAS code.
+func UUIDtoSAS(uuid string) (string, error) {
+	emojis, err := Emojis(uuid)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, e := range emojis {
+		sb.WriteString(e.Emoji)
+	}
+	return sb.String(), nil
+}
+
+// Describe returns the emoji descriptions of the SAS code, e.g. "Dog, Cat, Lion, ..."
+func Describe(uuid string) (string, error) {
+	emojis, err := Emojis(uuid)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, len(emojis))
+	for i, e := range emojis {
+		names[i] = e.Description
+	}
+	return strings.Join(names, ", "), nil
+}
+
+// VerifySAS checks that the code matches the UUID. Code can be given either
+// as emojis, or as their descriptions separated by spaces or commas.
+func VerifySAS(uuid string, code string) bool {
+	emojis, err := Emojis(uuid)
+	if err != nil {
+		return false
+	}
+
+	var emojiCode, descCode strings.Builder
+	for _, e := range emojis {
+		emojiCode.WriteString(e.Emoji)
+		descCode.WriteString(e.Description)
+	}
+
+	given := normalize(code)
+	return given == normalize(emojiCode.String()) || given == normalize(descCode.String())
+}
+
+// normalize strips separators, whitespace and emoji variation selectors, and lowercases the code.
+func normalize(code string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ',' || r == '\uFE0F' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, code)
+}
+
+// extract6Bits reads 6 bits from the hash, starting at bitOffset. Bits are read
+// most significant first.
+func extract6Bits(hash []byte, bitOffset int) int {
+	byteIndex := bitOffset / 8
+	bitInByte := bitOffset % 8
+
+	value := int(hash[byteIndex]) << 8
+	if byteIndex+1 < len(hash) {
+		value |= int(hash[byteIndex+1])
+	}
+	return (value >> (16 - bitsPerEmoji - bitInByte)) & 0x3F
+}
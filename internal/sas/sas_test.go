@@ -0,0 +1,68 @@
+package sas
+
+import "testing"
+
+// Expected codes computed with shortcode.js
+func TestUUIDtoSAS_MatchesJS(t *testing.T) {
+	cases := []struct {
+		uuid  string
+		code  string
+		names string
+	}{
+		{"dfec33c0-7d9b-4754-8aea-d7c6a6a540b8", "☎️🔥🐧🎂🎂🎩", "Telephone, Fire, Penguin, Cake, Cake, Hat"},
+		{"00000000-0000-0000-0000-000000000000", "🦄✏️👍🏆🤖🐢", "Unicorn, Pencil, Thumbs Up, Trophy, Robot, Turtle"},
+	}
+
+	for _, tc := range cases {
+		code, err := UUIDtoSAS(tc.uuid)
+		if err != nil {
+			t.Fatalf("UUIDtoSAS(%q): %v", tc.uuid, err)
+		}
+		if code != tc.code {
+			t.Errorf("UUIDtoSAS(%q) = %q, want %q", tc.uuid, code, tc.code)
+		}
+
+		names, err := Describe(tc.uuid)
+		if err != nil {
+			t.Fatalf("Describe(%q): %v", tc.uuid, err)
+		}
+		if names != tc.names {
+			t.Errorf("Describe(%q) = %q, want %q", tc.uuid, names, tc.names)
+		}
+	}
+}
+
+func TestUUIDtoSAS_Empty(t *testing.T) {
+	if _, err := UUIDtoSAS(""); err != ErrEmptyUUID {
+		t.Fatalf("expected ErrEmptyUUID, got %v", err)
+	}
+}
+
+func TestVerifySAS(t *testing.T) {
+	uuid := "00000000-0000-0000-0000-000000000000"
+
+	valid := []string{
+		"🦄✏️👍🏆🤖🐢",
+		"🦄✏👍🏆🤖🐢", // without variation selector
+		"🦄 ✏️ 👍 🏆 🤖 🐢",
+		"Unicorn, Pencil, Thumbs Up, Trophy, Robot, Turtle",
+		"unicorn pencil thumbsup trophy robot turtle",
+	}
+	for _, code := range valid {
+		if !VerifySAS(uuid, code) {
+			t.Errorf("VerifySAS(%q) = false, want true", code)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"🦄✏️👍🏆🤖",
+		"🐢🤖🏆👍✏️🦄",
+		"Unicorn, Pencil, Thumbs Up, Trophy, Robot, Dog",
+	}
+	for _, code := range invalid {
+		if VerifySAS(uuid, code) {
+			t.Errorf("VerifySAS(%q) = true, want false", code)
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package scim
+
+// SCIM filter expressions (RFC 7644, section 3.4.2.2), evaluated against
+// resources decoded into maps. Attribute names and string values compare case
+// insensitively, and values of multi-valued attributes match if any does.
+//
+//	userName eq "user@example.com"
+//	emails[type eq "work" and value ew "@example.com"]
+//	not (active eq false) or meta.lastModified gt "2026-01-01T00:00:00Z"
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter matches resources.
+type Filter interface {
+	Match(resource map[string]any) bool
+}
+
+// Comparison operators
+const (
+	OpEqual          = "eq"
+	OpNotEqual       = "ne"
+	OpContains       = "co"
+	OpStartsWith     = "sw"
+	OpEndsWith       = "ew"
+	OpGreater        = "gt"
+	OpGreaterOrEqual = "ge"
+	OpLess           = "lt"
+	OpLessOrEqual    = "le"
+	OpPresent        = "pr"
+)
+
+var compareOps = []string{OpEqual, OpNotEqual, OpContains, OpStartsWith, OpEndsWith, OpGreater, OpGreaterOrEqual, OpLess, OpLessOrEqual}
+
+// AttrPath is an attribute with an optional sub-attribute, e.g. "name.givenName".
+// Schema URN prefixes are dropped.
+type AttrPath struct {
+	Attr    string
+	SubAttr string
+}
+
+func (p AttrPath) String() string {
+	if p.SubAttr == "" {
+		return p.Attr
+	}
+	return p.Attr + "." + p.SubAttr
+}
+
+// ParseAttrPath parses "attr", "attr.sub" or "urn:...:attr.sub".
+func ParseAttrPath(s string) (AttrPath, error) {
+	if strings.HasPrefix(strings.ToLower(s), "urn:") {
+		i := strings.LastIndex(s, ":")
+		s = s[i+1:]
+	}
+	attr, sub, _ := strings.Cut(s, ".")
+	if !validAttrName(attr) || (sub != "" && !validAttrName(sub)) || strings.Contains(sub, ".") {
+		return AttrPath{}, fmt.Errorf("invalid attribute path %q", s)
+	}
+	return AttrPath{Attr: attr, SubAttr: sub}, nil
+}
+
+func validAttrName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !(unicode.IsLetter(r) || r == '$' || i > 0 && (unicode.IsDigit(r) || r == '_' || r == '-')) {
+			return false
+		}
+	}
+	return true
+}
+
+type andFilter struct{ left, right Filter }
+type orFilter struct{ left, right Filter }
+type notFilter struct{ filter Filter }
+
+func (f andFilter) Match(r map[string]any) bool { return f.left.Match(r) && f.right.Match(r) }
+func (f orFilter) Match(r map[string]any) bool  { return f.left.Match(r) || f.right.Match(r) }
+func (f notFilter) Match(r map[string]any) bool { return !f.filter.Match(r) }
+
+type compareFilter struct {
+	path  AttrPath
+	op    string
+	value any
+}
+
+func (f compareFilter) Match(r map[string]any) bool {
+	values := Values(r, f.path)
+	if f.op == OpPresent {
+		for _, v := range values {
+			if !isEmpty(v) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(values) == 0 {
+		// Missing attribute is null
+		values = []any{nil}
+	}
+	for _, v := range values {
+		if compare(v, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// valuePathFilter matches elements of a multi-valued attribute, e.g. emails[type eq "work"].
+type valuePathFilter struct {
+	attr   string
+	filter Filter
+}
+
+func (f valuePathFilter) Match(r map[string]any) bool {
+	v, _ := Get(r, f.attr)
+	for _, elem := range asList(v) {
+		if m, ok := elem.(map[string]any); ok && f.filter.Match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the attribute, matching the name case insensitively.
+func Get(m map[string]any, name string) (any, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Values returns the values at the path. Multi-valued attributes give a value
+// per element, and complex elements without a sub-attribute give their "value".
+func Values(r map[string]any, path AttrPath) []any {
+	v, ok := Get(r, path.Attr)
+	if !ok || v == nil {
+		return nil
+	}
+	var values []any
+	for _, elem := range asList(v) {
+		m, isMap := elem.(map[string]any)
+		switch {
+		case path.SubAttr != "" && isMap:
+			if sub, ok := Get(m, path.SubAttr); ok {
+				values = append(values, asList(sub)...)
+			}
+		case path.SubAttr != "":
+			// Simple value has no sub-attributes
+		case isMap:
+			if sub, ok := Get(m, "value"); ok {
+				values = append(values, sub)
+			}
+		default:
+			values = append(values, elem)
+		}
+	}
+	return values
+}
+
+func asList(v any) []any {
+	if list, ok := v.([]any); ok {
+		return list
+	}
+	return []any{v}
+}
+
+func isEmpty(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	}
+	return false
+}
+
+func compare(actual any, op string, expected any) bool {
+	switch expected := expected.(type) {
+	case nil:
+		switch op {
+		case OpEqual:
+			return actual == nil
+		case OpNotEqual:
+			return actual != nil
+		}
+		return false
+
+	case bool:
+		b, ok := actual.(bool)
+		if s, isString := actual.(string); isString {
+			b, ok = parseBool(s)
+		}
+		switch op {
+		case OpEqual:
+			return ok && b == expected
+		case OpNotEqual:
+			return !ok || b != expected
+		}
+		return false
+
+	case float64:
+		n, ok := actual.(float64)
+		if !ok {
+			return op == OpNotEqual
+		}
+		switch op {
+		case OpEqual:
+			return n == expected
+		case OpNotEqual:
+			return n != expected
+		case OpGreater:
+			return n > expected
+		case OpGreaterOrEqual:
+			return n >= expected
+		case OpLess:
+			return n < expected
+		case OpLessOrEqual:
+			return n <= expected
+		}
+		return false
+
+	case string:
+		s, ok := actual.(string)
+		if !ok {
+			if actual == nil {
+				return op == OpNotEqual
+			}
+			s = fmt.Sprint(actual)
+		}
+		s, expected = strings.ToLower(s), strings.ToLower(expected)
+		switch op {
+		case OpEqual:
+			return s == expected
+		case OpNotEqual:
+			return s != expected
+		case OpContains:
+			return strings.Contains(s, expected)
+		case OpStartsWith:
+			return strings.HasPrefix(s, expected)
+		case OpEndsWith:
+			return strings.HasSuffix(s, expected)
+		case OpGreater:
+			return s > expected
+		case OpGreaterOrEqual:
+			return s >= expected
+		case OpLess:
+			return s < expected
+		case OpLessOrEqual:
+			return s <= expected
+		}
+	}
+	return false
+}
+
+// parseBool accepts boolean strings in any case, as some identity providers send "False".
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+// --- Parser ---
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen   // (
+	tokenClose  // )
+	tokenLBrack // [
+	tokenRBrack // ]
+	tokenEOF
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value string // Unquoted string value
+	pos   int
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			kind := map[byte]tokenKind{'(': tokenOpen, ')': tokenClose, '[': tokenLBrack, ']': tokenRBrack}[c]
+			tokens = append(tokens, token{kind: kind, text: string(c), pos: i})
+			i++
+		case c == '"':
+			// JSON string, find the closing quote
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			var value string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &value); err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %w", i, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: s[i : j+1], value: value, pos: i})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: s[i:j], pos: i})
+			i = j
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokenWord && strings.EqualFold(t.text, word)
+}
+
+// ParseFilter parses a filter expression.
+func ParseFilter(s string) (Filter, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return f, nil
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Filter, error) {
+	if p.keyword("not") {
+		p.next()
+		if p.peek().kind != tokenOpen {
+			return nil, fmt.Errorf("expected ( after not at %d", p.peek().pos)
+		}
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{f}, nil
+	}
+
+	if p.peek().kind == tokenOpen {
+		p.next()
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenClose {
+			return nil, fmt.Errorf("expected ) at %d", t.pos)
+		}
+		return f, nil
+	}
+
+	return p.parseAttrExp()
+}
+
+func (p *parser) parseAttrExp() (Filter, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return nil, fmt.Errorf("expected attribute at %d", t.pos)
+	}
+	path, err := ParseAttrPath(t.text)
+	if err != nil {
+		return nil, err
+	}
+
+	// Value path, e.g. emails[type eq "work"]
+	if p.peek().kind == tokenLBrack {
+		if path.SubAttr != "" {
+			return nil, fmt.Errorf("unexpected [ after %s at %d", path, p.peek().pos)
+		}
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRBrack {
+			return nil, fmt.Errorf("expected ] at %d", t.pos)
+		}
+		return valuePathFilter{attr: path.Attr, filter: inner}, nil
+	}
+
+	opToken := p.next()
+	op := strings.ToLower(opToken.text)
+	if opToken.kind != tokenWord {
+		return nil, fmt.Errorf("expected operator at %d", opToken.pos)
+	}
+	if op == OpPresent {
+		return compareFilter{path: path, op: op}, nil
+	}
+	known := false
+	for _, o := range compareOps {
+		known = known || o == op
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown operator %q at %d", opToken.text, opToken.pos)
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return compareFilter{path: path, op: op, value: value}, nil
+}
+
+func (p *parser) parseValue() (any, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.value, nil
+	case tokenWord:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid value %q at %d", t.text, t.pos)
+}
@@ -0,0 +1,221 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decode(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	return m
+}
+
+const testUser = `{
+	"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+	"userName": "Ada.Lovelace@Example.com",
+	"externalId": "ada",
+	"active": true,
+	"name": {"givenName": "Ada", "familyName": "Lovelace"},
+	"emails": [
+		{"value": "ada@example.com", "type": "work", "primary": true},
+		{"value": "ada@home.example", "type": "home"}
+	],
+	"groups": [{"value": "g1", "display": "Staff"}],
+	"meta": {"lastModified": "2026-03-01T10:00:00Z"},
+	"loginCount": 3
+}`
+
+func TestParseFilter(t *testing.T) {
+	user := decode(t, testUser)
+
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{`userName eq "ada.lovelace@example.com"`, true},
+		{`USERNAME Eq "ADA.LOVELACE@EXAMPLE.COM"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "ada.lovelace@example.com"`, true},
+		{`userName ne "ada.lovelace@example.com"`, false},
+		{`userName sw "ada."`, true},
+		{`userName ew "@example.com"`, true},
+		{`userName co "love"`, true},
+		{`name.familyName eq "Lovelace"`, true},
+		{`name.middleName pr`, false},
+		{`externalId pr`, true},
+		{`title pr`, false},
+		{`title eq null`, true},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`loginCount gt 2`, true},
+		{`loginCount le 2`, false},
+		{`meta.lastModified gt "2026-01-01T00:00:00Z"`, true},
+		{`meta.lastModified lt "2026-01-01T00:00:00Z"`, false},
+		{`emails eq "ada@home.example"`, true},
+		{`emails.value eq "ada@example.com"`, true},
+		{`emails[type eq "work" and value ew "@example.com"]`, true},
+		{`emails[type eq "home" and value ew "@example.com"]`, false},
+		{`groups[value eq "g1"]`, true},
+		{`userName eq "x" or externalId eq "ada"`, true},
+		{`userName eq "x" or externalId eq "ada" and active eq false`, false},
+		{`(userName eq "x" or externalId eq "ada") and active eq true`, true},
+		{`not (active eq false)`, true},
+		{`not (emails[type eq "work"])`, false},
+		{`userName eq "with \"quotes\""`, false},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%s) failed: %v", tt.filter, err)
+			continue
+		}
+		if got := f.Match(user); got != tt.match {
+			t.Errorf("%s: match = %v, want %v", tt.filter, got, tt.match)
+		}
+	}
+
+	// Active sent as a string by some providers
+	if f, _ := ParseFilter(`active eq false`); !f.Match(map[string]any{"active": "False"}) {
+		t.Error(`active eq false does not match "False"`)
+	}
+
+	invalid := []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName xx "a"`,
+		`userName eq "unterminated`,
+		`userName eq unquoted`,
+		`(userName eq "a"`,
+		`emails[type eq "work"`,
+		`not active eq true`,
+		`userName eq "a" and`,
+		`userName eq "a" extra`,
+	}
+	for _, s := range invalid {
+		if _, err := ParseFilter(s); err == nil {
+			t.Errorf("ParseFilter(%s) succeeded, want error", s)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		ops   string
+		check func(t *testing.T, r map[string]any)
+	}{
+		{
+			"deactivate without path",
+			`[{"op": "Replace", "value": {"active": false, "name.givenName": "Augusta"}}]`,
+			func(t *testing.T, r map[string]any) {
+				if r["active"] != false {
+					t.Errorf("active = %v", r["active"])
+				}
+				if name := r["name"].(map[string]any); name["givenName"] != "Augusta" || name["familyName"] != "Lovelace" {
+					t.Errorf("name = %v", name)
+				}
+			},
+		},
+		{
+			"replace attribute",
+			`[{"op": "replace", "path": "userName", "value": "ada@example.com"}]`,
+			func(t *testing.T, r map[string]any) {
+				if r["userName"] != "ada@example.com" {
+					t.Errorf("userName = %v", r["userName"])
+				}
+			},
+		},
+		{
+			"replace filtered sub-attribute",
+			`[{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "lovelace@example.com"}]`,
+			func(t *testing.T, r map[string]any) {
+				if got := Values(r, AttrPath{Attr: "emails"}); len(got) != 2 || got[0] != "lovelace@example.com" {
+					t.Errorf("emails = %v", got)
+				}
+			},
+		},
+		{
+			"add filtered creates element",
+			`[{"op": "add", "path": "emails[type eq \"other\"].value", "value": "ada@other.example"}]`,
+			func(t *testing.T, r map[string]any) {
+				f, _ := ParseFilter(`emails[type eq "other" and value eq "ada@other.example"]`)
+				if !f.Match(r) {
+					t.Errorf("emails = %v", r["emails"])
+				}
+			},
+		},
+		{
+			"add members",
+			`[{"op": "add", "path": "groups", "value": [{"value": "g1"}, {"value": "g2"}]}]`,
+			func(t *testing.T, r map[string]any) {
+				if got := Values(r, AttrPath{Attr: "groups"}); len(got) != 2 || got[1] != "g2" {
+					t.Errorf("groups = %v", got)
+				}
+			},
+		},
+		{
+			"remove filtered",
+			`[{"op": "remove", "path": "groups[value eq \"g1\"]"}]`,
+			func(t *testing.T, r map[string]any) {
+				if got := Values(r, AttrPath{Attr: "groups"}); len(got) != 0 {
+					t.Errorf("groups = %v", got)
+				}
+			},
+		},
+		{
+			"remove listed values",
+			`[{"op": "remove", "path": "emails", "value": [{"value": "ada@home.example"}, {"value": "missing"}]}]`,
+			func(t *testing.T, r map[string]any) {
+				if got := Values(r, AttrPath{Attr: "emails"}); len(got) != 1 || got[0] != "ada@example.com" {
+					t.Errorf("emails = %v", got)
+				}
+			},
+		},
+		{
+			"remove attribute",
+			`[{"op": "remove", "path": "urn:ietf:params:scim:schemas:core:2.0:User:externalId"}]`,
+			func(t *testing.T, r map[string]any) {
+				if _, ok := r["externalId"]; ok {
+					t.Errorf("externalId = %v", r["externalId"])
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := decode(t, testUser)
+			var ops []PatchOp
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatalf("invalid operations: %v", err)
+			}
+			if err := Apply(user, ops); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			tt.check(t, user)
+		})
+	}
+
+	errors := []string{
+		`[{"op": "move", "path": "userName"}]`,
+		`[{"op": "remove"}]`,
+		`[{"op": "add", "value": "no object"}]`,
+		`[{"op": "replace", "path": "emails[type xx \"work\"]", "value": {}}]`,
+		`[{"op": "replace", "path": "emails[type ne \"work\"].value", "value": "x"}]`,
+	}
+	for _, s := range errors {
+		user := decode(t, testUser)
+		// No element of type ne "work" exists after removing the rest
+		user["emails"] = []any{map[string]any{"type": "work", "value": "a"}}
+		var ops []PatchOp
+		if err := json.Unmarshal([]byte(s), &ops); err != nil {
+			t.Fatalf("invalid operations: %v", err)
+		}
+		if err := Apply(user, ops); err == nil {
+			t.Errorf("Apply(%s) succeeded, want error", s)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package scim
+
+// PATCH operations (RFC 7644, section 3.5.2) applied to resources decoded into
+// maps. Identity providers differ in how they use them, so the rules are
+// relaxed where it is safe:
+//   - operation names are case insensitive ("Replace" from Entra ID)
+//   - removing missing values or members is not an error
+//   - add and replace on a filtered path with no match create the element,
+//     e.g. `emails[type eq "work"].value`
+//   - remove with a value removes the listed members of a multi-valued attribute
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Operation names
+const (
+	PatchAdd     = "add"
+	PatchReplace = "replace"
+	PatchRemove  = "remove"
+)
+
+// PatchRequest is the body of a PATCH request.
+type PatchRequest struct {
+	Schemas    []string  `json:"schemas"`
+	Operations []PatchOp `json:"Operations"`
+}
+
+// PatchOp is a single PATCH operation.
+type PatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+// patchPath is a parsed PATCH path: attr[filter].subAttr
+type patchPath struct {
+	attr    string
+	filter  Filter
+	where   string // Filter source, for errors
+	subAttr string
+}
+
+func parsePatchPath(s string) (patchPath, error) {
+	s = strings.TrimSpace(s)
+	// Drop schema URN, but not from colons in the filter
+	if strings.HasPrefix(strings.ToLower(s), "urn:") {
+		head, _, _ := strings.Cut(s, "[")
+		s = s[strings.LastIndex(head, ":")+1:]
+	}
+
+	var p patchPath
+	if open := strings.Index(s, "["); open >= 0 {
+		end := strings.LastIndex(s, "]")
+		if end < open {
+			return p, fmt.Errorf("unterminated filter in path %q", s)
+		}
+		f, err := ParseFilter(s[open+1 : end])
+		if err != nil {
+			return p, fmt.Errorf("invalid filter in path %q: %w", s, err)
+		}
+		p.filter, p.where = f, s[open+1:end]
+
+		rest := s[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") || !validAttrName(rest[1:]) {
+				return p, fmt.Errorf("invalid path %q", s)
+			}
+			p.subAttr = rest[1:]
+		}
+		s = s[:open]
+		if !validAttrName(s) {
+			return p, fmt.Errorf("invalid path %q", s)
+		}
+		p.attr = s
+		return p, nil
+	}
+
+	attr, err := ParseAttrPath(s)
+	if err != nil {
+		return p, err
+	}
+	p.attr, p.subAttr = attr.Attr, attr.SubAttr
+	return p, nil
+}
+
+// Apply applies the operations to the resource in order. Errors are *Error.
+func Apply(resource map[string]any, ops []PatchOp) error {
+	for _, op := range ops {
+		if err := applyOp(resource, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyOp(resource map[string]any, op PatchOp) error {
+	name := strings.ToLower(op.Op)
+	switch name {
+	case PatchAdd, PatchReplace, PatchRemove:
+	default:
+		return NewError(http.StatusBadRequest, ErrInvalidSyntax, "unknown operation %q", op.Op)
+	}
+
+	if op.Path == "" {
+		if name == PatchRemove {
+			return NewError(http.StatusBadRequest, ErrNoTarget, "remove requires a path")
+		}
+		values, ok := op.Value.(map[string]any)
+		if !ok {
+			return NewError(http.StatusBadRequest, ErrInvalidValue, "%s without path requires an object value", op.Op)
+		}
+		// Keys may be paths themselves, e.g. {"name.givenName": "Ada"}
+		for key, value := range values {
+			if strings.EqualFold(key, "schemas") {
+				continue
+			}
+			if err := applyOp(resource, PatchOp{Op: name, Path: key, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parsePatchPath(op.Path)
+	if err != nil {
+		return NewError(http.StatusBadRequest, ErrInvalidPath, "%v", err)
+	}
+	if path.filter != nil {
+		return applyFiltered(resource, name, path, op.Value)
+	}
+
+	if path.subAttr != "" {
+		current, _ := Get(resource, path.attr)
+		switch current := current.(type) {
+		case map[string]any:
+			applyAttr(current, name, path.subAttr, op.Value)
+		case []any:
+			for _, elem := range current {
+				if m, ok := elem.(map[string]any); ok {
+					applyAttr(m, name, path.subAttr, op.Value)
+				}
+			}
+		case nil:
+			if name != PatchRemove {
+				set(resource, path.attr, map[string]any{path.subAttr: op.Value})
+			}
+		default:
+			return NewError(http.StatusBadRequest, ErrInvalidPath, "%s has no sub-attributes", path.attr)
+		}
+		return nil
+	}
+
+	applyAttr(resource, name, path.attr, op.Value)
+	return nil
+}
+
+// applyAttr applies the operation to an attribute of the map.
+func applyAttr(m map[string]any, op, attr string, value any) {
+	current, exists := Get(m, attr)
+
+	switch op {
+	case PatchRemove:
+		list, isList := current.([]any)
+		if value == nil || !isList {
+			del(m, attr)
+			return
+		}
+		// Remove listed values, e.g. {"op": "remove", "path": "members", "value": [{"value": "id"}]}
+		remove := asList(value)
+		kept := []any{}
+		for _, elem := range list {
+			if !containsValue(remove, elem) {
+				kept = append(kept, elem)
+			}
+		}
+		set(m, attr, kept)
+
+	case PatchAdd:
+		if list, ok := current.([]any); ok {
+			for _, v := range asList(value) {
+				if !containsValue(list, v) {
+					list = append(list, v)
+				}
+			}
+			set(m, attr, list)
+			return
+		}
+		fallthrough
+
+	case PatchReplace:
+		existing, isMap := current.(map[string]any)
+		values, valueIsMap := value.(map[string]any)
+		if exists && isMap && valueIsMap {
+			for k, v := range values {
+				set(existing, k, v)
+			}
+			return
+		}
+		set(m, attr, value)
+	}
+}
+
+// applyFiltered applies the operation to elements of a multi-valued attribute matching the filter.
+func applyFiltered(resource map[string]any, op string, path patchPath, value any) error {
+	current, _ := Get(resource, path.attr)
+	list, ok := current.([]any)
+	if current != nil && !ok {
+		return NewError(http.StatusBadRequest, ErrInvalidPath, "%s is not multi-valued", path.attr)
+	}
+
+	matched := false
+	kept := []any{}
+	for _, elem := range list {
+		m, isMap := elem.(map[string]any)
+		if !isMap || !path.filter.Match(m) {
+			kept = append(kept, elem)
+			continue
+		}
+		matched = true
+
+		switch {
+		case op == PatchRemove && path.subAttr == "":
+			continue
+		case path.subAttr != "":
+			applyAttr(m, op, path.subAttr, value)
+		case op == PatchReplace:
+			if v, ok := value.(map[string]any); ok {
+				m = v
+			}
+		default:
+			if v, ok := value.(map[string]any); ok {
+				for k, sub := range v {
+					set(m, k, sub)
+				}
+			}
+		}
+		kept = append(kept, m)
+	}
+
+	if !matched && op != PatchRemove {
+		// Create the element from the equality conditions of the filter
+		elem, ok := filterValues(path.filter)
+		if !ok {
+			return NewError(http.StatusBadRequest, ErrNoTarget, "no %s match %s", path.attr, path.where)
+		}
+		if path.subAttr != "" {
+			set(elem, path.subAttr, value)
+		} else if v, ok := value.(map[string]any); ok {
+			for k, sub := range v {
+				set(elem, k, sub)
+			}
+		}
+		kept = append(kept, elem)
+	}
+
+	set(resource, path.attr, kept)
+	return nil
+}
+
+// filterValues returns the attribute values set by a filter of "eq" conditions joined by "and".
+func filterValues(f Filter) (map[string]any, bool) {
+	switch f := f.(type) {
+	case compareFilter:
+		if f.op != OpEqual || f.path.SubAttr != "" {
+			return nil, false
+		}
+		return map[string]any{f.path.Attr: f.value}, true
+	case andFilter:
+		left, ok := filterValues(f.left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := filterValues(f.right)
+		if !ok {
+			return nil, false
+		}
+		for k, v := range right {
+			left[k] = v
+		}
+		return left, true
+	}
+	return nil, false
+}
+
+// containsValue reports whether the list has the value. Complex values are compared by their "value".
+func containsValue(list []any, v any) bool {
+	key := valueKey(v)
+	for _, elem := range list {
+		if reflect.DeepEqual(valueKey(elem), key) {
+			return true
+		}
+	}
+	return false
+}
+
+func valueKey(v any) any {
+	if m, ok := v.(map[string]any); ok {
+		if value, ok := Get(m, "value"); ok {
+			return value
+		}
+	}
+	return v
+}
+
+// set replaces the attribute, keeping the case of an existing key.
+func set(m map[string]any, name string, value any) {
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			m[k] = value
+			return
+		}
+	}
+	m[name] = value
+}
+
+func del(m map[string]any, name string) {
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			delete(m, k)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package scim implements the protocol parts of SCIM 2.0 (RFC 7643, RFC 7644)
+// used by the provisioning API: filters, PATCH operations and message types.
+package scim
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Schema URNs
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// Content type of SCIM messages
+const ContentType = "application/scim+json"
+
+// Error types, sent as "scimType" of 400 and 409 errors
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidPath   = "invalidPath"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrInvalidValue  = "invalidValue"
+	ErrNoTarget      = "noTarget"
+	ErrMutability    = "mutability"
+	ErrUniqueness    = "uniqueness"
+	ErrTooMany       = "tooMany"
+)
+
+// Error is a SCIM error response.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+
+	status int
+}
+
+// NewError creates an error response. scimType may be empty.
+func NewError(status int, scimType string, format string, args ...any) *Error {
+	return &Error{
+		Schemas:  []string{SchemaError},
+		Status:   fmt.Sprint(status),
+		ScimType: scimType,
+		Detail:   fmt.Sprintf(format, args...),
+		status:   status,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.ScimType != "" {
+		return e.ScimType + ": " + e.Detail
+	}
+	return e.Detail
+}
+
+// StatusCode returns the HTTP status of the error.
+func (e *Error) StatusCode() int {
+	if e.status == 0 {
+		return http.StatusBadRequest
+	}
+	return e.status
+}
+
+// ListResponse is the response of resource queries.
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// NewListResponse pages the resources. startIndex is 1-based, as in the query.
+func NewListResponse(resources []any, startIndex, count int) ListResponse {
+	total := len(resources)
+	startIndex = max(startIndex, 1)
+	from := min(startIndex-1, total)
+	to := min(from+max(count, 0), total)
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: to - from,
+		Resources:    append([]any{}, resources[from:to]...),
+	}
+}
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_device_history_device_id;
+DROP TABLE IF EXISTS device_history;
+
+ALTER TABLE devices DROP COLUMN status_reason;
+ALTER TABLE devices DROP COLUMN ip_policy;
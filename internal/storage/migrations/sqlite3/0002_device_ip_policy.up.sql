@@ -0,0 +1,18 @@
+-- Per-device IP policy override. NULL uses the global DEVICE_IP_POLICY.
+ALTER TABLE devices ADD COLUMN ip_policy TEXT DEFAULT NULL;
+-- Stop code explaining why the device is in its current status, e.g. DEVICE_IP_CHANGED
+ALTER TABLE devices ADD COLUMN status_reason TEXT DEFAULT NULL;
+
+-- Device history, such as IP address changes
+CREATE TABLE IF NOT EXISTS device_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    old_value TEXT DEFAULT NULL,
+    new_value TEXT DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_history_device_id ON device_history (device_id, created_at DESC);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_device_tags_tag;
+DROP TABLE IF EXISTS device_tags;
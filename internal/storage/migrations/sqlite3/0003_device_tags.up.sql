@@ -0,0 +1,12 @@
+-- Tags for grouping devices, e.g. by building or rollout
+CREATE TABLE IF NOT EXISTS device_tags (
+    device_id TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE,
+
+    PRIMARY KEY (device_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_tags_tag ON device_tags (tag);
@@ -0,0 +1,2 @@
+ALTER TABLE devices DROP COLUMN alerted_at;
+ALTER TABLE devices DROP COLUMN last_seen_at;
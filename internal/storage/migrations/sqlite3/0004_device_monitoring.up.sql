@@ -0,0 +1,4 @@
+-- Last time the device contacted the server
+ALTER TABLE devices ADD COLUMN last_seen_at TIMESTAMP DEFAULT NULL;
+-- When an offline alert was sent for the device. Cleared when the device comes back online.
+ALTER TABLE devices ADD COLUMN alerted_at TIMESTAMP DEFAULT NULL;
@@ -0,0 +1,10 @@
+-- Kiosk display settings per device or per entry. Device settings override entry settings.
+CREATE TABLE IF NOT EXISTS display_settings (
+    scope TEXT NOT NULL CHECK (scope IN ('device', 'entry')),
+    scope_id TEXT NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (scope, scope_id, key)
+);
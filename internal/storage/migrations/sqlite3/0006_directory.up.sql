@@ -0,0 +1,39 @@
+-- User directory provisioned over SCIM
+CREATE TABLE IF NOT EXISTS directory_users (
+    id TEXT PRIMARY KEY,
+    user_name TEXT NOT NULL,
+    external_id TEXT,
+    display_name TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL,
+    active INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    -- Deleted users are kept for the audit trail
+    deleted_at TIMESTAMP
+);
+
+-- userName is unique among users not deleted
+CREATE UNIQUE INDEX IF NOT EXISTS idx_directory_users_user_name ON directory_users (user_name COLLATE NOCASE) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_directory_users_email ON directory_users (email COLLATE NOCASE);
+
+CREATE TABLE IF NOT EXISTS directory_groups (
+    id TEXT PRIMARY KEY,
+    display_name TEXT NOT NULL,
+    external_id TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_directory_groups_display_name ON directory_groups (display_name COLLATE NOCASE);
+
+CREATE TABLE IF NOT EXISTS directory_group_members (
+    group_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+
+    FOREIGN KEY (group_id) REFERENCES directory_groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES directory_users(id) ON DELETE CASCADE,
+
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_directory_group_members_user ON directory_group_members (user_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS access_list_changes;
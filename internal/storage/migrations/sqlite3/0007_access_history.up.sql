@@ -0,0 +1,15 @@
+-- Changes in access list users, recorded on every reload
+CREATE TABLE IF NOT EXISTS access_list_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- Normalised email
+    user_id TEXT NOT NULL,
+    -- added, removed, activated or deactivated
+    change TEXT NOT NULL,
+    -- Whether the user has access after the change
+    active INTEGER NOT NULL,
+    -- Access list file, or backend, granting or losing the access
+    source TEXT NOT NULL DEFAULT '',
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_list_changes_user ON access_list_changes (user_id, id);
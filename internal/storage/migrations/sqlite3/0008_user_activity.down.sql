@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS access_events;
+DROP TABLE IF EXISTS user_sessions;
@@ -0,0 +1,9 @@
+-- Alternate identifiers of users, e.g. student numbers or old addresses.
+-- Both are stored normalised.
+CREATE TABLE IF NOT EXISTS user_aliases (
+    alias TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_aliases_user ON user_aliases (user_id);
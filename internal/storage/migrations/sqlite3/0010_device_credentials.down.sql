@@ -0,0 +1,2 @@
+ALTER TABLE devices DROP COLUMN enroll_until;
+ALTER TABLE devices DROP COLUMN credential_id;
@@ -0,0 +1,7 @@
+-- ID of the current device credential. Other credentials of the device are not accepted.
+ALTER TABLE devices ADD COLUMN credential_id TEXT DEFAULT NULL;
+-- Approved device can fetch its credential once, until this time.
+ALTER TABLE devices ADD COLUMN enroll_until TIMESTAMP DEFAULT NULL;
+
+-- Devices approved before credentials were tracked get a day to fetch a new one
+UPDATE devices SET enroll_until = datetime('now', '+1 day') WHERE status = 'approved';
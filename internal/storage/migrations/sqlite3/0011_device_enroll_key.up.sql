@@ -0,0 +1,3 @@
+-- SHA-256 of the key the device generated when it first registered. Only the
+-- device knowing the key can register with the device ID and enroll.
+ALTER TABLE devices ADD COLUMN enroll_key_hash TEXT DEFAULT NULL;
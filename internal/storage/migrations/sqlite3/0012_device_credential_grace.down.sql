@@ -0,0 +1,2 @@
+ALTER TABLE devices DROP COLUMN credential_grace_until;
+ALTER TABLE devices DROP COLUMN previous_credential_id;
@@ -0,0 +1,4 @@
+-- Credential replaced by the last renewal. It's accepted until
+-- credential_grace_until, so a renewal whose response was lost can be retried.
+ALTER TABLE devices ADD COLUMN previous_credential_id TEXT DEFAULT NULL;
+ALTER TABLE devices ADD COLUMN credential_grace_until TIMESTAMP DEFAULT NULL;
@@ -25,6 +25,157 @@ type Device struct {
 	UpdatedAt  time.Time    `db:"updated_at"`
 	Status     DeviceStatus `db:"status"`
 	ApprovedBy *string      `db:"approved_by"`
+	// Per-device IP policy, nil uses the global policy
+	IPPolicy *string `db:"ip_policy"`
+	// Stop code explaining the current status, e.g. DEVICE_IP_CHANGED
+	StatusReason *string `db:"status_reason"`
+	// Last time the device contacted the server
+	LastSeenAt *time.Time `db:"last_seen_at"`
+	// When an offline alert was sent for the device
+	AlertedAt *time.Time `db:"alerted_at"`
+	// ID of the current credential, nil until the device has enrolled
+	CredentialID *string `db:"credential_id"`
+	// Approved device can fetch its credential once, until this time
+	EnrollUntil *time.Time `db:"enroll_until"`
+	// SHA-256 of the key the device registered with, see utils.HashEnrollKey
+	EnrollKeyHash *string `db:"enroll_key_hash"`
+	// Credential replaced by the last renewal, accepted until CredentialGraceUntil
+	PreviousCredentialID *string    `db:"previous_credential_id"`
+	CredentialGraceUntil *time.Time `db:"credential_grace_until"`
+}
+
+// Time an approved device has to fetch its credential
+const DeviceEnrollmentWindow = 15 * time.Minute
+
+// Time the credential replaced by a renewal is still accepted, so the device
+// can retry a renewal whose response it didn't get
+const DeviceCredentialGrace = 10 * time.Minute
+
+// InCredentialGrace reports whether the credential was replaced by the last
+// renewal, and is still accepted.
+func (d *Device) InCredentialGrace(credentialID string, now time.Time) bool {
+	return d.PreviousCredentialID != nil && *d.PreviousCredentialID == credentialID &&
+		d.CredentialGraceUntil != nil && now.Before(*d.CredentialGraceUntil)
+}
+
+// AcceptsCredential reports whether the credential is the current one, or the
+// one replaced by the last renewal during the grace period.
+func (d *Device) AcceptsCredential(credentialID string, now time.Time) bool {
+	if d.CredentialID != nil && *d.CredentialID == credentialID {
+		return true
+	}
+	return d.InCredentialGrace(credentialID, now)
+}
+
+// Device history events
+const (
+	DeviceEventIPChanged       = "ip_changed"
+	DeviceEventIPPolicyChanged = "ip_policy_changed"
+)
+
+type DeviceHistory struct {
+	ID        int64     `db:"id"`
+	DeviceID  string    `db:"device_id"`
+	Event     string    `db:"event"`
+	OldValue  *string   `db:"old_value"`
+	NewValue  *string   `db:"new_value"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type DisplayScope string
+
+const (
+	DisplayScopeDevice DisplayScope = "device"
+	DisplayScopeEntry  DisplayScope = "entry"
+)
+
+// DisplaySetting is a kiosk display setting for a device or an entry
+type DisplaySetting struct {
+	Scope     DisplayScope `db:"scope"`
+	ScopeID   string       `db:"scope_id"`
+	Key       string       `db:"key"`
+	Value     string       `db:"value"`
+	UpdatedAt time.Time    `db:"updated_at"`
+}
+
+// DirectoryUser is a user provisioned over SCIM
+type DirectoryUser struct {
+	ID          string     `db:"id"`
+	UserName    string     `db:"user_name"`
+	ExternalID  *string    `db:"external_id"`
+	DisplayName string     `db:"display_name"`
+	Email       string     `db:"email"`
+	Active      bool       `db:"active"`
+	CreatedAt   time.Time  `db:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at"`
+	DeletedAt   *time.Time `db:"deleted_at"`
+}
+
+// DirectoryGroup is a group provisioned over SCIM. Group names are used as roles.
+type DirectoryGroup struct {
+	ID          string    `db:"id"`
+	DisplayName string    `db:"display_name"`
+	ExternalID  *string   `db:"external_id"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
+
+// DirectoryMembership is a user in a directory group.
+type DirectoryMembership struct {
+	GroupID  string `db:"group_id"`
+	UserID   string `db:"id"`
+	UserName string `db:"user_name"`
+}
+
+// Access list change types
+const (
+	AccessChangeAdded       = "added"
+	AccessChangeRemoved     = "removed"
+	AccessChangeActivated   = "activated"
+	AccessChangeDeactivated = "deactivated"
+	// Access granted or denied by another file or backend than before
+	AccessChangeMoved = "moved"
+)
+
+// AccessChange is a change of a user in the access list
+type AccessChange struct {
+	ID     int64  `db:"id"`
+	UserID string `db:"user_id"`
+	Change string `db:"change"`
+	// Whether the user has access after the change
+	Active    bool      `db:"active"`
+	Source    string    `db:"source"`
+	ChangedAt time.Time `db:"changed_at"`
+}
+
+// UserSession is a login of a user, identified by the auth token ID
+type UserSession struct {
+	ID        string     `db:"id"`
+	UserID    string     `db:"user_id"`
+	ClientIP  string     `db:"client_ip"`
+	UserAgent string     `db:"user_agent"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	EndedAt   *time.Time `db:"ended_at"`
+}
+
+// AccessEvent is an entry attempt of an authenticated user
+type AccessEvent struct {
+	ID       int64  `db:"id"`
+	UserID   string `db:"user_id"`
+	EntryID  string `db:"entry_id"`
+	DeviceID string `db:"device_id"`
+	Granted  bool   `db:"granted"`
+	// Why access was denied
+	Reason    string    `db:"reason"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// UserAlias maps an alternate identifier, e.g. a student number, to a user ID
+type UserAlias struct {
+	Alias     string    `db:"alias"`
+	UserID    string    `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
 }
 
 type ApprovedDevice struct {
@@ -13,6 +13,8 @@ var (
 	ErrStorageProviderNotFound = errors.New("storage provider not available")
 	// ErrInvalidStorageProvider indicates that the storage provider in the context is of an invalid type
 	ErrInvalidStorageProvider = errors.New("invalid storage provider")
+	// ErrNotFound indicates that the directory user or group does not exist
+	ErrNotFound = errors.New("not found")
 )
 
 type Provider interface {
@@ -35,6 +37,93 @@ type Provider interface {
 	GetDevice(ctx context.Context, deviceID string) (*Device, error)
 	ListDevices(ctx context.Context, status DeviceStatus) ([]Device, error)
 	UpdateDeviceStatus(ctx context.Context, deviceID string, status DeviceStatus, approvedBy *string) error
+	// ApproveDevice marks the device approved and grants it the entry in a single transaction.
+	// Earlier credentials of the device are revoked, and the device can enroll for a new one.
+	// A device already approved and enrolled keeps its credential, and only gets the entry.
+	ApproveDevice(ctx context.Context, deviceID string, entryID int64, approvedBy string) error
+	// EnrollDevice records the first credential issued after approval. Returns false
+	// if the device is not approved, or the credential has been issued already.
+	EnrollDevice(ctx context.Context, deviceID string, credentialID string) (bool, error)
+	// RenewDeviceCredential replaces the current credential. previousID may also
+	// be the credential replaced by the last renewal, during DeviceCredentialGrace.
+	// Returns false if previousID is neither.
+	RenewDeviceCredential(ctx context.Context, deviceID string, previousID string, credentialID string) (bool, error)
+	// SetDeviceEnrollKey binds a device registered before enroll keys to the key.
+	// Returns false if the device already has a key.
+	SetDeviceEnrollKey(ctx context.Context, deviceID string, keyHash string) (bool, error)
+	// UpdateDeviceIP records the new IP address in device history. If reapprove is set,
+	// device is returned to pending state with the given reason.
+	UpdateDeviceIP(ctx context.Context, deviceID string, clientIP string, reapprove bool, reason string) error
+	// SetDeviceIPPolicy overrides the global IP policy for the device. nil restores the global policy.
+	SetDeviceIPPolicy(ctx context.Context, deviceID string, policy *string) error
+	ListDeviceHistory(ctx context.Context, deviceID string) ([]DeviceHistory, error)
+
+	// Device monitoring methods
+	TouchDevice(ctx context.Context, deviceID string, seenAt time.Time) error
+	// SetDeviceAlerted records when an offline alert was sent. nil clears it.
+	SetDeviceAlerted(ctx context.Context, deviceID string, alertedAt *time.Time) error
+
+	// Device tag methods
+	TagDevice(ctx context.Context, deviceID string, tag string) error
+	UntagDevice(ctx context.Context, deviceID string, tag string) error
+	ListDeviceTags(ctx context.Context, deviceID string) ([]string, error)
+	ListDevicesByTag(ctx context.Context, tag string) ([]Device, error)
+
+	// Display setting methods
+	SetDisplaySetting(ctx context.Context, setting DisplaySetting) error
+	UnsetDisplaySetting(ctx context.Context, scope DisplayScope, scopeID string, key string) error
+	ListDisplaySettings(ctx context.Context, scope DisplayScope, scopeID string) ([]DisplaySetting, error)
+
+	// Directory methods, for users and groups provisioned over SCIM. Missing users and groups return ErrNotFound.
+	CreateDirectoryUser(ctx context.Context, user DirectoryUser) error
+	GetDirectoryUser(ctx context.Context, id string) (*DirectoryUser, error)
+	// FindDirectoryUser returns the user with the email, or the user name.
+	FindDirectoryUser(ctx context.Context, email string) (*DirectoryUser, error)
+	ListDirectoryUsers(ctx context.Context) ([]DirectoryUser, error)
+	UpdateDirectoryUser(ctx context.Context, user DirectoryUser) error
+	// DeleteDirectoryUser deactivates and hides the user, and removes its group memberships.
+	DeleteDirectoryUser(ctx context.Context, id string) error
+	// CreateDirectoryGroup creates the group with the members in a single transaction.
+	CreateDirectoryGroup(ctx context.Context, group DirectoryGroup, userIDs []string) error
+	GetDirectoryGroup(ctx context.Context, id string) (*DirectoryGroup, error)
+	// FindDirectoryGroup returns the group with the display name, ignoring case.
+	FindDirectoryGroup(ctx context.Context, displayName string) (*DirectoryGroup, error)
+	ListDirectoryGroups(ctx context.Context) ([]DirectoryGroup, error)
+	// UpdateDirectoryGroup updates the group and replaces its members in a single transaction.
+	UpdateDirectoryGroup(ctx context.Context, group DirectoryGroup, userIDs []string) error
+	DeleteDirectoryGroup(ctx context.Context, id string) error
+	ListDirectoryGroupMembers(ctx context.Context, groupID string) ([]DirectoryUser, error)
+	ListDirectoryUserGroups(ctx context.Context, userID string) ([]DirectoryGroup, error)
+	// ListDirectoryMemberships lists the members of all groups.
+	ListDirectoryMemberships(ctx context.Context) ([]DirectoryMembership, error)
+
+	// Access list history methods
+	RecordAccessChanges(ctx context.Context, changes []AccessChange) error
+	// ListAccessChanges returns changes of the user, oldest first.
+	ListAccessChanges(ctx context.Context, userID string) ([]AccessChange, error)
+	// ListAccessStates returns the latest change of each user not removed.
+	ListAccessStates(ctx context.Context) ([]AccessChange, error)
+
+	// User activity methods
+	CreateUserSession(ctx context.Context, session UserSession) error
+	// EndUserSession marks the session ended, e.g. on logout.
+	EndUserSession(ctx context.Context, id string, endedAt time.Time) error
+	// ListUserSessions returns sessions of the user not ended or expired at the time, newest first.
+	ListUserSessions(ctx context.Context, userID string, now time.Time) ([]UserSession, error)
+	RecordAccessEvent(ctx context.Context, event AccessEvent) error
+	// ListAccessEvents returns the latest events of the user, newest first.
+	ListAccessEvents(ctx context.Context, userID string, limit int) ([]AccessEvent, error)
+	// PruneUserSessions removes sessions ended or expired before the time.
+	PruneUserSessions(ctx context.Context, olderThan time.Time) (int64, error)
+	// PruneAccessEvents removes events recorded before the time.
+	PruneAccessEvents(ctx context.Context, olderThan time.Time) (int64, error)
+
+	// User alias methods. Missing aliases return ErrNotFound.
+	// SetUserAlias maps the alias to the user, replacing an earlier mapping.
+	SetUserAlias(ctx context.Context, alias string, userID string) error
+	DeleteUserAlias(ctx context.Context, alias string) error
+	ResolveUserAlias(ctx context.Context, alias string) (string, error)
+	ListUserAliases(ctx context.Context) ([]UserAlias, error)
 
 	// Approved device methods
 	CreateApprovedDevice(ctx context.Context, device ApprovedDevice) error
@@ -4,8 +4,10 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"entry-access-control/internal/config"
 	"entry-access-control/internal/utils"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -39,9 +41,74 @@ type Queries struct {
 	GetDevice          SQL
 	ListDevices        SQL
 	UpdateDeviceStatus SQL
+	UpdateDeviceIP     SQL
+	SetDeviceIPPolicy  SQL
+	TouchDevice        SQL
+	SetDeviceAlerted   SQL
+	OpenEnrollment     SQL
+	EnrollDevice       SQL
+	RenewCredential    SQL
+	RetryRenewal       SQL
+	SetEnrollKey       SQL
+
+	// --- Device history queries ---
+	CreateDeviceHistory SQL
+	ListDeviceHistory   SQL
+
+	// --- Device tag queries ---
+	TagDevice        SQL
+	UntagDevice      SQL
+	ListDeviceTags   SQL
+	ListDevicesByTag SQL
+
+	// --- Display setting queries ---
+	SetDisplaySetting   SQL
+	UnsetDisplaySetting SQL
+	ListDisplaySettings SQL
+
+	// --- Directory queries ---
+	CreateDirectoryUser       SQL
+	GetDirectoryUser          SQL
+	FindDirectoryUser         SQL
+	ListDirectoryUsers        SQL
+	UpdateDirectoryUser       SQL
+	DeleteDirectoryUser       SQL
+	CreateDirectoryGroup      SQL
+	GetDirectoryGroup         SQL
+	FindDirectoryGroup        SQL
+	ListDirectoryGroups       SQL
+	UpdateDirectoryGroup      SQL
+	DeleteDirectoryGroup      SQL
+	AddDirectoryGroupMember   SQL
+	ClearDirectoryGroup       SQL
+	ClearDirectoryUserGroups  SQL
+	ListDirectoryGroupMembers SQL
+	ListDirectoryUserGroups   SQL
+	ListDirectoryMemberships  SQL
+
+	// --- Access list history queries ---
+	CreateAccessChange SQL
+	ListAccessChanges  SQL
+	ListAccessStates   SQL
+
+	// --- User activity queries ---
+	CreateUserSession SQL
+	EndUserSession    SQL
+	ListUserSessions  SQL
+	CreateAccessEvent SQL
+	ListAccessEvents  SQL
+	PruneUserSessions SQL
+	PruneAccessEvents SQL
+
+	// --- User alias queries ---
+	SetUserAlias     SQL
+	DeleteUserAlias  SQL
+	ResolveUserAlias SQL
+	ListUserAliases  SQL
 
 	// --- Approved device queries ---
 	CreateApprovedDevice        SQL
+	UpsertApprovedDevice        SQL
 	GetApprovedDevice           SQL
 	ListApprovedDevicesByDevice SQL
 	ListApprovedDevicesByEntry  SQL
@@ -78,13 +145,78 @@ func defaultQueries() Queries {
 		ExpireNonces: "DELETE FROM nonces WHERE expires_at <= ?",
 
 		// --- Device provisioning queries ---
-		CreateDevice:       "INSERT INTO devices (device_id, client_ip, created_at, updated_at, status) VALUES (?, ?, ?, ?, ?)",
-		GetDevice:          "SELECT device_id, client_ip, created_at, updated_at, status, approved_by FROM devices WHERE device_id = ?",
-		ListDevices:        "SELECT device_id, client_ip, created_at, updated_at, status, approved_by FROM devices WHERE status = ? ORDER BY created_at DESC",
-		UpdateDeviceStatus: "UPDATE devices SET status = ?, updated_at = ?, approved_by = ? WHERE device_id = ?",
+		CreateDevice:       "INSERT INTO devices (device_id, client_ip, created_at, updated_at, status, enroll_key_hash) VALUES (?, ?, ?, ?, ?, ?)",
+		GetDevice:          "SELECT device_id, client_ip, created_at, updated_at, status, approved_by, ip_policy, status_reason, last_seen_at, alerted_at, credential_id, enroll_until, enroll_key_hash, previous_credential_id, credential_grace_until FROM devices WHERE device_id = ?",
+		ListDevices:        "SELECT device_id, client_ip, created_at, updated_at, status, approved_by, ip_policy, status_reason, last_seen_at, alerted_at, credential_id, enroll_until, enroll_key_hash, previous_credential_id, credential_grace_until FROM devices WHERE status = ? ORDER BY created_at DESC",
+		UpdateDeviceStatus: "UPDATE devices SET status = ?, updated_at = ?, approved_by = ?, status_reason = NULL, credential_id = NULL, enroll_until = NULL, previous_credential_id = NULL, credential_grace_until = NULL WHERE device_id = ?",
+		UpdateDeviceIP:     "UPDATE devices SET client_ip = ?, updated_at = ?, status = ?, status_reason = ? WHERE device_id = ?",
+		SetDeviceIPPolicy:  "UPDATE devices SET ip_policy = ?, updated_at = ? WHERE device_id = ?",
+		TouchDevice:        "UPDATE devices SET last_seen_at = ? WHERE device_id = ?",
+		SetDeviceAlerted:   "UPDATE devices SET alerted_at = ? WHERE device_id = ?",
+		OpenEnrollment:     "UPDATE devices SET enroll_until = ? WHERE device_id = ?",
+		EnrollDevice:       "UPDATE devices SET credential_id = ?, enroll_until = NULL, previous_credential_id = NULL, credential_grace_until = NULL WHERE device_id = ? AND status = 'approved' AND enroll_until IS NOT NULL",
+		RenewCredential:    "UPDATE devices SET credential_id = ?, previous_credential_id = credential_id, credential_grace_until = ? WHERE device_id = ? AND status = 'approved' AND credential_id = ?",
+		RetryRenewal:       "UPDATE devices SET credential_id = ? WHERE device_id = ? AND status = 'approved' AND credential_id = ? AND previous_credential_id = ?",
+		SetEnrollKey:       "UPDATE devices SET enroll_key_hash = ? WHERE device_id = ? AND enroll_key_hash IS NULL",
+
+		// --- Device history queries ---
+		CreateDeviceHistory: "INSERT INTO device_history (device_id, event, old_value, new_value, created_at) VALUES (?, ?, ?, ?, ?)",
+		ListDeviceHistory:   "SELECT id, device_id, event, old_value, new_value, created_at FROM device_history WHERE device_id = ? ORDER BY created_at DESC, id DESC",
+
+		// --- Device tag queries ---
+		TagDevice:        "INSERT INTO device_tags (device_id, tag, created_at) VALUES (?, ?, ?) ON CONFLICT(device_id, tag) DO NOTHING",
+		UntagDevice:      "DELETE FROM device_tags WHERE device_id = ? AND tag = ?",
+		ListDeviceTags:   "SELECT tag FROM device_tags WHERE device_id = ? ORDER BY tag",
+		ListDevicesByTag: "SELECT d.device_id, d.client_ip, d.created_at, d.updated_at, d.status, d.approved_by, d.ip_policy, d.status_reason, d.last_seen_at, d.alerted_at, d.credential_id, d.enroll_until, d.enroll_key_hash, d.previous_credential_id, d.credential_grace_until FROM devices d JOIN device_tags t ON t.device_id = d.device_id WHERE t.tag = ? ORDER BY d.created_at DESC",
+
+		// --- Display setting queries ---
+		SetDisplaySetting:   "INSERT INTO display_settings (scope, scope_id, key, value, updated_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT(scope, scope_id, key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at",
+		UnsetDisplaySetting: "DELETE FROM display_settings WHERE scope = ? AND scope_id = ? AND key = ?",
+		ListDisplaySettings: "SELECT scope, scope_id, key, value, updated_at FROM display_settings WHERE scope = ? AND scope_id = ? ORDER BY key",
+
+		// --- Directory queries ---
+		CreateDirectoryUser:       "INSERT INTO directory_users (id, user_name, external_id, display_name, email, active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		GetDirectoryUser:          "SELECT id, user_name, external_id, display_name, email, active, created_at, updated_at, deleted_at FROM directory_users WHERE id = ? AND deleted_at IS NULL",
+		FindDirectoryUser:         "SELECT id, user_name, external_id, display_name, email, active, created_at, updated_at, deleted_at FROM directory_users WHERE (email = ? COLLATE NOCASE OR user_name = ? COLLATE NOCASE) AND deleted_at IS NULL ORDER BY email = ? COLLATE NOCASE DESC LIMIT 1",
+		ListDirectoryUsers:        "SELECT id, user_name, external_id, display_name, email, active, created_at, updated_at, deleted_at FROM directory_users WHERE deleted_at IS NULL ORDER BY created_at, id",
+		UpdateDirectoryUser:       "UPDATE directory_users SET user_name = ?, external_id = ?, display_name = ?, email = ?, active = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL",
+		DeleteDirectoryUser:       "UPDATE directory_users SET active = 0, deleted_at = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL",
+		CreateDirectoryGroup:      "INSERT INTO directory_groups (id, display_name, external_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		GetDirectoryGroup:         "SELECT id, display_name, external_id, created_at, updated_at FROM directory_groups WHERE id = ?",
+		FindDirectoryGroup:        "SELECT id, display_name, external_id, created_at, updated_at FROM directory_groups WHERE display_name = ? COLLATE NOCASE ORDER BY created_at, id LIMIT 1",
+		ListDirectoryGroups:       "SELECT id, display_name, external_id, created_at, updated_at FROM directory_groups ORDER BY created_at, id",
+		UpdateDirectoryGroup:      "UPDATE directory_groups SET display_name = ?, external_id = ?, updated_at = ? WHERE id = ?",
+		DeleteDirectoryGroup:      "DELETE FROM directory_groups WHERE id = ?",
+		AddDirectoryGroupMember:   "INSERT INTO directory_group_members (group_id, user_id) VALUES (?, ?) ON CONFLICT(group_id, user_id) DO NOTHING",
+		ClearDirectoryGroup:       "DELETE FROM directory_group_members WHERE group_id = ?",
+		ClearDirectoryUserGroups:  "DELETE FROM directory_group_members WHERE user_id = ?",
+		ListDirectoryGroupMembers: "SELECT u.id, u.user_name, u.external_id, u.display_name, u.email, u.active, u.created_at, u.updated_at, u.deleted_at FROM directory_users u JOIN directory_group_members m ON m.user_id = u.id WHERE m.group_id = ? AND u.deleted_at IS NULL ORDER BY u.user_name",
+		ListDirectoryUserGroups:   "SELECT g.id, g.display_name, g.external_id, g.created_at, g.updated_at FROM directory_groups g JOIN directory_group_members m ON m.group_id = g.id WHERE m.user_id = ? ORDER BY g.display_name",
+		ListDirectoryMemberships:  "SELECT m.group_id, u.id, u.user_name FROM directory_group_members m JOIN directory_users u ON u.id = m.user_id WHERE u.deleted_at IS NULL ORDER BY u.user_name",
+
+		// --- Access list history queries ---
+		CreateAccessChange: "INSERT INTO access_list_changes (user_id, change, active, source, changed_at) VALUES (?, ?, ?, ?, ?)",
+		ListAccessChanges:  "SELECT id, user_id, change, active, source, changed_at FROM access_list_changes WHERE user_id = ? ORDER BY id",
+		ListAccessStates:   "SELECT c.id, c.user_id, c.change, c.active, c.source, c.changed_at FROM access_list_changes c JOIN (SELECT MAX(id) AS id FROM access_list_changes GROUP BY user_id) latest ON latest.id = c.id WHERE c.change != 'removed' ORDER BY c.user_id",
+
+		// User activity queries
+		CreateUserSession: "INSERT INTO user_sessions (id, user_id, client_ip, user_agent, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		EndUserSession:    "UPDATE user_sessions SET ended_at = ? WHERE id = ? AND ended_at IS NULL",
+		ListUserSessions:  "SELECT id, user_id, client_ip, user_agent, created_at, expires_at, ended_at FROM user_sessions WHERE user_id = ? AND ended_at IS NULL AND expires_at > ? ORDER BY created_at DESC",
+		CreateAccessEvent: "INSERT INTO access_events (user_id, entry_id, device_id, granted, reason, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		ListAccessEvents:  "SELECT id, user_id, entry_id, device_id, granted, reason, created_at FROM access_events WHERE user_id = ? ORDER BY id DESC LIMIT ?",
+		PruneUserSessions: "DELETE FROM user_sessions WHERE COALESCE(ended_at, expires_at) < ?",
+		PruneAccessEvents: "DELETE FROM access_events WHERE created_at < ?",
+
+		// User alias queries
+		SetUserAlias:     "INSERT INTO user_aliases (alias, user_id) VALUES (?, ?) ON CONFLICT(alias) DO UPDATE SET user_id = excluded.user_id, created_at = CURRENT_TIMESTAMP",
+		DeleteUserAlias:  "DELETE FROM user_aliases WHERE alias = ?",
+		ResolveUserAlias: "SELECT user_id FROM user_aliases WHERE alias = ?",
+		ListUserAliases:  "SELECT alias, user_id, created_at FROM user_aliases ORDER BY user_id, alias",
 
 		// --- Approved device queries ---
 		CreateApprovedDevice:        "INSERT INTO approved_devices (device_id, entry_id, approved_by, approved_at) VALUES (?, ?, ?, ?)",
+		UpsertApprovedDevice:        "INSERT INTO approved_devices (device_id, entry_id, approved_by, approved_at) VALUES (?, ?, ?, ?) ON CONFLICT(device_id, entry_id) DO UPDATE SET approved_by = excluded.approved_by, approved_at = excluded.approved_at, revoked_at = NULL",
 		GetApprovedDevice:           "SELECT id, device_id, entry_id, approved_by, approved_at, revoked_at FROM approved_devices WHERE device_id = ? AND entry_id = ? AND revoked_at IS NULL",
 		ListApprovedDevicesByDevice: "SELECT id, device_id, entry_id, approved_by, approved_at, revoked_at FROM approved_devices WHERE device_id = ? AND revoked_at IS NULL ORDER BY approved_at DESC",
 		ListApprovedDevicesByEntry:  "SELECT id, device_id, entry_id, approved_by, approved_at, revoked_at FROM approved_devices WHERE entry_id = ? AND revoked_at IS NULL ORDER BY approved_at DESC",
@@ -380,7 +512,7 @@ func (p *SQLProvider) CreateDevice(ctx context.Context, device Device) error {
 		status = DeviceStatusPending
 	}
 
-	_, err := p.db.ExecContext(ctx, p.Queries.CreateDevice, device.DeviceID, device.ClientIP, createdAt, updatedAt, status)
+	_, err := p.db.ExecContext(ctx, p.Queries.CreateDevice, device.DeviceID, device.ClientIP, createdAt, updatedAt, status, device.EnrollKeyHash)
 	if err != nil {
 		return fmt.Errorf("failed to create device: %w", err)
 	}
@@ -431,6 +563,773 @@ func (p *SQLProvider) UpdateDeviceStatus(ctx context.Context, deviceID string, s
 	return nil
 }
 
+func (p *SQLProvider) ApproveDevice(ctx context.Context, deviceID string, entryID int64, approvedBy string) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	var device Device
+	if err := tx.GetContext(ctx, &device, p.Queries.GetDevice, deviceID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("device not found: %s", deviceID)
+		}
+		return fmt.Errorf("failed to get device: %w", err)
+	}
+	// An enrolled device only gets another entry. Its credential stays valid,
+	// and the entry is added to it on renewal.
+	enrolled := device.Status == DeviceStatusApproved && device.CredentialID != nil
+
+	if !enrolled {
+		if _, err := tx.ExecContext(ctx, p.Queries.UpdateDeviceStatus, DeviceStatusApproved, now, approvedBy, deviceID); err != nil {
+			return fmt.Errorf("failed to update device status: %w", err)
+		}
+	}
+
+	// Re-approving a revoked device restores the existing association
+	if _, err := tx.ExecContext(ctx, p.Queries.UpsertApprovedDevice, deviceID, entryID, approvedBy, now); err != nil {
+		return fmt.Errorf("failed to create approved device: %w", err)
+	}
+	if !enrolled {
+		if _, err := tx.ExecContext(ctx, p.Queries.OpenEnrollment, now.Add(DeviceEnrollmentWindow), deviceID); err != nil {
+			return fmt.Errorf("failed to open device enrollment: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	p.logger.Debug("Device approved", "device_id", deviceID, "entry_id", entryID, "approved_by", approvedBy)
+
+	return nil
+}
+
+func (p *SQLProvider) EnrollDevice(ctx context.Context, deviceID string, credentialID string) (bool, error) {
+	device, err := p.GetDevice(ctx, deviceID)
+	if err != nil {
+		return false, err
+	}
+	if device.EnrollUntil == nil || time.Now().After(*device.EnrollUntil) {
+		return false, nil
+	}
+
+	// Enrollment is cleared, so only one of concurrent requests gets it
+	result, err := p.db.ExecContext(ctx, p.Queries.EnrollDevice, credentialID, deviceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to enroll device: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	p.logger.Debug("Device enrolled", "device_id", deviceID, "enrolled", rowsAffected > 0)
+	return rowsAffected > 0, nil
+}
+
+func (p *SQLProvider) RenewDeviceCredential(ctx context.Context, deviceID string, previousID string, credentialID string) (bool, error) {
+	device, err := p.GetDevice(ctx, deviceID)
+	if err != nil {
+		return false, err
+	}
+	if device.CredentialID == nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	var result sql.Result
+	switch {
+	case *device.CredentialID == previousID:
+		result, err = p.db.ExecContext(ctx, p.Queries.RenewCredential, credentialID, now.Add(DeviceCredentialGrace), deviceID, previousID)
+	case device.InCredentialGrace(previousID, now):
+		// The response to the renewal was lost, the credential it issued is replaced
+		result, err = p.db.ExecContext(ctx, p.Queries.RetryRenewal, credentialID, deviceID, *device.CredentialID, previousID)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to renew device credential: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+func (p *SQLProvider) SetDeviceEnrollKey(ctx context.Context, deviceID string, keyHash string) (bool, error) {
+	result, err := p.db.ExecContext(ctx, p.Queries.SetEnrollKey, keyHash, deviceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to set device enroll key: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+func (p *SQLProvider) UpdateDeviceIP(ctx context.Context, deviceID string, clientIP string, reapprove bool, reason string) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var device Device
+	if err := tx.GetContext(ctx, &device, p.Queries.GetDevice, deviceID); err != nil {
+		return fmt.Errorf("failed to get device: %w", err)
+	}
+
+	status := device.Status
+	statusReason := device.StatusReason
+	if reapprove {
+		status = DeviceStatusPending
+		statusReason = &reason
+	}
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, p.Queries.UpdateDeviceIP, clientIP, now, status, statusReason, deviceID); err != nil {
+		return fmt.Errorf("failed to update device IP: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, p.Queries.CreateDeviceHistory, deviceID, DeviceEventIPChanged, device.ClientIP, clientIP, now); err != nil {
+		return fmt.Errorf("failed to create device history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	p.logger.Debug("Device IP updated", "device_id", deviceID, "old_ip", device.ClientIP, "new_ip", clientIP, "status", status)
+
+	return nil
+}
+
+func (p *SQLProvider) SetDeviceIPPolicy(ctx context.Context, deviceID string, policy *string) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var device Device
+	if err := tx.GetContext(ctx, &device, p.Queries.GetDevice, deviceID); err != nil {
+		return fmt.Errorf("failed to get device: %w", err)
+	}
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, p.Queries.SetDeviceIPPolicy, policy, now, deviceID); err != nil {
+		return fmt.Errorf("failed to set device IP policy: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, p.Queries.CreateDeviceHistory, deviceID, DeviceEventIPPolicyChanged, device.IPPolicy, policy, now); err != nil {
+		return fmt.Errorf("failed to create device history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	p.logger.Debug("Device IP policy set", "device_id", deviceID, "policy", policy)
+
+	return nil
+}
+
+func (p *SQLProvider) TouchDevice(ctx context.Context, deviceID string, seenAt time.Time) error {
+	if _, err := p.db.ExecContext(ctx, p.Queries.TouchDevice, seenAt, deviceID); err != nil {
+		return fmt.Errorf("failed to update device last seen: %w", err)
+	}
+	return nil
+}
+
+func (p *SQLProvider) SetDeviceAlerted(ctx context.Context, deviceID string, alertedAt *time.Time) error {
+	if _, err := p.db.ExecContext(ctx, p.Queries.SetDeviceAlerted, alertedAt, deviceID); err != nil {
+		return fmt.Errorf("failed to update device alert: %w", err)
+	}
+	return nil
+}
+
+// --- Device history methods ---
+func (p *SQLProvider) ListDeviceHistory(ctx context.Context, deviceID string) ([]DeviceHistory, error) {
+	var history []DeviceHistory
+
+	if err := p.db.SelectContext(ctx, &history, p.Queries.ListDeviceHistory, deviceID); err != nil {
+		return nil, fmt.Errorf("failed to list device history: %w", err)
+	}
+
+	return history, nil
+}
+
+// --- Device tag methods ---
+func (p *SQLProvider) TagDevice(ctx context.Context, deviceID string, tag string) error {
+	if _, err := p.db.ExecContext(ctx, p.Queries.TagDevice, deviceID, tag, time.Now()); err != nil {
+		return fmt.Errorf("failed to tag device: %w", err)
+	}
+
+	p.logger.Debug("Device tagged", "device_id", deviceID, "tag", tag)
+
+	return nil
+}
+
+func (p *SQLProvider) UntagDevice(ctx context.Context, deviceID string, tag string) error {
+	result, err := p.db.ExecContext(ctx, p.Queries.UntagDevice, deviceID, tag)
+	if err != nil {
+		return fmt.Errorf("failed to untag device: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("device %s does not have tag %s", deviceID, tag)
+	}
+
+	p.logger.Debug("Device untagged", "device_id", deviceID, "tag", tag)
+
+	return nil
+}
+
+func (p *SQLProvider) ListDeviceTags(ctx context.Context, deviceID string) ([]string, error) {
+	var tags []string
+
+	if err := p.db.SelectContext(ctx, &tags, p.Queries.ListDeviceTags, deviceID); err != nil {
+		return nil, fmt.Errorf("failed to list device tags: %w", err)
+	}
+
+	return tags, nil
+}
+
+func (p *SQLProvider) ListDevicesByTag(ctx context.Context, tag string) ([]Device, error) {
+	var devices []Device
+
+	if err := p.db.SelectContext(ctx, &devices, p.Queries.ListDevicesByTag, tag); err != nil {
+		return nil, fmt.Errorf("failed to list devices by tag: %w", err)
+	}
+
+	return devices, nil
+}
+
+// --- Display setting methods ---
+func (p *SQLProvider) SetDisplaySetting(ctx context.Context, setting DisplaySetting) error {
+	updatedAt := setting.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+
+	if _, err := p.db.ExecContext(ctx, p.Queries.SetDisplaySetting, setting.Scope, setting.ScopeID, setting.Key, setting.Value, updatedAt); err != nil {
+		return fmt.Errorf("failed to set display setting: %w", err)
+	}
+
+	p.logger.Debug("Display setting set", "scope", setting.Scope, "scope_id", setting.ScopeID, "key", setting.Key)
+
+	return nil
+}
+
+func (p *SQLProvider) UnsetDisplaySetting(ctx context.Context, scope DisplayScope, scopeID string, key string) error {
+	result, err := p.db.ExecContext(ctx, p.Queries.UnsetDisplaySetting, scope, scopeID, key)
+	if err != nil {
+		return fmt.Errorf("failed to unset display setting: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("display setting %s not set for %s %s", key, scope, scopeID)
+	}
+
+	p.logger.Debug("Display setting unset", "scope", scope, "scope_id", scopeID, "key", key)
+
+	return nil
+}
+
+func (p *SQLProvider) ListDisplaySettings(ctx context.Context, scope DisplayScope, scopeID string) ([]DisplaySetting, error) {
+	var settings []DisplaySetting
+
+	if err := p.db.SelectContext(ctx, &settings, p.Queries.ListDisplaySettings, scope, scopeID); err != nil {
+		return nil, fmt.Errorf("failed to list display settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// --- Directory methods ---
+func (p *SQLProvider) CreateDirectoryUser(ctx context.Context, user DirectoryUser) error {
+	now := time.Now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = now
+	}
+
+	if _, err := p.db.ExecContext(ctx, p.Queries.CreateDirectoryUser, user.ID, user.UserName, user.ExternalID, user.DisplayName, user.Email, user.Active, user.CreatedAt, user.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to create directory user: %w", err)
+	}
+
+	p.logger.Debug("Directory user created", "id", user.ID, "user_name", user.UserName)
+
+	return nil
+}
+
+func (p *SQLProvider) GetDirectoryUser(ctx context.Context, id string) (*DirectoryUser, error) {
+	var user DirectoryUser
+
+	if err := p.db.GetContext(ctx, &user, p.Queries.GetDirectoryUser, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get directory user: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (p *SQLProvider) FindDirectoryUser(ctx context.Context, email string) (*DirectoryUser, error) {
+	var user DirectoryUser
+
+	if err := p.db.GetContext(ctx, &user, p.Queries.FindDirectoryUser, email, email, email); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to find directory user: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (p *SQLProvider) ListDirectoryUsers(ctx context.Context) ([]DirectoryUser, error) {
+	var users []DirectoryUser
+
+	if err := p.db.SelectContext(ctx, &users, p.Queries.ListDirectoryUsers); err != nil {
+		return nil, fmt.Errorf("failed to list directory users: %w", err)
+	}
+
+	return users, nil
+}
+
+func (p *SQLProvider) UpdateDirectoryUser(ctx context.Context, user DirectoryUser) error {
+	result, err := p.db.ExecContext(ctx, p.Queries.UpdateDirectoryUser, user.UserName, user.ExternalID, user.DisplayName, user.Email, user.Active, time.Now(), user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update directory user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	p.logger.Debug("Directory user updated", "id", user.ID, "user_name", user.UserName, "active", user.Active)
+
+	return nil
+}
+
+func (p *SQLProvider) DeleteDirectoryUser(ctx context.Context, id string) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx, p.Queries.DeleteDirectoryUser, now, now, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete directory user: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, p.Queries.ClearDirectoryUserGroups, id); err != nil {
+		return fmt.Errorf("failed to remove group memberships: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	p.logger.Debug("Directory user deleted", "id", id)
+
+	return nil
+}
+
+func (p *SQLProvider) CreateDirectoryGroup(ctx context.Context, group DirectoryGroup, userIDs []string) error {
+	now := time.Now()
+	if group.CreatedAt.IsZero() {
+		group.CreatedAt = now
+	}
+	if group.UpdatedAt.IsZero() {
+		group.UpdatedAt = now
+	}
+
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, p.Queries.CreateDirectoryGroup, group.ID, group.DisplayName, group.ExternalID, group.CreatedAt, group.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to create directory group: %w", err)
+	}
+	if err := p.setDirectoryGroupMembers(ctx, tx, group.ID, userIDs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	p.logger.Debug("Directory group created", "id", group.ID, "display_name", group.DisplayName, "members", len(userIDs))
+
+	return nil
+}
+
+func (p *SQLProvider) GetDirectoryGroup(ctx context.Context, id string) (*DirectoryGroup, error) {
+	var group DirectoryGroup
+
+	if err := p.db.GetContext(ctx, &group, p.Queries.GetDirectoryGroup, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get directory group: %w", err)
+	}
+
+	return &group, nil
+}
+
+func (p *SQLProvider) FindDirectoryGroup(ctx context.Context, displayName string) (*DirectoryGroup, error) {
+	var group DirectoryGroup
+
+	if err := p.db.GetContext(ctx, &group, p.Queries.FindDirectoryGroup, displayName); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to find directory group: %w", err)
+	}
+
+	return &group, nil
+}
+
+func (p *SQLProvider) ListDirectoryGroups(ctx context.Context) ([]DirectoryGroup, error) {
+	var groups []DirectoryGroup
+
+	if err := p.db.SelectContext(ctx, &groups, p.Queries.ListDirectoryGroups); err != nil {
+		return nil, fmt.Errorf("failed to list directory groups: %w", err)
+	}
+
+	return groups, nil
+}
+
+func (p *SQLProvider) UpdateDirectoryGroup(ctx context.Context, group DirectoryGroup, userIDs []string) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, p.Queries.UpdateDirectoryGroup, group.DisplayName, group.ExternalID, time.Now(), group.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update directory group: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	if err := p.setDirectoryGroupMembers(ctx, tx, group.ID, userIDs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	p.logger.Debug("Directory group updated", "id", group.ID, "display_name", group.DisplayName, "members", len(userIDs))
+
+	return nil
+}
+
+func (p *SQLProvider) DeleteDirectoryGroup(ctx context.Context, id string) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, p.Queries.ClearDirectoryGroup, id); err != nil {
+		return fmt.Errorf("failed to remove group members: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, p.Queries.DeleteDirectoryGroup, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete directory group: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	p.logger.Debug("Directory group deleted", "id", id)
+
+	return nil
+}
+
+// setDirectoryGroupMembers replaces the members of the group in the transaction.
+func (p *SQLProvider) setDirectoryGroupMembers(ctx context.Context, tx *sqlx.Tx, groupID string, userIDs []string) error {
+	if _, err := tx.ExecContext(ctx, p.Queries.ClearDirectoryGroup, groupID); err != nil {
+		return fmt.Errorf("failed to remove group members: %w", err)
+	}
+	for _, userID := range userIDs {
+		if _, err := tx.ExecContext(ctx, p.Queries.AddDirectoryGroupMember, groupID, userID); err != nil {
+			return fmt.Errorf("failed to add group member: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p *SQLProvider) ListDirectoryGroupMembers(ctx context.Context, groupID string) ([]DirectoryUser, error) {
+	var users []DirectoryUser
+
+	if err := p.db.SelectContext(ctx, &users, p.Queries.ListDirectoryGroupMembers, groupID); err != nil {
+		return nil, fmt.Errorf("failed to list directory group members: %w", err)
+	}
+
+	return users, nil
+}
+
+func (p *SQLProvider) ListDirectoryUserGroups(ctx context.Context, userID string) ([]DirectoryGroup, error) {
+	var groups []DirectoryGroup
+
+	if err := p.db.SelectContext(ctx, &groups, p.Queries.ListDirectoryUserGroups, userID); err != nil {
+		return nil, fmt.Errorf("failed to list directory user groups: %w", err)
+	}
+
+	return groups, nil
+}
+
+func (p *SQLProvider) ListDirectoryMemberships(ctx context.Context) ([]DirectoryMembership, error) {
+	var memberships []DirectoryMembership
+
+	if err := p.db.SelectContext(ctx, &memberships, p.Queries.ListDirectoryMemberships); err != nil {
+		return nil, fmt.Errorf("failed to list directory memberships: %w", err)
+	}
+
+	return memberships, nil
+}
+
+// --- Access list history methods ---
+func (p *SQLProvider) RecordAccessChanges(ctx context.Context, changes []AccessChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, c := range changes {
+		changedAt := c.ChangedAt
+		if changedAt.IsZero() {
+			changedAt = now
+		}
+		if _, err := tx.ExecContext(ctx, p.Queries.CreateAccessChange, c.UserID, c.Change, c.Active, c.Source, changedAt); err != nil {
+			return fmt.Errorf("failed to record access change: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	p.logger.Debug("Access changes recorded", "changes", len(changes))
+
+	return nil
+}
+
+func (p *SQLProvider) ListAccessChanges(ctx context.Context, userID string) ([]AccessChange, error) {
+	var changes []AccessChange
+
+	if err := p.db.SelectContext(ctx, &changes, p.Queries.ListAccessChanges, userID); err != nil {
+		return nil, fmt.Errorf("failed to list access changes: %w", err)
+	}
+
+	return changes, nil
+}
+
+func (p *SQLProvider) ListAccessStates(ctx context.Context) ([]AccessChange, error) {
+	var states []AccessChange
+
+	if err := p.db.SelectContext(ctx, &states, p.Queries.ListAccessStates); err != nil {
+		return nil, fmt.Errorf("failed to list access states: %w", err)
+	}
+
+	return states, nil
+}
+
+// --- User activity methods ---
+func (p *SQLProvider) CreateUserSession(ctx context.Context, session UserSession) error {
+	createdAt := session.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	if _, err := p.db.ExecContext(ctx, p.Queries.CreateUserSession, session.ID, session.UserID, session.ClientIP, session.UserAgent, createdAt, session.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to create user session: %w", err)
+	}
+
+	p.logger.Debug("User session created", "user_id", session.UserID, "session_id", session.ID)
+
+	return nil
+}
+
+func (p *SQLProvider) EndUserSession(ctx context.Context, id string, endedAt time.Time) error {
+	if _, err := p.db.ExecContext(ctx, p.Queries.EndUserSession, endedAt, id); err != nil {
+		return fmt.Errorf("failed to end user session: %w", err)
+	}
+
+	p.logger.Debug("User session ended", "session_id", id)
+
+	return nil
+}
+
+func (p *SQLProvider) ListUserSessions(ctx context.Context, userID string, now time.Time) ([]UserSession, error) {
+	var sessions []UserSession
+
+	if err := p.db.SelectContext(ctx, &sessions, p.Queries.ListUserSessions, userID, now); err != nil {
+		return nil, fmt.Errorf("failed to list user sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (p *SQLProvider) RecordAccessEvent(ctx context.Context, event AccessEvent) error {
+	createdAt := event.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	if _, err := p.db.ExecContext(ctx, p.Queries.CreateAccessEvent, event.UserID, event.EntryID, event.DeviceID, event.Granted, event.Reason, createdAt); err != nil {
+		return fmt.Errorf("failed to record access event: %w", err)
+	}
+
+	p.logger.Debug("Access event recorded", "user_id", event.UserID, "entry_id", event.EntryID, "granted", event.Granted)
+
+	return nil
+}
+
+func (p *SQLProvider) ListAccessEvents(ctx context.Context, userID string, limit int) ([]AccessEvent, error) {
+	var events []AccessEvent
+
+	if err := p.db.SelectContext(ctx, &events, p.Queries.ListAccessEvents, userID, limit); err != nil {
+		return nil, fmt.Errorf("failed to list access events: %w", err)
+	}
+
+	return events, nil
+}
+
+func (p *SQLProvider) PruneUserSessions(ctx context.Context, olderThan time.Time) (int64, error) {
+	result, err := p.db.ExecContext(ctx, p.Queries.PruneUserSessions, olderThan)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune user sessions: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	p.logger.Info("User sessions pruned", "count", rowsAffected, "older_than", olderThan)
+
+	return rowsAffected, nil
+}
+
+func (p *SQLProvider) PruneAccessEvents(ctx context.Context, olderThan time.Time) (int64, error) {
+	result, err := p.db.ExecContext(ctx, p.Queries.PruneAccessEvents, olderThan)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune access events: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	p.logger.Info("Access events pruned", "count", rowsAffected, "older_than", olderThan)
+
+	return rowsAffected, nil
+}
+
+// --- User alias methods ---
+func (p *SQLProvider) SetUserAlias(ctx context.Context, alias string, userID string) error {
+	if _, err := p.db.ExecContext(ctx, p.Queries.SetUserAlias, alias, userID); err != nil {
+		return fmt.Errorf("failed to set user alias: %w", err)
+	}
+
+	p.logger.Debug("User alias set", "alias", alias, "user_id", userID)
+
+	return nil
+}
+
+func (p *SQLProvider) DeleteUserAlias(ctx context.Context, alias string) error {
+	result, err := p.db.ExecContext(ctx, p.Queries.DeleteUserAlias, alias)
+	if err != nil {
+		return fmt.Errorf("failed to delete user alias: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	p.logger.Debug("User alias deleted", "alias", alias)
+
+	return nil
+}
+
+func (p *SQLProvider) ResolveUserAlias(ctx context.Context, alias string) (string, error) {
+	var userID string
+
+	if err := p.db.GetContext(ctx, &userID, p.Queries.ResolveUserAlias, alias); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("failed to resolve user alias: %w", err)
+	}
+
+	return userID, nil
+}
+
+func (p *SQLProvider) ListUserAliases(ctx context.Context) ([]UserAlias, error) {
+	var aliases []UserAlias
+
+	if err := p.db.SelectContext(ctx, &aliases, p.Queries.ListUserAliases); err != nil {
+		return nil, fmt.Errorf("failed to list user aliases: %w", err)
+	}
+
+	return aliases, nil
+}
+
 // --- Approved device methods ---
 func (p *SQLProvider) CreateApprovedDevice(ctx context.Context, device ApprovedDevice) error {
 	approvedAt := device.ApprovedAt
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IPPolicy decides whether a device may continue from a different IP address
+// than the one it was approved from.
+type IPPolicy string
+
+const (
+	// IP must match exactly
+	IPPolicyStrict IPPolicy = "strict"
+	// IP must be in the same subnet, using the configured prefix length
+	IPPolicySubnet IPPolicy = "subnet"
+	// IP must be within the `allowed_networks` CIDRs
+	IPPolicyAllowedNetworks IPPolicy = "allowed_networks"
+	// IP is not checked
+	IPPolicyIgnore IPPolicy = "ignore"
+)
+
+var IPPolicies = []IPPolicy{IPPolicyStrict, IPPolicySubnet, IPPolicyAllowedNetworks, IPPolicyIgnore}
+
+// ParseIPPolicy validates the policy name. Empty string defaults to strict.
+func ParseIPPolicy(s string) (IPPolicy, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return IPPolicyStrict, nil
+	}
+	for _, p := range IPPolicies {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown IP policy %q, valid policies: strict, subnet, allowed_networks, ignore", s)
+}
+
+// ParseCIDRs parses a comma separated list of CIDR networks.
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for cidr := range strings.SplitSeq(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// IPBinding checks device IP changes against the policy.
+type IPBinding struct {
+	Policy IPPolicy
+	// Prefix lengths for the subnet policy
+	PrefixV4 int
+	PrefixV6 int
+	// Networks for the allowed_networks policy. Without any, no IP change is
+	// allowed, so the policy requires ALLOWED_NETWORKS in configuration.
+	Networks []*net.IPNet
+}
+
+// Allows reports whether a device recorded with recordedIP may be used from clientIP.
+func (b IPBinding) Allows(recordedIP string, clientIP string) bool {
+	if recordedIP == clientIP || b.Policy == IPPolicyIgnore {
+		return true
+	}
+
+	recorded := net.ParseIP(recordedIP)
+	client := net.ParseIP(clientIP)
+	if recorded == nil || client == nil {
+		return false
+	}
+
+	switch b.Policy {
+	case IPPolicySubnet:
+		return sameSubnet(recorded, client, b.PrefixV4, b.PrefixV6)
+	case IPPolicyAllowedNetworks:
+		for _, network := range b.Networks {
+			if network.Contains(client) {
+				return true
+			}
+		}
+		return false
+	default:
+		return recorded.Equal(client)
+	}
+}
+
+func sameSubnet(a net.IP, b net.IP, prefixV4 int, prefixV6 int) bool {
+	a4, b4 := a.To4(), b.To4()
+	switch {
+	case a4 != nil && b4 != nil:
+		mask := net.CIDRMask(prefixV4, 8*net.IPv4len)
+		return mask != nil && a4.Mask(mask).Equal(b4.Mask(mask))
+	case a4 == nil && b4 == nil:
+		mask := net.CIDRMask(prefixV6, 8*net.IPv6len)
+		return mask != nil && a.Mask(mask).Equal(b.Mask(mask))
+	default:
+		// Address family changed
+		return false
+	}
+}
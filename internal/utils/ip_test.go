@@ -0,0 +1,50 @@
+package utils
+
+import "testing"
+
+func TestIPBinding_Allows(t *testing.T) {
+	networks, err := ParseCIDRs("10.1.0.0/16, 2001:db8::/32")
+	if err != nil {
+		t.Fatalf("ParseCIDRs failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		policy   IPPolicy
+		recorded string
+		client   string
+		want     bool
+	}{
+		{"strict same", IPPolicyStrict, "10.1.2.3", "10.1.2.3", true},
+		{"strict changed", IPPolicyStrict, "10.1.2.3", "10.1.2.4", false},
+		{"subnet same /24", IPPolicySubnet, "10.1.2.3", "10.1.2.200", true},
+		{"subnet other /24", IPPolicySubnet, "10.1.2.3", "10.1.3.3", false},
+		{"subnet ipv6 same /64", IPPolicySubnet, "2001:db8::1", "2001:db8::ffff", true},
+		{"subnet family changed", IPPolicySubnet, "10.1.2.3", "2001:db8::1", false},
+		{"networks inside", IPPolicyAllowedNetworks, "10.1.2.3", "10.1.200.1", true},
+		{"networks outside", IPPolicyAllowedNetworks, "10.1.2.3", "192.168.0.1", false},
+		{"ignore", IPPolicyIgnore, "10.1.2.3", "192.168.0.1", true},
+		{"invalid ip", IPPolicySubnet, "10.1.2.3", "not-an-ip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := IPBinding{Policy: tt.policy, PrefixV4: 24, PrefixV6: 64, Networks: networks}
+			if got := b.Allows(tt.recorded, tt.client); got != tt.want {
+				t.Errorf("Allows(%q, %q) = %v, want %v", tt.recorded, tt.client, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIPPolicy(t *testing.T) {
+	if p, err := ParseIPPolicy(""); err != nil || p != IPPolicyStrict {
+		t.Errorf("empty policy = %q, %v; want strict", p, err)
+	}
+	if p, err := ParseIPPolicy(" Subnet "); err != nil || p != IPPolicySubnet {
+		t.Errorf("Subnet = %q, %v; want subnet", p, err)
+	}
+	if _, err := ParseIPPolicy("dhcp"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
@@ -77,3 +77,24 @@ func VerifyDeviceID(deviceID string, secret []byte) bool {
 
 	return hmac.Equal([]byte(providedSig), []byte(expectedSig))
 }
+
+// Length limits of the enroll key the device generates when it first registers
+const (
+	enrollKeyMinLength = 32
+	enrollKeyMaxLength = 256
+)
+
+// HashEnrollKey returns the SHA-256 of the device enroll key, as stored for the device.
+func HashEnrollKey(key string) (string, bool) {
+	if len(key) < enrollKeyMinLength || len(key) > enrollKeyMaxLength {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]), true
+}
+
+// VerifyEnrollKey checks the enroll key against the stored hash.
+func VerifyEnrollKey(key string, keyHash string) bool {
+	hash, ok := HashEnrollKey(key)
+	return ok && hmac.Equal([]byte(hash), []byte(keyHash))
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnrollKey(t *testing.T) {
+	key := strings.Repeat("ab", 32)
+	hash, ok := HashEnrollKey(key)
+	if !ok {
+		t.Fatalf("HashEnrollKey(%q) rejected a valid key", key)
+	}
+	if hash == key || len(hash) != 64 {
+		t.Errorf("HashEnrollKey(%q) = %q, want a SHA-256 hex digest", key, hash)
+	}
+
+	if !VerifyEnrollKey(key, hash) {
+		t.Error("VerifyEnrollKey rejected the key the hash was made of")
+	}
+	if VerifyEnrollKey(strings.Repeat("cd", 32), hash) {
+		t.Error("VerifyEnrollKey accepted another key")
+	}
+
+	for _, invalid := range []string{"", "short", strings.Repeat("x", 257)} {
+		if _, ok := HashEnrollKey(invalid); ok {
+			t.Errorf("HashEnrollKey(%q) expected to be rejected", invalid)
+		}
+		if VerifyEnrollKey(invalid, hash) {
+			t.Errorf("VerifyEnrollKey(%q) expected to be rejected", invalid)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package utils
 
-import "runtime/debug"
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
 
 // TODO: set by build system
 var BuildVersion = ""
@@ -24,3 +29,56 @@ func GetVersion() string {
 
 	return info.Main.Version
 }
+
+// parseVersion parses version like "v1.2.3" into its numeric parts. Pre-release
+// and build suffixes, e.g. "-dirty" or "+meta", are ignored.
+func parseVersion(version string) ([]int, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" {
+		return nil, fmt.Errorf("invalid version %q", version)
+	}
+
+	var parts []int
+	for p := range strings.SplitSeq(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", version)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// CompareVersions compares two versions. Returns -1 if a is older than b, 0 if
+// they are equal, and 1 if a is newer. Missing parts count as zero, so "v1.2"
+// equals "v1.2.0".
+func CompareVersions(a, b string) (int, error) {
+	pa, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range max(len(pa), len(pb)) {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
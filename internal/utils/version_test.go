@@ -0,0 +1,34 @@
+package utils
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2", "1.2.0", 0},
+		{"v1.2.3", "v1.10.0", -1},
+		{"v2.0.0", "v1.99.99", 1},
+		{"v1.2.3-dirty", "v1.2.3", 0},
+		{"v0.0.0-20251018120000-abcdef123456", "v0.1.0", -1},
+	}
+
+	for _, tt := range tests {
+		got, err := CompareVersions(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("CompareVersions(%q, %q) failed: %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"", "(devel)", "v1.x"} {
+		if _, err := CompareVersions(invalid, "v1.0.0"); err == nil {
+			t.Errorf("CompareVersions(%q) expected error", invalid)
+		}
+	}
+}
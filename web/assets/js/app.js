@@ -316,12 +316,51 @@ async function loadConfig() {
     }
 }
 
-// TODO: Add version check function
-function checkVersion() {
-    // Fetch version, and reload if mismatch
+// Minimum time between version reloads, to avoid reload loops if the page is cached
+const VERSION_RELOAD_INTERVAL = 60 * 1000;
+
+/**
+ * Returns the client version the page was loaded with
+ * @returns {string|null}
+ */
+function getClientVersion() {
+    return document.querySelector('meta[name="app-version"]')?.content || null;
+}
+
+/**
+ * Handles a version mismatch reported by the server. Outdated clients are sent
+ * to the error page, others reload to pick up the current version.
+ * @param {Object} detail - Version check result
+ * @param {string} detail.serverVersion - Version the server is running
+ * @param {boolean} detail.outdated - Client is older than the server accepts
+ */
+function checkVersion({ serverVersion, outdated }) {
+    const clientVersion = getClientVersion();
+
+    if (outdated) {
+        console.error(`Client version ${clientVersion} is no longer supported by server ${serverVersion}`);
+        const url = new URL('entry/outdated', document.baseURI);
+        if (clientVersion) {
+            url.searchParams.set('v', clientVersion);
+        }
+        window.location.href = url.toString();
+        return;
+    }
+
+    const lastReload = parseInt(sessionStorage.getItem('version_reload_at') || '0', 10);
+    if (Date.now() - lastReload < VERSION_RELOAD_INTERVAL) {
+        console.warn('Version mismatch persists after reload, waiting before retrying');
+        return;
+    }
+
+    console.log(`Client version ${clientVersion} differs from server ${serverVersion}, reloading`);
+    sessionStorage.setItem('version_reload_at', Date.now().toString());
+    window.location.reload();
 }
 
 export {
     DeviceProvisioning,
-    loadConfig
+    loadConfig,
+    getClientVersion,
+    checkVersion
 };
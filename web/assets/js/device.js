@@ -5,10 +5,70 @@ class DeviceProvisioning {
         // Key for storing device ID in local storage
         this.DEVICE_ID_KEY = 'device_id';
         this.DEVICE_AUTH_KEY = 'device_auth';
+        // Key for storing the enroll key, proving registrations are from this device
+        this.ENROLL_KEY_KEY = 'device_enroll_key';
+        // Renew device token when less than this is left (ms)
+        this.TOKEN_RENEW_BEFORE = 24 * 60 * 60 * 1000;
+        // How often token expiry is checked (ms)
+        this.TOKEN_CHECK_INTERVAL = 60 * 60 * 1000;
+        this.renewTimer = null;
 
         this.errorHandler = errorHandler;
         this.device_id = null;
         this.authenticated = false;
+        this.eventSource = null;
+        this.eventTypes = null;
+    }
+
+    /**
+     * Subscribes to the device server-sent events channel. Requires device credentials,
+     * passed as a query parameter as EventSource can't set headers.
+     * Each event is re-dispatched on document as `device:<type>`, with the parsed data as detail.
+     * EventSource reconnects automatically, and the server replays missed events using Last-Event-ID.
+     * @param {string[]} types - Event types to listen for
+     * @returns {EventSource} The event source
+     */
+    subscribe(types = ['status', 'entries', 'config', 'rotate', 'heartbeat']) {
+        const deviceId = this.getDeviceId();
+        const token = this.getToken();
+        if (!deviceId || !token) {
+            throw new Error('Device credentials are required for subscribing to events');
+        }
+        if (this.eventSource) {
+            this.eventSource.close();
+        }
+        this.eventTypes = types;
+
+        this.eventSource = new EventSource(`/api/provision/sse/${encodeURIComponent(deviceId)}?token=${encodeURIComponent(token)}`);
+
+        for (const type of types) {
+            this.eventSource.addEventListener(type, (e) => {
+                let data = null;
+                try {
+                    data = JSON.parse(e.data);
+                } catch (error) {
+                    console.error(`Failed to parse device event ${type}:`, error);
+                    return;
+                }
+                document.dispatchEvent(new CustomEvent(`device:${type}`, { detail: data }));
+            });
+        }
+
+        this.eventSource.addEventListener('error', () => {
+            console.warn('Device event channel disconnected, reconnecting...');
+        });
+
+        return this.eventSource;
+    }
+
+    /**
+     * Closes the server-sent events channel
+     */
+    unsubscribe() {
+        if (this.eventSource) {
+            this.eventSource.close();
+            this.eventSource = null;
+        }
     }
 
     /**
@@ -63,7 +123,10 @@ class DeviceProvisioning {
      */
     async registerDevice(existingDeviceId = null) {
         try {
-            const payload = existingDeviceId ? { device_id: existingDeviceId } : {};
+            const payload = { enroll_key: this.getEnrollKey() };
+            if (existingDeviceId) {
+                payload.device_id = existingDeviceId;
+            }
 
             const response = await fetch('/api/provision/register', {
                 method: 'POST',
@@ -85,7 +148,11 @@ class DeviceProvisioning {
                 this.device_id = data.device_id;
                 localStorage.setItem(this.DEVICE_ID_KEY, data.device_id);
             }
-            this.authenticated = data.authenticated || false;
+            if (data.token) {
+                this.storeToken(data.token, data.expires_at);
+            }
+            // Credentials are issued once after approval, after that the stored token is renewed
+            this.authenticated = data.authenticated || (data.status === 'approved' && this.getToken() !== null);
 
             console.log('Device registration response:', data);
             return data;
@@ -95,6 +162,22 @@ class DeviceProvisioning {
         }
     }
 
+    /**
+     * Gets the enroll key, generating it on first use. The key is sent on every
+     * registration, and the server only registers the device ID with the key
+     * it was issued with.
+     * @returns {string} The enroll key as hex
+     */
+    getEnrollKey() {
+        let key = localStorage.getItem(this.ENROLL_KEY_KEY);
+        if (!key) {
+            const bytes = crypto.getRandomValues(new Uint8Array(32));
+            key = Array.from(bytes, (b) => b.toString(16).padStart(2, '0')).join('');
+            localStorage.setItem(this.ENROLL_KEY_KEY, key);
+        }
+        return key;
+    }
+
     /**
      * Gets the current device ID from instance or local storage
      * @returns {string|null} The device ID or null if not found
@@ -111,6 +194,97 @@ class DeviceProvisioning {
         }
     }
 
+    /**
+     * Stores device credentials in local storage
+     * @param {string} token - Device token
+     * @param {string} expiresAt - Expiry time as RFC 3339 string
+     */
+    storeToken(token, expiresAt) {
+        localStorage.setItem(this.DEVICE_AUTH_KEY, JSON.stringify({ token: token, expires_at: expiresAt }));
+    }
+
+    /**
+     * Gets the device token from local storage
+     * @returns {string|null} The device token or null if not found
+     */
+    getToken() {
+        try {
+            const auth = JSON.parse(localStorage.getItem(this.DEVICE_AUTH_KEY));
+            return auth?.token || null;
+        } catch (error) {
+            console.error('Error retrieving device token:', error);
+            return null;
+        }
+    }
+
+    /**
+     * Renews the device token using /renew endpoint. Falls back to registering
+     * again if the current token is no longer accepted. On other failures the
+     * current token is kept, as the server accepts it for a while after a
+     * renewal whose response was lost.
+     * @returns {Promise<string|null>} The new device token
+     */
+    async renewToken() {
+        const token = this.getToken();
+        if (token) {
+            const response = await fetch('/api/provision/renew', {
+                method: 'POST',
+                headers: {
+                    'Accept': 'application/json',
+                    'Authorization': `Bearer ${token}`
+                }
+            });
+            if (response.ok) {
+                const data = await response.json();
+                this.storeToken(data.token, data.expires_at);
+                console.log('Device token renewed, expires at', data.expires_at);
+                // The event stream was opened with the replaced token
+                if (this.eventSource) {
+                    this.subscribe(this.eventTypes);
+                }
+                return data.token;
+            }
+            console.warn('Device token renewal failed with status', response.status);
+            if (response.status !== 401 && response.status !== 403) {
+                // Still accepted, renewal is retried on the next check
+                return token;
+            }
+            // Token is no longer accepted, only a new approval issues another one
+            localStorage.removeItem(this.DEVICE_AUTH_KEY);
+        }
+
+        await this.registerDevice(this.getDeviceId());
+        return this.getToken();
+    }
+
+    /**
+     * Makes sure there is a valid device token, renewing it when less than a
+     * day of validity is left.
+     * @param {boolean} force - Renew even if the token is still fresh
+     * @returns {Promise<string|null>} The device token
+     */
+    async ensureToken(force = false) {
+        let expiresAt = 0;
+        try {
+            const auth = JSON.parse(localStorage.getItem(this.DEVICE_AUTH_KEY));
+            expiresAt = auth?.expires_at ? Date.parse(auth.expires_at) : 0;
+        } catch (error) {
+            console.error('Error reading device token:', error);
+        }
+
+        if (force || expiresAt - Date.now() < this.TOKEN_RENEW_BEFORE) {
+            await this.renewToken();
+        }
+
+        // Check again later, so long-running kiosks keep their credentials fresh
+        clearTimeout(this.renewTimer);
+        this.renewTimer = setTimeout(() => {
+            this.ensureToken().catch((error) => console.error('Device token renewal failed:', error));
+        }, this.TOKEN_CHECK_INTERVAL);
+
+        return this.getToken();
+    }
+
     getAuthenticated() {
         return (this.authenticated === true);
     }
@@ -121,6 +295,8 @@ class DeviceProvisioning {
     clearDeviceId() {
         try {
             localStorage.removeItem(this.DEVICE_ID_KEY);
+            localStorage.removeItem(this.DEVICE_AUTH_KEY);
+            localStorage.removeItem(this.ENROLL_KEY_KEY);
             this.device_id = null;
             this.authenticated = false;
             console.log('Device ID cleared from local storage');
@@ -145,7 +321,6 @@ class DeviceProvisioning {
             
             // Set properties from response
             this.device_id = response.device_id;
-            this.authenticated = response.authenticated || false;
             
             console.log('Device provisioning completed:', {
                 device_id: this.device_id,
@@ -0,0 +1,148 @@
+/**
+ * Kiosk display settings
+ *
+ * Fetches per-device display settings from the server, and applies them to the
+ * entry view. Settings are re-fetched when the version stamp reported by the
+ * server changes.
+ *
+ * Elements used, if present:
+ *   - [data-kiosk="header"]: Header text
+ *   - [data-kiosk="idle-message"]: Idle message, replaces the element's default text
+ *   - [data-kiosk="dark"]: Overlay shown during dark hours
+ *   - qr-code: refresh-margin attribute is set from the QR refresh margin
+ */
+
+// How often dark hours are checked
+const DARK_HOURS_CHECK_INTERVAL = 60 * 1000;
+
+class KioskDisplay {
+    /**
+     * @param {string} deviceId - Signed device ID
+     * @param {function(): string|null} getToken - Returns the current device token
+     */
+    constructor(deviceId, getToken) {
+        this.deviceId = deviceId;
+        this.getToken = getToken;
+        this.version = null;
+        this.settings = {};
+        this._darkTimer = null;
+        this._defaults = new Map();
+    }
+
+    /**
+     * Fetches the settings, and applies them if they have changed
+     * @returns {Promise<Object>} The current settings
+     */
+    async load() {
+        const headers = {
+            'Accept': 'application/json',
+            'Authorization': `Bearer ${this.getToken()}`
+        };
+        if (this.version) {
+            headers['If-None-Match'] = `"${this.version}"`;
+        }
+
+        const response = await fetch(`/api/provision/config/${encodeURIComponent(this.deviceId)}`, {
+            cache: 'no-store',
+            headers: headers
+        });
+        if (response.status === 304) {
+            return this.settings;
+        }
+        if (!response.ok) {
+            throw new Error(`HTTP error! status: ${response.status}`);
+        }
+
+        const data = await response.json();
+        this.version = data.version;
+        this.settings = data.settings || {};
+        console.log('Kiosk display settings loaded, version', this.version);
+        this.apply();
+        return this.settings;
+    }
+
+    /**
+     * Re-fetches the settings if the version differs from the loaded one
+     * @param {string} version - Version stamp reported by the server
+     */
+    async checkVersion(version) {
+        if (!version || version === this.version) {
+            return;
+        }
+        try {
+            await this.load();
+        } catch (error) {
+            console.error('Failed to reload kiosk display settings:', error);
+        }
+    }
+
+    apply() {
+        const s = this.settings;
+
+        document.documentElement.lang = s.locale || 'en';
+        this._setText('header', s.header_text);
+        this._setText('idle-message', s.idle_message);
+
+        const margin = s.qr_refresh_margin ? String(s.qr_refresh_margin) : null;
+        document.querySelectorAll('qr-code').forEach(el => {
+            if (margin) {
+                el.setAttribute('refresh-margin', margin);
+            } else {
+                el.removeAttribute('refresh-margin');
+            }
+        });
+
+        clearInterval(this._darkTimer);
+        this._darkTimer = null;
+        this._updateDark();
+        if (s.dark_hours) {
+            this._darkTimer = setInterval(() => this._updateDark(), DARK_HOURS_CHECK_INTERVAL);
+        }
+    }
+
+    /**
+     * Sets text of a kiosk element, restoring its default text when unset.
+     * Elements without a default are hidden when unset.
+     */
+    _setText(name, text) {
+        document.querySelectorAll(`[data-kiosk="${name}"]`).forEach(el => {
+            if (!this._defaults.has(el)) {
+                this._defaults.set(el, el.textContent.trim());
+            }
+            const value = text || this._defaults.get(el);
+            el.textContent = value;
+            el.classList.toggle('hidden', !value);
+        });
+    }
+
+    /**
+     * Checks if the time is within dark hours, e.g. "22:00-06:00"
+     * @param {string} darkHours
+     * @param {Date} now
+     * @returns {boolean}
+     */
+    static inDarkHours(darkHours, now = new Date()) {
+        const match = /^(\d{2}):(\d{2})-(\d{2}):(\d{2})$/.exec(darkHours || '');
+        if (!match) {
+            return false;
+        }
+        const start = parseInt(match[1], 10) * 60 + parseInt(match[2], 10);
+        const end = parseInt(match[3], 10) * 60 + parseInt(match[4], 10);
+        const minutes = now.getHours() * 60 + now.getMinutes();
+
+        // Period may wrap over midnight
+        return start < end
+            ? minutes >= start && minutes < end
+            : minutes >= start || minutes < end;
+    }
+
+    _updateDark() {
+        const dark = KioskDisplay.inDarkHours(this.settings.dark_hours);
+        document.documentElement.classList.toggle('dark', dark);
+        document.querySelectorAll('[data-kiosk="dark"]').forEach(el => {
+            el.classList.toggle('hidden', !dark);
+        });
+    }
+}
+
+export { KioskDisplay };
@@ -14,9 +14,18 @@
  *   - background: Background color (default: #ffffff)
  *   - ecl: Error correction level: L, M, Q, H (default: M)
  *   - device-id: Include device ID in request (true/false)
+ *   - device-token: Send device credentials from local storage as Bearer token (true/false)
  *   - show-link: Display QR target URL as clickable link (true/false)
+ *   - events: Long-poll endpoint for device events (JSON with 'events' and 'last_id')
+ *   - refresh-margin: Seconds before expiry to refresh (default: at 50% of the lifetime)
  * 
- * Auto-refresh: Automatically refreshes based on expires_at in JSON response
+ * Auto-refresh: Automatically refreshes based on expires_at in JSON response,
+ * and immediately on a 'rotate' event. All events are re-dispatched as 'qr-device-event'.
+ *
+ * Version check: The client version from the page's app-version meta tag is sent
+ * with every request. When the server asks the client to reload, or refuses the
+ * client as too old, a 'qr-version' event is dispatched. When the display settings
+ * version stamp of the device changes, a 'qr-display-version' event is dispatched.
  */
 
 class QRCodeElement extends HTMLElement {
@@ -27,15 +36,22 @@ class QRCodeElement extends HTMLElement {
         this._abortController = null;
         this._currentContent = null;
         this._expiresAt = null;
+        this._eventsController = null;
+        this._lastEventId = null;
+        this._displayVersion = null;
     }
 
     static get observedAttributes() {
-        return ['src', 'width', 'height', 'color', 'background', 'ecl', 'device-id', 'show-link'];
+        return ['src', 'width', 'height', 'color', 'background', 'ecl', 'device-id', 'device-token', 'show-link', 'events', 'refresh-margin'];
     }
 
     connectedCallback() {
         this.render();
-        this.loadAndGenerateQR();
+        // Source may be set later, once the device has its credentials
+        if (this.getAttribute('src')) {
+            this.loadAndGenerateQR();
+        }
+        this._pollEvents();
     }
 
     disconnectedCallback() {
@@ -44,18 +60,151 @@ class QRCodeElement extends HTMLElement {
             this._abortController.abort();
             this._abortController = null;
         }
+        if (this._eventsController) {
+            this._eventsController.abort();
+            this._eventsController = null;
+        }
     }
 
     attributeChangedCallback(name, oldValue, newValue) {
         if (oldValue !== newValue) {
             if (name === 'src') {
                 this.loadAndGenerateQR();
+            } else if (name === 'events') {
+                if (this._eventsController) {
+                    this._eventsController.abort();
+                    this._eventsController = null;
+                }
+                if (this.isConnected) {
+                    this._pollEvents();
+                }
+            } else if (name === 'refresh-margin') {
+                this._scheduleRefresh(this._expiresAt);
             } else if (this._currentContent) {
                 this.generateQR(this._currentContent);
             }
         }
     }
 
+    /**
+     * Adds device_id query parameter to the URL if the device-id attribute is set
+     * @param {URL} url
+     */
+    _addDeviceId(url) {
+        const includeDeviceId = this.getAttribute('device-id') == 'true';
+        if (includeDeviceId) {
+            const deviceId = localStorage.getItem('device_id');
+            if (deviceId) {
+                url.searchParams.set('device_id', deviceId);
+            }
+        }
+    }
+
+    /**
+     * Returns request headers, with the device token if the device-token attribute is set
+     * @returns {Object}
+     */
+    _requestHeaders() {
+        const headers = { 'Accept': 'application/json' };
+        const version = document.querySelector('meta[name="app-version"]')?.content;
+        if (version) {
+            headers['X-Client-Version'] = version;
+        }
+        if (this.getAttribute('device-token') == 'true') {
+            try {
+                const auth = JSON.parse(localStorage.getItem('device_auth'));
+                if (auth?.token) {
+                    headers['Authorization'] = `Bearer ${auth.token}`;
+                }
+            } catch (error) {
+                console.error('Error reading device token:', error);
+            }
+        }
+        return headers;
+    }
+
+    /**
+     * Dispatches 'qr-display-version' event if the display settings version
+     * changed, and 'qr-version' event if the server asks the client to reload,
+     * or refuses the client as too old.
+     * @param {Response} response
+     * @returns {boolean} true if the 'qr-version' event was dispatched
+     */
+    _checkVersion(response) {
+        const displayVersion = response.headers.get('X-Display-Version');
+        if (displayVersion && displayVersion !== this._displayVersion) {
+            this._displayVersion = displayVersion;
+            this.dispatchEvent(new CustomEvent('qr-display-version', {
+                detail: { version: displayVersion }
+            }));
+        }
+
+        const outdated = response.status === 426;
+        const reload = response.headers.get('X-Client-Reload') === 'true';
+        if (!outdated && !reload) {
+            return false;
+        }
+
+        this.dispatchEvent(new CustomEvent('qr-version', {
+            detail: {
+                serverVersion: response.headers.get('X-Server-Version'),
+                outdated: outdated
+            }
+        }));
+        return true;
+    }
+
+    /**
+     * Long-polls the events endpoint for as long as the element is connected.
+     */
+    async _pollEvents() {
+        const src = this.getAttribute('events');
+        if (!src || this._eventsController) return;
+
+        this._eventsController = new AbortController();
+        const signal = this._eventsController.signal;
+
+        while (!signal.aborted) {
+            const url = new URL(src, window.location.href);
+            this._addDeviceId(url);
+            if (this._lastEventId !== null) {
+                url.searchParams.set('since', this._lastEventId);
+            }
+
+            try {
+                const response = await fetch(url.toString(), { signal: signal, cache: 'no-store', headers: this._requestHeaders() });
+                this._checkVersion(response);
+                if (!response.ok) {
+                    throw new Error(`HTTP error! status: ${response.status}`);
+                }
+                const data = await response.json();
+                this._lastEventId = data.last_id;
+
+                for (const event of data.events || []) {
+                    this._handleEvent(event);
+                }
+            } catch (error) {
+                if (error.name === 'AbortError') {
+                    return;
+                }
+                console.warn('Device event poll failed, retrying:', error);
+                // Back off before retrying
+                await new Promise(resolve => setTimeout(resolve, 5000));
+            }
+        }
+    }
+
+    _handleEvent(event) {
+        if (event.type === 'rotate') {
+            console.log('QR code used, rotating');
+            this.loadAndGenerateQR();
+        }
+
+        this.dispatchEvent(new CustomEvent('qr-device-event', {
+            detail: event
+        }));
+    }
+
     _clearTimer() {
         if (this._refreshTimer) {
             clearTimeout(this._refreshTimer);
@@ -78,8 +227,10 @@ class QRCodeElement extends HTMLElement {
             return;
         }
         
-        // Refresh at 50% of the lifetime
-        const refreshDelay = Math.max(timeUntilExpiry / 2, 1000); // Minimum 1 second
+        // Refresh at the margin before expiry, or at 50% of the lifetime
+        const margin = parseInt(this.getAttribute('refresh-margin') || '0', 10) * 1000;
+        const delay = margin > 0 ? timeUntilExpiry - margin : timeUntilExpiry / 2;
+        const refreshDelay = Math.max(delay, 1000); // Minimum 1 second
 
         this._refreshTimer = setTimeout(() => {
             this.loadAndGenerateQR();
@@ -190,13 +341,7 @@ class QRCodeElement extends HTMLElement {
         url.searchParams.set('cb', Date.now().toString());
         
         // Include device_id if attribute is set to "true"
-        const includeDeviceId = this.getAttribute('device-id') == 'true';
-        if (includeDeviceId) {
-            const deviceId = localStorage.getItem('device_id');
-            if (deviceId) {
-                url.searchParams.set('device_id', deviceId);
-            }
-        }
+        this._addDeviceId(url);
 
         const loadingEl = this.shadowRoot.getElementById('loading');
         const qrDisplayEl = this.shadowRoot.getElementById('qr-display');
@@ -213,8 +358,10 @@ class QRCodeElement extends HTMLElement {
             this._abortController = new AbortController();
 
             const response = await fetch(url.toString(), {
-                signal: this._abortController.signal
+                signal: this._abortController.signal,
+                headers: this._requestHeaders()
             });
+            this._checkVersion(response);
 
             if (!response.ok) {
                 throw new Error(`HTTP error! status: ${response.status}`);